@@ -0,0 +1,96 @@
+package epub
+
+import (
+	"fmt"
+	"time"
+)
+
+// BookMeta is the metadata of an EPUB built by Build. Fields with a zero value
+// are left at the default of NewEpub.
+type BookMeta struct {
+	Title       string
+	Authors     []string
+	Lang        string
+	Description string
+	Publisher   string
+	Date        time.Time
+	Subjects    []string
+}
+
+// SectionInput describes a section of an EPUB built by Build.
+type SectionInput struct {
+	Body  string // The body of the section, as for AddSection
+	Title string // The title of the section, as for AddSection
+	// The source of the CSS file of the section, as for AddCSS (optional).
+	// Sections with the same CSS source share the same CSS file.
+	CSS string
+	// The internal filename of the section, as for AddSection (optional)
+	Filename string
+}
+
+// SectionBuildError is thrown by Build if one of the sections couldn't be
+// added to the EPUB.
+type SectionBuildError struct {
+	Index int    // The index of the section that caused the error
+	Title string // The title of the section that caused the error
+	Err   error  // The underlying error that was thrown
+}
+
+func (e *SectionBuildError) Error() string {
+	return fmt.Sprintf("Error adding section %d (%q): %+v", e.Index, e.Title, e.Err)
+}
+
+// Build creates a new EPUB with the provided metadata and sections in one call,
+// which is convenient for generating EPUBs from data. It's the same as calling
+// NewEpub, the metadata setters of Pkg, AddCSS, and AddSection for each section
+// in order. The returned EPUB can be changed further before it's written.
+//
+// If a section can't be added, e.g. because its CSS can't be retrieved or its
+// filename is already used, SectionBuildError will be returned with the
+// underlying error.
+func Build(meta BookMeta, sections []SectionInput) (*Epub, error) {
+	e := NewEpub(meta.Title)
+	for _, author := range meta.Authors {
+		e.Pkg.AddCreator(author, PropertyRoleAuthor)
+	}
+	if meta.Lang != "" {
+		e.Pkg.SetLang(meta.Lang)
+	}
+	if meta.Description != "" {
+		e.Pkg.SetDescription(meta.Description)
+	}
+	if meta.Publisher != "" {
+		e.Pkg.SetPublisher(meta.Publisher)
+	}
+	if !meta.Date.IsZero() {
+		e.Pkg.SetDate(meta.Date)
+	}
+	for _, subject := range meta.Subjects {
+		e.Pkg.AddSubject(subject)
+	}
+
+	// Internal paths of the CSS files already added, by source
+	cssPaths := map[string]string{}
+	for i, section := range sections {
+		cssPath := ""
+		if section.CSS != "" {
+			var ok bool
+			cssPath, ok = cssPaths[section.CSS]
+			if !ok {
+				var err error
+				cssPath, err = e.AddCSS(section.CSS, "")
+				if err != nil {
+					return nil, &SectionBuildError{Index: i, Title: section.Title, Err: err}
+				}
+				cssPaths[section.CSS] = cssPath
+			}
+		}
+
+		_, err := e.AddSection(section.Body, section.Title, section.Filename, cssPath)
+		if err != nil {
+			return nil, &SectionBuildError{Index: i, Title: section.Title, Err: err}
+		}
+	}
+
+	return e, nil
+}
@@ -0,0 +1,82 @@
+package epub
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bmaupin/go-epub/internal/storage"
+)
+
+func TestBuild(t *testing.T) {
+	e, err := Build(
+		BookMeta{
+			Title:   testEpubTitle,
+			Authors: []string{testEpubAuthor},
+			Lang:    testEpubLang,
+		},
+		[]SectionInput{
+			{Body: testSectionBody, Title: testSectionTitle, CSS: testCoverCSSSource, Filename: testSectionFilename},
+			{Body: testSectionBody, Title: "Section 2", CSS: testCoverCSSSource},
+		},
+	)
+	if err != nil {
+		t.Fatalf("Error building EPUB: %s", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	for _, expected := range []string{
+		testEpubTitle,
+		testEpubAuthor,
+		fmt.Sprintf("<dc:language>%s</dc:language>", testEpubLang),
+	} {
+		if !strings.Contains(string(pkgFileContent), expected) {
+			t.Errorf("Package file doesn't contain %q\nGot: %s", expected, pkgFileContent)
+		}
+	}
+
+	// Both sections should share the same CSS file
+	for _, sectionFilename := range []string{testSectionFilename, "section0002.xhtml"} {
+		contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, sectionFilename))
+		if err != nil {
+			t.Errorf("Unexpected error reading section file: %s", err)
+		}
+		testCSSLinkElement := fmt.Sprintf(testCSSLinkTemplate, "../css/cover.css")
+		if !strings.Contains(string(contents), testCSSLinkElement) {
+			t.Errorf(
+				"CSS link doesn't match\n"+
+					"Got: %s\n"+
+					"Expected: %s",
+				contents,
+				testCSSLinkElement)
+		}
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestBuildSectionError(t *testing.T) {
+	_, err := Build(
+		BookMeta{Title: testEpubTitle},
+		[]SectionInput{
+			{Body: testSectionBody, Title: testSectionTitle, Filename: testSectionFilename},
+			{Body: testSectionBody, Title: "Section 2", Filename: testSectionFilename},
+		},
+	)
+	buildErr, ok := err.(*SectionBuildError)
+	if !ok {
+		t.Fatalf("Expected error SectionBuildError not returned. Returned instead: %+v", err)
+	}
+	if buildErr.Index != 1 {
+		t.Errorf("Section index doesn't match\nGot: %d\nExpected: %d", buildErr.Index, 1)
+	}
+	if _, ok := buildErr.Err.(*FilenameAlreadyUsedError); !ok {
+		t.Errorf("Expected error FilenameAlreadyUsedError not returned. Returned instead: %+v", buildErr.Err)
+	}
+}
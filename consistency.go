@@ -0,0 +1,179 @@
+package epub
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// ValidationError is returned by Validate for each problem found in the EPUB.
+type ValidationError struct {
+	Problem string // Description of the problem
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("Invalid EPUB: %s", e.Problem)
+}
+
+// Matches a well-formed BCP 47 language tag, e.g. en, en-US, zh-Hant-TW or
+// de-CH-1996, without checking that its subtags are registered
+var languageTagRegexp = regexp.MustCompile(`(?i)^(?:(?:[a-z]{2,3}(?:-[a-z]{3}){0,3}|[a-z]{4,8})(?:-[a-z]{4})?(?:-(?:[a-z]{2}|[0-9]{3}))?(?:-(?:[a-z0-9]{5,8}|[0-9][a-z0-9]{3}))*(?:-[0-9a-wyz](?:-[a-z0-9]{2,8})+)*(?:-x(?:-[a-z0-9]{1,8})+)?|x(?:-[a-z0-9]{1,8})+)$`)
+
+// Validate checks the structure of the EPUB without writing it and returns an
+// error of type ValidationError for each problem found, or no errors if the
+// EPUB is valid. It checks that the EPUB has at least one section, that
+// everything the EPUB refers to internally (e.g. the cover image, the
+// stylesheets of the sections, and the items of the spine) exists, that the EPUB has an identifier which
+// isn't empty, and that the language is a well-formed BCP 47 language tag
+// (e.g. en or pt-BR).
+//
+// This is a quick check before Write; it doesn't replace a full validation of
+// the written EPUB, e.g. with EPUBCheck, since the content of the sections and
+// of the media isn't checked.
+func (e *Epub) Validate() []error {
+	e.Lock()
+	defer e.Unlock()
+	problems := e.referenceProblems()
+
+	if len(e.spine()) == 0 {
+		problems = append(problems, "the EPUB has no sections")
+	}
+
+	sections := map[string]bool{}
+	for _, section := range e.sections {
+		sections[section.filename] = true
+	}
+	for _, section := range e.sections {
+		for _, link := range section.xhtml.xml.Head.Links {
+			if _, ok := e.css[path.Base(link.Href)]; !ok || path.Base(path.Dir(link.Href)) != CSSFolderName {
+				problems = append(problems, fmt.Sprintf("CSS %s of section %s is not a CSS file", link.Href, section.filename))
+			}
+		}
+		if section.parent != "" && !sections[section.parent] {
+			problems = append(problems, fmt.Sprintf("parent %s of section %s is not a section", section.parent, section.filename))
+		}
+	}
+	// Items added to the spine of the package directly must be sections, since
+	// only sections are added to the manifest
+	for _, itemref := range e.Pkg.xml.Spine.Items {
+		if !sections[itemref.Idref] {
+			problems = append(problems, fmt.Sprintf("spine item %s is not a section", itemref.Idref))
+		}
+	}
+
+	metadata := e.Pkg.xml.Metadata
+	uniqueIdentifier := false
+	for _, identifier := range metadata.Identifier {
+		if strings.TrimSpace(identifier.Data) == "" {
+			problems = append(problems, fmt.Sprintf("identifier %s is empty", identifier.ID))
+		}
+		if identifier.ID == e.Pkg.xml.UniqueIdentifier {
+			uniqueIdentifier = true
+		}
+	}
+	if !uniqueIdentifier {
+		problems = append(problems, fmt.Sprintf("unique identifier %s is not an identifier", e.Pkg.xml.UniqueIdentifier))
+	}
+	if !languageTagRegexp.MatchString(metadata.Language) {
+		problems = append(problems, fmt.Sprintf("language %q is not a language tag", metadata.Language))
+	}
+
+	errs := make([]error, len(problems))
+	for i, problem := range problems {
+		errs[i] = &ValidationError{Problem: problem}
+	}
+	return errs
+}
+
+// Check that everything the EPUB refers to internally (the cover, the start of
+// the body, global CSS, etc) still exists, e.g. after sections have been
+// removed or reordered
+func (e *Epub) checkReferences() error {
+	problems := e.referenceProblems()
+	if len(problems) > 0 {
+		return &InconsistentEpubError{Problems: problems}
+	}
+	return nil
+}
+
+// Get a description of each problem found by checkReferences
+func (e *Epub) referenceProblems() []string {
+	problems := []string{}
+
+	sections := map[string]bool{}
+	for _, section := range e.sections {
+		if sections[section.filename] {
+			problems = append(problems, fmt.Sprintf("section %s was added more than once", section.filename))
+		}
+		sections[section.filename] = true
+	}
+
+	if e.cover.xhtmlFilename != "" {
+		if !sections[e.cover.xhtmlFilename] {
+			problems = append(problems, fmt.Sprintf("cover page %s is not a section", e.cover.xhtmlFilename))
+		}
+		if _, ok := e.images[e.cover.imageFilename]; !ok {
+			problems = append(problems, fmt.Sprintf("cover image %s is not an image", e.cover.imageFilename))
+		}
+		if _, ok := e.css[e.cover.cssFilename]; !ok {
+			problems = append(problems, fmt.Sprintf("cover CSS %s is not a CSS file", e.cover.cssFilename))
+		}
+	}
+
+	if e.backCover.xhtmlFilename != "" {
+		if !sections[e.backCover.xhtmlFilename] {
+			problems = append(problems, fmt.Sprintf("back cover page %s is not a section", e.backCover.xhtmlFilename))
+		}
+		if _, ok := e.images[e.backCover.imageFilename]; !ok {
+			problems = append(problems, fmt.Sprintf("back cover image %s is not an image", e.backCover.imageFilename))
+		}
+		if _, ok := e.css[e.backCover.cssFilename]; !ok {
+			problems = append(problems, fmt.Sprintf("back cover CSS %s is not a CSS file", e.backCover.cssFilename))
+		}
+	}
+
+	if e.bodyStart != "" && !sections[e.bodyStart] {
+		problems = append(problems, fmt.Sprintf("start of the body %s is not a section", e.bodyStart))
+	}
+
+	for _, cssPath := range e.globalCSS {
+		if _, ok := e.css[path.Base(cssPath)]; !ok {
+			problems = append(problems, fmt.Sprintf("global CSS %s is not a CSS file", cssPath))
+		}
+	}
+
+	return problems
+}
+
+// Check that every item of the spine is in the manifest of the package file,
+// and every section is in both
+func (e *Epub) checkPackage() error {
+	problems := []string{}
+
+	manifest := map[string]bool{}
+	for _, item := range e.Pkg.xml.ManifestItems {
+		manifest[item.ID] = true
+	}
+	spine := map[string]bool{}
+	for _, itemref := range e.Pkg.xml.Spine.Items {
+		spine[itemref.Idref] = true
+		if !manifest[itemref.Idref] {
+			problems = append(problems, fmt.Sprintf("spine item %s is not in the manifest", itemref.Idref))
+		}
+	}
+
+	for _, section := range e.sections {
+		if !manifest[section.filename] {
+			problems = append(problems, fmt.Sprintf("section %s is not in the manifest", section.filename))
+		}
+		if !spine[section.filename] {
+			problems = append(problems, fmt.Sprintf("section %s is not in the spine", section.filename))
+		}
+	}
+
+	if len(problems) > 0 {
+		return &InconsistentEpubError{Problems: problems}
+	}
+	return nil
+}
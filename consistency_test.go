@@ -0,0 +1,103 @@
+package epub
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestInconsistentEpubError(t *testing.T) {
+	t.Run("RemovedCover", func(t *testing.T) {
+		e := NewEpub(testEpubTitle)
+		testImagePath, _ := e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+		e.SetCover(testImagePath, "")
+		e.AddSection(testSectionBody, testSectionTitle, "", "")
+		// Remove the cover page, which is still referenced as the cover
+		e.sections = e.sections[1:]
+
+		testInconsistentEpubError(t, e)
+	})
+	t.Run("RemovedBodyStart", func(t *testing.T) {
+		e := NewEpub(testEpubTitle)
+		testSectionPath, _ := e.AddSection(testSectionBody, testSectionTitle, "", "")
+		e.SetBodyStart(testSectionPath)
+		e.sections = nil
+
+		testInconsistentEpubError(t, e)
+	})
+	t.Run("SpineItemNotInManifest", func(t *testing.T) {
+		e := NewEpub(testEpubTitle)
+		e.AddSection(testSectionBody, testSectionTitle, "", "")
+		e.Pkg.AddToSpine("removed.xhtml")
+
+		testInconsistentEpubError(t, e)
+	})
+}
+
+func testInconsistentEpubError(t *testing.T, e *Epub) {
+	var b bytes.Buffer
+	_, err := e.WriteTo(&b)
+	if _, ok := err.(*InconsistentEpubError); !ok {
+		t.Errorf("Expected error InconsistentEpubError not returned. Returned instead: %+v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	testCSSPath, _ := e.AddCSS(testCoverCSSSource, testCoverCSSFilename)
+	testImagePath, _ := e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+	e.SetCover(testImagePath, "")
+	e.AddSection(testSectionBody, testSectionTitle, testSectionFilename, testCSSPath)
+	if errs := e.Validate(); len(errs) != 0 {
+		t.Errorf("Unexpected errors validating EPUB: %v", errs)
+	}
+
+	e.Pkg.SetLang("en-US")
+	if errs := e.Validate(); len(errs) != 0 {
+		t.Errorf("Unexpected errors validating EPUB with a region: %v", errs)
+	}
+
+	// Break the EPUB in every way Validate checks
+	delete(e.images, testImageFromFileFilename)
+	delete(e.css, testCoverCSSFilename)
+	e.Pkg.AddToSpine("removed.xhtml")
+	e.Pkg.xml.Metadata.Identifier[0].Data = " "
+	e.Pkg.SetLang("en_US")
+	errs := e.Validate()
+	testProblems := []string{
+		"cover image " + testImageFromFileFilename + " is not an image",
+		"CSS " + testCSSPath + " of section " + testSectionFilename + " is not a CSS file",
+		"spine item removed.xhtml is not a section",
+		"identifier pub-id is empty",
+		`language "en_US" is not a language tag`,
+	}
+	if len(errs) != len(testProblems) {
+		t.Fatalf("Unexpected number of errors\nGot: %v\nExpected: %v", errs, testProblems)
+	}
+	for i, err := range errs {
+		validationErr, ok := err.(*ValidationError)
+		if !ok {
+			t.Errorf("Expected error ValidationError not returned. Returned instead: %+v", err)
+			continue
+		}
+		if validationErr.Problem != testProblems[i] {
+			t.Errorf("Problem doesn't match\nGot: %s\nExpected: %s", validationErr.Problem, testProblems[i])
+		}
+	}
+
+	e = NewEpub(testEpubTitle)
+	errs = e.Validate()
+	if len(errs) != 1 || errs[0].(*ValidationError).Problem != "the EPUB has no sections" {
+		t.Errorf("Expected error for an EPUB without sections not returned. Returned instead: %v", errs)
+	}
+
+	for _, lang := range []string{"en", "pt-BR", "zh-Hant-TW", "de-CH-1996", "es-419", "x-klingon"} {
+		if !languageTagRegexp.MatchString(lang) {
+			t.Errorf("Language tag %s should be valid", lang)
+		}
+	}
+	for _, lang := range []string{"", "e", "en_US", "en-", "english language"} {
+		if languageTagRegexp.MatchString(lang) {
+			t.Errorf("Language tag %q should be invalid", lang)
+		}
+	}
+}
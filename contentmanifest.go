@@ -0,0 +1,115 @@
+package epub
+
+import (
+	"encoding/json"
+	"mime"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/vincent-petithory/dataurl"
+)
+
+// Resource kinds used in the content manifest
+const (
+	contentManifestKindCSS     = "css"
+	contentManifestKindFont    = "font"
+	contentManifestKindImage   = "image"
+	contentManifestKindVideo   = "video"
+	contentManifestKindAudio   = "audio"
+	contentManifestKindScript  = "script"
+	contentManifestKindSection = "section"
+)
+
+// contentManifest lists the contents of the EPUB for external tooling; it
+// isn't part of the EPUB itself
+type contentManifest struct {
+	Resources []contentManifestResource `json:"resources"`
+	// Filenames of the sections in reading order
+	Spine []string `json:"spine"`
+}
+
+type contentManifestResource struct {
+	Kind string `json:"kind"`
+	// Path of the resource relative to the sections, as returned by AddCSS,
+	// AddSection, etc
+	Path      string `json:"path"`
+	Source    string `json:"source,omitempty"`
+	MediaType string `json:"mediaType,omitempty"`
+	// Size in bytes, if it can be determined without retrieving the source
+	Size int64 `json:"size,omitempty"`
+}
+
+// ContentManifest returns a JSON document listing every resource (CSS files,
+// fonts, images, videos, audio files, scripts, and sections) added to the EPUB
+// along with the reading order of the sections. It is meant for build tooling
+// (e.g. caching or auditing) and isn't added to the EPUB.
+//
+// The media type and size of a resource are only included if they can be
+// determined without retrieving the resource, e.g. the size of a remote file
+// isn't known until the EPUB is written. The source of a resource is omitted if
+// it's a data URL, which would include the whole resource.
+func (e *Epub) ContentManifest() ([]byte, error) {
+	e.Lock()
+	defer e.Unlock()
+	m := contentManifest{
+		Resources: []contentManifestResource{},
+		Spine:     e.spine(),
+	}
+
+	g := e.newGrabber()
+	for _, media := range []struct {
+		kind       string
+		folderName string
+		mediaMap   map[string]string
+	}{
+		{contentManifestKindCSS, CSSFolderName, e.css},
+		{contentManifestKindFont, FontFolderName, e.fonts},
+		{contentManifestKindImage, ImageFolderName, e.images},
+		{contentManifestKindVideo, VideoFolderName, e.videos},
+		{contentManifestKindAudio, AudioFolderName, e.audios},
+		{contentManifestKindScript, ScriptFolderName, e.scripts},
+	} {
+		filenames := make([]string, 0, len(media.mediaMap))
+		for filename := range media.mediaMap {
+			filenames = append(filenames, filename)
+		}
+		sort.Strings(filenames)
+
+		for _, filename := range filenames {
+			source := media.mediaMap[filename]
+			r := contentManifestResource{
+				Kind:      media.kind,
+				Path:      path.Join("..", media.folderName, filename),
+				MediaType: mime.TypeByExtension(filepath.Ext(filename)),
+			}
+			if media.kind == contentManifestKindCSS {
+				r.MediaType = mediaTypeCSS
+			}
+			if isDataURL(source) {
+				if d, err := dataurl.DecodeString(source); err == nil {
+					r.Size = int64(len(d.Data))
+					if r.MediaType == "" {
+						r.MediaType = d.ContentType()
+					}
+				}
+			} else {
+				r.Source = source
+				if size, err := g.localSize(source); err == nil {
+					r.Size = size
+				}
+			}
+			m.Resources = append(m.Resources, r)
+		}
+	}
+
+	for _, section := range e.sections {
+		m.Resources = append(m.Resources, contentManifestResource{
+			Kind:      contentManifestKindSection,
+			Path:      section.filename,
+			MediaType: mediaTypeXhtml,
+		})
+	}
+
+	return json.MarshalIndent(m, "", "  ")
+}
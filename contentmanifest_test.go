@@ -0,0 +1,50 @@
+package epub
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/vincent-petithory/dataurl"
+)
+
+func TestContentManifest(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	testCSSPath, _ := e.AddCSS(testCoverCSSSource, testCoverCSSFilename)
+	testImagePath, _ := e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+	testFontPath, _ := e.AddFont(dataurl.EncodeBytes([]byte("font")), "font.ttf")
+	testSectionPath, _ := e.AddSection(testSectionBody, testSectionTitle, "", testCSSPath)
+	e.SetCover(testImagePath, testCSSPath)
+
+	output, err := e.ContentManifest()
+	if err != nil {
+		t.Errorf("Error generating content manifest: %s", err)
+	}
+
+	var m contentManifest
+	if err := json.Unmarshal(output, &m); err != nil {
+		t.Errorf("Content manifest isn't valid JSON: %s\n%s", err, output)
+	}
+
+	if len(m.Spine) != 2 || m.Spine[0] != defaultCoverXhtmlFilename || m.Spine[1] != testSectionPath {
+		t.Errorf("Unexpected spine in content manifest: %v", m.Spine)
+	}
+
+	resources := map[string]contentManifestResource{}
+	for _, r := range m.Resources {
+		resources[r.Path] = r
+	}
+	if len(resources) != 5 {
+		t.Errorf("Unexpected resources in content manifest: %+v", m.Resources)
+	}
+	image := resources[testImagePath]
+	if image.Kind != contentManifestKindImage || image.Source != testImageFromFileSource || image.MediaType != "image/png" || image.Size == 0 {
+		t.Errorf("Unexpected image resource in content manifest: %+v", image)
+	}
+	// Data URLs aren't included, since they contain the whole resource
+	if font := resources[testFontPath]; font.Source != "" || font.Size != 4 {
+		t.Errorf("Unexpected font resource in content manifest: %+v", font)
+	}
+	if resources[testCSSPath].MediaType != mediaTypeCSS {
+		t.Errorf("Unexpected CSS resource in content manifest: %+v", resources[testCSSPath])
+	}
+}
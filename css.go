@@ -0,0 +1,178 @@
+package epub
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bmaupin/go-epub/internal/storage"
+)
+
+// A rule, declaration, statement (e.g. @import), or comment of a CSS file
+type cssNode struct {
+	text     string // Selector of a rule, otherwise the whole node
+	comment  bool
+	block    bool
+	children []*cssNode
+}
+
+// Normalize the CSS file at the given path, see normalizeCSS
+func normalizeCSSFile(fsys storage.Storage, cssFilePath string) error {
+	content, err := storage.ReadFile(fsys, cssFilePath)
+	if err != nil {
+		return fmt.Errorf("unable to read CSS file: %w", err)
+	}
+	content = []byte(normalizeCSS(string(content)))
+	if err := fsys.WriteFile(cssFilePath, content, filePermissions); err != nil {
+		return fmt.Errorf("unable to write CSS file: %w", err)
+	}
+	return nil
+}
+
+// Rewrite CSS with consistent whitespace and one rule per line. Blocks which
+// contain rules (e.g. @media) span multiple lines with their rules indented.
+// Strings and comments are kept as they are.
+func normalizeCSS(css string) string {
+	nodes, _ := parseCSS(css, 0, false)
+	var b strings.Builder
+	writeCSS(&b, nodes, "")
+	return b.String()
+}
+
+// Parse CSS starting at index i until the end of the current block and return
+// the nodes along with the index after the block
+func parseCSS(css string, i int, inBlock bool) ([]*cssNode, int) {
+	nodes := []*cssNode{}
+	var b strings.Builder
+	lastSpace := false
+	// Parentheses can contain semicolons, e.g. in url(data:image/png;base64,...)
+	parens := 0
+	flush := func() {
+		text := strings.TrimSpace(b.String())
+		b.Reset()
+		if text == "" {
+			return
+		}
+		// Declarations are written as "property: value"
+		if colon := strings.Index(text, ":"); inBlock && colon > 0 && !strings.ContainsAny(text[:colon], `"'(`) {
+			text = strings.TrimSpace(text[:colon]) + ": " + strings.TrimSpace(text[colon+1:])
+		}
+		nodes = append(nodes, &cssNode{text: text})
+	}
+
+	for i < len(css) {
+		c := css[i]
+		switch {
+		case c == '"' || c == '\'':
+			end := i + 1
+			for end < len(css) && css[end] != c {
+				if css[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end < len(css) {
+				end++
+			} else {
+				end = len(css)
+			}
+			b.WriteString(css[i:end])
+			lastSpace = false
+			i = end
+			continue
+		case c == '/' && strings.HasPrefix(css[i:], "/*"):
+			end := strings.Index(css[i+2:], "*/")
+			if end == -1 {
+				end = len(css)
+			} else {
+				end = i + 2 + end + 2
+			}
+			if strings.TrimSpace(b.String()) == "" {
+				// Comments between nodes are nodes of their own
+				b.Reset()
+				nodes = append(nodes, &cssNode{text: css[i:end], comment: true})
+			} else {
+				b.WriteString(css[i:end])
+				lastSpace = false
+			}
+			i = end
+			continue
+		case c == '(' || c == ')':
+			if c == '(' {
+				parens++
+			} else if parens > 0 {
+				parens--
+			}
+			b.WriteByte(c)
+			lastSpace = false
+		case parens > 0 && (c == ';' || c == '{' || c == '}'):
+			b.WriteByte(c)
+			lastSpace = false
+		case c == ';':
+			flush()
+		case c == '{':
+			selector := strings.TrimSpace(b.String())
+			b.Reset()
+			children, end := parseCSS(css, i+1, true)
+			nodes = append(nodes, &cssNode{text: selector, block: true, children: children})
+			lastSpace = false
+			i = end
+			continue
+		case c == '}':
+			flush()
+			return nodes, i + 1
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			if !lastSpace {
+				b.WriteByte(' ')
+				lastSpace = true
+			}
+		default:
+			b.WriteByte(c)
+			lastSpace = false
+		}
+		i++
+	}
+	flush()
+
+	return nodes, i
+}
+
+// Write CSS nodes, one per line
+func writeCSS(b *strings.Builder, nodes []*cssNode, indent string) {
+	for _, n := range nodes {
+		b.WriteString(indent)
+		switch {
+		case !n.block:
+			writeCSSNode(b, n)
+		case hasCSSBlocks(n.children):
+			b.WriteString(n.text + " {\n")
+			writeCSS(b, n.children, indent+"  ")
+			b.WriteString(indent + "}")
+		default:
+			b.WriteString(n.text + " {")
+			for _, child := range n.children {
+				b.WriteString(" ")
+				writeCSSNode(b, child)
+			}
+			b.WriteString(" }")
+		}
+		b.WriteString("\n")
+	}
+}
+
+// Write a declaration, statement, or comment
+func writeCSSNode(b *strings.Builder, n *cssNode) {
+	b.WriteString(n.text)
+	if !n.comment {
+		b.WriteString(";")
+	}
+}
+
+// Check whether any of the nodes is a block, e.g. the rules of @media
+func hasCSSBlocks(nodes []*cssNode) bool {
+	for _, n := range nodes {
+		if n.block {
+			return true
+		}
+	}
+	return false
+}
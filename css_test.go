@@ -0,0 +1,63 @@
+package epub
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/bmaupin/go-epub/internal/storage"
+	"github.com/vincent-petithory/dataurl"
+)
+
+func Test_normalizeCSS(t *testing.T) {
+	tests := []struct {
+		name string
+		css  string
+		want string
+	}{
+		{"single line", "body{margin:0;color : red}p{text-indent:1em}", "body { margin: 0; color: red; }\np { text-indent: 1em; }\n"},
+		{"whitespace", "h1 ,\n\th2   {\n  font-weight :  bold ;\n}\n", "h1 , h2 { font-weight: bold; }\n"},
+		{"strings", `p::before{content:"a  {b};  c"}`, "p::before { content: \"a  {b};  c\"; }\n"},
+		{"urls", `@import url("style.css");div{background:url(data:image/png;base64,AAAA)}`, "@import url(\"style.css\");\ndiv { background: url(data:image/png;base64,AAAA); }\n"},
+		{"comments", "/* Headings */\nh1{color:red/* red */}", "/* Headings */\nh1 { color: red/* red */; }\n"},
+		{"nested blocks", "@media print{body{margin:0}a:hover{color:black}}", "@media print {\n  body { margin: 0; }\n  a:hover { color: black; }\n}\n"},
+		{"empty rule", "p{}", "p { }\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeCSS(tt.css); got != tt.want {
+				t.Errorf("normalizeCSS() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetNormalizeCSS(t *testing.T) {
+	testCSSContent := "body{color:red}p{margin:0}"
+	for _, normalize := range []bool{true, false} {
+		e := NewEpub(testEpubTitle)
+		e.SetNormalizeCSS(normalize)
+		testCSSPath, _ := e.AddCSS(dataurl.EncodeBytes([]byte(testCSSContent)), "epub.css")
+
+		tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+		contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, testCSSPath))
+		if err != nil {
+			t.Errorf("Unexpected error reading CSS file: %s", err)
+		}
+		want := testCSSContent
+		if normalize {
+			want = "body { color: red; }\np { margin: 0; }\n"
+		}
+		if string(contents) != want {
+			t.Errorf(
+				"CSS file contents don't match when normalizing is %v\n"+
+					"Got: %s\n"+
+					"Expected: %s",
+				normalize,
+				contents,
+				want)
+		}
+
+		cleanup(testEpubFilename, tempDir)
+	}
+}
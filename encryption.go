@@ -0,0 +1,236 @@
+package epub
+
+import (
+	"crypto/sha1"
+	"encoding/xml"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bmaupin/go-epub/internal/storage"
+)
+
+// Algorithms for AddEncryptedResource,
+// see https://www.w3.org/TR/xmlenc-core1/#sec-Alg-Block
+const (
+	EncryptionAlgorithmAES128CBC = "http://www.w3.org/2001/04/xmlenc#aes128-cbc"
+	EncryptionAlgorithmAES256CBC = "http://www.w3.org/2001/04/xmlenc#aes256-cbc"
+)
+
+const (
+	encryptionFilename = "encryption.xml"
+	// Number of bytes at the beginning of a font which are obfuscated
+	fontObfuscationLength = 1040
+	// See https://www.w3.org/publishing/epub3/epub-ocf.html#sec-font-obfuscation
+	fontObfuscationAlgorithm = "http://www.idpf.org/2008/embedding"
+	xmlnsContainer           = "urn:oasis:names:tc:opendocument:xmlns:container"
+	xmlnsEnc                 = "http://www.w3.org/2001/04/xmlenc#"
+)
+
+// An encrypted resource, which is encrypted when the EPUB is written
+type encryptedResource struct {
+	encrypt   func([]byte) ([]byte, error)
+	algorithm string
+}
+
+// encryptionRoot is the root element of META-INF/encryption.xml
+// Spec: https://www.w3.org/publishing/epub3/epub-ocf.html#sec-container-metainf-encryption.xml
+type encryptionRoot struct {
+	XMLName       xml.Name                  `xml:"encryption"`
+	Xmlns         string                    `xml:"xmlns,attr"`
+	XmlnsEnc      string                    `xml:"xmlns:enc,attr"`
+	EncryptedData []encryptionEncryptedData `xml:"enc:EncryptedData"`
+}
+
+type encryptionEncryptedData struct {
+	EncryptionMethod encryptionMethod          `xml:"enc:EncryptionMethod"`
+	CipherReference  encryptionCipherReference `xml:"enc:CipherData>enc:CipherReference"`
+}
+
+type encryptionMethod struct {
+	Algorithm string `xml:"Algorithm,attr"`
+}
+
+type encryptionCipherReference struct {
+	URI string `xml:"URI,attr"`
+}
+
+// AddEncryptedResource adds a resource to the EPUB which is encrypted with the
+// provided function when the EPUB is written, and declared in
+// META-INF/encryption.xml along with the algorithm used. It returns a relative
+// path to the resource that can be used in EPUB sections in the format:
+// ../EncryptedFolderName/internalFilename
+//
+// The source should either be a URL, a path to a local file, or an embedded
+// data URL. The media type in the package file is detected from the
+// unencrypted content.
+//
+// The algorithm is the URI identifying the encryption algorithm, e.g.
+// EncryptionAlgorithmAES128CBC. If it's empty or the encrypt function is nil,
+// InvalidValueError will be returned.
+//
+// The internal filename will be used when storing the resource in the EPUB
+// and must be unique among all encrypted resources. If the same filename is
+// used more than once, FilenameAlreadyUsedError will be returned. The internal
+// filename is optional; if no filename is provided, one will be generated.
+func (e *Epub) AddEncryptedResource(source string, internalFilename string, encrypt func([]byte) ([]byte, error), algorithm string) (string, error) {
+	e.Lock()
+	defer e.Unlock()
+	if err := e.checkManifestItems(1); err != nil {
+		return "", err
+	}
+	if encrypt == nil {
+		return "", &InvalidValueError{Name: "encrypt", Value: "nil"}
+	}
+	if algorithm == "" {
+		return "", &InvalidValueError{Name: "algorithm", Value: algorithm}
+	}
+
+	resourcePath, err := e.addMedia(source, internalFilename, encryptedFileFormat, EncryptedFolderName, e.encrypted)
+	if err != nil {
+		return "", err
+	}
+	e.encryption[path.Base(resourcePath)] = encryptedResource{
+		encrypt:   encrypt,
+		algorithm: algorithm,
+	}
+
+	return resourcePath, nil
+}
+
+// AddObfuscatedFont adds a font file to the EPUB like AddFont, but the font is
+// obfuscated with the IDPF font obfuscation algorithm when the EPUB is written,
+// so it can't simply be extracted and installed, as required by the licenses
+// of many commercial typefaces. The obfuscation is keyed off the unique
+// identifier of the EPUB and declared in META-INF/encryption.xml; reading
+// systems remove it when they render the font.
+//
+// The returned path can be used like the one returned by AddFont.
+func (e *Epub) AddObfuscatedFont(source string, internalFilename string) (string, error) {
+	e.Lock()
+	defer e.Unlock()
+	if err := e.checkManifestItems(1); err != nil {
+		return "", err
+	}
+	fontPath, err := e.addMedia(source, internalFilename, fontFileFormat, FontFolderName, e.fonts)
+	if err != nil {
+		return "", err
+	}
+	e.obfuscatedFonts[path.Base(fontPath)] = true
+
+	return fontPath, nil
+}
+
+// Obfuscate the beginning of a font by XORing it with the SHA-1 hash of the
+// unique identifier of the EPUB, without whitespace. Obfuscating the font again
+// restores it.
+func obfuscateFont(content []byte, uniqueIdentifier string) []byte {
+	key := sha1.Sum([]byte(strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\r', '\n':
+			return -1
+		}
+		return r
+	}, uniqueIdentifier)))
+
+	obfuscated := append([]byte(nil), content...)
+	for i := 0; i < len(obfuscated) && i < fontObfuscationLength; i++ {
+		obfuscated[i] ^= key[i%len(key)]
+	}
+	return obfuscated
+}
+
+// Get the encrypted resources from their source, encrypt them in the temporary
+// directory, obfuscate the fonts which have to be and write the encryption file
+func (e *Epub) writeEncryptedResources(rootEpubDir string) error {
+	if len(e.encrypted) == 0 && len(e.obfuscatedFonts) == 0 {
+		return nil
+	}
+
+	err := e.writeMedia(rootEpubDir, e.encrypted, EncryptedFolderName)
+	if err != nil {
+		return err
+	}
+
+	encryptionXML := &encryptionRoot{
+		Xmlns:    xmlnsContainer,
+		XmlnsEnc: xmlnsEnc,
+	}
+	// Sort the resources so the encryption file is the same every time
+	resourceFilenames := make([]string, 0, len(e.encrypted))
+	for resourceFilename := range e.encrypted {
+		resourceFilenames = append(resourceFilenames, resourceFilename)
+	}
+	sort.Strings(resourceFilenames)
+
+	for _, resourceFilename := range resourceFilenames {
+		resource := e.encryption[resourceFilename]
+		resourcePath := filepath.Join(rootEpubDir, contentFolderName, EncryptedFolderName, resourceFilename)
+		content, err := storage.ReadFile(e.stagingStorage(), resourcePath)
+		if err != nil {
+			return fmt.Errorf("unable to read encrypted resource: %w", err)
+		}
+		content, err = resource.encrypt(content)
+		if err != nil {
+			return fmt.Errorf("unable to encrypt %s: %w", resourceFilename, err)
+		}
+		if err := e.stagingStorage().WriteFile(resourcePath, content, filePermissions); err != nil {
+			return fmt.Errorf("unable to write encrypted resource: %w", err)
+		}
+
+		encryptionXML.EncryptedData = append(encryptionXML.EncryptedData, encryptionEncryptedData{
+			EncryptionMethod: encryptionMethod{Algorithm: resource.algorithm},
+			CipherReference: encryptionCipherReference{
+				URI: path.Join(contentFolderName, EncryptedFolderName, resourceFilename),
+			},
+		})
+	}
+
+	// The fonts have already been written with the other fonts
+	fontFilenames := make([]string, 0, len(e.obfuscatedFonts))
+	for fontFilename := range e.obfuscatedFonts {
+		if _, ok := e.fonts[fontFilename]; ok {
+			fontFilenames = append(fontFilenames, fontFilename)
+		}
+	}
+	sort.Strings(fontFilenames)
+
+	for _, fontFilename := range fontFilenames {
+		fontPath := filepath.Join(rootEpubDir, contentFolderName, FontFolderName, fontFilename)
+		content, err := storage.ReadFile(e.stagingStorage(), fontPath)
+		if err != nil {
+			return fmt.Errorf("unable to read obfuscated font: %w", err)
+		}
+		content = obfuscateFont(content, e.Pkg.uniqueIdentifier())
+		if err := e.stagingStorage().WriteFile(fontPath, content, filePermissions); err != nil {
+			return fmt.Errorf("unable to write obfuscated font: %w", err)
+		}
+
+		encryptionXML.EncryptedData = append(encryptionXML.EncryptedData, encryptionEncryptedData{
+			EncryptionMethod: encryptionMethod{Algorithm: fontObfuscationAlgorithm},
+			CipherReference: encryptionCipherReference{
+				URI: path.Join(contentFolderName, FontFolderName, fontFilename),
+			},
+		})
+	}
+
+	encryptionFileContent, err := xml.MarshalIndent(encryptionXML, "", "  ")
+	if err != nil {
+		panic(fmt.Sprintf(
+			"Error marshalling XML for encryption file: %s\n"+
+				"\tXML=%#v",
+			err,
+			encryptionXML))
+	}
+	encryptionFileContent = append([]byte(xml.Header), encryptionFileContent...)
+	encryptionFileContent = append(encryptionFileContent, "\n"...)
+
+	encryptionFilePath := filepath.Join(rootEpubDir, metaInfFolderName, encryptionFilename)
+	if err := e.stagingStorage().WriteFile(encryptionFilePath, encryptionFileContent, filePermissions); err != nil {
+		return fmt.Errorf("unable to write encryption file: %w", err)
+	}
+
+	return nil
+}
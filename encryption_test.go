@@ -0,0 +1,186 @@
+package epub
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bmaupin/go-epub/internal/storage"
+)
+
+// Not real encryption, but easy to reverse in tests
+func xorEncrypt(content []byte) ([]byte, error) {
+	encrypted := make([]byte, len(content))
+	for i := range content {
+		encrypted[i] = content[i] ^ 0x5a
+	}
+	return encrypted, nil
+}
+
+func TestAddEncryptedResource(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+
+	_, err := e.AddEncryptedResource(testImageFromFileSource, "", nil, EncryptionAlgorithmAES128CBC)
+	if _, ok := err.(*InvalidValueError); !ok {
+		t.Errorf("Expected error InvalidValueError not returned. Returned instead: %+v", err)
+	}
+	_, err = e.AddEncryptedResource(testImageFromFileSource, "", xorEncrypt, "")
+	if _, ok := err.(*InvalidValueError); !ok {
+		t.Errorf("Expected error InvalidValueError not returned. Returned instead: %+v", err)
+	}
+
+	testResourcePath, err := e.AddEncryptedResource(testImageFromFileSource, "secret.png", xorEncrypt, EncryptionAlgorithmAES128CBC)
+	if err != nil {
+		t.Errorf("Error adding encrypted resource: %s", err)
+	}
+	if testResourcePath != "../encrypted/secret.png" {
+		t.Errorf(
+			"Encrypted resource path doesn't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			testResourcePath,
+			"../encrypted/secret.png")
+	}
+	_, err = e.AddEncryptedResource(testImageFromFileSource, "secret.png", xorEncrypt, EncryptionAlgorithmAES128CBC)
+	if _, ok := err.(*FilenameAlreadyUsedError); !ok {
+		t.Errorf("Expected error FilenameAlreadyUsedError not returned. Returned instead: %+v", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	encryptionFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, metaInfFolderName, encryptionFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading encryption file: %s", err)
+	}
+	testEncryptedData := `<enc:EncryptedData>
+    <enc:EncryptionMethod Algorithm="http://www.w3.org/2001/04/xmlenc#aes128-cbc"></enc:EncryptionMethod>
+    <enc:CipherData>
+      <enc:CipherReference URI="EPUB/encrypted/secret.png"></enc:CipherReference>
+    </enc:CipherData>
+  </enc:EncryptedData>`
+	if !strings.Contains(string(encryptionFileContent), testEncryptedData) {
+		t.Errorf(
+			"Encryption file doesn't contain encrypted data\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			encryptionFileContent,
+			testEncryptedData)
+	}
+
+	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	testManifestItem := `<item id="secret.png" href="encrypted/secret.png" media-type="image/png"></item>`
+	if !strings.Contains(string(pkgFileContent), testManifestItem) {
+		t.Errorf(
+			"Package file doesn't contain encrypted resource\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			pkgFileContent,
+			testManifestItem)
+	}
+
+	resourceContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, EncryptedFolderName, "secret.png"))
+	if err != nil {
+		t.Errorf("Unexpected error reading encrypted resource: %s", err)
+	}
+	testResourceContent, err := os.ReadFile(testImageFromFileSource)
+	if err != nil {
+		t.Errorf("Unexpected error reading resource source: %s", err)
+	}
+	decryptedContent, _ := xorEncrypt(resourceContent)
+	if !bytes.Equal(decryptedContent, testResourceContent) {
+		t.Error("Encrypted resource doesn't match the encrypted source")
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestAddEncryptedResourceError(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	testErr := errors.New("no key")
+	e.AddEncryptedResource(testImageFromFileSource, "", func([]byte) ([]byte, error) {
+		return nil, testErr
+	}, EncryptionAlgorithmAES256CBC)
+
+	var b bytes.Buffer
+	_, err := e.WriteTo(&b)
+	if !errors.Is(err, testErr) {
+		t.Errorf("Expected encryption error not returned. Returned instead: %+v", err)
+	}
+}
+
+func TestAddObfuscatedFont(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	// Whitespace in the identifier is ignored by the obfuscation
+	e.Pkg.xml.Metadata.Identifier[0].Data = "urn:uuid: 8f6a7e5c-3b1d-4f3e-9a3c-2d6b5e4f1a0b\n"
+	testFontPath, err := e.AddObfuscatedFont(testFontFromFileSource, "font.ttf")
+	if err != nil {
+		t.Fatalf("Error adding font: %s", err)
+	}
+	if testFontPath != "../fonts/font.ttf" {
+		t.Errorf("Font path doesn't match\nGot: %s\nExpected: %s", testFontPath, "../fonts/font.ttf")
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	encryptionFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, metaInfFolderName, encryptionFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading encryption file: %s", err)
+	}
+	testEncryptedData := `<enc:EncryptedData>
+    <enc:EncryptionMethod Algorithm="http://www.idpf.org/2008/embedding"></enc:EncryptionMethod>
+    <enc:CipherData>
+      <enc:CipherReference URI="EPUB/fonts/font.ttf"></enc:CipherReference>
+    </enc:CipherData>
+  </enc:EncryptedData>`
+	if !strings.Contains(string(encryptionFileContent), testEncryptedData) {
+		t.Errorf(
+			"Encryption file doesn't contain obfuscated font\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			encryptionFileContent,
+			testEncryptedData)
+	}
+
+	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	testManifestItem := `<item id="font.ttf" href="fonts/font.ttf" media-type="font/ttf"></item>`
+	if !strings.Contains(string(pkgFileContent), testManifestItem) {
+		t.Errorf(
+			"Package file doesn't contain obfuscated font\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			pkgFileContent,
+			testManifestItem)
+	}
+
+	fontContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, FontFolderName, "font.ttf"))
+	if err != nil {
+		t.Errorf("Unexpected error reading obfuscated font: %s", err)
+	}
+	testFontContent, err := os.ReadFile(testFontFromFileSource)
+	if err != nil {
+		t.Errorf("Unexpected error reading font source: %s", err)
+	}
+	// The key is the SHA-1 hash of the identifier without whitespace
+	key := sha1.Sum([]byte("urn:uuid:8f6a7e5c-3b1d-4f3e-9a3c-2d6b5e4f1a0b"))
+	for i := range fontContent {
+		expected := testFontContent[i]
+		if i < 1040 {
+			expected ^= key[i%len(key)]
+		}
+		if fontContent[i] != expected {
+			t.Fatalf("Obfuscated font doesn't match at byte %d\nGot: %x\nExpected: %x", i, fontContent[i], expected)
+		}
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
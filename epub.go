@@ -26,15 +26,32 @@ Basic usage:
 package epub
 
 import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/sha256"
 	"fmt"
+	"html"
+	"image"
+	// Decoders for the cover image formats supported by SetFixedLayoutFromCover
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
 	"io/fs"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/bmaupin/go-epub/internal/storage"
+	"github.com/gabriel-vasile/mimetype"
 	// TODO: Eventually this should include the major version (e.g. github.com/gofrs/uuid/v3) but that would break
 	// compatibility with Go < 1.9 (https://github.com/golang/go/wiki/Modules#semantic-import-versioning)
 	"github.com/gofrs/uuid"
@@ -51,6 +68,16 @@ func (e *FilenameAlreadyUsedError) Error() string {
 	return fmt.Sprintf("Filename already used: %s", e.Filename)
 }
 
+// FilenameNotFoundError is thrown by ReplaceSection or SetBodyStart if no file with the given
+// filename has been added.
+type FilenameNotFoundError struct {
+	Filename string // Filename that caused the error
+}
+
+func (e *FilenameNotFoundError) Error() string {
+	return fmt.Sprintf("Filename not found: %s", e.Filename)
+}
+
 // FileRetrievalError is thrown by AddCSS, AddFont, AddImage, or Write if there was a
 // problem retrieving the source file that was provided.
 type FileRetrievalError struct {
@@ -62,17 +89,130 @@ func (e *FileRetrievalError) Error() string {
 	return fmt.Sprintf("Error retrieving %q from source: %+v", e.Source, e.Err)
 }
 
+// SpineIndexOutOfRangeError is thrown by SetCoverSpineIndex if the index given
+// is outside of the range of the spine.
+type SpineIndexOutOfRangeError struct {
+	Index int // The index that caused the error
+	Max   int // The highest index that would have been accepted
+}
+
+func (e *SpineIndexOutOfRangeError) Error() string {
+	return fmt.Sprintf("Spine index %d out of range [0, %d]", e.Index, e.Max)
+}
+
+// InvalidSectionOrderError is thrown by SetSectionOrder if the filenames given
+// aren't the filenames of the sections of the EPUB in some order.
+type InvalidSectionOrderError struct {
+	Missing   []string // Filenames of sections which weren't given
+	Unknown   []string // Filenames given which don't belong to any section
+	Duplicate []string // Filenames given more than once
+}
+
+func (e *InvalidSectionOrderError) Error() string {
+	problems := []string{}
+	if len(e.Missing) > 0 {
+		problems = append(problems, fmt.Sprintf("missing sections %s", strings.Join(e.Missing, ", ")))
+	}
+	if len(e.Unknown) > 0 {
+		problems = append(problems, fmt.Sprintf("unknown sections %s", strings.Join(e.Unknown, ", ")))
+	}
+	if len(e.Duplicate) > 0 {
+		problems = append(problems, fmt.Sprintf("duplicate sections %s", strings.Join(e.Duplicate, ", ")))
+	}
+	return fmt.Sprintf("Invalid section order: %s", strings.Join(problems, "; "))
+}
+
+// TooManyManifestItemsError is thrown by the methods adding files to the EPUB
+// (e.g. AddImage or AddSection) if adding them would exceed the limit set by
+// SetMaxManifestItems.
+type TooManyManifestItemsError struct {
+	Max int // The maximum number of files which can be added
+}
+
+func (e *TooManyManifestItemsError) Error() string {
+	return fmt.Sprintf("Adding the file would exceed the maximum of %d manifest items", e.Max)
+}
+
+// NestingTooDeepError is thrown by AddSubSection if nesting the section under
+// its parent would exceed the maximum depth set by SetMaxNestingDepth.
+type NestingTooDeepError struct {
+	Parent string // Filename of the parent section
+	Max    int    // The maximum nesting depth
+}
+
+func (e *NestingTooDeepError) Error() string {
+	return fmt.Sprintf("Nesting a section under %s would exceed the maximum depth of %d", e.Parent, e.Max)
+}
+
+// Progress is reported to the handler set by SetProgressHandler while the EPUB
+// file is being written, or when a warning is raised while it's being built.
+type Progress struct {
+	BytesWritten int64 // Number of bytes of the EPUB file written so far
+	Done         bool  // Whether the EPUB file has been written completely
+	// A problem which doesn't prevent the EPUB from being built, e.g.
+	// DuplicateSectionError, or nil when reporting the bytes written
+	Warning error
+}
+
+// DuplicateSectionError is reported as a warning to the progress handler if
+// SetEmbedChapterStats sets whether the word count of each section is added to
+// the metadata of the package file when the EPUB is written, e.g. so reading
+// apps can show how long each chapter takes to read. The counts are written as
+// non-standard meta elements named after the section, without its extension:
+//
+//	<meta name="chapter-wordcount:section0003" content="1423"></meta>
+//
+// Words are counted in the text of the section without markup, separated by
+// whitespace. The covers and sections whose body isn't valid XHTML are left
+// out. This is disabled by default.
+func (e *Epub) SetEmbedChapterStats(embed bool) {
+	e.Lock()
+	defer e.Unlock()
+	e.embedChapterStats = embed
+}
+
+// SetAutoChapterLinks sets whether links to the previous and next sections and
+// to the table of contents are added to the end of every section when the EPUB
+// is written, for reading systems without navigation controls. The links
+// follow the final reading order, leaving out the covers and sections which
+// aren't part of the linear reading order (see SetSectionLinear), and are
+// wrapped in <nav class="chapter-links"> so they can be styled. This is
+// disabled by default.
+func (e *Epub) SetAutoChapterLinks(enable bool) {
+	e.Lock()
+	defer e.Unlock()
+	e.autoChapterLinks = enable
+}
+
+// SetWarnDuplicateSections is enabled and a section is added with the same body
+// as a section which has already been added.
+type DuplicateSectionError struct {
+	Filename    string // The filename of the section which was added
+	DuplicateOf string // The filename of the section with the same body
+}
+
+func (e *DuplicateSectionError) Error() string {
+	return fmt.Sprintf("Section %s has the same body as section %s", e.Filename, e.DuplicateOf)
+}
+
 // Folder names used for resources inside the EPUB
 const (
-	CSSFolderName   = "css"
-	FontFolderName  = "fonts"
-	ImageFolderName = "images"
-	VideoFolderName = "videos"
+	AudioFolderName     = "audio"
+	CSSFolderName       = "css"
+	EncryptedFolderName = "encrypted"
+	FontFolderName      = "fonts"
+	ImageFolderName     = "images"
+	MediaFolderName     = "media"
+	ScriptFolderName    = "scripts"
+	VideoFolderName     = "videos"
 )
 
 const (
-	cssFileFormat          = "css%04d%s"
-	defaultCoverBody       = `<img src="%s" alt="Cover Image" />`
+	cssFileFormat     = "css%04d%s"
+	animatedCoverBody = `<video src="%s" poster="%s" autoplay="autoplay" loop="loop" muted="muted">
+  <img src="%s" alt="Cover Image" />
+</video>`
+	defaultCoverBody       = `<img src="%s" alt="%s" />`
 	defaultCoverCSSContent = `body {
   background-color: #FFFFFF;
   margin-bottom: 0px;
@@ -81,28 +221,55 @@ const (
   margin-top: 0px;
   text-align: center;
 }
-img {
+img, video {
   max-height: 100%;
   max-width: 100%;
 }
 `
+	defaultCoverAlt           = "Cover Image"
 	defaultCoverCSSFilename   = "cover.css"
 	defaultCoverCSSSource     = "cover.css"
 	defaultCoverImgFormat     = "cover%s"
 	defaultCoverXhtmlFilename = "cover.xhtml"
+	defaultCoverEpubType      = "cover"
+	backCoverBody             = `<img src="%s" alt="Back Cover Image" />`
+	audioFileFormat           = "audio%04d%s"
+	backCoverXhtmlFilename    = "backcover.xhtml"
+	backCoverLandmarkEpubType = "backmatter"
+	backCoverTitle            = "Back Cover"
+	bodyStartEpubType         = "bodymatter"
+	bodyStartGuideType        = "text"
+	bodyStartTitle            = "Start of Content"
+	copyrightPageEpubType     = "copyright-page"
+	copyrightPageFilename     = "copyright.xhtml"
+	copyrightPageTitle        = "Copyright"
 	defaultEpubLang           = "en"
+	encryptedFileFormat       = "encrypted%04d%s"
 	fontFileFormat            = "font%04d%s"
 	imageFileFormat           = "image%04d%s"
+	inlineImageTemplate       = `<img src="%s" alt="%s" />`
+	mediaFileFormat           = "media%04d%s"
+	scriptFileFormat          = "script%04d%s"
 	videoFileFormat           = "video%04d%s"
-	sectionFileFormat         = "section%04d.xhtml"
+	defaultSectionExtension   = ".xhtml"
+	defaultMaxNestingDepth    = 6
+	sectionFileFormat         = "section%04d%s"
 	urnUUIDPrefix             = "urn:uuid:"
+	viewportFormat            = "width=%d, height=%d"
+	webFontCSSTemplate        = `@font-face {
+  font-family: "%s";
+  src: url("%s");
+}
+`
+	webFontSourceTemplate = "/* Font source: %s */\n"
 )
 
 // Epub implements an EPUB file.
 type Epub struct {
 	sync.Mutex
 	*http.Client
-	cover *epubCover
+	cover     *epubCover
+	backCover *epubCover
 	// The key is the css filename, the value is the css source
 	css map[string]string
 	// The key is the font filename, the value is the font source
@@ -111,6 +278,10 @@ type Epub struct {
 	images map[string]string
 	// The key is the video filename, the value is the video source
 	videos map[string]string
+	// The key is the audio filename, the value is the audio source
+	audios map[string]string
+	// The key is the script filename, the value is the script source
+	scripts map[string]string
 	// Language
 	lang string
 	// Description
@@ -120,20 +291,153 @@ type Epub struct {
 	// The package file (package.opf)
 	Pkg      *Pkg
 	sections []epubSection
+	// Filename of the section where the body of the EPUB starts
+	bodyStart string
+	// Filename of the copyright page, if any
+	copyrightPage string
+	// Whether to add the copyright page to the TOC
+	copyrightPageInTOC bool
+	// File extension of generated section filenames
+	sectionExtension string
+	// Author in the EPUB v2 TOC file (toc.ncx) instead of the first author
+	ncxDocAuthor string
+	// Directory where remote media is cached between builds
+	downloadCacheDir string
+	// Whether to check with the server that cached media hasn't changed
+	revalidateDownloadCache bool
+	// Headers added to the requests to retrieve remote media
+	fetchHeaders http.Header
+	// Maximum number of media retrieved at the same time when writing
+	fetchConcurrency int
+	// Size above which data URLs are decoded to a file in stagingDir when
+	// they're added, or 0 to keep them in memory
+	dataURLStagingThreshold int
+	// Temporary directory where large data URLs are decoded to
+	stagingDir string
+	// Called with the progress of writing the EPUB file
+	progressHandler func(Progress)
+	// Context of the write in progress, if any, used to retrieve media
+	ctx context.Context
+	// Storage the EPUB is assembled in while it's written, if not the default
+	stagingFS storage.Storage
+	// Internal paths of the CSS files used by every section
+	globalCSS []string
+	// Whether to remove source map references from CSS files
+	stripCSSSourceMaps bool
+	// Whether to normalize the whitespace of CSS files
+	normalizeCSS bool
+	// Whether to remove color profiles and Exif metadata from images
+	stripColorProfiles bool
+	// Compression level of the files in the EPUB file, see compress/flate
+	compressionLevel int
+	// Whether to use the modification times of local source files in the EPUB
+	preserveSourceTimes bool
+	// Whether to add sections without a title to the TOC with a generated label
+	autoTOCLabels bool
+	// Maximum number of files which can be added to the EPUB, or 0 for no limit
+	maxManifestItems int
+	// Maximum depth of sections nested with AddSubSection, or 0 for no limit
+	maxNestingDepth int
+	// Whether to infer the page progression direction from the language
+	autoPageProgression bool
+	// Whether to add links to the previous and next sections to every section
+	autoChapterLinks bool
+	// Whether to write a manifest with the digests of the files of the EPUB
+	integrityManifest bool
+	// Whether to add the word count of each section to the package file
+	embedChapterStats bool
+	// Whether to warn about sections added with the same body as another one
+	warnDuplicateSections bool
+	// The key is the SHA-256 hash of the body of a section, the value is the
+	// filename of the first section added with that body
+	sectionBodyHashes map[[sha256.Size]byte]string
+	// Filename of the image used as the publisher logo
+	publisherLogo string
+	// Filename of the image used as the cover thumbnail
+	coverThumbnail string
+	// Content of the viewport meta element of every section, if any
+	viewport string
+	// Theme applied by ApplyTheme, if any
+	theme *Theme
+	// Internal path of the CSS file generated by ApplyTheme
+	themeCSSPath string
+	// Internal path of the CSS file added by SetResponsiveImages
+	responsiveImagesCSSPath string
+	// The key is the media filename, the value is the media source
+	media map[string]string
+	// The key is the media filename, the value is the filename of its fallback
+	mediaFallbacks map[string]string
+	// The key is the lexicon filename, the value is the lexicon source
+	lexicons map[string]string
+	// The key is the lexicon filename, the value is the language of the lexicon
+	lexiconLangs map[string]string
+	// The key is the media overlay filename, the value is the overlay source
+	overlays map[string]string
+	// The key is the media overlay filename, the value is its duration
+	overlayDurations map[string]time.Duration
+	// The key is the section filename, the value is its media overlay filename
+	sectionOverlays map[string]string
+	// Encrypted resources, see AddEncryptedResource
+	encrypted  map[string]string
+	encryption map[string]encryptedResource
+	// Filenames of the fonts which are obfuscated, see AddObfuscatedFont
+	obfuscatedFonts map[string]bool
+	// Order in which media files were added, by their path in the EPUB (e.g.
+	// images/image0001.png)
+	mediaSequence map[string]int
+	mediaAdded    int
+	// Position of media files in the manifest, by their path in the EPUB, see
+	// AddImageOrdered
+	manifestOrder map[string]int
+	// Entries added to the table of contents with AddTocEntryWithIcon
+	tocEntries []epubTocEntry
+	// Landmarks added with AddLandmark
+	landmarks []epubLandmark
 	// Table of contents
 	toc *toc
 }
 
 type epubCover struct {
-	cssFilename   string
-	cssTempFile   string
+	cssFilename string
+	// Whether the cover uses the default cover stylesheet
+	defaultCSS    bool
 	imageFilename string
+	videoFilename string
 	xhtmlFilename string
+	// Position of the cover page in the spine
+	spineIndex int
+}
+
+type epubTocEntry struct {
+	title string
+	// Paths relative to the TOC file
+	href     string
+	iconPath string
+	iconAlt  string
+}
+
+type epubLandmark struct {
+	epubType string
+	title    string
+	// Path relative to the TOC file
+	href string
+	// Filename of the section the landmark points to, or empty for the TOC
+	filename string
 }
 
 type epubSection struct {
 	filename string
 	xhtml    *xhtml
+	// Properties of the section in the package manifest, space separated
+	properties string
+	// Properties of the section in the spine, space separated
+	spineProperties string
+	// Position of the section in a spread, one of the PageSpread* constants
+	pageSpread string
+	// Whether the section isn't part of the linear reading order
+	nonLinear bool
+	// Filename of the section this section is nested under in the TOC, if any
+	parent string
 }
 
 // NewEpub returns a new Epub.
@@ -141,15 +445,33 @@ func NewEpub(title string) *Epub {
 	e := &Epub{}
 	e.cover = &epubCover{
 		cssFilename:   "",
-		cssTempFile:   "",
 		imageFilename: "",
 		xhtmlFilename: "",
 	}
+	e.backCover = &epubCover{}
 	e.Client = http.DefaultClient
 	e.css = make(map[string]string)
+	e.encrypted = make(map[string]string)
+	e.obfuscatedFonts = make(map[string]bool)
+	e.mediaSequence = make(map[string]int)
+	e.manifestOrder = make(map[string]int)
+	e.encryption = make(map[string]encryptedResource)
 	e.fonts = make(map[string]string)
 	e.images = make(map[string]string)
+	e.lexicons = make(map[string]string)
+	e.lexiconLangs = make(map[string]string)
+	e.media = make(map[string]string)
+	e.overlays = make(map[string]string)
+	e.overlayDurations = make(map[string]time.Duration)
+	e.sectionOverlays = make(map[string]string)
+	e.mediaFallbacks = make(map[string]string)
 	e.videos = make(map[string]string)
+	e.audios = make(map[string]string)
+	e.scripts = make(map[string]string)
+	e.sectionExtension = defaultSectionExtension
+	e.stripCSSSourceMaps = true
+	e.compressionLevel = flate.DefaultCompression
+	e.maxNestingDepth = defaultMaxNestingDepth
 	e.Pkg = NewPkg()
 	e.toc = newToc()
 	// Set minimal required attributes
@@ -174,11 +496,74 @@ func NewEpub(title string) *Epub {
 func (e *Epub) AddCSS(source string, internalFilename string) (string, error) {
 	e.Lock()
 	defer e.Unlock()
+	if err := e.checkManifestItems(1); err != nil {
+		return "", err
+	}
 	return e.addCSS(source, internalFilename)
 }
 
 func (e *Epub) addCSS(source string, internalFilename string) (string, error) {
-	return addMedia(e.Client, source, internalFilename, cssFileFormat, CSSFolderName, e.css)
+	return e.addMedia(source, internalFilename, cssFileFormat, CSSFolderName, e.css)
+}
+
+// AddCSSFromBytes adds a CSS file with the provided content to the EPUB like
+// AddCSS. The content is kept in memory until the EPUB is written, so no
+// temporary file is created.
+func (e *Epub) AddCSSFromBytes(content []byte, internalFilename string) (string, error) {
+	e.Lock()
+	defer e.Unlock()
+	if err := e.checkManifestItems(1); err != nil {
+		return "", err
+	}
+	return e.addCSSFromBytes(content, internalFilename)
+}
+
+func (e *Epub) addCSSFromBytes(content []byte, internalFilename string) (string, error) {
+	// Generate a filename if one isn't provided, since the data URL has none
+	for index := len(e.css) + 1; internalFilename == ""; index++ {
+		internalFilename = fmt.Sprintf(cssFileFormat, index, ".css")
+		if _, ok := e.css[internalFilename]; ok {
+			internalFilename = ""
+		}
+	}
+
+	g := e.newGrabber()
+	// Keep the content in memory even if it's large
+	g.stagingThreshold = 0
+	cssPath, err := addMedia(g, dataurl.New(content, mediaTypeCSS).String(), internalFilename, cssFileFormat, CSSFolderName, e.css)
+	if err != nil {
+		return "", err
+	}
+	e.recordMedia(CSSFolderName, internalFilename)
+
+	return cssPath, nil
+}
+
+// AddGlobalCSS adds a CSS file to the EPUB which will be used by every section
+// except the cover, in addition to the CSS of the section itself (if any). A
+// relative path to the CSS file is returned in the same format as AddCSS.
+//
+// Global CSS files are linked in the order they were added, before the CSS of
+// the section, so rules from the CSS of a section take precedence.
+//
+// The source and internal filename are the same as for AddCSS.
+func (e *Epub) AddGlobalCSS(source string, internalFilename string) (string, error) {
+	e.Lock()
+	defer e.Unlock()
+	if err := e.checkManifestItems(1); err != nil {
+		return "", err
+	}
+	return e.addGlobalCSS(source, internalFilename)
+}
+
+func (e *Epub) addGlobalCSS(source string, internalFilename string) (string, error) {
+	cssPath, err := e.addCSS(source, internalFilename)
+	if err != nil {
+		return "", err
+	}
+	e.globalCSS = append(e.globalCSS, cssPath)
+
+	return cssPath, nil
 }
 
 // AddFont adds a font file to the EPUB and returns a relative path to the font
@@ -195,7 +580,70 @@ func (e *Epub) addCSS(source string, internalFilename string) (string, error) {
 func (e *Epub) AddFont(source string, internalFilename string) (string, error) {
 	e.Lock()
 	defer e.Unlock()
-	return addMedia(e.Client, source, internalFilename, fontFileFormat, FontFolderName, e.fonts)
+	if err := e.checkManifestItems(1); err != nil {
+		return "", err
+	}
+	return e.addMedia(source, internalFilename, fontFileFormat, FontFolderName, e.fonts)
+}
+
+// AddWebFont adds a font file to the EPUB along with a CSS file containing an
+// @font-face rule which binds the font to the provided font family name. The
+// relative path to the CSS file is returned in the format:
+// ../CSSFolderName/internalFilename
+//
+// The font source should either be a URL, a path to a local file, or an
+// embedded data URL. If the font source is a URL, the CSS file notes it in a
+// comment, since most font licenses require attribution when the font is
+// redistributed. Make sure the license of the font allows embedding it in an
+// EPUB, as not all do.
+//
+// The returned CSS path can be used in EPUB sections like any CSS file
+// added by AddCSS, after which the font family can be used in that section.
+func (e *Epub) AddWebFont(source string, family string) (string, error) {
+	e.Lock()
+	defer e.Unlock()
+	if err := e.checkManifestItems(2); err != nil {
+		return "", err
+	}
+	fontPath, err := e.addMedia(source, "", fontFileFormat, FontFolderName, e.fonts)
+	if err != nil {
+		return "", err
+	}
+	fontFilename := filepath.Base(fontPath)
+
+	// Both the CSS and font folders are at the same level, so the path
+	// relative to the sections can also be used in the CSS file
+	cssContent := fmt.Sprintf(
+		webFontCSSTemplate,
+		strings.Replace(family, `"`, `\"`, -1),
+		fontPath,
+	)
+	// Local paths and data URLs aren't noted, since they'd leak paths of the
+	// machine the EPUB was made on or copy the whole font into the CSS
+	if isRemoteResource(source) {
+		cssContent = fmt.Sprintf(webFontSourceTemplate, strings.Replace(source, "*/", "*\\/", -1)) + cssContent
+	}
+	cssSource := dataurl.EncodeBytes([]byte(cssContent))
+
+	// Name the CSS file after the font file if possible
+	cssFilename := strings.TrimSuffix(fontFilename, filepath.Ext(fontFilename)) + ".css"
+	if _, ok := e.css[cssFilename]; ok {
+		cssFilename = ""
+		for index := len(e.css) + 1; cssFilename == ""; index++ {
+			cssFilename = fmt.Sprintf(cssFileFormat, index, ".css")
+			if _, ok := e.css[cssFilename]; ok {
+				cssFilename = ""
+			}
+		}
+	}
+
+	cssPath, err := e.addCSS(cssSource, cssFilename)
+	if err != nil {
+		delete(e.fonts, fontFilename)
+		return "", err
+	}
+
+	return cssPath, nil
 }
 
 // AddImage adds an image to the EPUB and returns a relative path to the image
@@ -212,7 +660,89 @@ func (e *Epub) AddFont(source string, internalFilename string) (string, error) {
 func (e *Epub) AddImage(source string, imageFilename string) (string, error) {
 	e.Lock()
 	defer e.Unlock()
-	return addMedia(e.Client, source, imageFilename, imageFileFormat, ImageFolderName, e.images)
+	if err := e.checkManifestItems(1); err != nil {
+		return "", err
+	}
+	return e.addMedia(source, imageFilename, imageFileFormat, ImageFolderName, e.images)
+}
+
+// AddImageOrdered adds an image to the EPUB like AddImage, with a hint for the
+// position of the image in the manifest of the package file, for the few tools
+// which give the order of the manifest a meaning. Images added with a hint are
+// listed before the other images, sorted by their hint; images with the same
+// hint, like the images added without one, are listed in the order they were
+// added.
+func (e *Epub) AddImageOrdered(source string, imageFilename string, order int) (string, error) {
+	e.Lock()
+	defer e.Unlock()
+	if err := e.checkManifestItems(1); err != nil {
+		return "", err
+	}
+	imagePath, err := e.addMedia(source, imageFilename, imageFileFormat, ImageFolderName, e.images)
+	if err != nil {
+		return "", err
+	}
+	e.manifestOrder[path.Join(ImageFolderName, path.Base(imagePath))] = order
+
+	return imagePath, nil
+}
+
+// ImageTag returns an <img> element showing the image at the given internal
+// path (as returned by AddImage) with the given alternative text, to be used in
+// the body of a section. The alternative text describes the image for readers
+// who can't see it and is escaped as needed; it should only be empty for
+// purely decorative images. If no such image exists, FilenameNotFoundError will
+// be returned.
+func (e *Epub) ImageTag(internalImagePath string, alt string) (string, error) {
+	e.Lock()
+	defer e.Unlock()
+	imageFilename := path.Base(internalImagePath)
+	if _, ok := e.images[imageFilename]; !ok || internalImagePath != path.Join("..", ImageFolderName, imageFilename) {
+		return "", &FilenameNotFoundError{Filename: internalImagePath}
+	}
+
+	return fmt.Sprintf(inlineImageTemplate, html.EscapeString(internalImagePath), html.EscapeString(alt)), nil
+}
+
+// AddInlineImage appends an image to the body of a section which has already
+// been added to the EPUB, embedding the image in the section itself as a data
+// URL instead of adding it to the EPUB as a separate file. This avoids path
+// issues and reduces the number of files, e.g. for tiny icons, but the image
+// is about a third larger due to the base64 encoding and it's embedded again
+// every time it's used, so AddImage should be used for anything else.
+//
+// The internal filename is the one returned by AddSection. If no section with
+// that filename exists, FilenameNotFoundError will be returned. The image
+// source should either be a URL, a path to a local file, or an embedded data
+// URL; if it can't be retrieved, FileRetrievalError will be returned. If it
+// isn't an image, InvalidValueError will be returned.
+func (e *Epub) AddInlineImage(internalFilename string, source string, alt string) error {
+	e.Lock()
+	defer e.Unlock()
+	var section *epubSection
+	for i := range e.sections {
+		if e.sections[i].filename == internalFilename {
+			section = &e.sections[i]
+			break
+		}
+	}
+	if section == nil {
+		return &FilenameNotFoundError{Filename: internalFilename}
+	}
+
+	content, err := e.newGrabber().readMedia(source)
+	if err != nil {
+		return err
+	}
+	mediaType := mimetype.Detect(content).String()
+	if !strings.HasPrefix(mediaType, "image/") {
+		return &InvalidValueError{Name: "source", Value: source}
+	}
+
+	img := fmt.Sprintf(inlineImageTemplate, dataurl.New(content, mediaType).String(), html.EscapeString(alt))
+	section.xhtml.xml.Body.XML += img + "\n"
+
+	return nil
 }
 
 // AddVideo adds an video to the EPUB and returns a relative path to the video
@@ -229,7 +759,91 @@ func (e *Epub) AddImage(source string, imageFilename string) (string, error) {
 func (e *Epub) AddVideo(source string, videoFilename string) (string, error) {
 	e.Lock()
 	defer e.Unlock()
-	return addMedia(e.Client, source, videoFilename, videoFileFormat, VideoFolderName, e.videos)
+	if err := e.checkManifestItems(1); err != nil {
+		return "", err
+	}
+	return e.addMedia(source, videoFilename, videoFileFormat, VideoFolderName, e.videos)
+}
+
+// AddAudio adds an audio file (e.g. narration or music) to the EPUB and returns
+// a relative path to the audio file that can be used in EPUB sections in the
+// format:
+// ../AudioFolderName/internalFilename
+//
+// The audio source should either be a URL, a path to a local file, or an
+// embedded data URL; in any case, the audio file will be retrieved and stored in
+// the EPUB. MP3, MP4 (e.g. .m4a), Ogg, and WAV files are declared in the package
+// file with the media types EPUB reading systems expect.
+//
+// The internal filename will be used when storing the audio file in the EPUB
+// and must be unique among all audio files. If the same filename is used more
+// than once, FilenameAlreadyUsedError will be returned. The internal filename is
+// optional; if no filename is provided, one will be generated.
+func (e *Epub) AddAudio(source string, audioFilename string) (string, error) {
+	e.Lock()
+	defer e.Unlock()
+	if err := e.checkManifestItems(1); err != nil {
+		return "", err
+	}
+	return e.addMedia(source, audioFilename, audioFileFormat, AudioFolderName, e.audios)
+}
+
+// AddJavaScript adds a JavaScript file to the EPUB and returns a relative path
+// to the script that can be used in EPUB sections in the format:
+// ../ScriptFolderName/internalFilename
+//
+// The script source should either be a URL, a path to a local file, or an
+// embedded data URL; in any case, the script will be retrieved and stored in
+// the EPUB. The script can be added to the head of a section with
+// Section.AppendHead, e.g. <script src="../scripts/quiz.js"></script>. Sections
+// using scripts must be marked with SetSectionScripted.
+//
+// The internal filename will be used when storing the script in the EPUB and
+// must be unique among all scripts. If the same filename is used more than
+// once, FilenameAlreadyUsedError will be returned. The internal filename is
+// optional; if no filename is provided, one will be generated.
+func (e *Epub) AddJavaScript(source string, internalFilename string) (string, error) {
+	e.Lock()
+	defer e.Unlock()
+	if err := e.checkManifestItems(1); err != nil {
+		return "", err
+	}
+	return e.addMedia(source, internalFilename, scriptFileFormat, ScriptFolderName, e.scripts)
+}
+
+// AddMediaWithFallback adds a media file whose media type isn't one of the
+// EPUB core media types (e.g. a 3D model or an uncommon image format) to the
+// EPUB along with a fallback in a core media type, which reading systems use
+// instead if they don't support the media. It returns a relative path to the
+// media file that can be used in EPUB sections in the format:
+// ../MediaFolderName/internalFilename
+//
+// The media and fallback sources should either be a URL, a path to a local
+// file, or an embedded data URL. The fallback is declared on the media file in
+// the package file and is stored in the EPUB with a generated filename.
+//
+// The internal filename will be used when storing the media file in the EPUB
+// and must be unique among all media files. If the same filename is used more
+// than once, FilenameAlreadyUsedError will be returned. The internal filename is
+// optional; if no filename is provided, one will be generated.
+func (e *Epub) AddMediaWithFallback(source string, fallbackSource string, internalFilename string) (string, error) {
+	e.Lock()
+	defer e.Unlock()
+	if err := e.checkManifestItems(2); err != nil {
+		return "", err
+	}
+	mediaPath, err := e.addMedia(source, internalFilename, mediaFileFormat, MediaFolderName, e.media)
+	if err != nil {
+		return "", err
+	}
+	fallbackPath, err := e.addMedia(fallbackSource, "", mediaFileFormat, MediaFolderName, e.media)
+	if err != nil {
+		delete(e.media, path.Base(mediaPath))
+		return "", err
+	}
+	e.mediaFallbacks[path.Base(mediaPath)] = path.Base(fallbackPath)
+
+	return mediaPath, nil
 }
 
 // AddSection adds a new section (chapter, etc) to the EPUB and returns a
@@ -254,15 +868,109 @@ func (e *Epub) AddVideo(source string, videoFilename string) (string, error) {
 func (e *Epub) AddSection(body string, sectionTitle string, internalFilename string, internalCSSPath string) (string, error) {
 	e.Lock()
 	defer e.Unlock()
+	if err := e.checkManifestItems(1); err != nil {
+		return "", err
+	}
 	return e.addSection(body, sectionTitle, internalFilename, internalCSSPath)
 }
 
-func (e *Epub) addSection(body string, sectionTitle string, internalFilename string, internalCSSPath string) (string, error) {
+// AddSectionWithCSS adds a new section to the EPUB like AddSection, but with
+// any number of stylesheets, e.g. a base stylesheet and one specific to the
+// section. The internal paths of the CSS files (as returned by AddCSS) are
+// linked from the section in the given order, so later stylesheets override
+// earlier ones.
+func (e *Epub) AddSectionWithCSS(body string, sectionTitle string, internalFilename string, internalCSSPaths ...string) (string, error) {
+	e.Lock()
+	defer e.Unlock()
+	if err := e.checkManifestItems(1); err != nil {
+		return "", err
+	}
+	return e.addSection(body, sectionTitle, internalFilename, internalCSSPaths...)
+}
+
+// AddSubSection adds a new section to the EPUB like AddSection, but nests it
+// under the section with the provided filename (as returned by AddSection or
+// AddSubSection) in the table of contents, e.g. a chapter under a part. The
+// nesting is reflected in both the EPUB 3 and EPUB 2 table of contents.
+//
+// The section is placed in the reading order after the parent section and the
+// sections already nested under it. If no section with the parent filename
+// exists, FilenameNotFoundError will be returned. If the parent section has no
+// title, the section is added to the top level of the table of contents. If
+// nesting the section would exceed the maximum depth (see SetMaxNestingDepth),
+// NestingTooDeepError will be returned.
+func (e *Epub) AddSubSection(parentFilename string, body string, sectionTitle string, internalFilename string, internalCSSPath string) (string, error) {
+	e.Lock()
+	defer e.Unlock()
+	if err := e.checkManifestItems(1); err != nil {
+		return "", err
+	}
+	parentIndex := -1
+	for i, section := range e.sections {
+		if section.filename == parentFilename {
+			parentIndex = i
+			break
+		}
+	}
+	if parentIndex == -1 {
+		return "", &FilenameNotFoundError{Filename: parentFilename}
+	}
+	if e.maxNestingDepth > 0 && e.nestingDepth(e.sections[parentIndex])+1 > e.maxNestingDepth {
+		return "", &NestingTooDeepError{Parent: parentFilename, Max: e.maxNestingDepth}
+	}
+
+	filename, err := e.addSection(body, sectionTitle, internalFilename, internalCSSPath)
+	if err != nil {
+		return "", err
+	}
+	s := e.sections[len(e.sections)-1]
+	s.parent = parentFilename
+
+	// Move the section after the sections already nested under the parent
+	index := parentIndex + 1
+	for index < len(e.sections)-1 && e.isNestedUnder(e.sections[index], parentFilename) {
+		index++
+	}
+	copy(e.sections[index+1:], e.sections[index:len(e.sections)-1])
+	e.sections[index] = s
+
+	return filename, nil
+}
+
+// Get the depth of a section in the TOC, 1 for a section at the top level
+func (e *Epub) nestingDepth(section epubSection) int {
+	parents := map[string]string{}
+	for _, s := range e.sections {
+		parents[s.filename] = s.parent
+	}
+	depth := 1
+	for parent := section.parent; parent != ""; parent = parents[parent] {
+		depth++
+	}
+	return depth
+}
+
+// Check whether a section is nested under the section with the given filename,
+// directly or indirectly
+func (e *Epub) isNestedUnder(section epubSection, parentFilename string) bool {
+	parents := map[string]string{}
+	for _, s := range e.sections {
+		parents[s.filename] = s.parent
+	}
+	for parent := section.parent; parent != ""; parent = parents[parent] {
+		if parent == parentFilename {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Epub) addSection(body string, sectionTitle string, internalFilename string, internalCSSPaths ...string) (string, error) {
 	// Generate a filename if one isn't provided
 	if internalFilename == "" {
 		index := 1
 		for internalFilename == "" {
-			internalFilename = fmt.Sprintf(sectionFileFormat, index)
+			internalFilename = fmt.Sprintf(sectionFileFormat, index, e.sectionExtension)
 			for _, section := range e.sections {
 				if section.filename == internalFilename {
 					internalFilename, index = "", index+1
@@ -281,9 +989,7 @@ func (e *Epub) addSection(body string, sectionTitle string, internalFilename str
 	x := newXhtml(body)
 	x.setTitle(sectionTitle)
 
-	if internalCSSPath != "" {
-		x.setCSS(internalCSSPath)
-	}
+	x.setCSS(internalCSSPaths...)
 
 	s := epubSection{
 		filename: internalFilename,
@@ -291,100 +997,1157 @@ func (e *Epub) addSection(body string, sectionTitle string, internalFilename str
 	}
 	e.sections = append(e.sections, s)
 
+	if e.warnDuplicateSections && strings.TrimSpace(body) != "" {
+		e.checkDuplicateSection(internalFilename, body)
+	}
+
 	return internalFilename, nil
 }
 
-// SetCover sets the cover page for the EPUB using the provided image source and
-// optional CSS.
+// Report a warning to the progress handler if a section with the same body as
+// the new section has already been added
+func (e *Epub) checkDuplicateSection(internalFilename string, body string) {
+	hash := sha256.Sum256([]byte(strings.TrimSpace(body)))
+	if _, ok := e.sectionBodyHashes[hash]; !ok {
+		e.sectionBodyHashes[hash] = internalFilename
+		return
+	}
+
+	// Sections may have been replaced since their hash was recorded, so look
+	// for a section which still has the same body
+	duplicateOf := ""
+	for _, section := range e.sections {
+		if section.filename != internalFilename && strings.TrimSpace(section.xhtml.xml.Body.XML) == strings.TrimSpace(body) {
+			duplicateOf = section.filename
+			break
+		}
+	}
+	if duplicateOf == "" {
+		e.sectionBodyHashes[hash] = internalFilename
+		return
+	}
+
+	if e.progressHandler != nil {
+		e.progressHandler(Progress{Warning: &DuplicateSectionError{Filename: internalFilename, DuplicateOf: duplicateOf}})
+	}
+}
+
+// ReplaceSection replaces the body, title, and CSS of a section which has
+// already been added to the EPUB, keeping its position in the reading order and
+// the table of contents.
 //
-// The internal path to an already-added image file (as returned by AddImage) is
-// required.
+// The internal filename is the one returned by AddSection. If no section with
+// that filename exists, FilenameNotFoundError will be returned.
 //
-// The internal path to an already-added CSS file (as returned by AddCSS) to be
-// used for the cover is optional. If the CSS path isn't provided, default CSS
-// will be used.
-func (e *Epub) SetCover(internalImagePath string, internalCSSPath string) {
+// The body, title, and CSS path have the same meaning as for AddSection.
+func (e *Epub) ReplaceSection(internalFilename string, body string, sectionTitle string, internalCSSPath string) error {
 	e.Lock()
 	defer e.Unlock()
-	// If a cover already exists
-	if e.cover.xhtmlFilename != "" {
-		// Remove the xhtml file
-		for i, section := range e.sections {
-			if section.filename == e.cover.xhtmlFilename {
-				e.sections = append(e.sections[:i], e.sections[i+1:]...)
-				break
-			}
+	for _, section := range e.sections {
+		if section.filename == internalFilename {
+			section.xhtml.setBody(body)
+			section.xhtml.setTitle(sectionTitle)
+			section.xhtml.setCSS(internalCSSPath)
+			return nil
 		}
+	}
 
-		// Remove the image
-		delete(e.images, e.cover.imageFilename)
-
-		// Remove the CSS
-		delete(e.css, e.cover.cssFilename)
+	return &FilenameNotFoundError{Filename: internalFilename}
+}
 
-		if e.cover.cssTempFile != "" {
-			os.Remove(e.cover.cssTempFile)
+// RemoveSection removes a section which has already been added to the EPUB, so
+// it won't be written to the EPUB or appear in the reading order or the table
+// of contents. Sections nested under it (see AddSubSection) are kept and nested
+// under its parent instead, or moved to the top level of the table of contents.
+// Its media overlay, if any, is removed as well.
+//
+// The internal filename is the one returned by AddSection. If no section with
+// that filename exists, FilenameNotFoundError will be returned.
+func (e *Epub) RemoveSection(internalFilename string) error {
+	e.Lock()
+	defer e.Unlock()
+	index := -1
+	for i, section := range e.sections {
+		if section.filename == internalFilename {
+			index = i
+			break
 		}
 	}
+	if index == -1 {
+		return &FilenameNotFoundError{Filename: internalFilename}
+	}
 
-	e.cover.imageFilename = filepath.Base(internalImagePath)
-	e.Pkg.SetCover(e.cover.imageFilename)
-
-	// Use default cover stylesheet if one isn't provided
-	if internalCSSPath == "" {
-		// Encode the default CSS
-		e.cover.cssTempFile = dataurl.EncodeBytes([]byte(defaultCoverCSSContent))
-		var err error
-		internalCSSPath, err = e.addCSS(e.cover.cssTempFile, defaultCoverCSSFilename)
-		// If that doesn't work, generate a filename
-		if _, ok := err.(*FilenameAlreadyUsedError); ok {
-			coverCSSFilename := fmt.Sprintf(
-				cssFileFormat,
-				len(e.css)+1,
-				".css",
-			)
-
-			internalCSSPath, err = e.addCSS(e.cover.cssTempFile, coverCSSFilename)
-			if _, ok := err.(*FilenameAlreadyUsedError); ok {
-				// This shouldn't cause an error
-				panic(fmt.Sprintf("Error adding default cover CSS file: %s", err))
-			}
-		}
-		if err != nil {
-			if _, ok := err.(*FilenameAlreadyUsedError); !ok {
-				panic(fmt.Sprintf("DEBUG %+v", err))
-			}
+	parent := e.sections[index].parent
+	e.sections = append(e.sections[:index], e.sections[index+1:]...)
+	for i := range e.sections {
+		if e.sections[i].parent == internalFilename {
+			e.sections[i].parent = parent
 		}
 	}
-	e.cover.cssFilename = filepath.Base(internalCSSPath)
 
-	coverBody := fmt.Sprintf(defaultCoverBody, internalImagePath)
-	// Title won't be used since the cover won't be added to the TOC
-	// First try to use the default cover filename
-	coverPath, err := e.addSection(coverBody, "", defaultCoverXhtmlFilename, internalCSSPath)
-	// If that doesn't work, generate a filename
-	if _, ok := err.(*FilenameAlreadyUsedError); ok {
-		coverPath, err = e.addSection(coverBody, "", "", internalCSSPath)
-		if _, ok := err.(*FilenameAlreadyUsedError); ok {
-			// This shouldn't cause an error since we're not specifying a filename
-			panic(fmt.Sprintf("Error adding default cover XHTML file: %s", err))
+	if overlayFilename, ok := e.sectionOverlays[internalFilename]; ok {
+		delete(e.overlays, overlayFilename)
+		delete(e.overlayDurations, overlayFilename)
+		delete(e.sectionOverlays, internalFilename)
+	}
+	if e.bodyStart == internalFilename {
+		e.bodyStart = ""
+	}
+	if e.copyrightPage == internalFilename {
+		e.copyrightPage = ""
+	}
+	landmarks := e.landmarks[:0]
+	for _, landmark := range e.landmarks {
+		if landmark.filename != internalFilename {
+			landmarks = append(landmarks, landmark)
+		}
+	}
+	e.landmarks = landmarks
+	for _, cover := range []*epubCover{e.cover, e.backCover} {
+		if cover.xhtmlFilename == internalFilename {
+			cover.xhtmlFilename = ""
 		}
 	}
-	e.cover.xhtmlFilename = filepath.Base(coverPath)
+
+	return nil
 }
 
-// SetTitle sets the title of the EPUB.
-func (e *Epub) SetTitle(title string) {
+// SetSectionOrder reorders the sections of the EPUB, and therefore the reading
+// order and the table of contents, to match the given internal filenames (as
+// returned by AddSection), so sections can be added in any order and arranged
+// at the end. Sections nested under another section with AddSubSection should
+// come after it.
+//
+// Every section must be given exactly once, except for the cover pages, which
+// are placed separately (see SetCoverSpineIndex) and may be left out.
+// Otherwise InvalidSectionOrderError will be returned listing the missing,
+// unknown, and duplicate filenames, and the order isn't changed.
+func (e *Epub) SetSectionOrder(filenames []string) error {
 	e.Lock()
 	defer e.Unlock()
-	e.Pkg.SetTitle(title)
-	e.toc.setTitle(title)
-}
+	sections := map[string]epubSection{}
+	for _, section := range e.sections {
+		sections[section.filename] = section
+	}
 
-// Add a media file to the EPUB and return the path relative to the EPUB section
+	orderErr := &InvalidSectionOrderError{}
+	ordered := make([]epubSection, 0, len(e.sections))
+	given := map[string]bool{}
+	for _, filename := range filenames {
+		section, ok := sections[filename]
+		switch {
+		case !ok:
+			orderErr.Unknown = append(orderErr.Unknown, filename)
+		case given[filename]:
+			orderErr.Duplicate = append(orderErr.Duplicate, filename)
+		default:
+			ordered = append(ordered, section)
+		}
+		given[filename] = true
+	}
+	for _, section := range e.sections {
+		if given[section.filename] {
+			continue
+		}
+		if section.filename == e.cover.xhtmlFilename || section.filename == e.backCover.xhtmlFilename {
+			ordered = append(ordered, section)
+			continue
+		}
+		orderErr.Missing = append(orderErr.Missing, section.filename)
+	}
+	if len(orderErr.Missing) > 0 || len(orderErr.Unknown) > 0 || len(orderErr.Duplicate) > 0 {
+		return orderErr
+	}
+
+	e.sections = ordered
+
+	return nil
+}
+
+// SetBodyStart sets the section where the body of the EPUB (e.g. the first
+// chapter) starts, after any front matter. Reading systems may use this to open
+// the EPUB there instead of at its beginning.
+//
+// The internal filename is the one returned by AddSection. If no section with
+// that filename exists, FilenameNotFoundError will be returned.
+func (e *Epub) SetBodyStart(internalFilename string) error {
+	e.Lock()
+	defer e.Unlock()
+	for _, section := range e.sections {
+		if section.filename == internalFilename {
+			e.bodyStart = internalFilename
+			return nil
+		}
+	}
+
+	return &FilenameNotFoundError{Filename: internalFilename}
+}
+
+// AddCopyrightPage adds a copyright page with the given body to the front
+// matter of the EPUB, before the other sections and after the cover, and returns
+// its filename. The page is marked as such for reading systems and added to the
+// landmarks, but not to the table of contents (see SetCopyrightPageInTOC). If a
+// copyright page has already been added, it's replaced.
+func (e *Epub) AddCopyrightPage(body string) (string, error) {
+	e.Lock()
+	defer e.Unlock()
+	if e.copyrightPage != "" {
+		for i, section := range e.sections {
+			if section.filename == e.copyrightPage {
+				e.sections = append(e.sections[:i], e.sections[i+1:]...)
+				break
+			}
+		}
+	} else if err := e.checkManifestItems(1); err != nil {
+		return "", err
+	}
+
+	// First try to use the default copyright page filename
+	filename := strings.TrimSuffix(copyrightPageFilename, defaultSectionExtension) + e.sectionExtension
+	filename, err := e.addSection(body, "", filename)
+	// If that doesn't work, generate a filename
+	if _, ok := err.(*FilenameAlreadyUsedError); ok {
+		filename, err = e.addSection(body, "", "")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Move the page before the other sections
+	s := e.sections[len(e.sections)-1]
+	s.xhtml.setBodyEpubType(copyrightPageEpubType)
+	copy(e.sections[1:], e.sections[:len(e.sections)-1])
+	e.sections[0] = s
+	e.copyrightPage = filename
+
+	return filename, nil
+}
+
+// SetCopyrightPageInTOC sets whether the copyright page added by
+// AddCopyrightPage is listed in the table of contents. This is disabled by
+// default.
+func (e *Epub) SetCopyrightPageInTOC(inTOC bool) {
+	e.Lock()
+	defer e.Unlock()
+	e.copyrightPageInTOC = inTOC
+}
+
+// AddLandmark adds a landmark to the EPUB, a link to a major structural part
+// such as the cover or the start of the content, which reading systems use to
+// navigate the EPUB (e.g. "go to beginning"). The type should be one of the
+// Landmark* constants or another type of the EPUB Structural Semantics
+// Vocabulary; if it's empty, InvalidValueError will be returned.
+//
+// The section path is the filename of a section as returned by AddSection,
+// optionally with a fragment (e.g. section0001.xhtml#part2). If no section with
+// that filename exists, FilenameNotFoundError will be returned. If it's empty,
+// the landmark points to the table of contents, e.g. for LandmarkTOC.
+func (e *Epub) AddLandmark(sectionPath string, epubType string, title string) error {
+	e.Lock()
+	defer e.Unlock()
+	if epubType == "" {
+		return &InvalidValueError{Name: "epub:type", Value: epubType}
+	}
+
+	landmark := epubLandmark{
+		epubType: epubType,
+		title:    title,
+		href:     tocNavFilename,
+	}
+	if sectionPath != "" {
+		landmark.filename = strings.SplitN(sectionPath, "#", 2)[0]
+		found := false
+		for _, section := range e.sections {
+			if section.filename == landmark.filename {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return &FilenameNotFoundError{Filename: sectionPath}
+		}
+		// The TOC file is in the parent folder of the sections
+		landmark.href = path.Join(xhtmlFolderName, sectionPath)
+	}
+	e.landmarks = append(e.landmarks, landmark)
+
+	return nil
+}
+
+// SetCover sets the cover page for the EPUB using the provided image source and
+// optional CSS.
+//
+// The internal path to an already-added image file (as returned by AddImage) is
+// required. The image may be a raster image (e.g. JPEG or PNG) or an SVG image.
+// It's marked as the cover image in the package file both for EPUB 3 reading
+// systems (the cover-image property) and for EPUB 2 reading systems (the cover
+// meta element).
+//
+// The internal path to an already-added CSS file (as returned by AddCSS) to be
+// used for the cover is optional. If the CSS path isn't provided, default CSS
+// will be used.
+func (e *Epub) SetCover(internalImagePath string, internalCSSPath string) {
+	e.Lock()
+	defer e.Unlock()
+	e.setCover(internalImagePath, internalCSSPath, "", coverBody(internalImagePath, ""))
+}
+
+// SetCoverWithAlt sets the cover page for the EPUB like SetCover, with the given
+// alternative text for the cover image, e.g. a description of the cover for
+// readers using a screen reader. If the alternative text is empty, "Cover
+// Image" is used like SetCover does.
+func (e *Epub) SetCoverWithAlt(internalImagePath string, internalCSSPath string, alt string) {
+	e.Lock()
+	defer e.Unlock()
+	e.setCover(internalImagePath, internalCSSPath, "", coverBody(internalImagePath, alt))
+}
+
+// Get the body of the cover page showing the given image with the given
+// alternative text, or the default one if it's empty
+func coverBody(internalImagePath string, alt string) string {
+	if alt == "" {
+		alt = defaultCoverAlt
+	}
+	return fmt.Sprintf(defaultCoverBody, internalImagePath, html.EscapeString(alt))
+}
+
+// SetCoverE is the same as SetCover, but checks that the CSS file exists first.
+// If the CSS path is provided but doesn't correspond to a CSS file added by
+// AddCSS, FilenameNotFoundError will be returned and the cover won't be set,
+// instead of the cover silently rendering unstyled.
+func (e *Epub) SetCoverE(internalImagePath string, internalCSSPath string) error {
+	e.Lock()
+	defer e.Unlock()
+	if internalCSSPath != "" {
+		cssFilename := path.Base(internalCSSPath)
+		if _, ok := e.css[cssFilename]; !ok || internalCSSPath != path.Join("..", CSSFolderName, cssFilename) {
+			return &FilenameNotFoundError{Filename: internalCSSPath}
+		}
+	}
+
+	e.setCover(internalImagePath, internalCSSPath, "", coverBody(internalImagePath, ""))
+
+	return nil
+}
+
+// SetCoverFromURL adds the image at the provided URL to the EPUB (see AddImage)
+// and sets it as the cover like SetCover, replacing any cover set before. If
+// the image can't be retrieved, FileRetrievalError will be returned and the
+// cover won't be changed.
+//
+// The internal path to an already-added CSS file (as returned by AddCSS) to be
+// used for the cover is optional. If the CSS path isn't provided, default CSS
+// will be used.
+func (e *Epub) SetCoverFromURL(url string, internalCSSPath string) error {
+	e.Lock()
+	defer e.Unlock()
+	internalImagePath := ""
+	// Reuse the image if it has already been added, e.g. when setting the same
+	// cover again
+	for imageFilename, source := range e.images {
+		if source == url {
+			internalImagePath = path.Join("..", ImageFolderName, imageFilename)
+			break
+		}
+	}
+	if internalImagePath == "" {
+		var err error
+		internalImagePath, err = e.addMedia(url, "", imageFileFormat, ImageFolderName, e.images)
+		if err != nil {
+			return err
+		}
+	}
+
+	e.setCover(internalImagePath, internalCSSPath, "", coverBody(internalImagePath, ""))
+
+	return nil
+}
+
+// SetAnimatedCover sets the cover page for the EPUB using the provided video,
+// which is played in a loop, and poster image. Reading systems which don't
+// support video show the poster image instead, which is also used as the cover
+// image of the EPUB (e.g. in library views).
+//
+// The internal paths to an already-added video file (as returned by AddVideo)
+// and image file (as returned by AddImage) are required. The video may also be
+// the URL of a remote video, in which case the cover page is marked as using
+// remote resources. The default cover CSS is used.
+func (e *Epub) SetAnimatedCover(internalVideoPath string, internalPosterImagePath string) {
+	e.Lock()
+	defer e.Unlock()
+	coverBody := fmt.Sprintf(animatedCoverBody, internalVideoPath, internalPosterImagePath, internalPosterImagePath)
+	if isRemoteResource(internalVideoPath) {
+		e.setCover(internalPosterImagePath, "", "", coverBody)
+		for i, section := range e.sections {
+			if section.filename == e.cover.xhtmlFilename {
+				e.sections[i].properties = addProperty(e.sections[i].properties, remoteResourcesProperty)
+				break
+			}
+		}
+	} else {
+		e.setCover(internalPosterImagePath, "", filepath.Base(internalVideoPath), coverBody)
+	}
+}
+
+func (e *Epub) setCover(internalImagePath string, internalCSSPath string, videoFilename string, coverBody string) {
+	// If a cover already exists, remove it
+	e.removeCover(e.cover, e.backCover, filepath.Base(internalImagePath), videoFilename, internalCSSPath)
+
+	e.cover.imageFilename = filepath.Base(internalImagePath)
+	e.cover.videoFilename = videoFilename
+	e.Pkg.SetCover(e.cover.imageFilename)
+
+	internalCSSPath = e.addCoverCSS(e.cover, internalCSSPath)
+
+	// Title won't be used since the cover won't be added to the TOC
+	e.cover.xhtmlFilename = e.addCoverSection(defaultCoverXhtmlFilename, coverBody, internalCSSPath, defaultCoverEpubType)
+}
+
+// SetBackCover sets the back cover page for the EPUB (e.g. with the blurb and
+// barcode) using the provided image source and optional CSS. The back cover page
+// is the last item in the reading order and is added to the landmarks.
+//
+// The internal path to an already-added image file (as returned by AddImage) is
+// required.
+//
+// The internal path to an already-added CSS file (as returned by AddCSS) to be
+// used for the back cover is optional. If the CSS path isn't provided, default
+// CSS will be used.
+func (e *Epub) SetBackCover(internalImagePath string, internalCSSPath string) {
+	e.Lock()
+	defer e.Unlock()
+	// If a back cover already exists, remove it
+	e.removeCover(e.backCover, e.cover, filepath.Base(internalImagePath), "", internalCSSPath)
+
+	e.backCover.imageFilename = filepath.Base(internalImagePath)
+
+	internalCSSPath = e.addCoverCSS(e.backCover, internalCSSPath)
+
+	coverBody := fmt.Sprintf(backCoverBody, internalImagePath)
+	e.backCover.xhtmlFilename = e.addCoverSection(backCoverXhtmlFilename, coverBody, internalCSSPath, backCoverLandmarkEpubType)
+}
+
+// Remove the page of a cover which is being replaced, along with its image,
+// video, and CSS unless they are used for the new cover or the other cover
+// (i.e. the back cover when replacing the front cover and vice versa) as well
+func (e *Epub) removeCover(cover *epubCover, otherCover *epubCover, imageFilename string, videoFilename string, internalCSSPath string) {
+	if cover.xhtmlFilename == "" {
+		return
+	}
+
+	// Remove the xhtml file
+	for i, section := range e.sections {
+		if section.filename == cover.xhtmlFilename {
+			e.sections = append(e.sections[:i], e.sections[i+1:]...)
+			break
+		}
+	}
+
+	// Remove the image, unless it's used for the new cover as well
+	if cover.imageFilename != imageFilename && cover.imageFilename != otherCover.imageFilename {
+		delete(e.images, cover.imageFilename)
+	}
+
+	// Remove the video, unless it's used for the new cover as well
+	if cover.videoFilename != videoFilename && cover.videoFilename != otherCover.videoFilename {
+		delete(e.videos, cover.videoFilename)
+	}
+
+	// Remove the CSS, unless it's used for the new cover as well
+	if (internalCSSPath == "" || cover.cssFilename != filepath.Base(internalCSSPath)) && cover.cssFilename != otherCover.cssFilename {
+		delete(e.css, cover.cssFilename)
+	}
+
+	cover.defaultCSS = false
+}
+
+// Set the CSS of a cover, adding the default cover stylesheet if one isn't
+// provided, and return the internal path to the CSS
+func (e *Epub) addCoverCSS(cover *epubCover, internalCSSPath string) string {
+	// Use default cover stylesheet if one isn't provided
+	cover.defaultCSS = internalCSSPath == ""
+	if cover.defaultCSS {
+		content := []byte(e.defaultCoverCSSContent())
+		var err error
+		internalCSSPath, err = e.addCSSFromBytes(content, defaultCoverCSSFilename)
+		// If that doesn't work, generate a filename
+		if _, ok := err.(*FilenameAlreadyUsedError); ok {
+			internalCSSPath, err = e.addCSSFromBytes(content, "")
+		}
+		if err != nil {
+			// This shouldn't cause an error since the CSS is in memory
+			panic(fmt.Sprintf("Error adding default cover CSS file: %s", err))
+		}
+	}
+	cover.cssFilename = filepath.Base(internalCSSPath)
+
+	return internalCSSPath
+}
+
+// Add the page of a cover with the epub:type of its body and return its
+// filename
+func (e *Epub) addCoverSection(defaultFilename string, coverBody string, internalCSSPath string, epubType string) string {
+	// First try to use the default cover filename
+	coverFilename := strings.TrimSuffix(defaultFilename, defaultSectionExtension) + e.sectionExtension
+	coverPath, err := e.addSection(coverBody, "", coverFilename, internalCSSPath)
+	// If that doesn't work, generate a filename
+	if _, ok := err.(*FilenameAlreadyUsedError); ok {
+		coverPath, err = e.addSection(coverBody, "", "", internalCSSPath)
+		if _, ok := err.(*FilenameAlreadyUsedError); ok {
+			// This shouldn't cause an error since we're not specifying a filename
+			panic(fmt.Sprintf("Error adding default cover XHTML file: %s", err))
+		}
+	}
+
+	// Mark the cover page as such for reading systems
+	e.sections[len(e.sections)-1].xhtml.setBodyEpubType(epubType)
+
+	return filepath.Base(coverPath)
+}
+
+// SetCoverSpineIndex sets the position of the cover page in the reading order
+// (spine) of the EPUB. By default the cover is the first item in the spine; an
+// index of 1 would place it after the first section, and so on.
+//
+// The index must be between 0 and the number of sections added so far (not
+// counting the cover), otherwise SpineIndexOutOfRangeError will be returned. If
+// sections are removed later on, the cover will be placed last.
+func (e *Epub) SetCoverSpineIndex(index int) error {
+	e.Lock()
+	defer e.Unlock()
+	max := len(e.sections)
+	if e.cover.xhtmlFilename != "" {
+		max--
+	}
+	if e.backCover.xhtmlFilename != "" {
+		max--
+	}
+	if index < 0 || index > max {
+		return &SpineIndexOutOfRangeError{Index: index, Max: max}
+	}
+	e.cover.spineIndex = index
+
+	return nil
+}
+
+// SetSectionLayout overrides the layout of a single section, e.g. to make a map
+// or infographic fixed-layout (pre-paginated) in an otherwise reflowable EPUB.
+// The layout must be one of the RenditionLayout* constants, otherwise
+// InvalidValueError will be returned.
+//
+// The internal filename is the one returned by AddSection. If no section with
+// that filename exists, FilenameNotFoundError will be returned.
+func (e *Epub) SetSectionLayout(internalFilename string, layout string) error {
+	e.Lock()
+	defer e.Unlock()
+	switch layout {
+	case RenditionLayoutPrePaginated, RenditionLayoutReflowable:
+	default:
+		return &InvalidValueError{Name: PropertyRenditionLayout, Value: layout}
+	}
+
+	for i, section := range e.sections {
+		if section.filename == internalFilename {
+			e.sections[i].spineProperties = PropertyRenditionLayout + "-" + layout
+			return nil
+		}
+	}
+
+	return &FilenameNotFoundError{Filename: internalFilename}
+}
+
+// SetSectionLinear sets whether a section is part of the linear reading order.
+// Sections which aren't, e.g. notes or sidebars, are still in the spine so they
+// can be reached through links, but reading systems may skip them when paging
+// through the EPUB. Sections are linear by default.
+//
+// The internal filename is the one returned by AddSection. If no section with
+// that filename exists, FilenameNotFoundError will be returned.
+func (e *Epub) SetSectionLinear(internalFilename string, linear bool) error {
+	e.Lock()
+	defer e.Unlock()
+	for i, section := range e.sections {
+		if section.filename == internalFilename {
+			e.sections[i].nonLinear = !linear
+			return nil
+		}
+	}
+
+	return &FilenameNotFoundError{Filename: internalFilename}
+}
+
+// SetSectionScripted sets whether a section contains scripts, either inline or
+// linked (e.g. JavaScript files added with AddJavaScript), or forms. Such
+// sections must be marked as scripted in the package file, otherwise the EPUB
+// isn't valid. Sections aren't scripted by default.
+//
+// The internal filename is the one returned by AddSection. If no section with
+// that filename exists, FilenameNotFoundError will be returned.
+func (e *Epub) SetSectionScripted(internalFilename string, scripted bool) error {
+	e.Lock()
+	defer e.Unlock()
+	for i, section := range e.sections {
+		if section.filename == internalFilename {
+			if scripted {
+				e.sections[i].properties = addProperty(section.properties, scriptedProperty)
+			} else {
+				e.sections[i].properties = removeProperty(section.properties, scriptedProperty)
+			}
+			return nil
+		}
+	}
+
+	return &FilenameNotFoundError{Filename: internalFilename}
+}
+
+// SetSectionPageSpread sets where a section is placed when pages are shown in
+// spreads of two, e.g. to make sure a two-page illustration starts on the left
+// page. The spread must be one of the PageSpread* constants, otherwise
+// InvalidValueError will be returned. An empty spread lets the reading system
+// place the section.
+//
+// The internal filename is the one returned by AddSection. If no section with
+// that filename exists, FilenameNotFoundError will be returned.
+func (e *Epub) SetSectionPageSpread(internalFilename string, spread string) error {
+	e.Lock()
+	defer e.Unlock()
+	switch spread {
+	case "", PageSpreadLeft, PageSpreadRight, PageSpreadCenter:
+	default:
+		return &InvalidValueError{Name: "page-spread", Value: spread}
+	}
+
+	for i, section := range e.sections {
+		if section.filename == internalFilename {
+			e.sections[i].pageSpread = spread
+			return nil
+		}
+	}
+
+	return &FilenameNotFoundError{Filename: internalFilename}
+}
+
+// SetFixedLayoutFromCover makes the EPUB fixed-layout (pre-paginated) with the
+// size of every page set to the dimensions of the cover image, which saves
+// entering the size manually for books with one image per page (e.g. comics).
+//
+// The cover must already be set with SetCover. If no cover is set or the
+// format of the cover image can't be decoded (only GIF, JPEG, and PNG can), the
+// layout isn't changed. FileRetrievalError will be returned if the cover image
+// can't be retrieved.
+func (e *Epub) SetFixedLayoutFromCover() error {
+	e.Lock()
+	defer e.Unlock()
+	coverSource, ok := e.images[e.cover.imageFilename]
+	if e.cover.xhtmlFilename == "" || !ok {
+		return nil
+	}
+
+	content, err := e.newGrabber().readMedia(coverSource)
+	if err != nil {
+		return err
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return nil
+	}
+
+	e.setFixedLayout(config.Width, config.Height)
+
+	return nil
+}
+
+// SetFixedLayout makes the EPUB fixed-layout (pre-paginated), e.g. for comics
+// or children's books, with the given size of every page in CSS pixels. The
+// size is set in the viewport of every section; the position of a section in a
+// spread can be set with SetSectionPageSpread. The width and height must be
+// positive, otherwise InvalidValueError will be returned.
+func (e *Epub) SetFixedLayout(width int, height int) error {
+	e.Lock()
+	defer e.Unlock()
+	if width <= 0 || height <= 0 {
+		return &InvalidValueError{Name: xhtmlViewportName, Value: fmt.Sprintf(viewportFormat, width, height)}
+	}
+	e.setFixedLayout(width, height)
+
+	return nil
+}
+
+// Set the layout of the EPUB to pre-paginated with the given page size
+func (e *Epub) setFixedLayout(width int, height int) {
+	e.Pkg.setMetaProperty(PropertyRenditionLayout, RenditionLayoutPrePaginated)
+	e.viewport = fmt.Sprintf(viewportFormat, width, height)
+}
+
+// SetTitle sets the title of the EPUB.
+func (e *Epub) SetTitle(title string) {
+	e.Lock()
+	defer e.Unlock()
+	e.Pkg.SetTitle(title)
+	e.toc.setTitle(title)
+}
+
+// Title returns the main title of the EPUB.
+func (e *Epub) Title() string {
+	e.Lock()
+	defer e.Unlock()
+	return e.Pkg.title()
+}
+
+// Lang returns the language of the EPUB, e.g. "en".
+func (e *Epub) Lang() string {
+	e.Lock()
+	defer e.Unlock()
+	return e.Pkg.xml.Metadata.Language
+}
+
+// Description returns the description of the EPUB, or an empty string if none
+// has been set.
+func (e *Epub) Description() string {
+	e.Lock()
+	defer e.Unlock()
+	return e.Pkg.xml.Metadata.Description
+}
+
+// Ppd returns the page progression direction of the EPUB (e.g. "rtl"), or an
+// empty string if none has been set.
+func (e *Epub) Ppd() string {
+	e.Lock()
+	defer e.Unlock()
+	return e.Pkg.xml.Spine.Ppd
+}
+
+// SetCoverThumbnail sets a smaller version of the cover, which is referenced
+// from the metadata of the package file with the OPDS thumbnail relation
+// (http://opds-spec.org/image/thumbnail) so catalog generators can find it.
+//
+// The internal path to an already-added image file (as returned by AddImage) is
+// required.
+func (e *Epub) SetCoverThumbnail(internalImagePath string) {
+	e.Lock()
+	defer e.Unlock()
+	e.coverThumbnail = filepath.Base(internalImagePath)
+}
+
+// SetPublisherLogo sets the logo of the publisher, which is referenced from the
+// metadata of the package file so distribution channels can find it.
+//
+// The internal path to an already-added image file (as returned by AddImage) is
+// required.
+func (e *Epub) SetPublisherLogo(internalImagePath string) {
+	e.Lock()
+	defer e.Unlock()
+	e.publisherLogo = filepath.Base(internalImagePath)
+}
+
+// SetSectionExtension sets the file extension used for the filenames generated
+// by AddSection and SetCover, which is ".xhtml" by default. Some conversion
+// tools expect ".html" instead. The extension must be one of ".xhtml", ".html",
+// or ".htm", otherwise InvalidValueError will be returned.
+//
+// This only affects sections added afterwards. Regardless of the extension, the
+// sections are XHTML documents and are listed in the package file with the
+// application/xhtml+xml media type, as required by the EPUB specification.
+func (e *Epub) SetSectionExtension(extension string) error {
+	e.Lock()
+	defer e.Unlock()
+	switch extension {
+	case ".xhtml", ".html", ".htm":
+	default:
+		return &InvalidValueError{Name: "section extension", Value: extension}
+	}
+	e.sectionExtension = extension
+
+	return nil
+}
+
+// SetProgressHandler sets a function which is called with the number of bytes
+// written by Write or WriteTo, every 64 KiB and once more when the EPUB file has
+// been written completely, e.g. to show a progress bar when uploading a large
+// EPUB. It's also called with warnings, e.g. by SetWarnDuplicateSections. A nil
+// handler disables progress reporting, which is the default.
+//
+// The handler is called while the EPUB is locked, so it must not call any
+// methods of the Epub, which would deadlock. Methods called from other
+// goroutines wait until the EPUB has been written.
+func (e *Epub) SetProgressHandler(handler func(Progress)) {
+	e.Lock()
+	defer e.Unlock()
+	e.progressHandler = handler
+}
+
+// SetFetchHeaders sets headers which are added to every request retrieving
+// media from a URL, e.g. a User-Agent or the Authorization needed by a CDN
+// which requires a token. They apply to all URL sources added with AddImage,
+// AddFont, AddVideo, AddCSS and the other Add* methods, both when the media is
+// added and when the EPUB is written, and to the checks of CheckRemoteResources.
+// Passing nil removes the headers.
+func (e *Epub) SetFetchHeaders(headers http.Header) {
+	e.Lock()
+	defer e.Unlock()
+	e.fetchHeaders = headers.Clone()
+}
+
+// SetFetchConcurrency sets the maximum number of media files (images, fonts,
+// CSS, videos, etc.) which are retrieved at the same time when the EPUB is
+// written, which speeds up writing an EPUB with many media files from URLs.
+// The media is still added to the package file in the same order. If a file
+// can't be retrieved, no more files are retrieved and the error of the first
+// file in that order which failed is returned. A value of 1 or less retrieves the files one
+// after the other, which is the default.
+func (e *Epub) SetFetchConcurrency(n int) {
+	e.Lock()
+	defer e.Unlock()
+	e.fetchConcurrency = n
+}
+
+// SetDownloadCacheDir sets a directory on the local filesystem in which media
+// retrieved from URLs (e.g. by AddImage or Write) is cached. Media already in
+// the cache isn't downloaded again, which speeds up retrying a build that
+// failed or rebuilding an EPUB which uses the same remote media.
+//
+// The directory is created if it doesn't exist. The cache is keyed by the URL
+// of the media and is never invalidated; delete the directory to clear it. An
+// empty path disables the cache, which is the default. See
+// SetRevalidateDownloadCache to check whether cached media is still up to date.
+func (e *Epub) SetDownloadCacheDir(path string) error {
+	e.Lock()
+	defer e.Unlock()
+	if path != "" {
+		if err := os.MkdirAll(path, dirPermissions); err != nil {
+			return err
+		}
+	}
+	e.downloadCacheDir = path
+
+	return nil
+}
+
+// SetRevalidateDownloadCache sets whether media in the download cache (see
+// SetDownloadCacheDir) is checked with the server before it's used. The
+// validators the server sent with the media (the ETag and Last-Modified
+// headers) are stored alongside it in the cache and sent back in a conditional
+// request (If-None-Match and If-Modified-Since); the cached media is only
+// downloaded again if it has changed. Media the server sent no validators for
+// is always taken from the cache. This is disabled by default, in which case
+// cached media is used without contacting the server.
+func (e *Epub) SetRevalidateDownloadCache(revalidate bool) {
+	e.Lock()
+	defer e.Unlock()
+	e.revalidateDownloadCache = revalidate
+}
+
+// SetDataURLStagingThreshold sets the size in bytes above which media added
+// from a data URL (e.g. with AddImage) is decoded to a temporary file right
+// away, instead of keeping the base64-encoded data URL in memory until the EPUB
+// is written. This reduces the memory used when adding many large media files
+// from memory. A size of 0 disables this, which is the default.
+//
+// The temporary files are removed by Close, or once the EPUB is no longer used
+// (i.e. garbage collected). An error is returned if the temporary directory
+// can't be created.
+func (e *Epub) SetDataURLStagingThreshold(size int) error {
+	e.Lock()
+	defer e.Unlock()
+	if size > 0 && e.stagingDir == "" {
+		stagingDir, err := ioutil.TempDir("", tempDirPrefix)
+		if err != nil {
+			return err
+		}
+		e.stagingDir = stagingDir
+		runtime.SetFinalizer(e, func(e *Epub) {
+			os.RemoveAll(e.stagingDir)
+		})
+	}
+	e.dataURLStagingThreshold = size
+
+	return nil
+}
+
+// Epub holds temporary files which can be released with Close
+var _ io.Closer = (*Epub)(nil)
+
+// Close removes the temporary files held by the EPUB, i.e. the media added from
+// data URLs which was decoded to temporary files (see
+// SetDataURLStagingThreshold). These are otherwise only removed once the EPUB
+// is garbage collected. The download cache (see SetDownloadCacheDir) is kept,
+// since it's meant to be shared between builds.
+//
+// Media added from data URLs may no longer be available afterwards, so the EPUB
+// shouldn't be written once it's closed. Closing an EPUB more than once has no
+// effect.
+func (e *Epub) Close() error {
+	e.Lock()
+	defer e.Unlock()
+	if e.stagingDir == "" {
+		return nil
+	}
+	runtime.SetFinalizer(e, nil)
+	err := os.RemoveAll(e.stagingDir)
+	e.stagingDir = ""
+	e.dataURLStagingThreshold = 0
+
+	return err
+}
+
+// SetNormalizeCSS sets whether CSS files are rewritten with consistent
+// whitespace and one rule per line when the EPUB is written, which makes
+// stylesheets with very long lines (e.g. minified third-party CSS) readable and
+// easier for some parsers. It is off by default so CSS files are stored exactly
+// as they were added.
+func (e *Epub) SetNormalizeCSS(normalize bool) {
+	e.Lock()
+	defer e.Unlock()
+	e.normalizeCSS = normalize
+}
+
+// SetPreserveSourceTimes sets whether the files in the EPUB which were added
+// from local files keep the modification time of the source file, e.g. for
+// archival. It is off by default. Files added from a URL or data URL and
+// generated files never have a modification time.
+func (e *Epub) SetPreserveSourceTimes(preserve bool) {
+	e.Lock()
+	defer e.Unlock()
+	e.preserveSourceTimes = preserve
+}
+
+// SetCompressionLevel sets how much the files in the EPUB file are compressed,
+// using the levels of compress/flate: from flate.BestSpeed to
+// flate.BestCompression, flate.HuffmanOnly, or flate.DefaultCompression, which
+// is the default. With flate.NoCompression, the files are stored without
+// compression, which is faster for EPUBs that mostly contain already
+// compressed media such as JPEG images or videos. The mimetype file is always
+// stored uncompressed as required by the EPUB spec. If the level is none of
+// these, InvalidValueError will be returned.
+func (e *Epub) SetCompressionLevel(level int) error {
+	e.Lock()
+	defer e.Unlock()
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		return &InvalidValueError{Name: "compression level", Value: strconv.Itoa(level)}
+	}
+	e.compressionLevel = level
+
+	return nil
+}
+
+// SetStripColorProfiles sets whether embedded ICC color profiles and Exif
+// metadata are removed from JPEG and PNG images when the EPUB is written, which
+// can make images considerably smaller. The image data itself isn't changed, so
+// there's no loss of quality, but images which use a color space other than
+// sRGB may look different, and the orientation stored in the Exif metadata is
+// lost. Images which can't be decoded are left unchanged. This is disabled by
+// default.
+func (e *Epub) SetStripColorProfiles(strip bool) {
+	e.Lock()
+	defer e.Unlock()
+	e.stripColorProfiles = strip
+}
+
+// SetStripCSSSourceMaps sets whether source map references (e.g.
+// /*# sourceMappingURL=epub.css.map */) are removed from CSS files when the EPUB
+// is written, which is the default. Since source maps aren't added to the EPUB,
+// such references would otherwise point to files which don't exist.
+func (e *Epub) SetStripCSSSourceMaps(strip bool) {
+	e.Lock()
+	defer e.Unlock()
+	e.stripCSSSourceMaps = strip
+}
+
+// SetTOCTitle sets the title of the table of contents page, which is used for
+// its heading as well as its document title. By default, both are the title of
+// the EPUB.
+func (e *Epub) SetTOCTitle(title string) {
+	e.Lock()
+	defer e.Unlock()
+	e.toc.setNavTitle(title)
+}
+
+// SetNCXDocAuthor sets the author shown by EPUB 2 reading systems, which is
+// written to the EPUB v2 table of contents (toc.ncx). By default, it's the first
+// author of the EPUB (see Pkg.AddCreator). An empty author restores the default.
+func (e *Epub) SetNCXDocAuthor(author string) {
+	e.Lock()
+	defer e.Unlock()
+	e.ncxDocAuthor = author
+}
+
+// SetAutoTOCLabels sets whether sections without a title are added to the table
+// of contents with a generated label based on their position in the reading
+// order (e.g. "Section 3"), instead of being left out of it, which is the
+// default. The covers are never added to the table of contents.
+func (e *Epub) SetAutoTOCLabels(autoLabels bool) {
+	e.Lock()
+	defer e.Unlock()
+	e.autoTOCLabels = autoLabels
+}
+
+// SetMaxManifestItems sets the maximum number of files (sections, CSS files,
+// fonts, images, and other media) which can be added to the EPUB, e.g. to catch
+// a loop which adds far more files than intended when generating an EPUB. Once
+// the limit is reached, methods such as AddSection and AddImage return
+// TooManyManifestItemsError. Files which have already been added are kept even
+// if the limit is lowered below their number. A limit of 0 (the default) or
+// less means there is no limit.
+func (e *Epub) SetMaxManifestItems(max int) {
+	e.Lock()
+	defer e.Unlock()
+	e.maxManifestItems = max
+}
+
+// SetMaxNestingDepth sets the maximum depth of sections nested with
+// AddSubSection, counting the sections at the top level of the table of
+// contents as depth 1, e.g. to guard against pathologically deep tables of
+// contents when the structure comes from untrusted input. Some reading systems
+// truncate or fail to display deeply nested tables of contents. Once the limit
+// would be exceeded, AddSubSection returns NestingTooDeepError. A limit of 0 or
+// less means there is no limit. The default is 6, matching the levels of HTML
+// headings.
+func (e *Epub) SetMaxNestingDepth(depth int) {
+	e.Lock()
+	defer e.Unlock()
+	e.maxNestingDepth = depth
+}
+
+// Check whether the given number of files can be added to the EPUB without
+// exceeding the limit set by SetMaxManifestItems
+func (e *Epub) checkManifestItems(count int) error {
+	if e.maxManifestItems <= 0 {
+		return nil
+	}
+
+	items := len(e.sections)
+	for _, mediaMap := range []map[string]string{
+		e.css,
+		e.fonts,
+		e.images,
+		e.videos,
+		e.audios,
+		e.scripts,
+		e.media,
+		e.lexicons,
+		e.overlays,
+		e.encrypted,
+	} {
+		items += len(mediaMap)
+	}
+	if items+count > e.maxManifestItems {
+		return &TooManyManifestItemsError{Max: e.maxManifestItems}
+	}
+
+	return nil
+}
+
+// SetAutoPageProgression sets whether the page progression direction of the
+// EPUB is set to "rtl" when it's written if the language of the EPUB (see
+// Pkg.SetLang) is written right to left, e.g. Arabic, Hebrew, Persian, or
+// Urdu, and no direction has been set with Pkg.SetPpd. It's disabled by
+// default.
+func (e *Epub) SetAutoPageProgression(auto bool) {
+	e.Lock()
+	defer e.Unlock()
+	e.autoPageProgression = auto
+}
+
+// SetWarnDuplicateSections sets whether a warning is reported to the progress
+// handler (see SetProgressHandler) when a section is added with the same body as
+// a section which has already been added under a different filename, which is
+// usually a mistake when generating EPUBs. The warning is a
+// DuplicateSectionError; the section is still added. Only sections added after
+// enabling it are checked. It's disabled by default.
+func (e *Epub) SetWarnDuplicateSections(warn bool) {
+	e.Lock()
+	defer e.Unlock()
+	e.warnDuplicateSections = warn
+	if warn && e.sectionBodyHashes == nil {
+		e.sectionBodyHashes = make(map[[sha256.Size]byte]string)
+	}
+}
+
+// SetTOCNumbering sets whether the entries of the table of contents are
+// prefixed with their number, e.g. "1. Introduction", which is useful for
+// reference works. Nested entries are numbered hierarchically, e.g. "1.1
+// Background". It is off by default; the titles of the sections aren't changed.
+func (e *Epub) SetTOCNumbering(numbering bool) {
+	e.Lock()
+	defer e.Unlock()
+	e.toc.setNumbering(numbering)
+}
+
+// AddTocEntryWithIcon adds an entry to the table of contents which shows an
+// icon before its title, e.g. for a visual table of contents. Entries added
+// this way come after the entries of the sections. Reading systems which
+// don't support images in the table of contents only show the title.
+//
+// The href is the path to a section as returned by AddSection, optionally with
+// a fragment (e.g. section0001.xhtml#map), or a URL.
+//
+// The internal path to an already-added image file (as returned by AddImage) is
+// required for the icon. If no such image exists, FilenameNotFoundError will be
+// returned. The alternate text of the icon is given by iconAlt, which can be
+// empty if the icon is purely decorative.
+func (e *Epub) AddTocEntryWithIcon(title string, href string, internalIconPath string, iconAlt string) error {
+	e.Lock()
+	defer e.Unlock()
+	iconFilename := path.Base(internalIconPath)
+	if _, ok := e.images[iconFilename]; !ok || internalIconPath != path.Join("..", ImageFolderName, iconFilename) {
+		return &FilenameNotFoundError{Filename: internalIconPath}
+	}
+
+	// The TOC file is in the parent folder of the sections
+	if !isRemoteResource(href) {
+		href = path.Join(xhtmlFolderName, href)
+	}
+	e.tocEntries = append(e.tocEntries, epubTocEntry{
+		title:    title,
+		href:     href,
+		iconPath: path.Join(ImageFolderName, iconFilename),
+		iconAlt:  iconAlt,
+	})
+
+	return nil
+}
+
+// Get a grabber to retrieve media using the settings of the EPUB
+func (e *Epub) newGrabber() grabber {
+	return grabber{
+		Client:           e.Client,
+		ctx:              e.context(),
+		cacheDir:         e.downloadCacheDir,
+		revalidateCache:  e.revalidateDownloadCache,
+		headers:          e.fetchHeaders,
+		stagingThreshold: e.dataURLStagingThreshold,
+		stagingDir:       e.stagingDir,
+		fsys:             e.stagingStorage(),
+	}
+}
+
+// Get the context of the write in progress, or an empty context if the EPUB
+// isn't being written
+func (e *Epub) context() context.Context {
+	if e.ctx == nil {
+		return context.Background()
+	}
+	return e.ctx
+}
+
+// Get the storage the EPUB is assembled in while it's written, which is the
+// default storage unless it's assembled in memory by Bytes
+func (e *Epub) stagingStorage() storage.Storage {
+	if e.stagingFS == nil {
+		return filesystem
+	}
+	return e.stagingFS
+}
+
+// Check whether the path of a resource points outside of the EPUB
+func isRemoteResource(resourcePath string) bool {
+	return strings.HasPrefix(resourcePath, "http://") || strings.HasPrefix(resourcePath, "https://")
+}
+
+// Add a media file to the EPUB and return the path relative to the EPUB section
 // files
-func addMedia(client *http.Client, source string, internalFilename string, mediaFileFormat string, mediaFolderName string, mediaMap map[string]string) (string, error) {
-	err := grabber{client}.checkMedia(source)
+func addMedia(g grabber, source string, internalFilename string, mediaFileFormat string, mediaFolderName string, mediaMap map[string]string) (string, error) {
+	err := g.checkMedia(source)
 	if err != nil {
 		return "", &FileRetrievalError{
 			Source: source,
@@ -409,6 +2172,18 @@ func addMedia(client *http.Client, source string, internalFilename string, media
 		return "", &FilenameAlreadyUsedError{Filename: internalFilename}
 	}
 
+	// Decode large data URLs right away so they don't have to be kept in memory
+	if g.stagingThreshold > 0 && len(source) > g.stagingThreshold && isDataURL(source) {
+		stagedPath, err := g.stageDataURL(source)
+		if err != nil {
+			return "", &FileRetrievalError{
+				Source: source,
+				Err:    err,
+			}
+		}
+		source = stagedPath
+	}
+
 	mediaMap[internalFilename] = source
 
 	return path.Join(
@@ -417,3 +2192,24 @@ func addMedia(client *http.Client, source string, internalFilename string, media
 		internalFilename,
 	), nil
 }
+
+// Add a media file like addMedia, recording the order in which media is added
+func (e *Epub) addMedia(source string, internalFilename string, mediaFileFormat string, mediaFolderName string, mediaMap map[string]string) (string, error) {
+	mediaPath, err := addMedia(e.newGrabber(), source, internalFilename, mediaFileFormat, mediaFolderName, mediaMap)
+	if err != nil {
+		return "", err
+	}
+	e.recordMedia(mediaFolderName, path.Base(mediaPath))
+
+	return mediaPath, nil
+}
+
+// Record that a media file has been added, so media is added to the manifest
+// in the order it was added. A media file added again after it was removed
+// loses its ordering hint.
+func (e *Epub) recordMedia(mediaFolderName string, mediaFilename string) {
+	mediaPath := path.Join(mediaFolderName, mediaFilename)
+	e.mediaAdded++
+	e.mediaSequence[mediaPath] = e.mediaAdded
+	delete(e.manifestOrder, mediaPath)
+}
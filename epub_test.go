@@ -8,15 +8,18 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
 	"sort"
 	"strings"
 	"testing"
 
 	"github.com/bmaupin/go-epub/internal/storage"
 	"github.com/gofrs/uuid"
+	"github.com/vincent-petithory/dataurl"
 )
 
 const (
@@ -33,12 +36,12 @@ const (
 	testCoverCSSSource       = "testdata/cover.css"
 	testCoverContentTemplate = `<?xml version="1.0" encoding="UTF-8"?>
 <!DOCTYPE html>
-<html xmlns="http://www.w3.org/1999/xhtml">
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
   <head>
     <title>%s</title>
     <link rel="stylesheet" type="text/css" href="%s"></link>
   </head>
-  <body>
+  <body epub:type="cover">
     <img src="%s" alt="Cover Image" />
   </body>
 </html>`
@@ -53,6 +56,7 @@ const (
 	testEpubPpd               = "rtl"
 	testEpubTitle             = "My title"
 	testEpubDescription       = "My description"
+	testEpubRights            = "Public domain"
 	testFontCSSFilename       = "font.css"
 	testFontCSSSource         = "testdata/font.css"
 	testFontFromFileSource    = "testdata/redacted-script-regular.ttf"
@@ -252,6 +256,44 @@ func TestAddFont(t *testing.T) {
 	cleanup(testEpubFilename, tempDir)
 }
 
+func TestAddWebFont(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	testFontCSSPath, err := e.AddWebFont(testFontFromFileSource, "Redacted Script")
+	if err != nil {
+		t.Errorf("Error adding web font: %s", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, testFontCSSPath))
+	if err != nil {
+		t.Errorf("Unexpected error reading font CSS file from EPUB: %s", err)
+	}
+
+	testFontFace := `@font-face {
+  font-family: "Redacted Script";
+  src: url("../fonts/redacted-script-regular.ttf");
+}`
+	if !strings.Contains(string(contents), testFontFace) {
+		t.Errorf(
+			"Font CSS file contents don't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			contents,
+			testFontFace)
+	}
+	if strings.Contains(string(contents), testFontFromFileSource) {
+		t.Errorf("Font CSS file shouldn't contain the local font source\nGot: %s", contents)
+	}
+
+	_, err = storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, FontFolderName, "redacted-script-regular.ttf"))
+	if err != nil {
+		t.Errorf("Unexpected error reading font file from EPUB: %s", err)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
 func TestAddImage(t *testing.T) {
 	e := NewEpub(testEpubTitle)
 	testImageFromFilePath, err := e.AddImage(testImageFromFileSource, testImageFromFileFilename)
@@ -300,6 +342,36 @@ func TestAddImage(t *testing.T) {
 	cleanup(testEpubFilename, tempDir)
 }
 
+func TestAddImageOrdered(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	e.AddImage(testImageFromFileSource, "a.png")
+	e.AddImageOrdered(testImageFromFileSource, "b.png", 2)
+	e.AddImageOrdered(testImageFromFileSource, "c.png", 1)
+	e.AddImage(testImageFromFileSource, "d.png")
+	e.AddImageOrdered(testImageFromFileSource, "e.png", 1)
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	// Images with a hint come first, then the others in the order they were
+	// added
+	expectedOrder := []string{"c.png", "e.png", "b.png", "a.png", "d.png"}
+	previous := -1
+	for _, filename := range expectedOrder {
+		index := strings.Index(string(pkgFileContent), `href="images/`+filename+`"`)
+		if index <= previous {
+			t.Errorf("Manifest items aren't in the order %v\nGot: %s", expectedOrder, pkgFileContent)
+			break
+		}
+		previous = index
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
 func TestAddVideo(t *testing.T) {
 	e := NewEpub(testEpubTitle)
 	testVideoFromFilePath, err := e.AddVideo(testVideoFromFileSource, testVideoFromFileFilename)
@@ -350,6 +422,189 @@ func TestAddVideo(t *testing.T) {
 	cleanup(testEpubFilename, tempDir)
 }
 
+func TestAddAudio(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	// Minimal headers are enough to detect the formats
+	testAudioWAV := dataurl.EncodeBytes([]byte("RIFF\x24\x00\x00\x00WAVEfmt "))
+	testAudioM4A := dataurl.EncodeBytes([]byte("\x00\x00\x00\x18ftypM4A \x00\x00\x00\x00M4A mp42isom"))
+	testAudioUnknown := dataurl.EncodeBytes([]byte("not really audio"))
+
+	testAudioWAVPath, err := e.AddAudio(testAudioWAV, "narration.wav")
+	if err != nil {
+		t.Errorf("Error adding audio: %s", err)
+	}
+	if testAudioWAVPath != "../"+AudioFolderName+"/narration.wav" {
+		t.Errorf("Unexpected audio path: %s", testAudioWAVPath)
+	}
+	_, err = e.AddAudio(testAudioM4A, "music.m4a")
+	if err != nil {
+		t.Errorf("Error adding audio: %s", err)
+	}
+	_, err = e.AddAudio(testAudioUnknown, "unknown.ogg")
+	if err != nil {
+		t.Errorf("Error adding audio: %s", err)
+	}
+	_, err = e.AddAudio(testAudioWAV, "narration.wav")
+	if _, ok := err.(*FilenameAlreadyUsedError); !ok {
+		t.Errorf("Expected error FilenameAlreadyUsedError not returned. Returned instead: %+v", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, testAudioWAVPath))
+	if err != nil {
+		t.Errorf("Unexpected error reading audio file from EPUB: %s", err)
+	}
+	if !strings.HasPrefix(string(contents), "RIFF") {
+		t.Errorf("Audio file contents don't match")
+	}
+
+	contents, err = storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	for _, expected := range []string{
+		`href="audio/narration.wav" media-type="audio/wav"`,
+		`href="audio/music.m4a" media-type="audio/mp4"`,
+		`href="audio/unknown.ogg" media-type="audio/ogg"`,
+	} {
+		if !strings.Contains(string(contents), expected) {
+			t.Errorf("Package file doesn't contain %s\nGot: %s", expected, contents)
+		}
+	}
+}
+
+func TestAddJavaScript(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	testScript := dataurl.EncodeBytes([]byte(`document.body.className = "js";`))
+	testScriptPath, err := e.AddJavaScript(testScript, "main.js")
+	if err != nil {
+		t.Errorf("Error adding script: %s", err)
+	}
+	if testScriptPath != "../"+ScriptFolderName+"/main.js" {
+		t.Errorf("Unexpected script path: %s", testScriptPath)
+	}
+	_, err = e.AddJavaScript(testScript, "main.js")
+	if _, ok := err.(*FilenameAlreadyUsedError); !ok {
+		t.Errorf("Expected error FilenameAlreadyUsedError not returned. Returned instead: %+v", err)
+	}
+
+	s, err := e.AddSectionHandle(testSectionBody, testSectionTitle, testSectionFilename, "")
+	if err != nil {
+		t.Fatalf("Error adding section: %s", err)
+	}
+	s.AppendHead(`<script src="` + testScriptPath + `"></script>`)
+	// Properties set for other reasons are kept
+	e.sections[0].properties = remoteResourcesProperty
+	if err := e.SetSectionScripted(testSectionFilename, true); err != nil {
+		t.Errorf("Error setting section scripted: %s", err)
+	}
+	e.AddSection(testSectionBody, testSectionTitle, "unscripted.xhtml", "")
+	e.SetSectionScripted("unscripted.xhtml", true)
+	e.SetSectionScripted("unscripted.xhtml", false)
+	err = e.SetSectionScripted("missing.xhtml", true)
+	if _, ok := err.(*FilenameNotFoundError); !ok {
+		t.Errorf("Expected error FilenameNotFoundError not returned. Returned instead: %+v", err)
+	}
+	if unused := e.UnusedResources(); len(unused) != 0 {
+		t.Errorf("Script linked from the head of a section is reported as unused: %v", unused)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	for _, expected := range []string{
+		`href="scripts/main.js" media-type="application/javascript"`,
+		`href="xhtml/` + testSectionFilename + `" media-type="application/xhtml+xml" properties="remote-resources scripted"`,
+		`href="xhtml/unscripted.xhtml" media-type="application/xhtml+xml"></item>`,
+	} {
+		if !strings.Contains(string(contents), expected) {
+			t.Errorf("Package file doesn't contain %s\nGot: %s", expected, contents)
+		}
+	}
+}
+
+func TestSectionSVGProperty(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	e.AddSection(`<svg xmlns="http://www.w3.org/2000/svg" width="10" height="10"><rect width="10" height="10"/></svg>`, "SVG", "svg.xhtml", "")
+	e.AddSection(`<svg:svg xmlns:svg="http://www.w3.org/2000/svg"/>`, "Prefixed SVG", "prefixed.xhtml", "")
+	e.AddSection(`<svg/><script>alert("hi");</script>`, "Scripted SVG", "scripted.xhtml", "")
+	e.SetSectionScripted("scripted.xhtml", true)
+	e.AddSection(`<p>An <svgimage/> isn't SVG</p>`, "No SVG", "nosvg.xhtml", "")
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	for _, expected := range []string{
+		`href="xhtml/svg.xhtml" media-type="application/xhtml+xml" properties="svg"`,
+		`href="xhtml/prefixed.xhtml" media-type="application/xhtml+xml" properties="svg"`,
+		`href="xhtml/scripted.xhtml" media-type="application/xhtml+xml" properties="scripted svg"`,
+		`href="xhtml/nosvg.xhtml" media-type="application/xhtml+xml"></item>`,
+	} {
+		if !strings.Contains(string(contents), expected) {
+			t.Errorf("Package file doesn't contain %s\nGot: %s", expected, contents)
+		}
+	}
+}
+
+func TestAddMediaWithFallback(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	testMediaPath, err := e.AddMediaWithFallback(testVideoFromFileSource, testImageFromFileSource, "sample.mp4")
+	if err != nil {
+		t.Errorf("Error adding media: %s", err)
+	}
+	if testMediaPath != "../media/sample.mp4" {
+		t.Errorf(
+			"Media path doesn't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			testMediaPath,
+			"../media/sample.mp4")
+	}
+	_, err = e.AddMediaWithFallback(testVideoFromFileSource, testImageFromFileSource, "sample.mp4")
+	if _, ok := err.(*FilenameAlreadyUsedError); !ok {
+		t.Errorf("Expected error FilenameAlreadyUsedError not returned. Returned instead: %+v", err)
+	}
+	_, err = e.AddMediaWithFallback(testVideoFromFileSource, "/sbin/thisShouldFail", "other.mp4")
+	if _, ok := err.(*FileRetrievalError); !ok {
+		t.Errorf("Expected error FileRetrievalError not returned. Returned instead: %+v", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	for _, testManifestItem := range []string{
+		`<item id="sample.mp4" href="media/sample.mp4" media-type="video/mp4" fallback="gophercolor16x16.png"></item>`,
+		`<item id="gophercolor16x16.png" href="media/gophercolor16x16.png" media-type="image/png"></item>`,
+	} {
+		if !strings.Contains(string(pkgFileContent), testManifestItem) {
+			t.Errorf(
+				"Package file doesn't contain manifest item\n"+
+					"Got: %s\n"+
+					"Expected: %s",
+				pkgFileContent,
+				testManifestItem)
+		}
+	}
+	if strings.Contains(string(pkgFileContent), "other.mp4") {
+		t.Errorf("Package file shouldn't contain media whose fallback couldn't be added\nGot: %s", pkgFileContent)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
 func TestAddSection(t *testing.T) {
 	e := NewEpub(testEpubTitle)
 	testSection1Path, err := e.AddSection(testSectionBody, testSectionTitle, testSectionFilename, "")
@@ -396,6 +651,198 @@ func TestAddSection(t *testing.T) {
 	cleanup(testEpubFilename, tempDir)
 }
 
+func TestAddSectionWithCSS(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	testBaseCSSPath, err := e.AddCSS(testCoverCSSSource, "base.css")
+	if err != nil {
+		t.Fatalf("Error adding CSS: %s", err)
+	}
+	testChapterCSSPath, err := e.AddCSS(testCoverCSSSource, "chapter.css")
+	if err != nil {
+		t.Fatalf("Error adding CSS: %s", err)
+	}
+	testSectionPath, err := e.AddSectionWithCSS(testSectionBody, testSectionTitle, testSectionFilename, testBaseCSSPath, testChapterCSSPath)
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, testSectionPath))
+	if err != nil {
+		t.Errorf("Unexpected error reading section file: %s", err)
+	}
+	testCSSLinkElements := fmt.Sprintf(testCSSLinkTemplate, testBaseCSSPath) + "\n" +
+		fmt.Sprintf(testCSSLinkTemplate, testChapterCSSPath)
+	if !strings.Contains(trimAllSpace(string(contents)), testCSSLinkElements) {
+		t.Errorf(
+			"CSS links don't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			contents,
+			testCSSLinkElements)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestReplaceSection(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	testCSSPath, _ := e.AddCSS(testCoverCSSSource, testCoverCSSFilename)
+	e.AddSection(testSectionBody, "Old title", testSectionFilename, testCSSPath)
+	e.AddSection(testSectionBody, "Section 2", "", "")
+
+	err := e.ReplaceSection("doesnotexist.xhtml", testSectionBody, testSectionTitle, "")
+	if _, ok := err.(*FilenameNotFoundError); !ok {
+		t.Errorf("Expected error FilenameNotFoundError not returned. Returned instead: %+v", err)
+	}
+	err = e.ReplaceSection(testSectionFilename, testSectionBody, testSectionTitle, "")
+	if err != nil {
+		t.Errorf("Error replacing section: %s", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, testSectionFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading section file: %s", err)
+	}
+
+	testSectionContents := fmt.Sprintf(testSectionContentTemplate, testSectionTitle, testSectionBody)
+	if trimAllSpace(string(contents)) != trimAllSpace(testSectionContents) {
+		t.Errorf(
+			"Section file contents don't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			contents,
+			testSectionContents)
+	}
+
+	if e.toc.navXML.Links[0].A.Data != testSectionTitle {
+		t.Errorf("TOC entry not replaced, got: %s", e.toc.navXML.Links[0].A.Data)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestRemoveSection(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	e.AddSection(testSectionBody, "Part 1", testSectionFilename, "")
+	testSubSectionPath, _ := e.AddSubSection(testSectionFilename, testSectionBody, "Chapter 1", "", "")
+	testSection2Path, _ := e.AddSection(testSectionBody, "Section 2", "", "")
+
+	err := e.RemoveSection("doesnotexist.xhtml")
+	if _, ok := err.(*FilenameNotFoundError); !ok {
+		t.Errorf("Expected error FilenameNotFoundError not returned. Returned instead: %+v", err)
+	}
+	err = e.RemoveSection(testSectionFilename)
+	if err != nil {
+		t.Errorf("Error removing section: %s", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+
+	_, err = storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, testSectionFilename))
+	if err == nil {
+		t.Error("Removed section shouldn't be written to the EPUB")
+	}
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	if strings.Contains(string(contents), testSectionFilename) {
+		t.Errorf("Package file shouldn't reference the removed section\nGot: %s", contents)
+	}
+
+	// The subsection is moved to the top level of the TOC
+	links := e.toc.navXML.Links
+	if len(links) != 2 || links[0].A.Data != "Chapter 1" || links[0].Children != nil || links[1].A.Data != "Section 2" {
+		t.Errorf("TOC entries don't match, got: %+v", links)
+	}
+	if sections := e.Sections(); !reflect.DeepEqual(sections, []string{testSubSectionPath, testSection2Path}) {
+		t.Errorf("Sections don't match\nGot: %v\nExpected: %v", sections, []string{testSubSectionPath, testSection2Path})
+	}
+}
+
+func TestSetSectionOrder(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	testSection1Path, _ := e.AddSection(testSectionBody, "Section 1", "", "")
+	testSection2Path, _ := e.AddSection(testSectionBody, "Section 2", "", "")
+	testSection3Path, _ := e.AddSection(testSectionBody, "Section 3", "", "")
+
+	err := e.SetSectionOrder([]string{testSection3Path, "doesnotexist.xhtml", testSection3Path})
+	orderErr, ok := err.(*InvalidSectionOrderError)
+	if !ok {
+		t.Fatalf("Expected error InvalidSectionOrderError not returned. Returned instead: %+v", err)
+	}
+	expectedErr := &InvalidSectionOrderError{
+		Missing:   []string{testSection1Path, testSection2Path},
+		Unknown:   []string{"doesnotexist.xhtml"},
+		Duplicate: []string{testSection3Path},
+	}
+	if !reflect.DeepEqual(orderErr, expectedErr) {
+		t.Errorf("Error doesn't match\nGot: %+v\nExpected: %+v", orderErr, expectedErr)
+	}
+
+	expected := []string{testSection3Path, testSection1Path, testSection2Path}
+	err = e.SetSectionOrder(expected)
+	if err != nil {
+		t.Errorf("Error setting section order: %s", err)
+	}
+	if sections := e.Sections(); !reflect.DeepEqual(sections, expected) {
+		t.Errorf("Sections don't match\nGot: %v\nExpected: %v", sections, expected)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+	links := e.toc.navXML.Links
+	if len(links) != 3 || links[0].A.Data != "Section 3" || links[1].A.Data != "Section 1" || links[2].A.Data != "Section 2" {
+		t.Errorf("TOC entries don't match, got: %+v", links)
+	}
+}
+
+func TestSetSectionExtension(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	err := e.SetSectionExtension(".txt")
+	if _, ok := err.(*InvalidValueError); !ok {
+		t.Errorf("Expected error InvalidValueError not returned. Returned instead: %+v", err)
+	}
+	err = e.SetSectionExtension(".html")
+	if err != nil {
+		t.Errorf("Error setting section extension: %s", err)
+	}
+	testSectionPath, _ := e.AddSection(testSectionBody, testSectionTitle, "", "")
+	testImagePath, _ := e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+	e.SetCover(testImagePath, "")
+
+	if testSectionPath != "section0001.html" {
+		t.Errorf("Unexpected section filename: %s", testSectionPath)
+	}
+	if e.cover.xhtmlFilename != "cover.html" {
+		t.Errorf("Unexpected cover filename: %s", e.cover.xhtmlFilename)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	testManifestItem := `<item id="section0001.html" href="xhtml/section0001.html" media-type="application/xhtml+xml"></item>`
+	if !strings.Contains(string(pkgFileContent), testManifestItem) {
+		t.Errorf(
+			"Package file doesn't contain manifest item\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			pkgFileContent,
+			testManifestItem)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
 func TestSetCover(t *testing.T) {
 	e := NewEpub(testEpubTitle)
 	testImagePath, _ := e.AddImage(testImageFromFileSource, testImageFromFileFilename)
@@ -422,32 +869,804 @@ func TestSetCover(t *testing.T) {
 	cleanup(testEpubFilename, tempDir)
 }
 
-func TestManifestItems(t *testing.T) {
-	testManifestItems := []string{`id="filenamewithspace.png" href="images/filename with space.png" media-type="image/png"></item>`,
-		`id="gophercolor16x16.png" href="images/gophercolor16x16.png" media-type="image/png"></item>`,
-		`id="id01filenametest.png" href="images/01filenametest.png" media-type="image/png"></item>`,
-		`id="image0005.png" href="images/image0005.png" media-type="image/png"></item>`,
-		`id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"></item>`,
-		`id="testfromfile.png" href="images/testfromfile.png" media-type="image/png"></item>`,
-	}
-
+func TestSetCoverImageProperty(t *testing.T) {
 	e := NewEpub(testEpubTitle)
-	e.AddImage(testImageFromFileSource, testImageFromFileFilename)
-	e.AddImage(testImageFromFileSource, "")
-	// In particular, we want to test these next two, which will be modified by fixXMLId()
-	e.AddImage(testImageFromFileSource, testNumberFilenameStart)
-	e.AddImage(testImageFromFileSource, testSpaceInFilename)
-	e.AddImage(testImageFromURLSource, "")
+	testImagePath, _ := e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+	testNewImagePath, _ := e.AddImage(testImageFromFileSource, "newcover.png")
+	e.SetCover(testImagePath, "")
+	e.SetBackCover(testImagePath, "")
+	// Only the image of the new cover should be marked as the cover image
+	e.SetCover(testNewImagePath, "")
 
 	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
 
-	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
 	if err != nil {
 		t.Errorf("Unexpected error reading package file: %s", err)
 	}
-
-	// Get just the manifest portion of the package file
-	manifestContentFromFile := string(pkgFileContent)[strings.Index(string(pkgFileContent), "<manifest>"):strings.Index(string(pkgFileContent), "</manifest>")]
+	for _, expected := range []string{
+		`<meta name="cover" content="newcover.png"></meta>`,
+		`href="images/newcover.png" media-type="image/png" properties="cover-image"`,
+		`href="images/` + testImageFromFileFilename + `" media-type="image/png"></item>`,
+	} {
+		if !strings.Contains(string(contents), expected) {
+			t.Errorf("Package file doesn't contain %s\nGot: %s", expected, contents)
+		}
+	}
+	if count := strings.Count(string(contents), coverImageProperties); count != 1 {
+		t.Errorf("Unexpected number of cover images\nGot: %d\nExpected: 1", count)
+	}
+}
+
+func TestSetCoverWithAlt(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	testImagePath, _ := e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+	e.SetCoverWithAlt(testImagePath, "", `A gopher & a "book"`)
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, defaultCoverXhtmlFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading cover XHTML file: %s", err)
+	}
+	testCoverImage := `<img src="../images/testfromfile.png" alt="A gopher &amp; a &#34;book&#34;" />`
+	if !strings.Contains(string(contents), testCoverImage) {
+		t.Errorf(
+			"Cover image doesn't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			contents,
+			testCoverImage)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+
+	if body := coverBody(testImagePath, ""); !strings.Contains(body, `alt="Cover Image"`) {
+		t.Errorf("Default alternative text not used\nGot: %s", body)
+	}
+}
+
+func TestSetCoverSVG(t *testing.T) {
+	// The comment keeps the SVG image from being detected by its content
+	testSVG := `<?xml version="1.0" encoding="UTF-8"?>
+<!-- ` + strings.Repeat("Generated cover. ", 300) + ` -->
+<svg xmlns="http://www.w3.org/2000/svg" width="600" height="800"><rect width="600" height="800"/></svg>`
+	e := NewEpub(testEpubTitle)
+	testImagePath, err := e.AddImage(dataurl.EncodeBytes([]byte(testSVG)), "cover.svg")
+	if err != nil {
+		t.Fatalf("Error adding image: %s", err)
+	}
+	e.SetCover(testImagePath, "")
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	for _, expected := range []string{
+		`<meta name="cover" content="cover.svg"></meta>`,
+		`href="images/cover.svg" media-type="image/svg+xml" properties="cover-image"`,
+	} {
+		if !strings.Contains(string(contents), expected) {
+			t.Errorf("Package file doesn't contain %s\nGot: %s", expected, contents)
+		}
+	}
+
+	contents, err = storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, defaultCoverXhtmlFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading cover XHTML file: %s", err)
+	}
+	if !strings.Contains(string(contents), `<img src="`+testImagePath+`" alt="Cover Image" />`) {
+		t.Errorf("Cover file doesn't reference the SVG image\nGot: %s", contents)
+	}
+}
+
+func TestImageTag(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	testImagePath, _ := e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+
+	_, err := e.ImageTag("../images/doesnotexist.png", "Missing")
+	if _, ok := err.(*FilenameNotFoundError); !ok {
+		t.Errorf("Expected error FilenameNotFoundError not returned. Returned instead: %+v", err)
+	}
+	tag, err := e.ImageTag(testImagePath, "A <small> gopher")
+	if err != nil {
+		t.Errorf("Error getting image tag: %s", err)
+	}
+	testTag := `<img src="../images/testfromfile.png" alt="A &lt;small&gt; gopher" />`
+	if tag != testTag {
+		t.Errorf("Image tag doesn't match\nGot: %s\nExpected: %s", tag, testTag)
+	}
+}
+
+func TestSetCoverDefaultCSS(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	// The default cover CSS should be kept in memory even if data URLs are
+	// otherwise staged to temporary files
+	if err := e.SetDataURLStagingThreshold(1); err != nil {
+		t.Fatalf("Error setting data URL staging threshold: %s", err)
+	}
+	testImagePath, _ := e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+	// Take the default filename of the cover CSS
+	testCSSPath, err := e.AddCSSFromBytes([]byte("body { margin: 0; }"), defaultCoverCSSFilename)
+	if err != nil {
+		t.Fatalf("Error adding CSS: %s", err)
+	}
+	if testCSSPath != "../css/"+defaultCoverCSSFilename {
+		t.Errorf("CSS path doesn't match\nGot: %s\nExpected: %s", testCSSPath, "../css/"+defaultCoverCSSFilename)
+	}
+	e.SetCover(testImagePath, "")
+
+	if !e.cover.defaultCSS || !isDataURL(e.css[e.cover.cssFilename]) {
+		t.Errorf("Default cover CSS should be kept in memory, got source %s", e.css[e.cover.cssFilename])
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, CSSFolderName, e.cover.cssFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading cover CSS file: %s", err)
+	}
+	if string(contents) != defaultCoverCSSContent {
+		t.Errorf("Cover CSS doesn't match\nGot: %s\nExpected: %s", contents, defaultCoverCSSContent)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestSetCoverE(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	testImagePath, _ := e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+	testCSSPath, _ := e.AddCSS(testCoverCSSSource, testCoverCSSFilename)
+
+	err := e.SetCoverE(testImagePath, "../css/missing.css")
+	if _, ok := err.(*FilenameNotFoundError); !ok {
+		t.Errorf("Expected error FilenameNotFoundError not returned. Returned instead: %+v", err)
+	}
+	if e.cover.xhtmlFilename != "" {
+		t.Errorf("Cover was set despite the error")
+	}
+
+	err = e.SetCoverE(testImagePath, testCSSPath)
+	if err != nil {
+		t.Errorf("Error setting cover: %s", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, defaultCoverXhtmlFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading cover XHTML file: %s", err)
+	}
+
+	testCoverContents := fmt.Sprintf(testCoverContentTemplate, testEpubTitle, testCSSPath, testImagePath)
+	if trimAllSpace(string(contents)) != trimAllSpace(testCoverContents) {
+		t.Errorf(
+			"Cover file contents don't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			contents,
+			testCoverContents)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestAddInlineImage(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	testSectionPath, _ := e.AddSection(testSectionBody, testSectionTitle, "", "")
+
+	err := e.AddInlineImage("doesnotexist.xhtml", testImageFromFileSource, "")
+	if _, ok := err.(*FilenameNotFoundError); !ok {
+		t.Errorf("Expected error FilenameNotFoundError not returned. Returned instead: %+v", err)
+	}
+	err = e.AddInlineImage(testSectionPath, testCoverCSSSource, "")
+	if _, ok := err.(*InvalidValueError); !ok {
+		t.Errorf("Expected error InvalidValueError not returned. Returned instead: %+v", err)
+	}
+	err = e.AddInlineImage(testSectionPath, testImageFromFileSource, `Gopher & "friends"`)
+	if err != nil {
+		t.Errorf("Error adding inline image: %s", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, testSectionPath))
+	if err != nil {
+		t.Errorf("Unexpected error reading section file: %s", err)
+	}
+	testImgElement := `<img src="data:image/png;base64,`
+	if !strings.Contains(string(contents), testImgElement) || !strings.Contains(string(contents), `alt="Gopher &amp; &#34;friends&#34;" />`) {
+		t.Errorf(
+			"Section doesn't contain the inline image\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			contents,
+			testImgElement)
+	}
+
+	// The image shouldn't be added to the EPUB as a separate file
+	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	if strings.Contains(string(pkgFileContent), "image/png") {
+		t.Errorf("Package file shouldn't contain the inline image\nGot: %s", pkgFileContent)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestSetCoverFromURL(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cover.png", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, testImageFromFileSource)
+	}))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	e := NewEpub(testEpubTitle)
+	err := e.SetCoverFromURL(ts.URL+"/missing.png", "")
+	if _, ok := err.(*FileRetrievalError); !ok {
+		t.Errorf("Expected error FileRetrievalError not returned. Returned instead: %+v", err)
+	}
+	if e.cover.xhtmlFilename != "" {
+		t.Errorf("Cover was set despite the error")
+	}
+
+	// Setting the same cover again shouldn't add the image twice
+	for i := 0; i < 2; i++ {
+		err = e.SetCoverFromURL(ts.URL+"/cover.png", "")
+		if err != nil {
+			t.Errorf("Error setting cover: %s", err)
+		}
+	}
+	if len(e.images) != 1 {
+		t.Errorf("Expected 1 image, got %d", len(e.images))
+	}
+	testImagePath := "../images/cover.png"
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, defaultCoverXhtmlFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading cover XHTML file: %s", err)
+	}
+
+	testCoverContents := fmt.Sprintf(testCoverContentTemplate, testEpubTitle, "../css/"+defaultCoverCSSFilename, testImagePath)
+	if trimAllSpace(string(contents)) != trimAllSpace(testCoverContents) {
+		t.Errorf(
+			"Cover file contents don't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			contents,
+			testCoverContents)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestSetAnimatedCover(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	testImagePath, _ := e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+	testVideoPath, _ := e.AddVideo(testVideoFromFileSource, testVideoFromFileFilename)
+	e.SetCover(testImagePath, "")
+	e.SetAnimatedCover(testVideoPath, testImagePath)
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, defaultCoverXhtmlFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading cover XHTML file: %s", err)
+	}
+
+	testCoverBody := fmt.Sprintf(animatedCoverBody, testVideoPath, testImagePath, testImagePath)
+	if !strings.Contains(string(contents), testCoverBody) {
+		t.Errorf(
+			"Cover file contents don't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			contents,
+			testCoverBody)
+	}
+
+	// The poster image should still be the cover image
+	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	testCoverImageItem := `href="images/testfromfile.png" media-type="image/png" properties="cover-image"`
+	if !strings.Contains(string(pkgFileContent), testCoverImageItem) {
+		t.Errorf(
+			"Package file doesn't contain cover image\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			pkgFileContent,
+			testCoverImageItem)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestSetBackCover(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	testImagePath, _ := e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+	testBackImagePath, _ := e.AddImage(testImageFromFileSource, "back.png")
+	e.SetCover(testImagePath, "")
+	// Replacing the back cover shouldn't remove the image of the front cover
+	e.SetBackCover(testImagePath, "")
+	e.SetBackCover(testBackImagePath, "")
+	e.AddSection(testSectionBody, testSectionTitle, testSectionFilename, "")
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	testSpine := `<itemref idref="cover.xhtml"></itemref>
+    <itemref idref="section0001.xhtml"></itemref>
+    <itemref idref="backcover.xhtml"></itemref>`
+	if !strings.Contains(string(pkgFileContent), testSpine) {
+		t.Errorf(
+			"Spine doesn't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			pkgFileContent,
+			testSpine)
+	}
+	if !strings.Contains(string(pkgFileContent), `href="images/testfromfile.png"`) {
+		t.Errorf("Front cover image should still be in the package file\nGot: %s", pkgFileContent)
+	}
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, "backcover.xhtml"))
+	if err != nil {
+		t.Errorf("Unexpected error reading back cover XHTML file: %s", err)
+	}
+	testBackCoverBody := `<body epub:type="backmatter">
+<img src="../images/back.png" alt="Back Cover Image" />
+</body>`
+	if !strings.Contains(string(contents), testBackCoverBody) {
+		t.Errorf(
+			"Back cover body doesn't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			contents,
+			testBackCoverBody)
+	}
+
+	navFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, tocNavFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading nav file: %s", err)
+	}
+	testLandmark := `<a epub:type="backmatter" href="xhtml/backcover.xhtml">Back Cover</a>`
+	if !strings.Contains(string(navFileContent), testLandmark) {
+		t.Errorf(
+			"Nav file doesn't contain back cover landmark\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			navFileContent,
+			testLandmark)
+	}
+	if strings.Count(string(navFileContent), "backcover.xhtml") != 1 {
+		t.Errorf("Back cover should only be in the landmarks of the nav file\nGot: %s", navFileContent)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestAddCopyrightPage(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	testImagePath, _ := e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+	e.SetCover(testImagePath, "")
+	e.AddSection(testSectionBody, testSectionTitle, testSectionFilename, "")
+	e.AddCopyrightPage("<p>Replaced</p>")
+	filename, err := e.AddCopyrightPage("<p>Copyright 2024</p>")
+	if err != nil {
+		t.Errorf("Error adding copyright page: %s", err)
+	}
+	if filename != copyrightPageFilename {
+		t.Errorf("Copyright page filename doesn't match\nGot: %s\nExpected: %s", filename, copyrightPageFilename)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	testSpine := `<itemref idref="cover.xhtml"></itemref>
+    <itemref idref="copyright.xhtml"></itemref>
+    <itemref idref="section0001.xhtml"></itemref>`
+	if !strings.Contains(string(pkgFileContent), testSpine) {
+		t.Errorf(
+			"Spine doesn't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			pkgFileContent,
+			testSpine)
+	}
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, filename))
+	if err != nil {
+		t.Errorf("Unexpected error reading copyright page XHTML file: %s", err)
+	}
+	testCopyrightBody := `<body epub:type="copyright-page">
+<p>Copyright 2024</p>
+</body>`
+	if !strings.Contains(string(contents), testCopyrightBody) {
+		t.Errorf(
+			"Copyright page body doesn't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			contents,
+			testCopyrightBody)
+	}
+
+	navFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, tocNavFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading nav file: %s", err)
+	}
+	testLandmark := `<a epub:type="copyright-page" href="xhtml/copyright.xhtml">Copyright</a>`
+	if !strings.Contains(string(navFileContent), testLandmark) {
+		t.Errorf(
+			"Nav file doesn't contain copyright page landmark\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			navFileContent,
+			testLandmark)
+	}
+	if strings.Count(string(navFileContent), "copyright.xhtml") != 1 {
+		t.Errorf("Copyright page shouldn't be in the TOC\nGot: %s", navFileContent)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+
+	// Writing the EPUB again shouldn't add the copyright page to the guide again
+	tempDir = writeAndExtractEpub(t, e, testEpubFilename)
+
+	pkgFileContent, err = storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	if strings.Count(string(pkgFileContent), `<reference type="copyright-page"`) != 1 {
+		t.Errorf("Guide should contain the copyright page exactly once\nGot: %s", pkgFileContent)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+
+	e = NewEpub(testEpubTitle)
+	e.AddSection(testSectionBody, testSectionTitle, testSectionFilename, "")
+	e.AddCopyrightPage("<p>Copyright 2024</p>")
+	e.SetCopyrightPageInTOC(true)
+	tempDir = writeAndExtractEpub(t, e, testEpubFilename)
+
+	navFileContent, err = storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, tocNavFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading nav file: %s", err)
+	}
+	testTOCEntry := `<a href="xhtml/copyright.xhtml">Copyright</a>`
+	if !strings.Contains(string(navFileContent), testTOCEntry) {
+		t.Errorf("Copyright page should be in the TOC\nGot: %s", navFileContent)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestSetCoverSpineIndex(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	e.AddSection(testSectionBody, testSectionTitle, "halftitle.xhtml", "")
+	e.AddSection(testSectionBody, testSectionTitle, testSectionFilename, "")
+	testImagePath, _ := e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+	e.SetCover(testImagePath, "")
+
+	err := e.SetCoverSpineIndex(3)
+	if _, ok := err.(*SpineIndexOutOfRangeError); !ok {
+		t.Errorf("Expected error SpineIndexOutOfRangeError not returned. Returned instead: %+v", err)
+	}
+	err = e.SetCoverSpineIndex(1)
+	if err != nil {
+		t.Errorf("Error setting cover spine index: %s", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+
+	testSpine := `<itemref idref="halftitle.xhtml"></itemref>
+    <itemref idref="cover.xhtml"></itemref>
+    <itemref idref="section0001.xhtml"></itemref>`
+	if !strings.Contains(string(pkgFileContent), testSpine) {
+		t.Errorf(
+			"Spine doesn't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			pkgFileContent,
+			testSpine)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestSetFixedLayoutFromCover(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	err := e.Pkg.SetRenditionLayout("scrolled")
+	if _, ok := err.(*InvalidValueError); !ok {
+		t.Errorf("Expected error InvalidValueError not returned. Returned instead: %+v", err)
+	}
+	// Without a cover, the layout isn't changed
+	err = e.SetFixedLayoutFromCover()
+	if err != nil {
+		t.Errorf("Error setting fixed layout without a cover: %s", err)
+	}
+	if e.viewport != "" {
+		t.Errorf("Viewport shouldn't be set without a cover, got: %s", e.viewport)
+	}
+
+	testImagePath, _ := e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+	e.SetCover(testImagePath, "")
+	err = e.SetFixedLayoutFromCover()
+	if err != nil {
+		t.Errorf("Error setting fixed layout from cover: %s", err)
+	}
+	testSectionPath, _ := e.AddSection(testSectionBody, testSectionTitle, "", "")
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	testLayoutElement := `<meta property="rendition:layout">pre-paginated</meta>`
+	if !strings.Contains(string(pkgFileContent), testLayoutElement) {
+		t.Errorf(
+			"Rendition layout meta element doesn't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			pkgFileContent,
+			testLayoutElement)
+	}
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, testSectionPath))
+	if err != nil {
+		t.Errorf("Unexpected error reading section file: %s", err)
+	}
+	testViewportElement := `<meta name="viewport" content="width=16, height=15"></meta>`
+	if !strings.Contains(string(contents), testViewportElement) {
+		t.Errorf(
+			"Viewport meta element doesn't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			contents,
+			testViewportElement)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestSetFixedLayout(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	err := e.SetFixedLayout(0, 1600)
+	if _, ok := err.(*InvalidValueError); !ok {
+		t.Errorf("Expected error InvalidValueError not returned. Returned instead: %+v", err)
+	}
+	err = e.SetFixedLayout(1200, 1600)
+	if err != nil {
+		t.Errorf("Error setting fixed layout: %s", err)
+	}
+	testSectionPath, _ := e.AddSection(testSectionBody, testSectionTitle, "", "")
+	e.SetSectionPageSpread(testSectionPath, PageSpreadRight)
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	for _, testElement := range []string{
+		`<meta property="rendition:layout">pre-paginated</meta>`,
+		`<itemref idref="section0001.xhtml" properties="page-spread-right"></itemref>`,
+	} {
+		if !strings.Contains(string(pkgFileContent), testElement) {
+			t.Errorf(
+				"Package file doesn't contain the expected element\n"+
+					"Got: %s\n"+
+					"Expected: %s",
+				pkgFileContent,
+				testElement)
+		}
+	}
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, testSectionPath))
+	if err != nil {
+		t.Errorf("Unexpected error reading section file: %s", err)
+	}
+	testViewportElement := `<meta name="viewport" content="width=1200, height=1600"></meta>`
+	if !strings.Contains(string(contents), testViewportElement) {
+		t.Errorf(
+			"Viewport meta element doesn't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			contents,
+			testViewportElement)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestSetSectionLayout(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	e.AddSection(testSectionBody, testSectionTitle, "", "")
+	testMapPath, _ := e.AddSection(testSectionBody, testSectionTitle, "map.xhtml", "")
+
+	err := e.SetSectionLayout("doesnotexist.xhtml", RenditionLayoutPrePaginated)
+	if _, ok := err.(*FilenameNotFoundError); !ok {
+		t.Errorf("Expected error FilenameNotFoundError not returned. Returned instead: %+v", err)
+	}
+	err = e.SetSectionLayout(testMapPath, "fixed")
+	if _, ok := err.(*InvalidValueError); !ok {
+		t.Errorf("Expected error InvalidValueError not returned. Returned instead: %+v", err)
+	}
+	err = e.SetSectionLayout(testMapPath, RenditionLayoutPrePaginated)
+	if err != nil {
+		t.Errorf("Error setting section layout: %s", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	testSpine := `<itemref idref="section0001.xhtml"></itemref>
+    <itemref idref="map.xhtml" properties="rendition:layout-pre-paginated"></itemref>`
+	if !strings.Contains(string(pkgFileContent), testSpine) {
+		t.Errorf(
+			"Spine doesn't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			pkgFileContent,
+			testSpine)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestSetSectionLinear(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	e.AddSection(testSectionBody, testSectionTitle, "", "")
+	testNotesPath, _ := e.AddSection(testSectionBody, "Notes", "notes.xhtml", "")
+	testMapPath, _ := e.AddSection(testSectionBody, "Map", "map.xhtml", "")
+
+	err := e.SetSectionLinear("doesnotexist.xhtml", false)
+	if _, ok := err.(*FilenameNotFoundError); !ok {
+		t.Errorf("Expected error FilenameNotFoundError not returned. Returned instead: %+v", err)
+	}
+	err = e.SetSectionPageSpread(testMapPath, "page-spread-top")
+	if _, ok := err.(*InvalidValueError); !ok {
+		t.Errorf("Expected error InvalidValueError not returned. Returned instead: %+v", err)
+	}
+	if err := e.SetSectionLinear(testNotesPath, false); err != nil {
+		t.Errorf("Error setting section linear: %s", err)
+	}
+	if err := e.SetSectionLayout(testMapPath, RenditionLayoutPrePaginated); err != nil {
+		t.Errorf("Error setting section layout: %s", err)
+	}
+	if err := e.SetSectionPageSpread(testMapPath, PageSpreadLeft); err != nil {
+		t.Errorf("Error setting section page spread: %s", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	testSpine := `<itemref idref="section0001.xhtml"></itemref>
+    <itemref idref="notes.xhtml" linear="no"></itemref>
+    <itemref idref="map.xhtml" properties="rendition:layout-pre-paginated page-spread-left"></itemref>`
+	if !strings.Contains(string(pkgFileContent), testSpine) {
+		t.Errorf(
+			"Spine doesn't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			pkgFileContent,
+			testSpine)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestSetPublisherLogo(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	testImagePath, _ := e.AddImage(testImageFromFileSource, "logo.png")
+	e.SetPublisherLogo(testImagePath)
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+
+	for _, testElement := range []string{
+		`prefix="foaf: http://xmlns.com/foaf/spec/"`,
+		`<link rel="foaf:logo" href="images/logo.png" media-type="image/png"></link>`,
+	} {
+		if !strings.Contains(string(pkgFileContent), testElement) {
+			t.Errorf(
+				"Package file doesn't contain publisher logo\n"+
+					"Got: %s\n"+
+					"Expected: %s",
+				pkgFileContent,
+				testElement)
+		}
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestSetCoverThumbnail(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	testImagePath, _ := e.AddImage(testImageFromFileSource, "thumbnail.png")
+	e.SetCoverThumbnail(testImagePath)
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+
+	for _, testElement := range []string{
+		`prefix="opds: http://opds-spec.org/"`,
+		`<link rel="opds:image/thumbnail" href="images/thumbnail.png" media-type="image/png"></link>`,
+	} {
+		if !strings.Contains(string(pkgFileContent), testElement) {
+			t.Errorf(
+				"Package file doesn't contain cover thumbnail\n"+
+					"Got: %s\n"+
+					"Expected: %s",
+				pkgFileContent,
+				testElement)
+		}
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestManifestItems(t *testing.T) {
+	testManifestItems := []string{`id="filenamewithspace.png" href="images/filename with space.png" media-type="image/png"></item>`,
+		`id="gophercolor16x16.png" href="images/gophercolor16x16.png" media-type="image/png"></item>`,
+		`id="id01filenametest.png" href="images/01filenametest.png" media-type="image/png"></item>`,
+		`id="image0005.png" href="images/image0005.png" media-type="image/png"></item>`,
+		`id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"></item>`,
+		`id="testfromfile.png" href="images/testfromfile.png" media-type="image/png"></item>`,
+	}
+
+	e := NewEpub(testEpubTitle)
+	e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+	e.AddImage(testImageFromFileSource, "")
+	// In particular, we want to test these next two, which will be modified by fixXMLId()
+	e.AddImage(testImageFromFileSource, testNumberFilenameStart)
+	e.AddImage(testImageFromFileSource, testSpaceInFilename)
+	e.AddImage(testImageFromURLSource, "")
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+
+	// Get just the manifest portion of the package file
+	manifestContentFromFile := string(pkgFileContent)[strings.Index(string(pkgFileContent), "<manifest>"):strings.Index(string(pkgFileContent), "</manifest>")]
 	// Convert the manifest portion of the package file to a slice
 	pkgFileManifestItems := strings.Split(manifestContentFromFile, "<item")
 	// Drop the <manifest> and </manifest>
@@ -472,6 +1691,117 @@ func TestManifestItems(t *testing.T) {
 	cleanup(testEpubFilename, tempDir)
 }
 
+func TestSetWarnDuplicateSections(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	warnings := []error{}
+	e.SetProgressHandler(func(p Progress) {
+		if p.Warning != nil {
+			warnings = append(warnings, p.Warning)
+		}
+	})
+
+	// Sections added before enabling the warning aren't checked
+	e.AddSection("<h1>Before</h1>", testSectionTitle, "", "")
+	e.AddSection("<h1>Before</h1>", testSectionTitle, "", "")
+	if len(warnings) != 0 {
+		t.Errorf("Unexpected warnings: %v", warnings)
+	}
+
+	e.SetWarnDuplicateSections(true)
+	e.AddSection(testSectionBody, testSectionTitle, "section-a.xhtml", "")
+	e.AddSection("<h1>Other section</h1>", testSectionTitle, "", "")
+	_, err := e.AddSection(testSectionBody, testSectionTitle, "section-b.xhtml", "")
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+
+	expected := []error{&DuplicateSectionError{Filename: "section-b.xhtml", DuplicateOf: "section-a.xhtml"}}
+	if !reflect.DeepEqual(warnings, expected) {
+		t.Errorf("Warnings don't match\nGot: %v\nExpected: %v", warnings, expected)
+	}
+
+	// A section which has been replaced since isn't a duplicate anymore
+	warnings = nil
+	e.ReplaceSection("section-a.xhtml", "<h1>Replaced</h1>", testSectionTitle, "")
+	e.AddSection(testSectionBody, testSectionTitle, "section-c.xhtml", "")
+	expected = []error{&DuplicateSectionError{Filename: "section-c.xhtml", DuplicateOf: "section-b.xhtml"}}
+	if !reflect.DeepEqual(warnings, expected) {
+		t.Errorf("Warnings don't match\nGot: %v\nExpected: %v", warnings, expected)
+	}
+}
+
+func TestSetMaxManifestItems(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	e.SetMaxManifestItems(2)
+
+	_, err := e.AddCSS(testCoverCSSSource, "")
+	if err != nil {
+		t.Errorf("Error adding CSS: %s", err)
+	}
+	_, err = e.AddSection(testSectionBody, testSectionTitle, "", "")
+	if err != nil {
+		t.Errorf("Error adding section: %s", err)
+	}
+	_, err = e.AddImage(testImageFromFileSource, "")
+	if _, ok := err.(*TooManyManifestItemsError); !ok {
+		t.Errorf("Expected error TooManyManifestItemsError not returned. Returned instead: %+v", err)
+	}
+	_, err = e.AddSection(testSectionBody, testSectionTitle, "", "")
+	if _, ok := err.(*TooManyManifestItemsError); !ok {
+		t.Errorf("Expected error TooManyManifestItemsError not returned. Returned instead: %+v", err)
+	}
+	if len(e.images) != 0 || len(e.sections) != 1 {
+		t.Errorf("Files shouldn't be added once the limit is reached, got %d images and %d sections", len(e.images), len(e.sections))
+	}
+
+	e.SetMaxManifestItems(0)
+	_, err = e.AddImage(testImageFromFileSource, "")
+	if err != nil {
+		t.Errorf("Error adding image: %s", err)
+	}
+}
+
+func TestMetadataGetters(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	e.Pkg.SetLang(testEpubLang)
+	e.Pkg.SetDescription(testEpubDescription)
+	e.Pkg.SetPpd(ppdRTL)
+	e.Pkg.AddCreator("Illustrator", PropertyRoleArtist)
+	e.Pkg.AddCreator(testEpubAuthor, PropertyRoleAuthor)
+	e.Pkg.AddAuthor("Second Author", "ja")
+	e.Pkg.AddIdentifier("9780000000002", SchemeONIXCodeList5, PropertyIdentifierTypeISBN13)
+
+	for _, tt := range []struct {
+		name     string
+		got      string
+		expected string
+	}{
+		{"Title", e.Title(), testEpubTitle},
+		{"Lang", e.Lang(), testEpubLang},
+		{"Description", e.Description(), testEpubDescription},
+		{"Ppd", e.Ppd(), ppdRTL},
+	} {
+		if tt.got != tt.expected {
+			t.Errorf("%s doesn't match\nGot: %s\nExpected: %s", tt.name, tt.got, tt.expected)
+		}
+	}
+
+	expectedAuthors := []string{testEpubAuthor, "Second Author"}
+	if authors := e.Pkg.Authors(); !reflect.DeepEqual(authors, expectedAuthors) {
+		t.Errorf("Authors don't match\nGot: %v\nExpected: %v", authors, expectedAuthors)
+	}
+
+	identifiers := e.Pkg.Identifiers()
+	if len(identifiers) != 2 || identifiers[1].Data != "9780000000002" {
+		t.Errorf("Identifiers don't match\nGot: %v", identifiers)
+	}
+	// The identifiers are a copy
+	identifiers[0].Data = "changed"
+	if e.Pkg.Identifiers()[0].Data == "changed" {
+		t.Error("Changing the returned identifiers shouldn't change the EPUB")
+	}
+}
+
 func TestFilenameAlreadyUsedError(t *testing.T) {
 	e := NewEpub(testEpubTitle)
 
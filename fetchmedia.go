@@ -2,14 +2,21 @@ package epub
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"github.com/bmaupin/go-epub/internal/storage"
 	"github.com/gabriel-vasile/mimetype"
 	"github.com/vincent-petithory/dataurl"
 )
@@ -18,6 +25,28 @@ import (
 // if onlyChecl is true, the methods will not perform actual grab to spare memory and bandwidth
 type grabber struct {
 	*http.Client
+	// Context of the requests to retrieve media by URL, if any
+	ctx context.Context
+	// If set, media retrieved by URL is cached in this directory
+	cacheDir string
+	// Whether to check with the server that cached media hasn't changed
+	revalidateCache bool
+	// Headers added to the requests to retrieve media by URL
+	headers http.Header
+	// If set, data URLs longer than this are decoded to a file in stagingDir
+	// when they're added
+	stagingThreshold int
+	stagingDir       string
+	// Storage the media is fetched into, the default storage if nil
+	fsys storage.Storage
+}
+
+// Get the storage media is fetched into
+func (g grabber) storage() storage.Storage {
+	if g.fsys == nil {
+		return filesystem
+	}
+	return g.fsys
 }
 
 func (g grabber) checkMedia(mediaSource string) error {
@@ -49,7 +78,7 @@ func (g grabber) fetchMedia(mediaSource, mediaFolderPath, mediaFilename string)
 		mediaFilename,
 	)
 	// failfast, create the output file handler at the begining, if we cannot write the file, bail out
-	w, err := filesystem.Create(mediaFilePath)
+	w, err := g.storage().Create(mediaFilePath)
 	if err != nil {
 		return "", fmt.Errorf("unable to create file %s: %s", mediaFilePath, err)
 	}
@@ -83,12 +112,16 @@ func (g grabber) fetchMedia(mediaSource, mediaFolderPath, mediaFilename string)
 	}
 
 	// Detect the mediaType
-	r, err := filesystem.Open(mediaFilePath)
+	r, err := g.storage().Open(mediaFilePath)
 	if err != nil {
 		return "", err
 	}
 	defer r.Close()
-	mime, err := mimetype.DetectReader(r)
+	// Keep the first bytes around to detect font formats
+	header := make([]byte, 4)
+	n, _ := io.ReadFull(r, header)
+	header = header[:n]
+	mime, err := mimetype.DetectReader(io.MultiReader(bytes.NewReader(header), r))
 	if err != nil {
 		panic(err)
 	}
@@ -100,24 +133,291 @@ func (g grabber) fetchMedia(mediaSource, mediaFolderPath, mediaFilename string)
 			mtype = "text/css"
 		}
 	}
+
+	// Is it SVG? It's only detected if the <svg> element is near the start of
+	// the file, e.g. not after a long comment
+	if mime.Is("text/xml") || mime.Is("application/xml") || mime.Is("text/plain") {
+		if filepath.Ext(mediaSource) == ".svg" || filepath.Ext(mediaFilename) == ".svg" {
+			mtype = mediaTypeSVG
+		}
+	}
+
+	// Is it a font?
+	if fontType := fontMediaType(header, mediaFilename, mtype); fontType != "" {
+		mtype = fontType
+	}
 	return mtype, nil
 }
 
+// Media types audio files are often detected as, and the media types EPUB
+// reading systems expect for them
+var audioMediaTypes = map[string]string{
+	"application/ogg": mediaTypeOgg,
+	"audio/mp3":       mediaTypeMP3,
+	"audio/vnd.wave":  mediaTypeWAV,
+	"audio/wave":      mediaTypeWAV,
+	"audio/x-m4a":     mediaTypeMP4Audio,
+	"audio/x-mp4a":    mediaTypeMP4Audio,
+	"audio/x-mpeg":    mediaTypeMP3,
+	"audio/x-wav":     mediaTypeWAV,
+	"video/mp4":       mediaTypeMP4Audio,
+}
+
+// Media types of audio files by extension, for files whose content isn't
+// recognized
+var audioExtensionMediaTypes = map[string]string{
+	".m4a":  mediaTypeMP4Audio,
+	".m4b":  mediaTypeMP4Audio,
+	".mp3":  mediaTypeMP3,
+	".mp4":  mediaTypeMP4Audio,
+	".oga":  mediaTypeOgg,
+	".ogg":  mediaTypeOgg,
+	".opus": mediaTypeOgg,
+	".wav":  mediaTypeWAV,
+}
+
+// audioMediaType returns the media type to declare for an audio file with the
+// detected media type
+func audioMediaType(detected string, filename string) string {
+	if mtype, ok := audioMediaTypes[detected]; ok {
+		return mtype
+	}
+	if !strings.HasPrefix(detected, "audio/") {
+		if mtype, ok := audioExtensionMediaTypes[strings.ToLower(filepath.Ext(filename))]; ok {
+			return mtype
+		}
+	}
+	return detected
+}
+
+// Media types JavaScript files are declared with
+var javaScriptMediaTypes = map[string]bool{
+	"application/ecmascript":   true,
+	"application/javascript":   true,
+	"application/x-javascript": true,
+	"text/ecmascript":          true,
+	"text/javascript":          true,
+}
+
+// Legacy or generic media types which fonts are often detected as
+var legacyFontMediaTypes = map[string]string{
+	"application/x-font-ttf":      mediaTypeTTF,
+	"application/x-font-truetype": mediaTypeTTF,
+	"application/font-sfnt":       mediaTypeTTF,
+	"font/sfnt":                   mediaTypeTTF,
+	"application/x-font-otf":      mediaTypeOTF,
+	"application/x-font-opentype": mediaTypeOTF,
+	"application/vnd.ms-opentype": mediaTypeOTF,
+	"font/opentype":               mediaTypeOTF,
+	"application/font-woff":       mediaTypeWOFF,
+	"application/x-font-woff":     mediaTypeWOFF,
+	"application/font-woff2":      mediaTypeWOFF2,
+	"application/x-font-woff2":    mediaTypeWOFF2,
+}
+
+// fontMediaType returns the font media type required by EPUB 3.3 (font/ttf,
+// font/otf, font/woff, or font/woff2) for a file, based on its first bytes,
+// its extension, and the media type it was detected as. An empty string is
+// returned if the file isn't one of these fonts.
+//
+// Spec: https://www.w3.org/TR/epub-33/#sec-core-media-types
+func fontMediaType(header []byte, filename string, detectedType string) string {
+	// OpenType fonts with CFF outlines start with "OTTO", TrueType fonts with
+	// 0x00010000
+	switch string(header) {
+	case "OTTO":
+		return mediaTypeOTF
+	case "\x00\x01\x00\x00":
+		return mediaTypeTTF
+	case "wOFF":
+		return mediaTypeWOFF
+	case "wOF2":
+		return mediaTypeWOFF2
+	}
+
+	if fontType, ok := legacyFontMediaTypes[detectedType]; ok {
+		return fontType
+	}
+
+	if detectedType == "application/octet-stream" {
+		switch strings.ToLower(filepath.Ext(filename)) {
+		case ".ttf":
+			return mediaTypeTTF
+		case ".otf":
+			return mediaTypeOTF
+		case ".woff":
+			return mediaTypeWOFF
+		case ".woff2":
+			return mediaTypeWOFF2
+		}
+	}
+
+	return ""
+}
+
+// readMedia returns the content of mediaSource, which can be a URL, a local path
+// or an inline dataurl (as specified in RFC 2397)
+func (g grabber) readMedia(mediaSource string) ([]byte, error) {
+	fetchErrors := make([]error, 0)
+	for _, f := range []func(string, bool) (io.ReadCloser, error){
+		g.localHandler,
+		g.httpHandler,
+		g.dataURLHandler,
+	} {
+		source, err := f(mediaSource, false)
+		if err != nil {
+			fetchErrors = append(fetchErrors, err)
+			continue
+		}
+		defer source.Close()
+		return ioutil.ReadAll(source)
+	}
+	return nil, &FileRetrievalError{Source: mediaSource, Err: fetchError(fetchErrors)}
+}
+
 func (g grabber) httpHandler(mediaSource string, onlyCheck bool) (io.ReadCloser, error) {
-	var resp *http.Response
-	var err error
+	if g.cacheDir != "" {
+		return g.cachedHTTPHandler(mediaSource, onlyCheck)
+	}
+	method := http.MethodGet
 	if onlyCheck {
-		resp, err = g.Head(mediaSource)
-	} else {
-		resp, err = g.Get(mediaSource)
+		method = http.MethodHead
 	}
+	resp, err := g.request(method, mediaSource, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Send a request for the media at the given URL with the headers of the
+// grabber and the given additional headers, e.g. to make it conditional
+func (g grabber) request(method string, mediaSource string, headers http.Header) (*http.Response, error) {
+	ctx := g.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, mediaSource, nil)
+	if err != nil {
+		return nil, err
+	}
+	addHeaders(req, g.headers)
+	addHeaders(req, headers)
+	resp, err := g.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	if resp.StatusCode > 400 {
+		resp.Body.Close()
 		return nil, errors.New("cannot get file, bad return code")
 	}
-	return resp.Body, nil
+	return resp, nil
+}
+
+// Add the headers to the request, replacing the default ones such as the
+// User-Agent
+func addHeaders(req *http.Request, headers http.Header) {
+	for name, values := range headers {
+		req.Header[http.CanonicalHeaderKey(name)] = values
+	}
+}
+
+// cachedHTTPHandler is like httpHandler but gets the media from the cache
+// directory, downloading it there first if it isn't cached yet. If the grabber
+// revalidates the cache, cached media is only used once the server confirms it
+// hasn't changed.
+func (g grabber) cachedHTTPHandler(mediaSource string, onlyCheck bool) (io.ReadCloser, error) {
+	hash := sha256.Sum256([]byte(mediaSource))
+	cacheFilePath := filepath.Join(g.cacheDir, hex.EncodeToString(hash[:]))
+
+	var conditional http.Header
+	if _, err := os.Stat(cacheFilePath); err == nil {
+		if onlyCheck {
+			return nil, nil
+		}
+		if g.revalidateCache {
+			conditional = readCacheValidators(cacheFilePath)
+		}
+		// Without validators there's no way to check whether the media changed
+		if conditional == nil {
+			return os.Open(cacheFilePath)
+		}
+	} else if onlyCheck {
+		uncached := g
+		uncached.cacheDir = ""
+		return uncached.httpHandler(mediaSource, onlyCheck)
+	}
+
+	resp, err := g.request(http.MethodGet, mediaSource, conditional)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if conditional != nil && resp.StatusCode == http.StatusNotModified {
+		return os.Open(cacheFilePath)
+	}
+
+	// Download to a temporary file first so an interrupted download doesn't
+	// end up in the cache
+	w, err := ioutil.TempFile(g.cacheDir, tempDirPrefix)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(w, resp.Body)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(w.Name(), cacheFilePath)
+	}
+	if err != nil {
+		os.Remove(w.Name())
+		return nil, err
+	}
+	writeCacheValidators(cacheFilePath, resp.Header)
+
+	return os.Open(cacheFilePath)
+}
+
+// Suffix of the files next to the cached media holding the headers to send to
+// check whether the media has changed
+const cacheValidatorsSuffix = ".validators"
+
+// Get the conditional request headers (If-None-Match and If-Modified-Since) for
+// the cached media at the given path, or nil if the server sent no validators
+// when the media was downloaded
+func readCacheValidators(cacheFilePath string) http.Header {
+	content, err := ioutil.ReadFile(cacheFilePath + cacheValidatorsSuffix)
+	if err != nil {
+		return nil
+	}
+	var conditional http.Header
+	if err := json.Unmarshal(content, &conditional); err != nil || len(conditional) == 0 {
+		return nil
+	}
+	return conditional
+}
+
+// Store the conditional request headers for the cached media at the given path
+// based on the ETag and Last-Modified headers of the response it was downloaded
+// with. Failing to store them only means the media can't be revalidated.
+func writeCacheValidators(cacheFilePath string, respHeader http.Header) {
+	conditional := http.Header{}
+	if etag := respHeader.Get("ETag"); etag != "" {
+		conditional.Set("If-None-Match", etag)
+	}
+	if lastModified := respHeader.Get("Last-Modified"); lastModified != "" {
+		conditional.Set("If-Modified-Since", lastModified)
+	}
+	if len(conditional) == 0 {
+		os.Remove(cacheFilePath + cacheValidatorsSuffix)
+		return
+	}
+	content, err := json.Marshal(conditional)
+	if err != nil {
+		return
+	}
+	ioutil.WriteFile(cacheFilePath+cacheValidatorsSuffix, content, filePermissions)
 }
 
 func (g grabber) localHandler(mediaSource string, onlyCheck bool) (io.ReadCloser, error) {
@@ -130,6 +430,24 @@ func (g grabber) localHandler(mediaSource string, onlyCheck bool) (io.ReadCloser
 	return os.Open(mediaSource)
 }
 
+// Get the size of a local media source without reading it
+func (g grabber) localSize(mediaSource string) (int64, error) {
+	r, err := g.localHandler(mediaSource, false)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	f, ok := r.(fs.File)
+	if !ok {
+		return 0, fmt.Errorf("unable to get the size of %s", mediaSource)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
 func (g grabber) dataURLHandler(mediaSource string, onlyCheck bool) (io.ReadCloser, error) {
 	if onlyCheck {
 		_, err := dataurl.DecodeString(mediaSource)
@@ -142,6 +460,34 @@ func (g grabber) dataURLHandler(mediaSource string, onlyCheck bool) (io.ReadClos
 	return ioutil.NopCloser(bytes.NewReader(data.Data)), nil
 }
 
+// stageDataURL decodes a data URL to a file in the staging directory and
+// returns the path to the file, which can be used as the source instead
+func (g grabber) stageDataURL(mediaSource string) (string, error) {
+	data, err := dataurl.DecodeString(mediaSource)
+	if err != nil {
+		return "", err
+	}
+	w, err := ioutil.TempFile(g.stagingDir, tempDirPrefix)
+	if err != nil {
+		return "", err
+	}
+	_, err = w.Write(data.Data)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(w.Name())
+		return "", err
+	}
+
+	return w.Name(), nil
+}
+
+// Check whether a media source is an inline dataurl
+func isDataURL(mediaSource string) bool {
+	return strings.HasPrefix(mediaSource, "data:")
+}
+
 type fetchError []error
 
 func (f fetchError) Error() string {
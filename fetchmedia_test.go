@@ -1,15 +1,23 @@
 package epub
 
 import (
+	"archive/zip"
+	"bytes"
 	"fmt"
 	"io"
 	"io/fs"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/bmaupin/go-epub/internal/storage"
+	"github.com/vincent-petithory/dataurl"
 )
 
 var golangFavicon = strings.Replace(`AAABAAEAEBAAAAEAIABoBAAAFgAAACgAAAAQAAAAIAAAAAEAIAAAAAAAAAAAAAAAAAAAAAAAAAAA
@@ -133,6 +141,16 @@ func testFetchMedia(t *testing.T) {
 			"",
 			true,
 		},
+		{
+			"font",
+			args{
+				mediaSource:     filepath.Join("testdata", "redacted-script-regular.ttf"),
+				mediaFolderPath: "/",
+				mediaFilename:   "test.ttf",
+			},
+			"font/ttf",
+			false,
+		},
 		{
 			"CSS",
 			args{
@@ -156,7 +174,7 @@ func testFetchMedia(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			g := &grabber{http.DefaultClient}
+			g := &grabber{Client: http.DefaultClient}
 			gotMediaType, err := g.fetchMedia(tt.args.mediaSource, tt.args.mediaFolderPath, tt.args.mediaFilename)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("fetchMedia() error = %v, wantErr %v", err, tt.wantErr)
@@ -175,3 +193,301 @@ func testFetchMedia(t *testing.T) {
 		})
 	}
 }
+
+func TestDownloadCache(t *testing.T) {
+	filename := "gophercolor16x16.png"
+	requests := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/image.png", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		data, err := os.Open(filepath.Join("testdata", filename))
+		if err != nil {
+			t.Fatal("cannot open testdata")
+		}
+		defer data.Close()
+		io.Copy(w, data)
+	}))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	cacheDir := t.TempDir()
+	for i := 0; i < 2; i++ {
+		e := NewEpub(testEpubTitle)
+		if err := e.SetDownloadCacheDir(cacheDir); err != nil {
+			t.Fatalf("Error setting download cache dir: %s", err)
+		}
+		if _, err := e.AddImage(ts.URL+"/image.png", ""); err != nil {
+			t.Fatalf("Error adding image: %s", err)
+		}
+		var b bytes.Buffer
+		if _, err := e.WriteTo(&b); err != nil {
+			t.Fatalf("Error writing EPUB: %s", err)
+		}
+	}
+
+	// One HEAD request when first adding the image and one GET request when
+	// first writing the EPUB; everything else should come from the cache
+	if requests != 2 {
+		t.Errorf("Expected 2 requests, got %d", requests)
+	}
+}
+
+func TestSetRevalidateDownloadCache(t *testing.T) {
+	original, err := os.ReadFile(filepath.Join("testdata", "gophercolor16x16.png"))
+	if err != nil {
+		t.Fatal("cannot open testdata")
+	}
+	updated, _ := newTestPNG(t)
+	content, version := original, 1
+	downloads, notModified := 0, 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/image.png", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := fmt.Sprintf(`"v%d"`, version)
+		if r.Header.Get("If-None-Match") == etag {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if r.Method == http.MethodGet {
+			downloads++
+		}
+		w.Header().Set("ETag", etag)
+		w.Write(content)
+	}))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	cacheDir := t.TempDir()
+	build := func() []byte {
+		e := NewEpub(testEpubTitle)
+		if err := e.SetDownloadCacheDir(cacheDir); err != nil {
+			t.Fatalf("Error setting download cache dir: %s", err)
+		}
+		e.SetRevalidateDownloadCache(true)
+		if _, err := e.AddImage(ts.URL+"/image.png", "image.png"); err != nil {
+			t.Fatalf("Error adding image: %s", err)
+		}
+		b, err := e.Bytes()
+		if err != nil {
+			t.Fatalf("Error writing EPUB: %s", err)
+		}
+		z, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+		if err != nil {
+			t.Fatalf("Error reading EPUB: %s", err)
+		}
+		f, err := z.Open(contentFolderName + "/" + ImageFolderName + "/image.png")
+		if err != nil {
+			t.Fatalf("Error opening image in EPUB: %s", err)
+		}
+		defer f.Close()
+		image, err := ioutil.ReadAll(f)
+		if err != nil {
+			t.Fatalf("Error reading image in EPUB: %s", err)
+		}
+		return image
+	}
+
+	build()
+	// The cached image hasn't changed, so it isn't downloaded again
+	if image := build(); !bytes.Equal(image, original) || downloads != 1 || notModified != 1 {
+		t.Errorf("Expected the cached image to be used, got %d downloads and %d not modified responses", downloads, notModified)
+	}
+
+	content, version = updated, 2
+	if image := build(); !bytes.Equal(image, updated) || downloads != 2 {
+		t.Errorf("Expected the changed image to be downloaded again, got %d downloads", downloads)
+	}
+}
+
+func TestSetFetchHeaders(t *testing.T) {
+	filename := "gophercolor16x16.png"
+	testUserAgent := "go-epub-test"
+	testAuthorization := "Bearer token"
+	mux := http.NewServeMux()
+	mux.HandleFunc("/image.png", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != testAuthorization || r.UserAgent() != testUserAgent {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		data, err := os.Open(filepath.Join("testdata", filename))
+		if err != nil {
+			t.Fatal("cannot open testdata")
+		}
+		defer data.Close()
+		io.Copy(w, data)
+	}))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	e := NewEpub(testEpubTitle)
+	if _, err := e.AddImage(ts.URL+"/image.png", ""); err == nil {
+		t.Error("Expected an error adding an image without the headers")
+	}
+
+	headers := http.Header{}
+	headers.Set("User-Agent", testUserAgent)
+	headers.Set("Authorization", testAuthorization)
+	e.SetFetchHeaders(headers)
+	if _, err := e.AddImage(ts.URL+"/image.png", ""); err != nil {
+		t.Fatalf("Error adding image: %s", err)
+	}
+	var b bytes.Buffer
+	if _, err := e.WriteTo(&b); err != nil {
+		t.Errorf("Error writing EPUB: %s", err)
+	}
+}
+
+func TestSetFetchConcurrency(t *testing.T) {
+	data, err := ioutil.ReadFile(testImageFromFileSource)
+	if err != nil {
+		t.Fatal("cannot open testdata")
+	}
+	const imageCount = 6
+	const concurrency = 3
+	var mu sync.Mutex
+	var writing bool
+	var inFlight, maxInFlight int
+	var failing string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		if r.URL.Path == failing {
+			mu.Unlock()
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		delayed := writing
+		mu.Unlock()
+		// The first images take the longest, so they're retrieved last
+		if delayed {
+			var i int
+			fmt.Sscanf(r.URL.Path, "/%d.png", &i)
+			time.Sleep(time.Duration(imageCount-i) * 20 * time.Millisecond)
+		}
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		w.Write(data)
+	}))
+	defer ts.Close()
+
+	e := NewEpub(testEpubTitle)
+	e.SetFetchConcurrency(concurrency)
+	for i := 0; i < imageCount; i++ {
+		if _, err := e.AddImage(fmt.Sprintf("%s/%d.png", ts.URL, i), fmt.Sprintf("image%d.png", i)); err != nil {
+			t.Fatalf("Error adding image: %s", err)
+		}
+	}
+	mu.Lock()
+	writing = true
+	maxInFlight = 0
+	mu.Unlock()
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	mu.Lock()
+	if maxInFlight < 2 || maxInFlight > concurrency {
+		t.Errorf("Unexpected number of images retrieved at the same time\nGot: %d\nExpected: between 2 and %d", maxInFlight, concurrency)
+	}
+	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Fatalf("Unexpected error reading package file: %s", err)
+	}
+	previous := -1
+	for i := 0; i < imageCount; i++ {
+		index := strings.Index(string(pkgFileContent), fmt.Sprintf(`href="images/image%d.png"`, i))
+		if index <= previous {
+			t.Errorf("Images aren't in the manifest in the order they were added\nGot: %s", pkgFileContent)
+			break
+		}
+		previous = index
+	}
+	cleanup(testEpubFilename, tempDir)
+
+	// The first image which can't be retrieved is reported
+	failing = "/4.png"
+	mu.Unlock()
+	_, err = e.WriteTo(ioutil.Discard)
+	if retrievalErr, ok := err.(*FileRetrievalError); !ok || retrievalErr.Source != ts.URL+"/4.png" {
+		t.Errorf("Expected error FileRetrievalError not returned. Returned instead: %+v", err)
+	}
+}
+
+func TestDataURLStaging(t *testing.T) {
+	data, err := ioutil.ReadFile(testImageFromFileSource)
+	if err != nil {
+		t.Fatal("cannot open testdata")
+	}
+	source := dataurl.EncodeBytes(data)
+
+	e := NewEpub(testEpubTitle)
+	if err := e.SetDataURLStagingThreshold(len(source) - 1); err != nil {
+		t.Fatalf("Error setting data URL staging threshold: %s", err)
+	}
+	testImagePath, err := e.AddImage(source, testImageFromFileFilename)
+	if err != nil {
+		t.Fatalf("Error adding image: %s", err)
+	}
+	// A data URL below the threshold should be kept as is
+	testSmallImagePath, err := e.AddImage(dataurl.EncodeBytes(data[:8]), "small.png")
+	if err != nil {
+		t.Fatalf("Error adding image: %s", err)
+	}
+
+	stagedPath := e.images[testImageFromFileFilename]
+	if isDataURL(stagedPath) || filepath.Dir(stagedPath) != e.stagingDir {
+		t.Errorf("Data URL wasn't staged\nGot: %s", stagedPath)
+	}
+	if !isDataURL(e.images[filepath.Base(testSmallImagePath)]) {
+		t.Errorf("Data URL below the threshold was staged")
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, ImageFolderName, filepath.Base(testImagePath)))
+	if err != nil {
+		t.Errorf("Unexpected error reading image file: %s", err)
+	}
+	if !bytes.Equal(contents, data) {
+		t.Errorf("Staged image file contents don't match")
+	}
+	cleanup(testEpubFilename, tempDir)
+
+	stagingDir := e.stagingDir
+	for i := 0; i < 2; i++ {
+		if err := e.Close(); err != nil {
+			t.Errorf("Error closing EPUB: %s", err)
+		}
+	}
+	if _, err := os.Stat(stagingDir); !os.IsNotExist(err) {
+		t.Errorf("Staging directory wasn't removed by Close: %v", err)
+	}
+}
+
+func Test_fontMediaType(t *testing.T) {
+	tests := []struct {
+		name         string
+		header       string
+		filename     string
+		detectedType string
+		want         string
+	}{
+		{"TrueType magic", "\x00\x01\x00\x00", "font", "application/octet-stream", "font/ttf"},
+		{"OpenType magic", "OTTO", "font", "application/octet-stream", "font/otf"},
+		{"WOFF magic", "wOFF", "font", "application/font-woff", "font/woff"},
+		{"WOFF2 magic", "wOF2", "font", "application/octet-stream", "font/woff2"},
+		{"legacy TrueType type", "", "font", "application/x-font-ttf", "font/ttf"},
+		{"legacy OpenType type", "", "font", "application/vnd.ms-opentype", "font/otf"},
+		{"WOFF2 extension", "", "font.WOFF2", "application/octet-stream", "font/woff2"},
+		{"not a font", "\x89PNG", "image.png", "image/png", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fontMediaType([]byte(tt.header), tt.filename, tt.detectedType); got != tt.want {
+				t.Errorf("fontMediaType() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
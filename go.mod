@@ -1,9 +1,11 @@
 module github.com/bmaupin/go-epub
 
-go 1.15
+go 1.17
 
 require (
 	github.com/gabriel-vasile/mimetype v1.3.1
 	github.com/gofrs/uuid v3.1.0+incompatible
 	github.com/vincent-petithory/dataurl v0.0.0-20191104211930-d1553a71de50
 )
+
+require golang.org/x/net v0.0.0-20210505024714-0287a6fb4125 // indirect
@@ -0,0 +1,338 @@
+package epub
+
+import (
+	"encoding/xml"
+	"html"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// HTML elements which can't have any content, so they're written self-closed,
+// e.g. <br/>
+var htmlVoidElements = map[string]bool{
+	"area":   true,
+	"base":   true,
+	"br":     true,
+	"col":    true,
+	"embed":  true,
+	"hr":     true,
+	"img":    true,
+	"input":  true,
+	"link":   true,
+	"meta":   true,
+	"param":  true,
+	"source": true,
+	"track":  true,
+	"wbr":    true,
+}
+
+// Escapes the text of an element for XHTML
+var xhtmlTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// The namespace of the xml prefix, e.g. of xml:lang
+const xmlNamespaceURI = "http://www.w3.org/XML/1998/namespace"
+
+// A part of an HTML document which will become a section
+type htmlSplit struct {
+	title string
+	body  string
+}
+
+// AddSectionsFromHTML splits an HTML document into multiple sections at its
+// headings and adds them to the EPUB, returning the relative paths to the
+// sections in the same format as AddSection.
+//
+// The document is split at every heading with a level up to the split level,
+// e.g. a split level of 2 splits at every <h1> and <h2> element. The text of
+// each heading is used as the title of its section, so the sections are added
+// to the table of contents. Any content before the first heading is added as a
+// section without a title. Only headings which are direct children of the
+// <body> element (or top-level elements if the document has no <body>) are
+// considered, since splitting inside other elements would break the markup.
+// The content of the sections is written as XHTML, e.g. with void elements like
+// <br> closed and all attribute values quoted.
+//
+// The split level must be between 1 and 6, otherwise InvalidValueError will be
+// returned. If the document can't be parsed, nothing is added and the error is
+// returned.
+func (e *Epub) AddSectionsFromHTML(html string, splitLevel int) ([]string, error) {
+	e.Lock()
+	defer e.Unlock()
+	if splitLevel < 1 || splitLevel > 6 {
+		return nil, &InvalidValueError{Name: "split level", Value: strconv.Itoa(splitLevel)}
+	}
+
+	splits, err := splitHTML(html, splitLevel)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.checkManifestItems(len(splits)); err != nil {
+		return nil, err
+	}
+
+	sectionPaths := []string{}
+	for _, split := range splits {
+		sectionPath, err := e.addSection(split.body, split.title, "", "")
+		if err != nil {
+			return sectionPaths, err
+		}
+		sectionPaths = append(sectionPaths, sectionPath)
+	}
+
+	return sectionPaths, nil
+}
+
+// Split the body of an HTML document at its top-level headings. The splits are
+// written as XHTML, since the document doesn't have to be well-formed XML.
+func splitHTML(html string, splitLevel int) ([]htmlSplit, error) {
+	d := newHTMLDecoder(html)
+	w := newXHTMLWriter()
+
+	// Whether the content is being read; the whole document unless a <body>
+	// element is found
+	inBody := true
+	// Depth relative to the content
+	depth := 0
+	// Offsets in the written content where it's split, and the titles of the
+	// splits
+	splitOffsets := []int{}
+	titles := []string{}
+	// Depth of the heading whose title is being read, or -1
+	headingDepth := -1
+	var title strings.Builder
+
+tokens:
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := t.(type) {
+		case xml.StartElement:
+			w.declare(t.Attr)
+			name := strings.ToLower(t.Name.Local)
+			if name == "body" {
+				w.b.Reset()
+				inBody, depth = true, 0
+				splitOffsets, titles = nil, nil
+				continue
+			}
+			if name == "head" {
+				inBody = false
+			}
+			if inBody && depth == 0 && isHeading(name, splitLevel) {
+				splitOffsets = append(splitOffsets, w.b.Len())
+				headingDepth = depth
+				title.Reset()
+			}
+			depth++
+		case xml.EndElement:
+			name := strings.ToLower(t.Name.Local)
+			if name == "body" {
+				break tokens
+			}
+			if name == "head" {
+				inBody = true
+				continue
+			}
+			depth--
+			if depth == headingDepth {
+				titles = append(titles, strings.Join(strings.Fields(title.String()), " "))
+				headingDepth = -1
+			}
+		case xml.CharData:
+			if headingDepth != -1 {
+				title.Write(t)
+			}
+		}
+		if inBody {
+			w.writeToken(t)
+		}
+	}
+
+	content := w.b.String()
+	splits := []htmlSplit{}
+	// Content before the first heading
+	firstEnd := len(content)
+	if len(splitOffsets) > 0 {
+		firstEnd = splitOffsets[0]
+	}
+	if first := strings.TrimSpace(content[:firstEnd]); first != "" {
+		splits = append(splits, htmlSplit{body: first})
+	}
+	for i, splitOffset := range splitOffsets {
+		end := len(content)
+		if i+1 < len(splitOffsets) {
+			end = splitOffsets[i+1]
+		}
+		s := htmlSplit{body: strings.TrimSpace(content[splitOffset:end])}
+		if i < len(titles) {
+			s.title = titles[i]
+		}
+		splits = append(splits, s)
+	}
+
+	return splits, nil
+}
+
+// Writes the tokens of an HTML document as XHTML, e.g. with void elements like
+// <br> closed and all attribute values quoted and escaped
+type xhtmlWriter struct {
+	b strings.Builder
+	// Prefixes of the namespaces declared in the document by their URI, or an
+	// empty prefix for default namespaces
+	prefixes map[string]string
+	// Whether each of the open elements is a void element, which has already
+	// been closed
+	void []bool
+}
+
+func newXHTMLWriter() *xhtmlWriter {
+	return &xhtmlWriter{
+		prefixes: map[string]string{xmlNamespaceURI: "xml"},
+	}
+}
+
+// Remember the namespaces declared by the attributes of an element
+func (w *xhtmlWriter) declare(attrs []xml.Attr) {
+	for _, a := range attrs {
+		switch {
+		case a.Name.Space == "xmlns":
+			w.prefixes[a.Value] = a.Name.Local
+		case a.Name.Space == "" && a.Name.Local == "xmlns":
+			w.prefixes[a.Value] = ""
+		}
+	}
+}
+
+// Get the name of an element or attribute along with its prefix. The decoder
+// replaces declared prefixes with the namespace and leaves other prefixes as
+// they are.
+func (w *xhtmlWriter) name(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	prefix, ok := w.prefixes[n.Space]
+	if !ok {
+		prefix = n.Space
+	}
+	if prefix == "" {
+		return n.Local
+	}
+	return prefix + ":" + n.Local
+}
+
+func (w *xhtmlWriter) writeToken(t xml.Token) {
+	switch t := t.(type) {
+	case xml.StartElement:
+		w.b.WriteString("<" + w.name(t.Name))
+		for _, a := range t.Attr {
+			w.b.WriteString(" " + w.name(a.Name) + `="` + html.EscapeString(a.Value) + `"`)
+		}
+		void := htmlVoidElements[strings.ToLower(t.Name.Local)]
+		if void {
+			w.b.WriteString("/>")
+		} else {
+			w.b.WriteString(">")
+		}
+		w.void = append(w.void, void)
+	case xml.EndElement:
+		void := false
+		if len(w.void) > 0 {
+			void = w.void[len(w.void)-1]
+			w.void = w.void[:len(w.void)-1]
+		}
+		if !void {
+			w.b.WriteString("</" + w.name(t.Name) + ">")
+		}
+	case xml.CharData:
+		w.b.WriteString(xhtmlTextEscaper.Replace(string(t)))
+	case xml.Comment:
+		w.b.WriteString("<!--" + string(t) + "-->")
+	}
+}
+
+// RewriteResourceLinks rewrites the src, href, poster, xlink:href, and data
+// attributes of the elements in the body of a section according to the mapping
+// of old to new paths, e.g. to point the images of imported HTML content to
+// images added with AddImage. Attributes whose value isn't in the mapping are
+// left alone.
+//
+// The internal filename is the one returned by AddSection. If no section with
+// that filename exists, FilenameNotFoundError will be returned. An error is also
+// returned if the body of the section can't be parsed.
+func (e *Epub) RewriteResourceLinks(internalFilename string, mapping map[string]string) error {
+	e.Lock()
+	defer e.Unlock()
+	for _, section := range e.sections {
+		if section.filename == internalFilename {
+			body, err := rewriteResourceLinks(section.xhtml.xml.Body.XML, mapping)
+			if err != nil {
+				return err
+			}
+			section.xhtml.xml.Body.XML = body
+			return nil
+		}
+	}
+
+	return &FilenameNotFoundError{Filename: internalFilename}
+}
+
+// Rewrite the resource attributes of the tags in an HTML fragment
+func rewriteResourceLinks(body string, mapping map[string]string) (string, error) {
+	d := newHTMLDecoder(body)
+
+	var b strings.Builder
+	// Offset up to which the body has been copied
+	copied := int64(0)
+	for {
+		offset := d.InputOffset()
+		t, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if _, ok := t.(xml.StartElement); !ok {
+			continue
+		}
+
+		// Only rewrite the attributes within the tag itself
+		tag := body[offset:d.InputOffset()]
+		tag = resourceAttributeRegexp.ReplaceAllStringFunc(tag, func(attribute string) string {
+			m := resourceAttributeRegexp.FindStringSubmatch(attribute)
+			value := m[2] + m[3]
+			newValue, ok := mapping[html.UnescapeString(value)]
+			if !ok {
+				return attribute
+			}
+			return m[1] + `"` + html.EscapeString(newValue) + `"`
+		})
+		b.WriteString(body[copied:offset])
+		b.WriteString(tag)
+		copied = d.InputOffset()
+	}
+	b.WriteString(body[copied:])
+
+	return b.String(), nil
+}
+
+// Check whether an element is a heading with a level up to maxLevel
+func isHeading(name string, maxLevel int) bool {
+	return len(name) == 2 && name[0] == 'h' && name[1] >= '1' && int(name[1]-'0') <= maxLevel
+}
+
+// Get a decoder which is lenient enough to parse HTML
+func newHTMLDecoder(html string) *xml.Decoder {
+	d := xml.NewDecoder(strings.NewReader(html))
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	d.Entity = xml.HTMLEntity
+	return d
+}
@@ -0,0 +1,155 @@
+package epub
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bmaupin/go-epub/internal/storage"
+)
+
+const testHTMLDocument = `<!DOCTYPE html>
+<html>
+<head><title>Book</title></head>
+<body>
+<p>Preface</p>
+<h1>Chapter <em>1</em></h1>
+<p>One</p>
+<h2>Part 1.1</h2>
+<p>One point one<br></p>
+<div><h1>Not split</h1></div>
+<h1>Chapter 2</h1>
+<p>Two</p>
+</body>
+</html>`
+
+func TestAddSectionsFromHTML(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	_, err := e.AddSectionsFromHTML(testHTMLDocument, 7)
+	if _, ok := err.(*InvalidValueError); !ok {
+		t.Errorf("Expected error InvalidValueError not returned. Returned instead: %+v", err)
+	}
+
+	sectionPaths, err := e.AddSectionsFromHTML(testHTMLDocument, 1)
+	if err != nil {
+		t.Errorf("Error adding sections from HTML: %s", err)
+	}
+	if len(sectionPaths) != 3 || len(e.sections) != 3 {
+		t.Fatalf("Expected 3 sections, got: %v", sectionPaths)
+	}
+
+	testSections := []struct {
+		title       string
+		bodyPrefix  string
+		bodyContent string
+	}{
+		{"", "<p>Preface</p>", "Preface"},
+		{"Chapter 1", "<h1>Chapter <em>1</em></h1>", "<h2>Part 1.1</h2>"},
+		{"Chapter 2", "<h1>Chapter 2</h1>", "Two"},
+	}
+	for i, testSection := range testSections {
+		x := e.sections[i].xhtml
+		body := strings.TrimSpace(x.xml.Body.XML)
+		if x.Title() != testSection.title || !strings.HasPrefix(body, testSection.bodyPrefix) || !strings.Contains(body, testSection.bodyContent) {
+			t.Errorf("Unexpected section %d\nGot title: %s\nGot body: %s", i, x.Title(), body)
+		}
+		if strings.Contains(body, "</body>") {
+			t.Errorf("Section %d body shouldn't contain the end of the document\nGot: %s", i, body)
+		}
+	}
+	if !strings.Contains(e.sections[1].xhtml.xml.Body.XML, "Not split") {
+		t.Errorf("Nested headings shouldn't split the document")
+	}
+
+	e = NewEpub(testEpubTitle)
+	sectionPaths, err = e.AddSectionsFromHTML(testHTMLDocument, 2)
+	if err != nil {
+		t.Errorf("Error adding sections from HTML: %s", err)
+	}
+	if len(sectionPaths) != 4 || e.sections[2].xhtml.Title() != "Part 1.1" {
+		t.Errorf("Expected the document to be split at <h2> as well, got: %v", sectionPaths)
+	}
+}
+
+func TestAddSectionsFromHTMLWellFormed(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	_, err := e.AddSectionsFromHTML(`<html><body>
+<h1 class=title>Chapter&nbsp;1</h1>
+<p lang=en xml:lang=en>One<br>Two &amp; three<hr>
+<img src="a.png" alt="A &quot;quote&quot;"><input type=checkbox checked>
+<!-- note -->
+</body></html>`, 1)
+	if err != nil {
+		t.Fatalf("Error adding sections from HTML: %s", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, "section0001.xhtml"))
+	if err != nil {
+		t.Fatalf("Unexpected error reading section file: %s", err)
+	}
+	d := xml.NewDecoder(bytes.NewReader(contents))
+	for {
+		_, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Section isn't well-formed XML: %s\nGot: %s", err, contents)
+		}
+	}
+	for _, expected := range []string{
+		"<h1 class=\"title\">Chapter\u00a01</h1>",
+		`<p lang="en" xml:lang="en">One<br/>Two &amp; three<hr/>`,
+		`<img src="a.png" alt="A &#34;quote&#34;"/><input type="checkbox" checked="checked"/>`,
+		`<!-- note -->`,
+	} {
+		if !strings.Contains(string(contents), expected) {
+			t.Errorf(
+				"Section doesn't contain the expected markup\n"+
+					"Got: %s\n"+
+					"Expected: %s",
+				contents,
+				expected)
+		}
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestRewriteResourceLinks(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	testSectionPath, _ := e.AddSection(`<p>src="img/a.png" stays text</p>
+<img src="img/a.png" alt="A" /><img alt="B" src='img/b.png'/>
+<a href="other.html?a=1&amp;b=2">Link</a> <a href="unmapped.html">Unmapped</a>`, testSectionTitle, "", "")
+
+	err := e.RewriteResourceLinks("doesnotexist.xhtml", nil)
+	if _, ok := err.(*FilenameNotFoundError); !ok {
+		t.Errorf("Expected error FilenameNotFoundError not returned. Returned instead: %+v", err)
+	}
+
+	err = e.RewriteResourceLinks(testSectionPath, map[string]string{
+		"img/a.png":          "../images/a.png",
+		"img/b.png":          "../images/b.png",
+		"other.html?a=1&b=2": "section0002.xhtml",
+	})
+	if err != nil {
+		t.Errorf("Error rewriting resource links: %s", err)
+	}
+
+	testBody := `<p>src="img/a.png" stays text</p>
+<img src="../images/a.png" alt="A" /><img alt="B" src="../images/b.png"/>
+<a href="section0002.xhtml">Link</a> <a href="unmapped.html">Unmapped</a>`
+	if body := strings.TrimSpace(e.sections[0].xhtml.xml.Body.XML); body != testBody {
+		t.Errorf(
+			"Section body doesn't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			body,
+			testBody)
+	}
+}
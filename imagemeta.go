@@ -0,0 +1,134 @@
+package epub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+
+	"github.com/bmaupin/go-epub/internal/storage"
+)
+
+const (
+	// JPEG markers
+	jpegMarkerSOI  = 0xd8 // Start of image
+	jpegMarkerEOI  = 0xd9 // End of image
+	jpegMarkerSOS  = 0xda // Start of scan, followed by the compressed image data
+	jpegMarkerAPP1 = 0xe1 // Exif and XMP metadata
+	jpegMarkerAPP2 = 0xe2 // ICC color profile
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// PNG chunks which are removed: the ICC color profile and Exif metadata
+var pngMetadataChunks = map[string]bool{
+	"iCCP": true,
+	"eXIf": true,
+}
+
+// Remove the embedded color profile and Exif metadata from the image file at
+// the given path, see stripImageMetadata
+func stripImageMetadataFile(fsys storage.Storage, imageFilePath string) error {
+	content, err := storage.ReadFile(fsys, imageFilePath)
+	if err != nil {
+		return fmt.Errorf("unable to read image file: %w", err)
+	}
+	stripped, ok := stripImageMetadata(content)
+	if !ok {
+		return nil
+	}
+	if err := fsys.WriteFile(imageFilePath, stripped, filePermissions); err != nil {
+		return fmt.Errorf("unable to write image file: %w", err)
+	}
+	return nil
+}
+
+// Remove the embedded ICC color profile and Exif metadata from a JPEG or PNG
+// image without re-encoding the image data, so there's no loss of quality. The
+// returned bool is false if the image isn't a JPEG or PNG image, can't be
+// decoded, or has nothing to remove; the image should be kept unchanged then.
+func stripImageMetadata(content []byte) ([]byte, bool) {
+	var stripped []byte
+	var ok bool
+	switch {
+	case len(content) > 2 && content[0] == 0xff && content[1] == jpegMarkerSOI:
+		stripped, ok = stripJPEGMetadata(content)
+	case bytes.HasPrefix(content, pngSignature):
+		stripped, ok = stripPNGMetadata(content)
+	}
+	if !ok || len(stripped) == len(content) {
+		return nil, false
+	}
+	// Make sure the image is still valid
+	if _, _, err := image.DecodeConfig(bytes.NewReader(stripped)); err != nil {
+		return nil, false
+	}
+	return stripped, true
+}
+
+// Remove the APP1 and APP2 segments of a JPEG image, which hold the Exif
+// metadata and the ICC color profile
+func stripJPEGMetadata(content []byte) ([]byte, bool) {
+	stripped := make([]byte, 0, len(content))
+	stripped = append(stripped, content[:2]...)
+	i := 2
+	for i < len(content) {
+		if content[i] != 0xff {
+			return nil, false
+		}
+		// Markers may be preceded by any number of fill bytes
+		if i+1 < len(content) && content[i+1] == 0xff {
+			i++
+			continue
+		}
+		if i+1 >= len(content) {
+			return nil, false
+		}
+		marker := content[i+1]
+		if marker == jpegMarkerEOI {
+			return append(stripped, content[i:]...), true
+		}
+		if i+4 > len(content) {
+			return nil, false
+		}
+		// The length includes the two bytes of the length itself
+		end := i + 2 + int(binary.BigEndian.Uint16(content[i+2:i+4]))
+		if end > len(content) {
+			return nil, false
+		}
+		if marker == jpegMarkerSOS {
+			// The rest is the image data, which has no more metadata
+			return append(stripped, content[i:]...), true
+		}
+		if marker != jpegMarkerAPP1 && marker != jpegMarkerAPP2 {
+			stripped = append(stripped, content[i:end]...)
+		}
+		i = end
+	}
+	return nil, false
+}
+
+// Remove the iCCP and eXIf chunks of a PNG image, which hold the ICC color
+// profile and the Exif metadata
+func stripPNGMetadata(content []byte) ([]byte, bool) {
+	stripped := make([]byte, 0, len(content))
+	stripped = append(stripped, pngSignature...)
+	i := len(pngSignature)
+	for i+8 <= len(content) {
+		// Each chunk has a length, a type, the data and a checksum
+		length := int(binary.BigEndian.Uint32(content[i : i+4]))
+		chunkType := string(content[i+4 : i+8])
+		end := i + 12 + length
+		if length < 0 || end > len(content) {
+			return nil, false
+		}
+		if !pngMetadataChunks[chunkType] {
+			stripped = append(stripped, content[i:end]...)
+		}
+		i = end
+		if chunkType == "IEND" {
+			return stripped, true
+		}
+	}
+	return nil, false
+}
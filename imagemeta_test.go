@@ -0,0 +1,124 @@
+package epub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"path/filepath"
+	"testing"
+
+	"github.com/bmaupin/go-epub/internal/storage"
+	"github.com/vincent-petithory/dataurl"
+)
+
+func newTestImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for x := 0; x < 4; x++ {
+		img.Set(x, x, color.RGBA{R: 255, A: 255})
+	}
+	return img
+}
+
+// Encode a JPEG image, without and with Exif metadata and a color profile
+func newTestJPEG(t *testing.T) (clean []byte, withMetadata []byte) {
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, newTestImage(), nil); err != nil {
+		t.Fatalf("Error encoding JPEG: %s", err)
+	}
+	clean = b.Bytes()
+
+	segment := func(marker byte, data string) []byte {
+		s := []byte{0xff, marker, 0, 0}
+		binary.BigEndian.PutUint16(s[2:], uint16(len(data)+2))
+		return append(s, data...)
+	}
+	withMetadata = append([]byte{}, clean[:2]...)
+	withMetadata = append(withMetadata, segment(jpegMarkerAPP1, "Exif\x00\x00MM\x00\x2a")...)
+	withMetadata = append(withMetadata, segment(jpegMarkerAPP2, "ICC_PROFILE\x00\x01\x01profile")...)
+	withMetadata = append(withMetadata, clean[2:]...)
+	return clean, withMetadata
+}
+
+// Encode a PNG image, without and with a color profile
+func newTestPNG(t *testing.T) (clean []byte, withMetadata []byte) {
+	var b bytes.Buffer
+	if err := png.Encode(&b, newTestImage()); err != nil {
+		t.Fatalf("Error encoding PNG: %s", err)
+	}
+	clean = b.Bytes()
+
+	data := "sRGB\x00\x00profile"
+	chunk := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	copy(chunk[4:], "iCCP")
+	chunk = append(chunk, data...)
+	checksum := make([]byte, 4)
+	binary.BigEndian.PutUint32(checksum, crc32.ChecksumIEEE(chunk[4:]))
+	chunk = append(chunk, checksum...)
+	// The color profile must come after the IHDR chunk, which is the first one
+	ihdrEnd := len(pngSignature) + 12 + 13
+	withMetadata = append([]byte{}, clean[:ihdrEnd]...)
+	withMetadata = append(withMetadata, chunk...)
+	withMetadata = append(withMetadata, clean[ihdrEnd:]...)
+	return clean, withMetadata
+}
+
+func Test_stripImageMetadata(t *testing.T) {
+	cleanJPEG, jpegWithMetadata := newTestJPEG(t)
+	cleanPNG, pngWithMetadata := newTestPNG(t)
+	tests := []struct {
+		name    string
+		content []byte
+		want    []byte
+		wantOk  bool
+	}{
+		{"jpeg", jpegWithMetadata, cleanJPEG, true},
+		{"png", pngWithMetadata, cleanPNG, true},
+		{"jpeg without metadata", cleanJPEG, nil, false},
+		{"png without metadata", cleanPNG, nil, false},
+		{"truncated jpeg", jpegWithMetadata[:30], nil, false},
+		{"truncated png", pngWithMetadata[:40], nil, false},
+		{"not an image", []byte("GIF89a"), nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := stripImageMetadata(tt.content)
+			if ok != tt.wantOk {
+				t.Fatalf("stripImageMetadata() ok = %v, want %v", ok, tt.wantOk)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("stripImageMetadata() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetStripColorProfiles(t *testing.T) {
+	cleanJPEG, jpegWithMetadata := newTestJPEG(t)
+	for _, strip := range []bool{true, false} {
+		e := NewEpub(testEpubTitle)
+		e.SetStripColorProfiles(strip)
+		testImagePath, _ := e.AddImage(dataurl.New(jpegWithMetadata, mediaTypeJpeg).String(), "image.jpg")
+		e.AddSection(`<img src="`+testImagePath+`" alt="Test"/>`, testSectionTitle, testSectionFilename, "")
+
+		tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+		contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, ImageFolderName, "image.jpg"))
+		if err != nil {
+			t.Errorf("Unexpected error reading image file: %s", err)
+		}
+		expected := jpegWithMetadata
+		if strip {
+			expected = cleanJPEG
+		}
+		if !bytes.Equal(contents, expected) {
+			t.Errorf("Image file doesn't match when stripping is %v\nGot: %q\nExpected: %q", strip, contents, expected)
+		}
+
+		cleanup(testEpubFilename, tempDir)
+	}
+}
@@ -0,0 +1,99 @@
+package epub
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Name of the integrity manifest in the META-INF folder, which uses the format
+// of sha256sum so it can be checked with sha256sum -c in the extracted EPUB
+const integrityFilename = "integrity.sha256"
+
+// GenerateIntegrityManifest makes Write add a manifest with the SHA-256 digest
+// of every file in the EPUB to META-INF/integrity.sha256, so the contents of the
+// EPUB can be verified later on, e.g. for archival. Each line of the manifest
+// has the digest in hexadecimal followed by two spaces and the path of the file
+// in the EPUB, sorted by path, like the output of sha256sum.
+//
+// The digests are computed each time the EPUB is written, since some files
+// such as the package file change every time. If the EPUB refers to files which
+// don't exist (anymore), e.g. a cover page which has been removed, it can't be
+// written and InconsistentEpubError will be returned without enabling the
+// manifest.
+//
+// This is a plain checksum manifest, not a signature; anyone modifying the
+// EPUB could update it as well.
+func (e *Epub) GenerateIntegrityManifest() error {
+	e.Lock()
+	defer e.Unlock()
+	if err := e.checkReferences(); err != nil {
+		return err
+	}
+	e.integrityManifest = true
+	return nil
+}
+
+// SetIntegrityManifest sets whether the integrity manifest is written, see
+// GenerateIntegrityManifest, which is the same as SetIntegrityManifest(true)
+// except for checking the EPUB first. This is disabled by default.
+func (e *Epub) SetIntegrityManifest(generate bool) {
+	e.Lock()
+	defer e.Unlock()
+	e.integrityManifest = generate
+}
+
+// Write the integrity manifest listing the digests of all the files in the
+// temporary directory
+func (e *Epub) writeIntegrityManifest(rootEpubDir string) error {
+	fsys := e.stagingStorage()
+	manifestPath := filepath.Join(rootEpubDir, metaInfFolderName, integrityFilename)
+	digests := map[string]string{}
+	err := fs.WalkDir(fsys, rootEpubDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || path == manifestPath {
+			return nil
+		}
+		relativePath, err := filepath.Rel(rootEpubDir, path)
+		if err != nil {
+			return err
+		}
+
+		f, err := fsys.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return err
+		}
+		digests[filepath.ToSlash(relativePath)] = hex.EncodeToString(h.Sum(nil))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to compute digests for integrity manifest: %w", err)
+	}
+
+	paths := make([]string, 0, len(digests))
+	for p := range digests {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	var b strings.Builder
+	for _, p := range paths {
+		fmt.Fprintf(&b, "%s  %s\n", digests[p], p)
+	}
+
+	if err := fsys.WriteFile(manifestPath, []byte(b.String()), filePermissions); err != nil {
+		return fmt.Errorf("unable to write integrity manifest: %w", err)
+	}
+	return nil
+}
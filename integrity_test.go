@@ -0,0 +1,89 @@
+package epub
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bmaupin/go-epub/internal/storage"
+)
+
+func TestSetIntegrityManifest(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	testImagePath, _ := e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+	e.SetCover(testImagePath, "")
+	e.AddSection(testSectionBody, testSectionTitle, testSectionFilename, "")
+	e.SetIntegrityManifest(true)
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	manifest, err := storage.ReadFile(filesystem, filepath.Join(tempDir, metaInfFolderName, integrityFilename))
+	if err != nil {
+		t.Fatalf("Unexpected error reading integrity manifest: %s", err)
+	}
+	paths := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSuffix(string(manifest), "\n"), "\n") {
+		parts := strings.SplitN(line, "  ", 2)
+		if len(parts) != 2 {
+			t.Fatalf("Unexpected line in integrity manifest: %q", line)
+		}
+		paths[parts[1]] = true
+		contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, filepath.FromSlash(parts[1])))
+		if err != nil {
+			t.Errorf("Unexpected error reading file %s: %s", parts[1], err)
+			continue
+		}
+		digest := sha256.Sum256(contents)
+		if hex.EncodeToString(digest[:]) != parts[0] {
+			t.Errorf("Digest of %s doesn't match\nGot: %s\nExpected: %x", parts[1], parts[0], digest)
+		}
+	}
+	for _, p := range []string{
+		mimetypeFilename,
+		metaInfFolderName + "/" + containerFilename,
+		contentFolderName + "/" + pkgFilename,
+		contentFolderName + "/" + tocNavFilename,
+		contentFolderName + "/" + xhtmlFolderName + "/" + testSectionFilename,
+		contentFolderName + "/" + ImageFolderName + "/" + testImageFromFileFilename,
+	} {
+		if !paths[p] {
+			t.Errorf("Integrity manifest doesn't list %s\nGot: %s", p, manifest)
+		}
+	}
+	if paths[metaInfFolderName+"/"+integrityFilename] {
+		t.Errorf("Integrity manifest shouldn't list itself")
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestGenerateIntegrityManifest(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	testSectionPath, _ := e.AddSection(testSectionBody, testSectionTitle, "", "")
+	e.SetBodyStart(testSectionPath)
+	sections := e.sections
+	e.sections = nil
+	err := e.GenerateIntegrityManifest()
+	if _, ok := err.(*InconsistentEpubError); !ok {
+		t.Errorf("Expected error InconsistentEpubError not returned. Returned instead: %+v", err)
+	}
+
+	e.sections = sections
+	if err := e.GenerateIntegrityManifest(); err != nil {
+		t.Errorf("Error generating integrity manifest: %s", err)
+	}
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	if _, err := storage.ReadFile(filesystem, filepath.Join(tempDir, metaInfFolderName, integrityFilename)); err != nil {
+		t.Errorf("Unexpected error reading integrity manifest: %s", err)
+	}
+	cleanup(testEpubFilename, tempDir)
+
+	e.SetIntegrityManifest(false)
+	tempDir = writeAndExtractEpub(t, e, testEpubFilename)
+	if _, err := storage.ReadFile(filesystem, filepath.Join(tempDir, metaInfFolderName, integrityFilename)); err == nil {
+		t.Error("Integrity manifest shouldn't be written once disabled")
+	}
+	cleanup(testEpubFilename, tempDir)
+}
@@ -4,12 +4,15 @@ import (
 	"io/fs"
 	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/bmaupin/go-epub/internal/storage"
 )
 
 type Memory struct {
+	// Guards fs, since media may be written concurrently
+	mu sync.RWMutex
 	fs map[string]*file
 }
 
@@ -35,6 +38,8 @@ func NewMemory() *Memory {
 // ValidPath(name), returning a *PathError with Err set to
 // ErrInvalid or ErrNotExist.
 func (m *Memory) Open(name string) (fs.File, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	var f fs.File
 	var ok bool
 	if f, ok = m.fs[name]; !ok {
@@ -45,6 +50,8 @@ func (m *Memory) Open(name string) (fs.File, error) {
 
 // WriteFile writes data to the named file, creating it if necessary. If the file does not exist, WriteFile creates it with permissions perm (before umask); otherwise WriteFile truncates it before writing, without changing permissions.
 func (m *Memory) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if !fs.ValidPath(name) {
 		return fs.ErrInvalid
 	}
@@ -60,6 +67,8 @@ func (m *Memory) WriteFile(name string, data []byte, perm fs.FileMode) error {
 
 // Mkdir creates a new directory with the specified name and permission bits (before umask). If there is an error, it will be of type *PathError.
 func (m *Memory) Mkdir(name string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if !fs.ValidPath(path.Base(name)) {
 		return fs.ErrInvalid
 	}
@@ -74,6 +83,8 @@ func (m *Memory) Mkdir(name string, perm fs.FileMode) error {
 
 // RemoveAll removes path and any children it contains. It removes everything it can but returns the first error it encounters. If the path does not exist, RemoveAll returns nil (no error). If there is an error, it will be of type *PathError.
 func (m *Memory) RemoveAll(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	for k := range m.fs {
 		if strings.HasPrefix(k, name) {
 			delete(m.fs, k)
@@ -84,6 +95,8 @@ func (m *Memory) RemoveAll(name string) error {
 
 // Create creates or truncates the named file. If the file already exists, it is truncated. If the file does not exist, it is created with mode 0666 (before umask). If successful, methods on the returned File can be used for I/O; the associated file descriptor has mode O_RDWR. If there is an error, it will be of type *PathError.
 func (m *Memory) Create(name string) (storage.File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if !fs.ValidPath(path.Base(name)) {
 		return nil, fs.ErrInvalid
 	}
@@ -99,6 +112,8 @@ func (m *Memory) Create(name string) (storage.File, error) {
 // ReadDir reads the named directory
 // and returns a list of directory entries sorted by filename.
 func (m *Memory) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	output := make([]fs.DirEntry, 0)
 	for k, v := range m.fs {
 		if path.Dir(k) == name {
@@ -112,6 +127,8 @@ func (m *Memory) ReadDir(name string) ([]fs.DirEntry, error) {
 // If there is an error, it should be of type *PathError.
 // This makes Memory compatible with the StatFS interface
 func (m *Memory) Stat(name string) (fs.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	f, ok := m.fs[name]
 	if !ok {
 		return nil, &fs.PathError{
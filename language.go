@@ -0,0 +1,151 @@
+package epub
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Minimum number of common words of a language the text needs to contain to
+// be detected as that language
+const languageMinMatches = 3
+
+// LanguageDetectionError is thrown by DetectLanguage if the language of the
+// content can't be detected with confidence.
+type LanguageDetectionError struct {
+	Candidates []string // Languages the content might be in, most likely first
+}
+
+func (e *LanguageDetectionError) Error() string {
+	if len(e.Candidates) == 0 {
+		return "Unable to detect the language of the content"
+	}
+	return fmt.Sprintf("Unable to detect the language of the content, candidates: %s", strings.Join(e.Candidates, ", "))
+}
+
+// Languages which are detected by their script, for the most common language
+// written in the script
+var languageScripts = []struct {
+	lang   string
+	script *unicode.RangeTable
+}{
+	{"ar", unicode.Arabic},
+	{"el", unicode.Greek},
+	{"he", unicode.Hebrew},
+	{"hi", unicode.Devanagari},
+	{"ja", unicode.Hiragana},
+	{"ja", unicode.Katakana},
+	{"ko", unicode.Hangul},
+	{"ru", unicode.Cyrillic},
+	{"th", unicode.Thai},
+	{"zh", unicode.Han},
+}
+
+// Common words of languages written in the Latin script, which are detected by
+// how often these words occur
+var languageWords = map[string][]string{
+	"de": {"der", "die", "das", "und", "ist", "nicht", "ein", "eine", "ich", "zu", "den", "mit", "sich", "auf", "dem", "auch", "es", "sie"},
+	"en": {"the", "and", "of", "to", "is", "that", "it", "was", "with", "for", "this", "you", "he", "she", "not", "are", "have", "be"},
+	"es": {"el", "los", "las", "y", "es", "una", "por", "con", "del", "para", "pero", "lo", "su", "al", "está", "muy", "como", "fue"},
+	"fr": {"le", "les", "et", "est", "des", "une", "du", "dans", "pas", "pour", "qui", "elle", "sur", "avec", "ce", "au", "je", "vous"},
+	"it": {"il", "gli", "è", "che", "di", "per", "non", "della", "sono", "ma", "anche", "questo", "nel", "alla", "ha", "le", "si", "come"},
+	"nl": {"het", "een", "en", "van", "dat", "niet", "ik", "te", "op", "zijn", "met", "voor", "er", "maar", "ook", "wat", "hij", "is"},
+	"pt": {"o", "os", "não", "com", "do", "da", "uma", "para", "em", "mas", "são", "ao", "é", "um", "dos", "muito", "ele", "foi"},
+}
+
+// DetectLanguage guesses the primary language of the sections from their text
+// and returns it as a BCP 47 language tag (e.g. "en"), which can be passed to
+// Pkg.SetLang. This is useful when the content comes from mixed sources and the
+// declared language might be wrong.
+//
+// Text in a non-Latin script is detected as the most common language written in
+// that script, e.g. "ru" for Cyrillic. Text in the Latin script is detected by
+// the frequency of common words and is limited to Dutch, English, French,
+// German, Italian, Portuguese, and Spanish.
+//
+// If the language can't be detected with confidence, e.g. because there's too
+// little text or it's split between two languages, LanguageDetectionError will
+// be returned with the most likely candidates. An error is also returned if
+// the body of a section isn't valid XHTML.
+func (e *Epub) DetectLanguage() (string, error) {
+	e.Lock()
+	defer e.Unlock()
+	text, err := e.preview(false)
+	if err != nil {
+		return "", err
+	}
+	return detectLanguage(text)
+}
+
+func detectLanguage(text string) (string, error) {
+	// Count the letters of each script
+	latin := 0
+	scripts := map[string]int{}
+	for _, r := range text {
+		if unicode.Is(unicode.Latin, r) {
+			latin++
+			continue
+		}
+		for _, s := range languageScripts {
+			if unicode.Is(s.script, r) {
+				scripts[s.lang]++
+				break
+			}
+		}
+	}
+	// Japanese mixes kana with Han characters
+	if scripts["ja"] > 0 {
+		scripts["ja"] += scripts["zh"]
+		delete(scripts, "zh")
+	}
+
+	candidates := rankLanguages(scripts)
+	if len(candidates) > 0 && scripts[candidates[0]] > latin {
+		if len(candidates) > 1 && scripts[candidates[1]]*2 > scripts[candidates[0]] {
+			return "", &LanguageDetectionError{Candidates: candidates[:2]}
+		}
+		return candidates[0], nil
+	}
+
+	// Count the common words of each language
+	words := map[string]int{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	}) {
+		words[word]++
+	}
+	matches := map[string]int{}
+	for lang, commonWords := range languageWords {
+		for _, word := range commonWords {
+			matches[lang] += words[word]
+		}
+	}
+
+	candidates = rankLanguages(matches)
+	if len(candidates) == 0 || matches[candidates[0]] < languageMinMatches {
+		return "", &LanguageDetectionError{}
+	}
+	// The most likely language must be clearly ahead of the next one
+	if len(candidates) > 1 && matches[candidates[1]]*3 > matches[candidates[0]]*2 {
+		return "", &LanguageDetectionError{Candidates: candidates[:2]}
+	}
+	return candidates[0], nil
+}
+
+// Sort the languages with a count by count, highest first
+func rankLanguages(counts map[string]int) []string {
+	langs := []string{}
+	for lang, count := range counts {
+		if count > 0 {
+			langs = append(langs, lang)
+		}
+	}
+	sort.Slice(langs, func(i, j int) bool {
+		if counts[langs[i]] != counts[langs[j]] {
+			return counts[langs[i]] > counts[langs[j]]
+		}
+		return langs[i] < langs[j]
+	})
+	return langs
+}
@@ -0,0 +1,61 @@
+package epub
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		lang string
+	}{
+		{"English", `<p>It was the best of times, it was the worst of times. She said that this is not the end of the story and that you have to wait for it.</p>`, "en"},
+		{"German", `<p>Es ist nicht leicht, die Sprache zu lernen, aber sie macht auch Spaß. Ich lese jeden Tag mit dem Buch und der Zeitung.</p>`, "de"},
+		{"French", `<p>Elle est partie dans la nuit et les étoiles brillaient pour elle. Je ne sais pas ce qui est arrivé avec vous au village.</p>`, "fr"},
+		{"Russian", `<p>Все счастливые семьи похожи друг на друга, каждая несчастливая семья несчастлива по-своему.</p>`, "ru"},
+		{"Japanese", `<p>吾輩は猫である。名前はまだ無い。</p>`, "ja"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := NewEpub(testEpubTitle)
+			e.AddSection(test.body, "", "", "")
+			lang, err := e.DetectLanguage()
+			if err != nil {
+				t.Fatalf("Error detecting language: %s", err)
+			}
+			if lang != test.lang {
+				t.Errorf(
+					"Detected language doesn't match\n"+
+						"Got: %s\n"+
+						"Expected: %s",
+					lang,
+					test.lang)
+			}
+		})
+	}
+}
+
+func TestDetectLanguageAmbiguous(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	_, err := e.DetectLanguage()
+	if _, ok := err.(*LanguageDetectionError); !ok {
+		t.Errorf("Expected error LanguageDetectionError not returned. Returned instead: %+v", err)
+	}
+
+	e.AddSection(`<p>The cat is with the dog and the bird.</p>`, "", "", "")
+	e.AddSection(`<p>Die Katze ist mit dem Hund und der Vogel.</p>`, "", "", "")
+	_, err = e.DetectLanguage()
+	if err, ok := err.(*LanguageDetectionError); !ok {
+		t.Errorf("Expected error LanguageDetectionError not returned. Returned instead: %+v", err)
+	} else if sort.Strings(err.Candidates); !reflect.DeepEqual(err.Candidates, []string{"de", "en"}) {
+		t.Errorf(
+			"Language candidates don't match\n"+
+				"Got: %v\n"+
+				"Expected: %v",
+			err.Candidates,
+			[]string{"de", "en"})
+	}
+}
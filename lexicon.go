@@ -0,0 +1,83 @@
+package epub
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	// LexiconFolderName is the folder name used for pronunciation lexicons
+	// inside the EPUB
+	LexiconFolderName = "lexicons"
+
+	lexiconExtension  = ".pls"
+	lexiconFileFormat = "lexicon%04d%s"
+	lexiconLinkRel    = "pronunciation"
+	mediaTypePLS      = "application/pls+xml"
+)
+
+// AddPronunciationLexicon adds a pronunciation lexicon in the PLS format
+// (https://www.w3.org/TR/pronunciation-lexicon/) to the EPUB, which
+// text-to-speech engines of reading systems use to pronounce words correctly,
+// e.g. names or words borrowed from other languages. The lexicon is linked from
+// every section.
+//
+// The source should either be a URL, a path to a local file, or an embedded
+// data URL. The language is the language of the words in the lexicon as a BCP
+// 47 language tag (e.g. "en"); it is optional but should be set if the EPUB
+// contains more than one lexicon.
+func (e *Epub) AddPronunciationLexicon(source string, lang string) error {
+	e.Lock()
+	defer e.Unlock()
+	if err := e.checkManifestItems(1); err != nil {
+		return err
+	}
+	// Sources such as data URLs don't have a usable filename
+	internalFilename := fmt.Sprintf(lexiconFileFormat, len(e.lexicons)+1, lexiconExtension)
+	lexiconPath, err := e.addMedia(source, internalFilename, lexiconFileFormat, LexiconFolderName, e.lexicons)
+	if err != nil {
+		return err
+	}
+	e.lexiconLangs[path.Base(lexiconPath)] = lang
+
+	return nil
+}
+
+// Get the lexicons from their source and save them in the temporary directory
+func (e *Epub) writeLexicons(rootEpubDir string) error {
+	err := e.writeMedia(rootEpubDir, e.lexicons, LexiconFolderName)
+	if err != nil {
+		return err
+	}
+
+	// The media type of the lexicons can't be detected from their content since
+	// they're plain XML
+	for i, item := range e.Pkg.xml.ManifestItems {
+		if path.Dir(item.Href) == LexiconFolderName {
+			e.Pkg.xml.ManifestItems[i].MediaType = mediaTypePLS
+		}
+	}
+	return nil
+}
+
+// Get the links to the lexicons for the head of the sections
+func (e *Epub) lexiconLinks() []xhtmlLink {
+	lexiconFilenames := make([]string, 0, len(e.lexicons))
+	for lexiconFilename := range e.lexicons {
+		lexiconFilenames = append(lexiconFilenames, lexiconFilename)
+	}
+	sort.Strings(lexiconFilenames)
+
+	links := []xhtmlLink{}
+	for _, lexiconFilename := range lexiconFilenames {
+		links = append(links, xhtmlLink{
+			Rel:      lexiconLinkRel,
+			Type:     mediaTypePLS,
+			Href:     filepath.ToSlash(filepath.Join("..", LexiconFolderName, lexiconFilename)),
+			Hreflang: e.lexiconLangs[lexiconFilename],
+		})
+	}
+	return links
+}
@@ -0,0 +1,64 @@
+package epub
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bmaupin/go-epub/internal/storage"
+	"github.com/vincent-petithory/dataurl"
+)
+
+const testLexiconContent = `<?xml version="1.0" encoding="UTF-8"?>
+<lexicon version="1.0" xmlns="http://www.w3.org/2005/01/pronunciation-lexicon" alphabet="ipa" xml:lang="en">
+  <lexeme>
+    <grapheme>go-epub</grapheme>
+    <alias>go e-pub</alias>
+  </lexeme>
+</lexicon>
+`
+
+func TestAddPronunciationLexicon(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	err := e.AddPronunciationLexicon("/sbin/thisShouldFail", "en")
+	if _, ok := err.(*FileRetrievalError); !ok {
+		t.Errorf("Expected error FileRetrievalError not returned. Returned instead: %+v", err)
+	}
+	err = e.AddPronunciationLexicon(dataurl.New([]byte(testLexiconContent), mediaTypePLS).String(), "en")
+	if err != nil {
+		t.Errorf("Error adding pronunciation lexicon: %s", err)
+	}
+	testSectionPath, _ := e.AddSection(testSectionBody, testSectionTitle, "", "")
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	testManifestItem := `<item id="lexicon0001.pls" href="lexicons/lexicon0001.pls" media-type="application/pls+xml"></item>`
+	if !strings.Contains(string(pkgFileContent), testManifestItem) {
+		t.Errorf(
+			"Package file doesn't contain lexicon\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			pkgFileContent,
+			testManifestItem)
+	}
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, testSectionPath))
+	if err != nil {
+		t.Errorf("Unexpected error reading section file: %s", err)
+	}
+	testLink := `<link rel="pronunciation" type="application/pls+xml" href="../lexicons/lexicon0001.pls" hreflang="en"></link>`
+	if !strings.Contains(string(contents), testLink) {
+		t.Errorf(
+			"Section file doesn't link to lexicon\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			contents,
+			testLink)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
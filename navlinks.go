@@ -0,0 +1,54 @@
+package epub
+
+import (
+	"fmt"
+	"html"
+	"path"
+	"strings"
+)
+
+const (
+	chapterLinksTemplate = `<nav class="chapter-links">%s</nav>`
+	chapterLinksPrevious = "Previous"
+	chapterLinksContents = "Contents"
+	chapterLinksNext     = "Next"
+)
+
+// Get the navigation links added to the end of each section by
+// SetAutoChapterLinks, keyed by the filename of the section. The previous and
+// next links point to the adjacent sections in the reading order, leaving out
+// the covers and the sections which aren't part of the linear reading order,
+// which don't get links either.
+func (e *Epub) chapterLinks() map[string]string {
+	nonLinear := map[string]bool{}
+	for _, section := range e.sections {
+		nonLinear[section.filename] = section.nonLinear
+	}
+	filenames := []string{}
+	for _, filename := range e.spine() {
+		if filename != e.cover.xhtmlFilename && filename != e.backCover.xhtmlFilename && !nonLinear[filename] {
+			filenames = append(filenames, filename)
+		}
+	}
+
+	// The TOC file is in the parent folder of the sections
+	contentsHref := path.Join("..", tocNavFilename)
+	chapterLinks := map[string]string{}
+	for i, filename := range filenames {
+		links := []string{}
+		if i > 0 {
+			links = append(links, chapterLink(filenames[i-1], "prev", chapterLinksPrevious))
+		}
+		links = append(links, chapterLink(contentsHref, "contents", chapterLinksContents))
+		if i < len(filenames)-1 {
+			links = append(links, chapterLink(filenames[i+1], "next", chapterLinksNext))
+		}
+		chapterLinks[filename] = fmt.Sprintf(chapterLinksTemplate, strings.Join(links, " | "))
+	}
+	return chapterLinks
+}
+
+// Get a link of the chapter navigation
+func chapterLink(href string, rel string, text string) string {
+	return fmt.Sprintf(`<a href="%s" rel="%s">%s</a>`, html.EscapeString(href), rel, text)
+}
@@ -0,0 +1,57 @@
+package epub
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bmaupin/go-epub/internal/storage"
+)
+
+func TestSetAutoChapterLinks(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	testImagePath, _ := e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+	e.SetCover(testImagePath, "")
+	e.AddSection(testSectionBody, "Chapter 2", "chapter2.xhtml", "")
+	e.AddSection(testSectionBody, "Chapter 1", "chapter1.xhtml", "")
+	e.AddSection(testSectionBody, "Notes", "notes.xhtml", "")
+	e.SetSectionLinear("notes.xhtml", false)
+	// The links follow the final reading order
+	e.SetSectionOrder([]string{"chapter1.xhtml", "chapter2.xhtml", "notes.xhtml"})
+	e.SetAutoChapterLinks(true)
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	for filename, testLinks := range map[string]string{
+		"chapter1.xhtml": `<nav class="chapter-links"><a href="../nav.xhtml" rel="contents">Contents</a> | <a href="chapter2.xhtml" rel="next">Next</a></nav>`,
+		"chapter2.xhtml": `<nav class="chapter-links"><a href="chapter1.xhtml" rel="prev">Previous</a> | <a href="../nav.xhtml" rel="contents">Contents</a></nav>`,
+	} {
+		contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, filename))
+		if err != nil {
+			t.Errorf("Unexpected error reading section file: %s", err)
+		}
+		if !strings.Contains(string(contents), testLinks) {
+			t.Errorf(
+				"Chapter links of %s don't match\n"+
+					"Got: %s\n"+
+					"Expected: %s",
+				filename,
+				contents,
+				testLinks)
+		}
+	}
+	for _, filename := range []string{defaultCoverXhtmlFilename, "notes.xhtml"} {
+		contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, filename))
+		if err != nil {
+			t.Errorf("Unexpected error reading section file: %s", err)
+		}
+		if strings.Contains(string(contents), "chapter-links") {
+			t.Errorf("%s shouldn't have chapter links\nGot: %s", filename, contents)
+		}
+	}
+	if strings.Contains(e.sections[0].xhtml.xml.Body.XML, "chapter-links") {
+		t.Errorf("Chapter links should only be added to the written file")
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
@@ -0,0 +1,119 @@
+package epub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image/png"
+	"sort"
+	"strings"
+
+	"github.com/vincent-petithory/dataurl"
+)
+
+// PNG chunks which affect how the image is displayed but aren't written when
+// re-encoding it, so images with them are left unchanged
+var pngColorChunks = map[string]bool{
+	"cHRM": true,
+	"gAMA": true,
+	"iCCP": true,
+	"sBIT": true,
+	"sRGB": true,
+}
+
+// UnoptimizedImagesError is returned by OptimizeImages along with the bytes
+// saved if some images couldn't be optimized without loss, e.g. JPEG images, so
+// callers can tell which images weren't made smaller.
+type UnoptimizedImagesError struct {
+	Filenames []string // Filenames of the images which were left unchanged
+}
+
+func (e *UnoptimizedImagesError) Error() string {
+	return fmt.Sprintf("Images can't be optimized without loss: %s", strings.Join(e.Filenames, ", "))
+}
+
+// OptimizeImages recompresses the PNG images which have been added to the EPUB
+// with the best compression, without changing a single pixel, and returns the
+// total number of bytes saved. Images are only replaced if they get smaller.
+// Textual metadata of the images (e.g. tEXt chunks) is dropped.
+//
+// Other formats such as JPEG are skipped, since they can't be re-encoded
+// without loss, as are PNG images with color space information (e.g. a gamma
+// or an ICC color profile), which would be lost. Images which can't be decoded
+// are skipped as well. The skipped images are listed by UnoptimizedImagesError,
+// which is returned after all other images have been optimized. If an image
+// can't be retrieved from its source, FileRetrievalError is returned along with
+// the bytes saved so far.
+func (e *Epub) OptimizeImages() (int64, error) {
+	e.Lock()
+	defer e.Unlock()
+	filenames := make([]string, 0, len(e.images))
+	for filename := range e.images {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+
+	var saved int64
+	skipped := []string{}
+	for _, filename := range filenames {
+		g := e.newGrabber()
+		content, err := g.readMedia(e.images[filename])
+		if err != nil {
+			return saved, err
+		}
+		optimized, ok := optimizePNG(content)
+		if !ok {
+			skipped = append(skipped, filename)
+			continue
+		}
+		if optimized == nil {
+			continue
+		}
+
+		source := dataurl.New(optimized, mediaTypePNG).String()
+		// Decode large data URLs right away like addMedia does
+		if g.stagingThreshold > 0 && len(source) > g.stagingThreshold {
+			if source, err = g.stageDataURL(source); err != nil {
+				return saved, &FileRetrievalError{Source: e.images[filename], Err: err}
+			}
+		}
+		e.images[filename] = source
+		saved += int64(len(content) - len(optimized))
+	}
+
+	if len(skipped) > 0 {
+		return saved, &UnoptimizedImagesError{Filenames: skipped}
+	}
+	return saved, nil
+}
+
+// Re-encode a PNG image with the best compression. The returned image is nil if
+// it doesn't get smaller. The returned bool is false if the image can't be
+// optimized at all since it isn't a PNG image, can't be decoded, or has color
+// space information which would be lost.
+func optimizePNG(content []byte) ([]byte, bool) {
+	if !bytes.HasPrefix(content, pngSignature) {
+		return nil, false
+	}
+	for i := len(pngSignature); i+8 <= len(content); {
+		length := int(binary.BigEndian.Uint32(content[i : i+4]))
+		if pngColorChunks[string(content[i+4:i+8])] {
+			return nil, false
+		}
+		i += 12 + length
+	}
+
+	img, err := png.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, false
+	}
+	var b bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := encoder.Encode(&b, img); err != nil {
+		return nil, false
+	}
+	if b.Len() >= len(content) {
+		return nil, true
+	}
+	return b.Bytes(), true
+}
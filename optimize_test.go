@@ -0,0 +1,96 @@
+package epub
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bmaupin/go-epub/internal/storage"
+	"github.com/vincent-petithory/dataurl"
+)
+
+func TestOptimizeImages(t *testing.T) {
+	var b bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.NoCompression}
+	if err := encoder.Encode(&b, newTestImage()); err != nil {
+		t.Fatalf("Error encoding PNG: %s", err)
+	}
+	uncompressedPNG := b.Bytes()
+	_, pngWithColorProfile := newTestPNG(t)
+	_, testJPEG := newTestJPEG(t)
+
+	e := NewEpub(testEpubTitle)
+	e.AddImage(dataurl.New(uncompressedPNG, mediaTypePNG).String(), "uncompressed.png")
+	e.AddImage(dataurl.New(pngWithColorProfile, mediaTypePNG).String(), "profile.png")
+	e.AddImage(dataurl.New(testJPEG, mediaTypeJpeg).String(), "image.jpg")
+
+	saved, err := e.OptimizeImages()
+	unoptimized, ok := err.(*UnoptimizedImagesError)
+	if !ok {
+		t.Fatalf("Expected error UnoptimizedImagesError not returned. Returned instead: %+v", err)
+	}
+	if !reflect.DeepEqual(unoptimized.Filenames, []string{"image.jpg", "profile.png"}) {
+		t.Errorf("Unoptimized images don't match\nGot: %v\nExpected: %v", unoptimized.Filenames, []string{"image.jpg", "profile.png"})
+	}
+	if saved <= 0 {
+		t.Errorf("Expected bytes to be saved, got %d", saved)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, ImageFolderName, "uncompressed.png"))
+	if err != nil {
+		t.Errorf("Unexpected error reading image file: %s", err)
+	}
+	if int64(len(uncompressedPNG)-len(contents)) != saved {
+		t.Errorf("Saved bytes don't match\nGot: %d\nExpected: %d", saved, len(uncompressedPNG)-len(contents))
+	}
+	optimized, err := png.Decode(bytes.NewReader(contents))
+	if err != nil {
+		t.Fatalf("Unexpected error decoding optimized image: %s", err)
+	}
+	original := newTestImage()
+	bounds := original.Bounds()
+	if optimized.Bounds() != bounds {
+		t.Fatalf("Optimized image bounds don't match\nGot: %v\nExpected: %v", optimized.Bounds(), bounds)
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if !samePixel(optimized, original, x, y) {
+				t.Fatalf("Optimized image differs at %d,%d", x, y)
+			}
+		}
+	}
+
+	// Images which can't be optimized without loss are unchanged
+	for filename, expected := range map[string][]byte{
+		"profile.png": pngWithColorProfile,
+		"image.jpg":   testJPEG,
+	} {
+		contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, ImageFolderName, filename))
+		if err != nil {
+			t.Errorf("Unexpected error reading image file: %s", err)
+		}
+		if !bytes.Equal(contents, expected) {
+			t.Errorf("Image %s shouldn't have been changed", filename)
+		}
+	}
+
+	cleanup(testEpubFilename, tempDir)
+
+	// No error is returned if every image could be optimized
+	e = NewEpub(testEpubTitle)
+	e.AddImage(dataurl.New(uncompressedPNG, mediaTypePNG).String(), "uncompressed.png")
+	if _, err := e.OptimizeImages(); err != nil {
+		t.Errorf("Error optimizing images: %s", err)
+	}
+}
+
+func samePixel(a image.Image, b image.Image, x int, y int) bool {
+	r1, g1, b1, a1 := a.At(x, y).RGBA()
+	r2, g2, b2, a2 := b.At(x, y).RGBA()
+	return r1 == r2 && g1 == g2 && b1 == b2 && a1 == a2
+}
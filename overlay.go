@@ -0,0 +1,244 @@
+package epub
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// OverlayFolderName is the folder name used for media overlays inside the
+	// EPUB
+	OverlayFolderName = "overlays"
+
+	mediaTypeSMIL      = "application/smil+xml"
+	overlayExtension   = ".smil"
+	overlayFileFormat  = "overlay%04d%s"
+	overlayClockFormat = "%d:%02d:%02d.%03d"
+)
+
+// MediaOverlayError is thrown by AddMediaOverlay if the SMIL file can't be
+// parsed, contains an invalid clock value, or contains a clip without an end.
+type MediaOverlayError struct {
+	Source string // The source of the media overlay
+	Err    error  // The underlying error that was thrown
+}
+
+func (e *MediaOverlayError) Error() string {
+	return fmt.Sprintf("Error parsing media overlay %q: %+v", e.Source, e.Err)
+}
+
+// AddMediaOverlay adds a media overlay (a SMIL file which synchronizes the text
+// of a section with an audio recording of it, see
+// https://www.w3.org/publishing/epub3/epub-mediaoverlays.html) for a section
+// which has already been added to the EPUB, replacing any media overlay added
+// for the section before. The audio files referenced by the SMIL file must be
+// added to the EPUB separately, e.g. with AddAudio.
+//
+// The duration of the media overlay is computed from the clips of its <audio>
+// elements and declared in the package file, along with the total duration of
+// all media overlays as required by the specification. For this, each <audio>
+// element must have a clipEnd attribute, since the duration of the audio files
+// isn't known.
+//
+// The internal filename is the one returned by AddSection. If no section with
+// that filename exists, FilenameNotFoundError will be returned. The SMIL source
+// should either be a URL, a path to a local file, or an embedded data URL; if
+// it can't be retrieved, FileRetrievalError will be returned. If it can't be
+// parsed, MediaOverlayError will be returned.
+func (e *Epub) AddMediaOverlay(internalFilename string, source string) error {
+	e.Lock()
+	defer e.Unlock()
+	found := false
+	for _, section := range e.sections {
+		if section.filename == internalFilename {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return &FilenameNotFoundError{Filename: internalFilename}
+	}
+	if _, ok := e.sectionOverlays[internalFilename]; !ok {
+		if err := e.checkManifestItems(1); err != nil {
+			return err
+		}
+	}
+
+	content, err := e.newGrabber().readMedia(source)
+	if err != nil {
+		return err
+	}
+	duration, err := smilDuration(string(content))
+	if err != nil {
+		return &MediaOverlayError{Source: source, Err: err}
+	}
+
+	// Replace the media overlay added for the section before, if any
+	if overlayFilename, ok := e.sectionOverlays[internalFilename]; ok {
+		delete(e.overlays, overlayFilename)
+		delete(e.overlayDurations, overlayFilename)
+	}
+
+	// Sources such as data URLs don't have a usable filename, so one is
+	// generated which isn't used yet
+	overlayFilename := ""
+	for i := len(e.overlays) + 1; overlayFilename == ""; i++ {
+		overlayFilename = fmt.Sprintf(overlayFileFormat, i, overlayExtension)
+		if _, ok := e.overlays[overlayFilename]; ok {
+			overlayFilename = ""
+		}
+	}
+	e.overlays[overlayFilename] = source
+	e.overlayDurations[overlayFilename] = duration
+	e.sectionOverlays[internalFilename] = overlayFilename
+
+	return nil
+}
+
+// Get the media overlays from their source, save them in the temporary
+// directory, and declare their durations in the package file
+func (e *Epub) writeMediaOverlays(rootEpubDir string) error {
+	if len(e.overlays) == 0 {
+		return nil
+	}
+
+	err := e.writeMedia(rootEpubDir, e.overlays, OverlayFolderName)
+	if err != nil {
+		return err
+	}
+
+	// The media type of SMIL files can't be detected from their content since
+	// they're plain XML
+	for i, item := range e.Pkg.xml.ManifestItems {
+		if path.Dir(item.Href) == OverlayFolderName {
+			e.Pkg.xml.ManifestItems[i].MediaType = mediaTypeSMIL
+		}
+	}
+
+	// Sort the media overlays so the package file is the same every time
+	overlayFilenames := make([]string, 0, len(e.overlays))
+	for overlayFilename := range e.overlays {
+		overlayFilenames = append(overlayFilenames, overlayFilename)
+	}
+	sort.Strings(overlayFilenames)
+
+	total := time.Duration(0)
+	for _, overlayFilename := range overlayFilenames {
+		duration := e.overlayDurations[overlayFilename]
+		total += duration
+		e.Pkg.setMeta("#"+fixXMLId(overlayFilename), PropertyMediaDuration, formatClockValue(duration))
+	}
+	e.Pkg.setMetaProperty(PropertyMediaDuration, formatClockValue(total))
+
+	return nil
+}
+
+// Compute the duration of a SMIL file from the clips of its <audio> elements
+func smilDuration(smil string) (time.Duration, error) {
+	d := xml.NewDecoder(strings.NewReader(smil))
+	total := time.Duration(0)
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		se, ok := t.(xml.StartElement)
+		if !ok || se.Name.Local != "audio" {
+			continue
+		}
+
+		var clipBegin, clipEnd time.Duration
+		hasClipEnd := false
+		src := ""
+		for _, attr := range se.Attr {
+			switch attr.Name.Local {
+			case "src":
+				src = attr.Value
+			case "clipBegin":
+				clipBegin, err = parseClockValue(attr.Value)
+			case "clipEnd":
+				clipEnd, err = parseClockValue(attr.Value)
+				hasClipEnd = true
+			}
+			if err != nil {
+				return 0, err
+			}
+		}
+		// Without clipEnd, the clip plays until the end of the audio file, whose
+		// duration can't be known from the SMIL file
+		if !hasClipEnd {
+			return 0, fmt.Errorf("audio clip %q has no clipEnd", src)
+		}
+		if clipEnd < clipBegin {
+			return 0, fmt.Errorf("clipEnd %v is before clipBegin %v", clipEnd, clipBegin)
+		}
+		total += clipEnd - clipBegin
+	}
+
+	return total, nil
+}
+
+// Parse a SMIL clock value, e.g. 0:01:30.5, 01:30.5, 90.5s, or 90500ms
+// Spec: https://www.w3.org/TR/SMIL3/smil-timing.html#q22
+func parseClockValue(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+
+	if strings.Contains(value, ":") {
+		parts := strings.Split(value, ":")
+		if len(parts) > 3 {
+			return 0, fmt.Errorf("invalid clock value %q", value)
+		}
+		seconds := 0.0
+		for _, part := range parts {
+			n, err := strconv.ParseFloat(part, 64)
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("invalid clock value %q", value)
+			}
+			seconds = seconds*60 + n
+		}
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+
+	unit := time.Second
+	for _, u := range []struct {
+		suffix string
+		unit   time.Duration
+	}{
+		{"ms", time.Millisecond},
+		{"min", time.Minute},
+		{"h", time.Hour},
+		{"s", time.Second},
+	} {
+		if strings.HasSuffix(value, u.suffix) {
+			value = strings.TrimSuffix(value, u.suffix)
+			unit = u.unit
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid clock value %q", value)
+	}
+	return time.Duration(n * float64(unit)), nil
+}
+
+// Format a duration as a full SMIL clock value, e.g. 0:01:30.500
+func formatClockValue(d time.Duration) string {
+	d = d.Round(time.Millisecond)
+	return fmt.Sprintf(
+		overlayClockFormat,
+		d/time.Hour,
+		d%time.Hour/time.Minute,
+		d%time.Minute/time.Second,
+		d%time.Second/time.Millisecond,
+	)
+}
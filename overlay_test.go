@@ -0,0 +1,142 @@
+package epub
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bmaupin/go-epub/internal/storage"
+	"github.com/vincent-petithory/dataurl"
+)
+
+const testOverlayTemplate = `<smil xmlns="http://www.w3.org/ns/SMIL" version="3.0">
+  <body>
+    <par id="p1">
+      <text src="../xhtml/section0001.xhtml#p1"/>
+      <audio src="../audio/chapter.mp3" clipBegin="%s" clipEnd="%s"/>
+    </par>
+    <par id="p2">
+      <text src="../xhtml/section0001.xhtml#p2"/>
+      <audio src="../audio/chapter.mp3" clipBegin="%s" clipEnd="%s"/>
+    </par>
+  </body>
+</smil>`
+
+func Test_parseClockValue(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"0:01:30.5", 90*time.Second + 500*time.Millisecond, false},
+		{"01:30", 90 * time.Second, false},
+		{"90.5s", 90*time.Second + 500*time.Millisecond, false},
+		{"90", 90 * time.Second, false},
+		{"1500ms", 1500 * time.Millisecond, false},
+		{"2min", 2 * time.Minute, false},
+		{"1.5h", 90 * time.Minute, false},
+		{"1:2:3:4", 0, true},
+		{"soon", 0, true},
+		{"-5s", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseClockValue(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseClockValue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseClockValue() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_smilDuration(t *testing.T) {
+	tests := []struct {
+		name    string
+		smil    string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"clips", fmt.Sprintf(testOverlayTemplate, "0s", "1.5s", "1.5s", "3.25s"), 3250 * time.Millisecond, false},
+		{"clipEnd only", `<smil><body><par><audio src="a.mp3" clipEnd="2s"/></par></body></smil>`, 2 * time.Second, false},
+		{"no clipEnd", `<smil><body><par><audio src="a.mp3" clipBegin="2s"/></par></body></smil>`, 0, true},
+		{"no clip", `<smil><body><par><audio src="a.mp3"/></par></body></smil>`, 0, true},
+		{"clipEnd before clipBegin", fmt.Sprintf(testOverlayTemplate, "2s", "1s", "0s", "1s"), 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := smilDuration(tt.smil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("smilDuration() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("smilDuration() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddMediaOverlay(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	testSectionPath, _ := e.AddSection(testSectionBody, testSectionTitle, "", "")
+	testSection2Path, _ := e.AddSection(testSectionBody, testSectionTitle, "", "")
+	testOverlay := dataurl.EncodeBytes([]byte(fmt.Sprintf(testOverlayTemplate, "0s", "1.5s", "1.5s", "3.25s")))
+	testOverlay2 := dataurl.EncodeBytes([]byte(fmt.Sprintf(testOverlayTemplate, "0:00:00", "0:01:00", "0:01:00", "0:01:30.500")))
+
+	err := e.AddMediaOverlay("doesnotexist.xhtml", testOverlay)
+	if _, ok := err.(*FilenameNotFoundError); !ok {
+		t.Errorf("Expected error FilenameNotFoundError not returned. Returned instead: %+v", err)
+	}
+	err = e.AddMediaOverlay(testSectionPath, dataurl.EncodeBytes([]byte(fmt.Sprintf(testOverlayTemplate, "2s", "1s", "0s", "1s"))))
+	if _, ok := err.(*MediaOverlayError); !ok {
+		t.Errorf("Expected error MediaOverlayError not returned. Returned instead: %+v", err)
+	}
+
+	// The second overlay of a section replaces the first
+	e.AddMediaOverlay(testSectionPath, testOverlay2)
+	err = e.AddMediaOverlay(testSectionPath, testOverlay)
+	if err != nil {
+		t.Errorf("Error adding media overlay: %s", err)
+	}
+	err = e.AddMediaOverlay(testSection2Path, testOverlay2)
+	if err != nil {
+		t.Errorf("Error adding media overlay: %s", err)
+	}
+	e.Pkg.SetMediaActiveClass("-epub-media-overlay-active")
+	e.Pkg.SetMediaPlaybackActiveClass("-epub-media-overlay-playing")
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	for _, testElement := range []string{
+		`<meta refines="#overlay0001.smil" property="media:duration">0:00:03.250</meta>`,
+		`<meta refines="#overlay0002.smil" property="media:duration">0:01:30.500</meta>`,
+		`<meta property="media:duration">0:01:33.750</meta>`,
+		`<meta property="media:active-class">-epub-media-overlay-active</meta>`,
+		`<meta property="media:playback-active-class">-epub-media-overlay-playing</meta>`,
+		`<item id="overlay0001.smil" href="overlays/overlay0001.smil" media-type="application/smil+xml"></item>`,
+		`<item id="section0001.xhtml" href="xhtml/section0001.xhtml" media-type="application/xhtml+xml" media-overlay="overlay0001.smil"></item>`,
+		`<item id="section0002.xhtml" href="xhtml/section0002.xhtml" media-type="application/xhtml+xml" media-overlay="overlay0002.smil"></item>`,
+	} {
+		if !strings.Contains(string(pkgFileContent), testElement) {
+			t.Errorf(
+				"Package file doesn't contain media overlay element\n"+
+					"Got: %s\n"+
+					"Expected: %s",
+				pkgFileContent,
+				testElement)
+		}
+	}
+	if strings.Count(string(pkgFileContent), "overlays/") != 2 {
+		t.Errorf("Replaced media overlay shouldn't be in the package file\nGot: %s", pkgFileContent)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
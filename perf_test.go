@@ -2,6 +2,7 @@ package epub
 
 import (
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -44,3 +45,49 @@ func BenchmarkAddImage_file(b *testing.B) {
 		}
 	}
 }
+
+// Build an EPUB with a few resources and sections to compare ways to write it
+func newBenchmarkEpub(b *testing.B) *Epub {
+	e := NewEpub("test")
+	cssPath, err := e.AddCSS("testdata/cover.css", "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	imagePath, err := e.AddImage("testdata/gophercolor16x16.png", "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		_, err := e.AddSection(`<h1>Section</h1><p><img src="`+imagePath+`" alt="Gopher"/></p>`, "Section", "", cssPath)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	return e
+}
+
+func BenchmarkWriteTo_staging(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		e := newBenchmarkEpub(b)
+		b.StartTimer()
+		_, err := e.WriteTo(ioutil.Discard)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBytes(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		e := newBenchmarkEpub(b)
+		b.StartTimer()
+		_, err := e.Bytes()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
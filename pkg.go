@@ -4,6 +4,8 @@ import (
 	"encoding/xml"
 	"fmt"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -23,12 +25,98 @@ const (
 	PropertyDisplaySequence   = "display-seq"
 	PropertyMetadataAuthority = "meta-auth"
 
+	// Content is the name used to sort a creator or contributor, e.g.
+	// "Natsume, Sōseki" for "Sōseki Natsume"
+	PropertyFileAs = "file-as"
+
 	// Content uses SchemeONIXCodeList5 or SchemeXSDString,
 	// use PropertyIdentifierType* constants,
 	// see https://onix-codelists.io/codelist/5
 	PropertyIdentifierType = "identifier-type"
 	// Content is a timestamp in UTC, format 2011-01-01T12:00:00Z (formal specification CCYY-MM-DDThh:mm:ssZ)
 	PropertyModified = "dcterms:modified"
+
+	// Content uses RenditionFlow* constants,
+	// see https://www.w3.org/publishing/epub3/epub-packages.html#flow
+	PropertyRenditionFlow = "rendition:flow"
+
+	// Content uses RenditionLayout* constants,
+	// see https://www.w3.org/publishing/epub3/epub-packages.html#layout
+	PropertyRenditionLayout = "rendition:layout"
+
+	// Content is a SMIL clock value, e.g. 0:01:30.500,
+	// see https://www.w3.org/publishing/epub3/epub-mediaoverlays.html#sec-package-metadata
+	PropertyMediaDuration            = "media:duration"
+	PropertyMediaActiveClass         = "media:active-class"
+	PropertyMediaPlaybackActiveClass = "media:playback-active-class"
+
+	// Content is "true" or "false", see
+	// https://help.apple.com/itc/booksassetguide/#/itccf8ecf5c8
+	PropertyIBooksSpecifiedFonts = "ibooks:specified-fonts"
+
+	// Content uses IBooksScrollAxis* constants
+	PropertyIBooksScrollAxis = "ibooks:scroll-axis"
+
+	// Content is the name of a collection the EPUB belongs to, such as a
+	// series, refined by PropertyCollectionType and PropertyGroupPosition,
+	// see https://www.w3.org/publishing/epub3/epub-packages.html#sec-belongs-to-collection
+	PropertyBelongsToCollection = "belongs-to-collection"
+	// Content uses CollectionType* constants
+	PropertyCollectionType = "collection-type"
+	// Content is the position of the EPUB in the collection, e.g. 2 or 2.5
+	PropertyGroupPosition = "group-position"
+
+	// Accessibility metadata, the content uses the values of the schema.org
+	// vocabulary (e.g. "textual" or "alternativeText"), see
+	// https://www.w3.org/TR/epub-a11y/#sec-disc-package
+	PropertyAccessMode           = "schema:accessMode"
+	PropertyAccessModeSufficient = "schema:accessModeSufficient"
+	PropertyAccessibilityFeature = "schema:accessibilityFeature"
+	PropertyAccessibilityHazard  = "schema:accessibilityHazard"
+	PropertyAccessibilitySummary = "schema:accessibilitySummary"
+)
+
+const (
+	CollectionTypeSeries = "series"
+	CollectionTypeSet    = "set"
+)
+
+// Types of titles, see
+// https://www.w3.org/publishing/epub3/epub-packages.html#sec-title-type
+const (
+	TitleTypeMain       = "main"
+	TitleTypeSubtitle   = "subtitle"
+	TitleTypeShort      = "short"
+	TitleTypeCollection = "collection"
+	TitleTypeEdition    = "edition"
+	TitleTypeExpanded   = "expanded"
+)
+
+const (
+	RenditionFlowPaginated          = "paginated"
+	RenditionFlowScrolledContinuous = "scrolled-continuous"
+	RenditionFlowScrolledDoc        = "scrolled-doc"
+	RenditionFlowAuto               = "auto"
+)
+
+const (
+	RenditionLayoutPrePaginated = "pre-paginated"
+	RenditionLayoutReflowable   = "reflowable"
+)
+
+// Spine properties placing a page on the left or right of a spread, or in the
+// center as a single page, see
+// https://www.w3.org/publishing/epub3/epub-packages.html#sec-page-spread
+const (
+	PageSpreadLeft   = "page-spread-left"
+	PageSpreadRight  = "page-spread-right"
+	PageSpreadCenter = "rendition:page-spread-center"
+)
+
+const (
+	IBooksScrollAxisDefault    = "default"
+	IBooksScrollAxisHorizontal = "horizontal"
+	IBooksScrollAxisVertical   = "vertical"
 )
 
 const (
@@ -73,6 +161,10 @@ const (
 	pkgCreatorID     = "creator"
 	pkgContributorID = "contributor"
 	pkgIdentifierID  = "pub-id"
+	pkgSeriesID      = "series"
+	pkgTitleID       = "title"
+
+	spineLinearNo = "no"
 
 	pkgFileTemplate = `<?xml version="1.0" encoding="UTF-8"?>
 <package version="3.0" unique-identifier="pub-id" xmlns="http://www.idpf.org/2007/opf">
@@ -80,7 +172,6 @@ const (
     <dc:identifier id="pub-id"></dc:identifier>
     <dc:title></dc:title>
     <dc:language></dc:language>
-    <dc:description></dc:description>
   </metadata>
   <manifest>
   </manifest>
@@ -89,9 +180,37 @@ const (
 </package>
 `
 
+	prefixIBooks    = "ibooks"
+	prefixIBooksURI = "http://vocabulary.itunes.apple.com/rdf/ibooks/vocabulary-extensions-1.0/"
+
 	xmlnsDc = "http://purl.org/dc/elements/1.1/"
+
+	// Names of the <meta> elements used by Calibre for series
+	calibreSeries      = "calibre:series"
+	calibreSeriesIndex = "calibre:series_index"
 )
 
+// InvalidValueError is thrown by setters such as SetRenditionFlow if the value
+// given isn't one of the values allowed for the setting.
+type InvalidValueError struct {
+	Name  string // Name of the setting
+	Value string // Value that caused the error
+}
+
+func (e *InvalidValueError) Error() string {
+	return fmt.Sprintf("Invalid value for %s: %q", e.Name, e.Value)
+}
+
+// IdentifierNotFoundError is thrown by RemoveIdentifier and
+// SetUniqueIdentifier if no identifier has the given ID.
+type IdentifierNotFoundError struct {
+	ID string // ID of the identifier that was given
+}
+
+func (e *IdentifierNotFoundError) Error() string {
+	return fmt.Sprintf("Identifier with ID %q not found", e.ID)
+}
+
 // pkg implements the package document file (package.opf), which contains
 // metadata about the EPUB (title, author, etc) as well as a list of files the
 // EPUB contains.
@@ -107,15 +226,27 @@ type PkgRoot struct {
 	XMLName          xml.Name    `xml:"http://www.idpf.org/2007/opf package"`
 	UniqueIdentifier string      `xml:"unique-identifier,attr"`
 	Version          string      `xml:"version,attr"`
+	Prefix           string      `xml:"prefix,attr,omitempty"`
 	Metadata         PkgMetadata `xml:"metadata"`
 	ManifestItems    []PkgItem   `xml:"manifest>item"`
 	Spine            PkgSpine    `xml:"spine"`
+	Guide            *PkgGuide   `xml:"guide,omitempty"`
+}
+
+// <dc:title>, with the language of the title if it differs from the language
+// of the EPUB
+// Ex: <dc:title xml:lang="ja">吾輩は猫である</dc:title>
+type PkgTitle struct {
+	ID   string `xml:"id,attr,omitempty"`
+	Lang string `xml:"xml:lang,attr,omitempty"`
+	Data string `xml:",chardata"`
 }
 
 // <dc:creator>, e.g. the author
 type PkgCreator struct {
 	XMLName xml.Name `xml:"dc:creator"`
 	ID      string   `xml:"id,attr"`
+	Lang    string   `xml:"xml:lang,attr,omitempty"`
 	Data    string   `xml:",chardata"`
 }
 
@@ -123,6 +254,7 @@ type PkgCreator struct {
 type PkgContributor struct {
 	XMLName xml.Name `xml:"dc:contributor"`
 	ID      string   `xml:"id,attr"`
+	Lang    string   `xml:"xml:lang,attr,omitempty"`
 	Data    string   `xml:",chardata"`
 }
 
@@ -139,16 +271,35 @@ type PkgIdentifier struct {
 //	<item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml" />
 //	<item id="section0001.xhtml" href="xhtml/section0001.xhtml" media-type="application/xhtml+xml" />
 type PkgItem struct {
-	ID         string `xml:"id,attr"`
-	Href       string `xml:"href,attr"`
-	MediaType  string `xml:"media-type,attr"`
-	Properties string `xml:"properties,attr,omitempty"`
+	ID           string `xml:"id,attr"`
+	Href         string `xml:"href,attr"`
+	MediaType    string `xml:"media-type,attr"`
+	Properties   string `xml:"properties,attr,omitempty"`
+	Fallback     string `xml:"fallback,attr,omitempty"`
+	MediaOverlay string `xml:"media-overlay,attr,omitempty"`
 }
 
 // <itemref> elements, which define the reading order
 // Ex: <itemref idref="section0001.xhtml" />
 type PkgItemref struct {
 	Idref string `xml:"idref,attr"`
+	// "no" if the item isn't part of the linear reading order
+	Linear     string `xml:"linear,attr,omitempty"`
+	Properties string `xml:"properties,attr,omitempty"`
+}
+
+// The EPUB 2 <guide>, which is only written if it has references
+type PkgGuide struct {
+	References []PkgReference `xml:"reference"`
+}
+
+// <reference> elements of the EPUB 2 <guide>, which point to fundamental
+// structural components of the EPUB
+// Ex: <reference type="text" title="Start" href="xhtml/section0001.xhtml" />
+type PkgReference struct {
+	Type  string `xml:"type,attr"`
+	Title string `xml:"title,attr,omitempty"`
+	Href  string `xml:"href,attr"`
 }
 
 // The <meta> element, which contains modified date, role of the creator (e.g.
@@ -170,20 +321,89 @@ type PkgMeta struct {
 type PkgMetadata struct {
 	XmlnsDc    string          `xml:"xmlns:dc,attr"`
 	Identifier []PkgIdentifier `xml:"dc:identifier"`
+	// The main title
 	// Ex: <dc:title>Your title here</dc:title>
-	Title string `xml:"dc:title"`
+	Title string `xml:"-"`
+	// ID of the main title, which is only set if other metadata refines it
+	TitleID string `xml:"-"`
+	// Language of the main title if it differs from the language of the EPUB
+	TitleLang string `xml:"-"`
+	// The other titles, which are written after the main title
+	// Ex: <dc:title xml:lang="ja">吾輩は猫である</dc:title>
+	Titles []PkgTitle `xml:"-"`
 	// Ex: <dc:language>en</dc:language>
 	Language    string `xml:"dc:language"`
 	Description string `xml:"dc:description,omitempty"`
 	Publisher   string `xml:"dc:publisher,omitempty"`
 	// e.g. a URL
 	Source string `xml:"dc:source,omitempty"`
-	Date   string `xml:"dc:date,omitempty"`
+	// Copyright or license statement
+	Rights string `xml:"dc:rights,omitempty"`
+	// Place or time period the content is about
+	Coverage string `xml:"dc:coverage,omitempty"`
+	// Related resource, e.g. the printed edition
+	Relation string `xml:"dc:relation,omitempty"`
+	Date     string `xml:"dc:date,omitempty"`
 	// Tags
 	Subject     []string `xml:"dc:subject,omitempty"`
 	Creator     []PkgCreator
 	Contributor []PkgContributor
 	Meta        []PkgMeta `xml:"meta"`
+	Link        []PkgLink `xml:"link"`
+}
+
+// The <metadata> element without its methods, so it can be marshalled along
+// with the titles
+type pkgMetadata PkgMetadata
+
+// MarshalXML writes the main title and the other titles as <dc:title> elements
+// after the identifiers, with the main title first as required by the EPUB spec
+func (m PkgMetadata) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	titles := append([]PkgTitle{{
+		ID:   m.TitleID,
+		Lang: m.TitleLang,
+		Data: m.Title,
+	}}, m.Titles...)
+
+	// The fields of the embedded metadata with the same names are ignored
+	return e.EncodeElement(struct {
+		XmlnsDc    string          `xml:"xmlns:dc,attr"`
+		Identifier []PkgIdentifier `xml:"dc:identifier"`
+		Title      []PkgTitle      `xml:"dc:title"`
+		pkgMetadata
+	}{m.XmlnsDc, m.Identifier, titles, pkgMetadata(m)}, start)
+}
+
+// UnmarshalXML reads the first <dc:title> element as the main title and the
+// others as the other titles
+func (m *PkgMetadata) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	v := struct {
+		Title []PkgTitle `xml:"dc:title"`
+		pkgMetadata
+	}{pkgMetadata: pkgMetadata(*m)}
+	if err := d.DecodeElement(&v, &start); err != nil {
+		return err
+	}
+
+	*m = PkgMetadata(v.pkgMetadata)
+	if len(v.Title) > 0 {
+		m.Title = v.Title[0].Data
+		m.TitleID = v.Title[0].ID
+		m.TitleLang = v.Title[0].Lang
+		m.Titles = v.Title[1:]
+	}
+	return nil
+}
+
+// The <link> element, which associates a resource with the EPUB, e.g. a record
+// or a publisher logo
+// Ex: <link rel="foaf:logo" href="images/logo.png" media-type="image/png" />
+type PkgLink struct {
+	Rel        string `xml:"rel,attr"`
+	Href       string `xml:"href,attr"`
+	MediaType  string `xml:"media-type,attr,omitempty"`
+	Properties string `xml:"properties,attr,omitempty"`
+	Refines    string `xml:"refines,attr,omitempty"`
 }
 
 // The <spine> element
@@ -236,12 +456,93 @@ func (p *Pkg) AddToSpine(id string) {
 	p.xml.Spine.Items = append(p.xml.Spine.Items, *i)
 }
 
+// AddLink adds a link to a resource to the metadata. The href is relative to
+// the package file for resources in the EPUB; the media type is required for
+// those. If the relationship (rel) isn't part of the EPUB vocabulary, it must
+// use a prefix declared with AddPrefix.
+func (p *Pkg) AddLink(href string, rel string, mediaType string) {
+	p.xml.Metadata.Link = append(p.xml.Metadata.Link, PkgLink{
+		Rel:       rel,
+		Href:      filepath.ToSlash(href),
+		MediaType: mediaType,
+	})
+}
+
+// AddPrefix declares a prefix for a vocabulary used by properties or link
+// relationships which aren't part of the EPUB vocabulary, e.g. "foaf" for
+// "http://xmlns.com/foaf/spec/". Declaring a prefix which has already been
+// declared has no effect.
+func (p *Pkg) AddPrefix(prefix string, uri string) {
+	// The prefix attribute is a list of "prefix: uri" pairs
+	fields := strings.Fields(p.xml.Prefix)
+	for i := 0; i+1 < len(fields); i += 2 {
+		if fields[i] == prefix+":" {
+			return
+		}
+	}
+	if p.xml.Prefix != "" {
+		p.xml.Prefix += " "
+	}
+	p.xml.Prefix += prefix + ": " + uri
+}
+
+// AddToGuide adds a reference to the EPUB 2 guide for backward compatibility
+func (p *Pkg) AddToGuide(referenceType string, title string, href string) {
+	r := &PkgReference{
+		Type:  referenceType,
+		Title: title,
+		Href:  filepath.ToSlash(href),
+	}
+
+	if p.xml.Guide == nil {
+		p.xml.Guide = &PkgGuide{}
+	}
+	p.xml.Guide.References = append(p.xml.Guide.References, *r)
+}
+
 func (p *Pkg) AddCreator(author, role string) {
-	id := fmt.Sprintf("%s%d", pkgCreatorID, len(p.xml.Metadata.Creator))
+	p.addCreator(author, role, "")
+}
+
+// AddAuthor adds an author of the EPUB in the given language (e.g. "ja"), which
+// is needed for metadata in several languages to be displayed and sorted
+// correctly. The language is optional; if it's empty, the author is in the
+// language of the EPUB.
+func (p *Pkg) AddAuthor(author, lang string) {
+	p.addCreator(author, PropertyRoleAuthor, lang)
+}
+
+// AddCreatorWithSeq adds a creator of the EPUB like AddCreator, along with its
+// display sequence, which is the position reading systems show the creator at
+// among the creators, starting at 1 (e.g. the first author of a co-authored
+// work). If the sequence is less than 1, InvalidValueError will be returned.
+func (p *Pkg) AddCreatorWithSeq(creator, role string, seq int) error {
+	if seq < 1 {
+		return &InvalidValueError{Name: PropertyDisplaySequence, Value: strconv.Itoa(seq)}
+	}
+	id := p.addCreator(creator, role, "")
+	p.setDisplaySeq(id, seq)
+
+	return nil
+}
+
+// AddAuthorWithFileAs adds an author of the EPUB like AddAuthor, along with the
+// name reading systems use to sort the author, e.g. "Natsume, Sōseki" for
+// "Sōseki Natsume". Without it, authors are usually sorted by their first name.
+// An empty sort name is ignored.
+func (p *Pkg) AddAuthorWithFileAs(author, fileAs, lang string) {
+	id := p.addCreator(author, PropertyRoleAuthor, lang)
+	p.setFileAs(id, fileAs)
+}
+
+// Add a creator and return its ID
+func (p *Pkg) addCreator(author, role, lang string) string {
+	id := p.newMetadataID(pkgCreatorID)
 
 	p.xml.Metadata.Creator = append(p.xml.Metadata.Creator, PkgCreator{
 		Data: author,
 		ID:   id,
+		Lang: lang,
 	})
 	meta := PkgMeta{
 		Refines:  "#" + id,
@@ -252,10 +553,39 @@ func (p *Pkg) AddCreator(author, role string) {
 	}
 
 	p.xml.Metadata.Meta = updateMeta(p.xml.Metadata.Meta, meta)
+
+	return id
 }
 
 func (p *Pkg) AddContributor(contributor, role string) {
-	id := fmt.Sprintf("%s%d", pkgContributorID, len(p.xml.Metadata.Contributor))
+	p.addContributor(contributor, role)
+}
+
+// AddContributorWithFileAs adds a contributor of the EPUB like AddContributor,
+// along with the name reading systems use to sort the contributor (see
+// AddAuthorWithFileAs). An empty sort name is ignored.
+func (p *Pkg) AddContributorWithFileAs(contributor, role, fileAs string) {
+	id := p.addContributor(contributor, role)
+	p.setFileAs(id, fileAs)
+}
+
+// AddContributorWithSeq adds a contributor of the EPUB like AddContributor,
+// along with its display sequence among the contributors (see
+// AddCreatorWithSeq). If the sequence is less than 1, InvalidValueError will be
+// returned.
+func (p *Pkg) AddContributorWithSeq(contributor, role string, seq int) error {
+	if seq < 1 {
+		return &InvalidValueError{Name: PropertyDisplaySequence, Value: strconv.Itoa(seq)}
+	}
+	id := p.addContributor(contributor, role)
+	p.setDisplaySeq(id, seq)
+
+	return nil
+}
+
+// Add a contributor and return its ID
+func (p *Pkg) addContributor(contributor, role string) string {
+	id := p.newMetadataID(pkgContributorID)
 
 	p.xml.Metadata.Contributor = append(p.xml.Metadata.Contributor, PkgContributor{
 		Data: contributor,
@@ -270,6 +600,34 @@ func (p *Pkg) AddContributor(contributor, role string) {
 	}
 
 	p.xml.Metadata.Meta = updateMeta(p.xml.Metadata.Meta, meta)
+
+	return id
+}
+
+// Add the sort name of the creator or contributor with the given ID, unless
+// it's empty
+func (p *Pkg) setFileAs(id, fileAs string) {
+	if fileAs == "" {
+		return
+	}
+	meta := PkgMeta{
+		Refines:  "#" + id,
+		Property: PropertyFileAs,
+		Data:     fileAs,
+	}
+
+	p.xml.Metadata.Meta = updateMeta(p.xml.Metadata.Meta, meta)
+}
+
+// Add the display sequence of the element with the given ID
+func (p *Pkg) setDisplaySeq(id string, seq int) {
+	meta := PkgMeta{
+		Refines:  "#" + id,
+		Property: PropertyDisplaySequence,
+		Data:     strconv.Itoa(seq),
+	}
+
+	p.xml.Metadata.Meta = updateMeta(p.xml.Metadata.Meta, meta)
 }
 
 // Add an EPUB 2 cover meta element for backward compatibility (http://idpf.org/forum/topic-715)
@@ -289,15 +647,44 @@ func (p *Pkg) AddCustomMeta(name, content string) {
 	p.xml.Metadata.Meta = updateMeta(p.xml.Metadata.Meta, meta)
 }
 
+// RemoveMeta removes all <meta> elements with the given property (e.g.
+// PropertyMediaDuration), including those refining other elements.
+func (p *Pkg) RemoveMeta(property string) {
+	p.removeMeta(func(meta PkgMeta) bool {
+		return property != "" && meta.Property == property
+	})
+}
+
+// RemoveCustomMeta removes all <meta> elements with the given name, such as
+// those added by AddCustomMeta or the EPUB 2 cover meta element added by
+// SetCover.
+func (p *Pkg) RemoveCustomMeta(name string) {
+	p.removeMeta(func(meta PkgMeta) bool {
+		return name != "" && meta.Name == name
+	})
+}
+
+// Remove the <meta> elements matching the given function
+func (p *Pkg) removeMeta(match func(PkgMeta) bool) {
+	metas := p.xml.Metadata.Meta[:0]
+	for _, meta := range p.xml.Metadata.Meta {
+		if !match(meta) {
+			metas = append(metas, meta)
+		}
+	}
+	p.xml.Metadata.Meta = metas
+}
+
 // AddIdentifier adds an identifier of the EPUB, such as a UUID, DOI,
 // ISBN or ISSN. If no identifier is set, a UUID will be automatically
 // generated.
 func (p *Pkg) AddIdentifier(identifier, typeSchema, typeContent string) {
-	var id string
-	if len(p.xml.Metadata.Identifier) == 0 {
-		id = pkgIdentifierID
-	} else {
-		id = fmt.Sprintf("%s%d", pkgIdentifierID, len(p.xml.Metadata.Identifier))
+	// The IDs of identifiers have their own prefix, so they never collide with
+	// the IDs of creators or contributors
+	used := p.metadataIDs()
+	id := pkgIdentifierID
+	for i := len(p.xml.Metadata.Identifier); used[id]; i++ {
+		id = fmt.Sprintf("%s%d", pkgIdentifierID, i)
 	}
 
 	p.xml.Metadata.Identifier = append(p.xml.Metadata.Identifier, PkgIdentifier{
@@ -314,6 +701,62 @@ func (p *Pkg) AddIdentifier(identifier, typeSchema, typeContent string) {
 	p.xml.Metadata.Meta = updateMeta(p.xml.Metadata.Meta, meta)
 }
 
+// RemoveIdentifier removes the identifier with the given ID (see Identifiers)
+// along with its metadata, e.g. the UUID generated by NewEpub once another
+// identifier such as an ISBN has been added. If the unique identifier of the
+// EPUB is removed, the first remaining identifier becomes the unique
+// identifier.
+//
+// If no identifier has the given ID, IdentifierNotFoundError will be returned.
+// Since an EPUB must have an identifier, the last identifier can't be removed;
+// InvalidValueError will be returned instead.
+func (p *Pkg) RemoveIdentifier(id string) error {
+	identifiers := []PkgIdentifier{}
+	for _, identifier := range p.xml.Metadata.Identifier {
+		if identifier.ID != id {
+			identifiers = append(identifiers, identifier)
+		}
+	}
+	if len(identifiers) == len(p.xml.Metadata.Identifier) {
+		return &IdentifierNotFoundError{ID: id}
+	}
+	if len(identifiers) == 0 {
+		return &InvalidValueError{Name: "identifier", Value: id}
+	}
+	p.xml.Metadata.Identifier = identifiers
+
+	metas := []PkgMeta{}
+	for _, meta := range p.xml.Metadata.Meta {
+		if meta.Refines != "#"+id {
+			metas = append(metas, meta)
+		}
+	}
+	p.xml.Metadata.Meta = metas
+
+	if p.xml.UniqueIdentifier == id {
+		p.xml.UniqueIdentifier = identifiers[0].ID
+	}
+
+	return nil
+}
+
+// SetUniqueIdentifier sets the identifier with the given ID (see Identifiers)
+// as the unique identifier of the EPUB, e.g. an ISBN added with AddIdentifier
+// instead of the UUID generated by NewEpub. The unique identifier is the one
+// reading systems use to tell EPUBs apart, and the key used to obfuscate fonts.
+//
+// If no identifier has the given ID, IdentifierNotFoundError will be returned.
+func (p *Pkg) SetUniqueIdentifier(id string) error {
+	for _, identifier := range p.xml.Metadata.Identifier {
+		if identifier.ID == id {
+			p.xml.UniqueIdentifier = id
+			return nil
+		}
+	}
+
+	return &IdentifierNotFoundError{ID: id}
+}
+
 func (p *Pkg) SetLang(lang string) {
 	p.xml.Metadata.Language = lang
 }
@@ -330,6 +773,24 @@ func (p *Pkg) SetSource(source string) {
 	p.xml.Metadata.Source = source
 }
 
+// SetRights sets a statement about the rights held in and over the EPUB, e.g. a
+// copyright notice or the license the EPUB is distributed under.
+func (p *Pkg) SetRights(rights string) {
+	p.xml.Metadata.Rights = rights
+}
+
+// SetCoverage sets the spatial or temporal topic of the content of the EPUB,
+// e.g. a place or a period of time.
+func (p *Pkg) SetCoverage(coverage string) {
+	p.xml.Metadata.Coverage = coverage
+}
+
+// SetRelation sets a resource related to the EPUB, e.g. the identifier or URL
+// of the printed edition.
+func (p *Pkg) SetRelation(relation string) {
+	p.xml.Metadata.Relation = relation
+}
+
 func (p *Pkg) SetDate(dt time.Time) {
 	p.xml.Metadata.Date = dt.UTC().Format(time.RFC3339)
 }
@@ -342,6 +803,80 @@ func (p *Pkg) AddSubject(subject string) {
 	p.xml.Metadata.Subject = append(p.xml.Metadata.Subject, subject)
 }
 
+// SetSeries sets the series the EPUB belongs to and its position in the series
+// (e.g. 2 for the second book), replacing the series previously set. Both the
+// EPUB 3 belongs-to-collection metadata and the calibre:series metadata used by
+// Calibre and older readers are added. If the name is empty, the series is
+// removed.
+func (p *Pkg) SetSeries(name string, position float64) {
+	p.removeMeta(func(meta PkgMeta) bool {
+		return meta.ID == pkgSeriesID ||
+			meta.Refines == "#"+pkgSeriesID ||
+			meta.Name == calibreSeries ||
+			meta.Name == calibreSeriesIndex
+	})
+	if name == "" {
+		return
+	}
+
+	index := strconv.FormatFloat(position, 'f', -1, 64)
+	p.xml.Metadata.Meta = append(p.xml.Metadata.Meta,
+		PkgMeta{
+			ID:       pkgSeriesID,
+			Property: PropertyBelongsToCollection,
+			Data:     name,
+		},
+		PkgMeta{
+			Refines:  "#" + pkgSeriesID,
+			Property: PropertyCollectionType,
+			Data:     CollectionTypeSeries,
+		},
+		PkgMeta{
+			Refines:  "#" + pkgSeriesID,
+			Property: PropertyGroupPosition,
+			Data:     index,
+		},
+		PkgMeta{
+			Name:    calibreSeries,
+			Content: name,
+		},
+		PkgMeta{
+			Name:    calibreSeriesIndex,
+			Content: index,
+		},
+	)
+}
+
+// AddAccessMode adds a way in which the content of the EPUB can be perceived,
+// such as "textual" or "visual".
+func (p *Pkg) AddAccessMode(mode string) {
+	p.addMetaProperty(PropertyAccessMode, mode)
+}
+
+// AddAccessModeSufficient adds a set of access modes sufficient to understand
+// all of the content of the EPUB, separated by commas (e.g. "textual,visual").
+func (p *Pkg) AddAccessModeSufficient(modes string) {
+	p.addMetaProperty(PropertyAccessModeSufficient, modes)
+}
+
+// AddAccessibilityFeature adds an accessibility feature of the EPUB, such as
+// "alternativeText", "structuralNavigation" or "tableOfContents".
+func (p *Pkg) AddAccessibilityFeature(feature string) {
+	p.addMetaProperty(PropertyAccessibilityFeature, feature)
+}
+
+// AddAccessibilityHazard adds a characteristic of the EPUB which can be
+// physiologically dangerous to some users, such as "flashing", or "none".
+func (p *Pkg) AddAccessibilityHazard(hazard string) {
+	p.addMetaProperty(PropertyAccessibilityHazard, hazard)
+}
+
+// SetAccessibilitySummary sets a human-readable summary of the accessibility
+// of the EPUB.
+func (p *Pkg) SetAccessibilitySummary(summary string) {
+	p.setMetaProperty(PropertyAccessibilitySummary, summary)
+}
+
 func (p *Pkg) SetPpd(direction string) {
 	p.xml.Spine.Ppd = direction
 }
@@ -355,10 +890,277 @@ func (p *Pkg) SetModified(timestamp string) {
 	p.xml.Metadata.Meta = updateMeta(p.xml.Metadata.Meta, meta)
 }
 
+// SetMediaActiveClass sets the CSS class which reading systems apply to the
+// element of a section which is currently being read aloud by a media overlay.
+func (p *Pkg) SetMediaActiveClass(class string) {
+	p.setMetaProperty(PropertyMediaActiveClass, class)
+}
+
+// SetMediaPlaybackActiveClass sets the CSS class which reading systems apply to
+// the root element of a section while its media overlay is playing.
+func (p *Pkg) SetMediaPlaybackActiveClass(class string) {
+	p.setMetaProperty(PropertyMediaPlaybackActiveClass, class)
+}
+
+// SetRenditionFlow sets how reading systems should handle content overflow,
+// e.g. whether the content should be paginated or scrolled. The flow must be
+// one of the RenditionFlow* constants, otherwise InvalidValueError will be
+// returned.
+func (p *Pkg) SetRenditionFlow(flow string) error {
+	switch flow {
+	case RenditionFlowPaginated, RenditionFlowScrolledContinuous, RenditionFlowScrolledDoc, RenditionFlowAuto:
+	default:
+		return &InvalidValueError{Name: PropertyRenditionFlow, Value: flow}
+	}
+	p.setMetaProperty(PropertyRenditionFlow, flow)
+
+	return nil
+}
+
+// SetRenditionLayout sets whether the content is reflowable (the default) or
+// pre-paginated, i.e. fixed-layout. The layout must be one of the
+// RenditionLayout* constants, otherwise InvalidValueError will be returned.
+func (p *Pkg) SetRenditionLayout(layout string) error {
+	switch layout {
+	case RenditionLayoutPrePaginated, RenditionLayoutReflowable:
+	default:
+		return &InvalidValueError{Name: PropertyRenditionLayout, Value: layout}
+	}
+	p.setMetaProperty(PropertyRenditionLayout, layout)
+
+	return nil
+}
+
+// SetIBooksSpecifiedFonts sets whether Apple Books should use the fonts
+// embedded in the EPUB by default instead of its own.
+func (p *Pkg) SetIBooksSpecifiedFonts(specified bool) {
+	p.AddPrefix(prefixIBooks, prefixIBooksURI)
+	p.setMetaProperty(PropertyIBooksSpecifiedFonts, strconv.FormatBool(specified))
+}
+
+// SetIBooksScrollAxis sets the direction in which Apple Books scrolls the
+// content in scrolling mode. The axis must be one of the IBooksScrollAxis*
+// constants, otherwise InvalidValueError will be returned.
+func (p *Pkg) SetIBooksScrollAxis(axis string) error {
+	switch axis {
+	case IBooksScrollAxisDefault, IBooksScrollAxisHorizontal, IBooksScrollAxisVertical:
+	default:
+		return &InvalidValueError{Name: PropertyIBooksScrollAxis, Value: axis}
+	}
+	p.AddPrefix(prefixIBooks, prefixIBooksURI)
+	p.setMetaProperty(PropertyIBooksScrollAxis, axis)
+
+	return nil
+}
+
 func (p *Pkg) SetTitle(title string) {
 	p.xml.Metadata.Title = title
 }
 
+// SetTitleLang sets the language of the main title (e.g. "ja") if it differs
+// from the language of the EPUB.
+func (p *Pkg) SetTitleLang(lang string) {
+	p.xml.Metadata.TitleLang = lang
+}
+
+// AddTitle adds another title of the EPUB after the main title, e.g. the title
+// in another language. The language is optional; if it's empty, the title is in
+// the language of the EPUB.
+func (p *Pkg) AddTitle(title, lang string) {
+	p.xml.Metadata.Titles = append(p.xml.Metadata.Titles, PkgTitle{
+		Lang: lang,
+		Data: title,
+	})
+}
+
+// AddTitleWithType adds another title of the EPUB after the main title with
+// the given type, which must be one of the TitleType* constants other than
+// TitleTypeMain (e.g. a subtitle or the edition), otherwise InvalidValueError
+// will be returned since there can only be one main title; use SetTitle to
+// change it instead. The display sequence is the position of the title when reading systems show the
+// titles together, starting at 1; 0 leaves the position to the reading system.
+//
+// The main title is marked with TitleTypeMain as well, since reading systems
+// can't tell which title is the main one otherwise.
+func (p *Pkg) AddTitleWithType(title, titleType string, displaySeq int) error {
+	switch titleType {
+	case TitleTypeSubtitle, TitleTypeShort, TitleTypeCollection, TitleTypeEdition, TitleTypeExpanded:
+	default:
+		return &InvalidValueError{Name: PropertyTitleType, Value: titleType}
+	}
+	if displaySeq < 0 {
+		return &InvalidValueError{Name: PropertyDisplaySequence, Value: strconv.Itoa(displaySeq)}
+	}
+
+	metadata := &p.xml.Metadata
+	if metadata.TitleID == "" {
+		metadata.TitleID = p.newMetadataID(pkgTitleID)
+	}
+	if p.refinement(metadata.TitleID, PropertyTitleType) == "" {
+		p.refineTitle(metadata.TitleID, TitleTypeMain, 0)
+	}
+
+	id := p.newMetadataID(pkgTitleID)
+	metadata.Titles = append(metadata.Titles, PkgTitle{
+		ID:   id,
+		Data: title,
+	})
+	p.refineTitle(id, titleType, displaySeq)
+
+	return nil
+}
+
+// Get an ID for a new element of the metadata, made of the prefix and the
+// lowest number which gives an ID that isn't used by any other element, e.g.
+// by a creator of an opened EPUB
+func (p *Pkg) newMetadataID(prefix string) string {
+	used := p.metadataIDs()
+	for i := 0; ; i++ {
+		id := fmt.Sprintf("%s%d", prefix, i)
+		if !used[id] {
+			return id
+		}
+	}
+}
+
+// Get the IDs used by the elements of the metadata
+func (p *Pkg) metadataIDs() map[string]bool {
+	used := map[string]bool{}
+	for _, identifier := range p.xml.Metadata.Identifier {
+		used[identifier.ID] = true
+	}
+	used[p.xml.Metadata.TitleID] = true
+	for _, title := range p.xml.Metadata.Titles {
+		used[title.ID] = true
+	}
+	for _, creator := range p.xml.Metadata.Creator {
+		used[creator.ID] = true
+	}
+	for _, contributor := range p.xml.Metadata.Contributor {
+		used[contributor.ID] = true
+	}
+	for _, meta := range p.xml.Metadata.Meta {
+		used[meta.ID] = true
+	}
+	return used
+}
+
+// Add the type and the display sequence (unless it's 0) of the title with the
+// given ID
+func (p *Pkg) refineTitle(id, titleType string, displaySeq int) {
+	p.xml.Metadata.Meta = append(p.xml.Metadata.Meta, PkgMeta{
+		Refines:  "#" + id,
+		Property: PropertyTitleType,
+		Data:     titleType,
+	})
+	if displaySeq > 0 {
+		p.setDisplaySeq(id, displaySeq)
+	}
+}
+
+// Get the value of the meta element refining the element with the given ID
+// with the property, or an empty string if there's none
+func (p *Pkg) refinement(id, property string) string {
+	for _, meta := range p.xml.Metadata.Meta {
+		if meta.Refines == "#"+id && meta.Property == property {
+			return meta.Data
+		}
+	}
+	return ""
+}
+
+// Authors returns the names of the creators of the EPUB with the author role
+// (see AddAuthor), in the order they were added.
+func (p *Pkg) Authors() []string {
+	authors := []string{}
+	for _, creator := range p.xml.Metadata.Creator {
+		if p.isAuthor(creator) {
+			authors = append(authors, creator.Data)
+		}
+	}
+	return authors
+}
+
+// Identifiers returns a copy of the identifiers of the EPUB, starting with the
+// one generated by NewEpub unless it has been replaced.
+func (p *Pkg) Identifiers() []PkgIdentifier {
+	return append([]PkgIdentifier{}, p.xml.Metadata.Identifier...)
+}
+
+// Metadata returns a copy of the metadata of the EPUB, e.g. to inspect an EPUB
+// read with Open.
+func (p *Pkg) Metadata() PkgMetadata {
+	return p.xml.clone().Metadata
+}
+
+// Get the main title of the EPUB
+func (p *Pkg) title() string {
+	return p.xml.Metadata.Title
+}
+
+// Get the unique identifier of the EPUB
+func (p *Pkg) uniqueIdentifier() string {
+	for _, identifier := range p.xml.Metadata.Identifier {
+		if identifier.ID == p.xml.UniqueIdentifier {
+			return identifier.Data
+		}
+	}
+	return ""
+}
+
+// Get the first author of the EPUB, or the first creator if no creator has the
+// author role
+func (p *Pkg) author() string {
+	if authors := p.Authors(); len(authors) > 0 {
+		return authors[0]
+	}
+	if len(p.xml.Metadata.Creator) > 0 {
+		return p.xml.Metadata.Creator[0].Data
+	}
+	return ""
+}
+
+// Check whether a creator has the author role
+func (p *Pkg) isAuthor(creator PkgCreator) bool {
+	for _, meta := range p.xml.Metadata.Meta {
+		if meta.Refines == "#"+creator.ID && meta.Property == PropertyRole && meta.Data == PropertyRoleAuthor {
+			return true
+		}
+	}
+	return false
+}
+
+// Set the global (not refining another element) <meta> element with the given
+// property, replacing its value if it has already been set
+func (p *Pkg) setMetaProperty(property string, data string) {
+	p.setMeta("", property, data)
+}
+
+// Add a global <meta> element with the given property, unless one with the same
+// value already exists
+func (p *Pkg) addMetaProperty(property string, data string) {
+	p.xml.Metadata.Meta = updateMeta(p.xml.Metadata.Meta, PkgMeta{
+		Property: property,
+		Data:     data,
+	})
+}
+
+// Set the <meta> element with the given property refining the given element
+// (e.g. "#id"), replacing its value if it has already been set
+func (p *Pkg) setMeta(refines string, property string, data string) {
+	for i, meta := range p.xml.Metadata.Meta {
+		if meta.Property == property && meta.Refines == refines {
+			p.xml.Metadata.Meta[i].Data = data
+			return
+		}
+	}
+	p.xml.Metadata.Meta = append(p.xml.Metadata.Meta, PkgMeta{
+		Refines:  refines,
+		Property: property,
+		Data:     data,
+	})
+}
+
 // Update the <meta> element
 func updateMeta(a []PkgMeta, m PkgMeta) []PkgMeta {
 	indexToReplace := -1
@@ -387,13 +1189,11 @@ func updateMeta(a []PkgMeta, m PkgMeta) []PkgMeta {
 	return a
 }
 
-// Write the package file to the temporary directory
-func (p *Pkg) write(tempDir string) {
+// Get the content of the package file, with the modification date set to now
+func (p *Pkg) marshal() []byte {
 	now := time.Now().UTC().Format("2006-01-02T15:04:05Z")
 	p.SetModified(now)
 
-	pkgFilePath := filepath.Join(tempDir, contentFolderName, pkgFilename)
-
 	output, err := xml.MarshalIndent(p.xml, "", "  ")
 	if err != nil {
 		panic(fmt.Sprintf(
@@ -407,7 +1207,24 @@ func (p *Pkg) write(tempDir string) {
 	// It's generally nice to have files end with a newline
 	pkgFileContent = append(pkgFileContent, "\n"...)
 
-	if err := filesystem.WriteFile(pkgFilePath, []byte(pkgFileContent), filePermissions); err != nil {
-		panic(fmt.Sprintf("Error writing package file: %s", err))
+	return pkgFileContent
+}
+
+// Get a copy of the package file which can be changed without changing this
+// one
+func (r *PkgRoot) clone() *PkgRoot {
+	c := *r
+	c.Metadata.Identifier = append([]PkgIdentifier(nil), r.Metadata.Identifier...)
+	c.Metadata.Titles = append([]PkgTitle(nil), r.Metadata.Titles...)
+	c.Metadata.Subject = append([]string(nil), r.Metadata.Subject...)
+	c.Metadata.Creator = append([]PkgCreator(nil), r.Metadata.Creator...)
+	c.Metadata.Contributor = append([]PkgContributor(nil), r.Metadata.Contributor...)
+	c.Metadata.Meta = append([]PkgMeta(nil), r.Metadata.Meta...)
+	c.Metadata.Link = append([]PkgLink(nil), r.Metadata.Link...)
+	c.ManifestItems = append([]PkgItem(nil), r.ManifestItems...)
+	c.Spine.Items = append([]PkgItemref(nil), r.Spine.Items...)
+	if r.Guide != nil {
+		c.Guide = &PkgGuide{References: append([]PkgReference(nil), r.Guide.References...)}
 	}
+	return &c
 }
@@ -0,0 +1,589 @@
+package epub
+
+import (
+	"encoding/xml"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bmaupin/go-epub/internal/storage"
+)
+
+func TestSetRenditionFlow(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	err := e.Pkg.SetRenditionFlow("sideways")
+	if _, ok := err.(*InvalidValueError); !ok {
+		t.Errorf("Expected error InvalidValueError not returned. Returned instead: %+v", err)
+	}
+	e.Pkg.SetRenditionFlow(RenditionFlowPaginated)
+	err = e.Pkg.SetRenditionFlow(RenditionFlowScrolledDoc)
+	if err != nil {
+		t.Errorf("Error setting rendition flow: %s", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+
+	testRenditionFlowElement := `<meta property="rendition:flow">scrolled-doc</meta>`
+	if !strings.Contains(string(pkgFileContent), testRenditionFlowElement) {
+		t.Errorf(
+			"Rendition flow meta element doesn't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			pkgFileContent,
+			testRenditionFlowElement)
+	}
+	if strings.Count(string(pkgFileContent), PropertyRenditionFlow) != 1 {
+		t.Errorf("Expected exactly one rendition flow meta element, got: %s", pkgFileContent)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestSetIBooksOptions(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	err := e.Pkg.SetIBooksScrollAxis("diagonal")
+	if _, ok := err.(*InvalidValueError); !ok {
+		t.Errorf("Expected error InvalidValueError not returned. Returned instead: %+v", err)
+	}
+	e.Pkg.SetIBooksSpecifiedFonts(false)
+	e.Pkg.SetIBooksSpecifiedFonts(true)
+	err = e.Pkg.SetIBooksScrollAxis(IBooksScrollAxisVertical)
+	if err != nil {
+		t.Errorf("Error setting scroll axis: %s", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+
+	for _, testElement := range []string{
+		`prefix="ibooks: http://vocabulary.itunes.apple.com/rdf/ibooks/vocabulary-extensions-1.0/"`,
+		`<meta property="ibooks:specified-fonts">true</meta>`,
+		`<meta property="ibooks:scroll-axis">vertical</meta>`,
+	} {
+		if !strings.Contains(string(pkgFileContent), testElement) {
+			t.Errorf(
+				"Package file doesn't contain the expected element\n"+
+					"Got: %s\n"+
+					"Expected: %s",
+				pkgFileContent,
+				testElement)
+		}
+	}
+	if strings.Count(string(pkgFileContent), PropertyIBooksSpecifiedFonts) != 1 {
+		t.Errorf("Expected exactly one specified fonts meta element, got: %s", pkgFileContent)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestSetSeries(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	e.Pkg.SetSeries("Removed Series", 1)
+	e.Pkg.SetSeries("My Series", 2.5)
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+
+	for _, testElement := range []string{
+		`<meta property="belongs-to-collection" id="series">My Series</meta>`,
+		`<meta refines="#series" property="collection-type">series</meta>`,
+		`<meta refines="#series" property="group-position">2.5</meta>`,
+		`<meta name="calibre:series" content="My Series"></meta>`,
+		`<meta name="calibre:series_index" content="2.5"></meta>`,
+	} {
+		if !strings.Contains(string(pkgFileContent), testElement) {
+			t.Errorf(
+				"Package file doesn't contain the expected element\n"+
+					"Got: %s\n"+
+					"Expected: %s",
+				pkgFileContent,
+				testElement)
+		}
+	}
+	if strings.Count(string(pkgFileContent), PropertyGroupPosition) != 1 {
+		t.Errorf("Expected exactly one group position meta element, got: %s", pkgFileContent)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+
+	e.Pkg.SetSeries("", 0)
+	for _, meta := range e.Pkg.xml.Metadata.Meta {
+		if meta.ID == pkgSeriesID || meta.Refines == "#"+pkgSeriesID || meta.Name == calibreSeries || meta.Name == calibreSeriesIndex {
+			t.Errorf("Series meta element not removed: %+v", meta)
+		}
+	}
+}
+
+func TestAccessibilityMetadata(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	e.Pkg.AddAccessMode("textual")
+	e.Pkg.AddAccessMode("visual")
+	e.Pkg.AddAccessModeSufficient("textual")
+	e.Pkg.AddAccessibilityFeature("alternativeText")
+	e.Pkg.AddAccessibilityFeature("alternativeText")
+	e.Pkg.AddAccessibilityHazard("none")
+	e.Pkg.SetAccessibilitySummary("Replaced summary")
+	e.Pkg.SetAccessibilitySummary("All images have alternative text.")
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+
+	for testElement, count := range map[string]int{
+		`<meta property="schema:accessMode">textual</meta>`:                                     1,
+		`<meta property="schema:accessMode">visual</meta>`:                                      1,
+		`<meta property="schema:accessModeSufficient">textual</meta>`:                           1,
+		`<meta property="schema:accessibilityFeature">alternativeText</meta>`:                   1,
+		`<meta property="schema:accessibilityHazard">none</meta>`:                               1,
+		`<meta property="schema:accessibilitySummary">All images have alternative text.</meta>`: 1,
+		PropertyAccessibilitySummary:                                                            1,
+	} {
+		if strings.Count(string(pkgFileContent), testElement) != count {
+			t.Errorf(
+				"Package file doesn't contain the expected element %d time(s)\n"+
+					"Got: %s\n"+
+					"Expected: %s",
+				count,
+				pkgFileContent,
+				testElement)
+		}
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestEmptyDescription(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	if strings.Contains(string(pkgFileContent), "dc:description") {
+		t.Errorf("Package file shouldn't contain a description element if none was set\nGot: %s", pkgFileContent)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestAddPrefix(t *testing.T) {
+	p := NewPkg()
+	p.AddPrefix("foaf", "http://xmlns.com/foaf/spec/")
+	p.AddPrefix("schema", "http://schema.org/")
+	p.AddPrefix("foaf", "http://xmlns.com/foaf/spec/")
+
+	testPrefix := "foaf: http://xmlns.com/foaf/spec/ schema: http://schema.org/"
+	if p.xml.Prefix != testPrefix {
+		t.Errorf(
+			"Prefix doesn't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			p.xml.Prefix,
+			testPrefix)
+	}
+}
+
+func TestMetadataLang(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	e.Pkg.SetTitleLang("en")
+	e.Pkg.AddTitle("吾輩は猫である", "ja")
+	e.Pkg.AddAuthor("夏目漱石", "ja")
+	e.Pkg.AddCreator(testEpubAuthor, PropertyRoleAuthor)
+
+	metadata := e.Pkg.Metadata()
+	if metadata.Title != testEpubTitle {
+		t.Errorf("Main title doesn't match\nGot: %s\nExpected: %s", metadata.Title, testEpubTitle)
+	}
+	if len(metadata.Titles) != 1 || metadata.Titles[0].Data != "吾輩は猫である" {
+		t.Errorf("Other titles don't match\nGot: %+v\nExpected: %s", metadata.Titles, "吾輩は猫である")
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+
+	for _, testElement := range []string{
+		`<dc:title xml:lang="en">` + testEpubTitle + `</dc:title>`,
+		`<dc:title xml:lang="ja">吾輩は猫である</dc:title>`,
+		`<dc:creator id="creator0" xml:lang="ja">夏目漱石</dc:creator>`,
+		`<dc:creator id="creator1">` + testEpubAuthor + `</dc:creator>`,
+	} {
+		if !strings.Contains(string(pkgFileContent), testElement) {
+			t.Errorf(
+				"Package file doesn't contain the expected element\n"+
+					"Got: %s\n"+
+					"Expected: %s",
+				pkgFileContent,
+				testElement)
+		}
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestAddTitleWithType(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	if err := e.Pkg.AddTitleWithType("A Subtitle", TitleTypeSubtitle, 2); err != nil {
+		t.Errorf("Unexpected error adding title: %s", err)
+	}
+	if err := e.Pkg.AddTitleWithType("Second Edition", TitleTypeEdition, 0); err != nil {
+		t.Errorf("Unexpected error adding title: %s", err)
+	}
+	err := e.Pkg.AddTitleWithType("Invalid", "sub-title", 0)
+	if _, ok := err.(*InvalidValueError); !ok {
+		t.Errorf("Expected error InvalidValueError not returned. Returned instead: %+v", err)
+	}
+	err = e.Pkg.AddTitleWithType("Another Main Title", TitleTypeMain, 0)
+	if _, ok := err.(*InvalidValueError); !ok {
+		t.Errorf("Expected error InvalidValueError not returned. Returned instead: %+v", err)
+	}
+	err = e.Pkg.AddTitleWithType("Invalid", TitleTypeShort, -1)
+	if _, ok := err.(*InvalidValueError); !ok {
+		t.Errorf("Expected error InvalidValueError not returned. Returned instead: %+v", err)
+	}
+	if e.Title() != testEpubTitle {
+		t.Errorf("Main title doesn't match\nGot: %s\nExpected: %s", e.Title(), testEpubTitle)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+
+	for _, testElement := range []string{
+		`<dc:title id="title0">` + testEpubTitle + `</dc:title>`,
+		`<dc:title id="title1">A Subtitle</dc:title>`,
+		`<dc:title id="title2">Second Edition</dc:title>`,
+		`<meta refines="#title0" property="title-type">main</meta>`,
+		`<meta refines="#title1" property="title-type">subtitle</meta>`,
+		`<meta refines="#title1" property="display-seq">2</meta>`,
+		`<meta refines="#title2" property="title-type">edition</meta>`,
+	} {
+		if !strings.Contains(string(pkgFileContent), testElement) {
+			t.Errorf(
+				"Package file doesn't contain the expected element\n"+
+					"Got: %s\n"+
+					"Expected: %s",
+				pkgFileContent,
+				testElement)
+		}
+	}
+	if count := strings.Count(string(pkgFileContent), `>main</meta>`); count != 1 {
+		t.Errorf("Main title should be marked once, got %d", count)
+	}
+	if strings.Contains(string(pkgFileContent), `refines="#title2" property="display-seq"`) {
+		t.Errorf("Package file shouldn't contain a display sequence for the edition\nGot: %s", pkgFileContent)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestFileAs(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	e.Pkg.AddAuthorWithFileAs("夏目漱石", "Natsume, Sōseki", "ja")
+	e.Pkg.AddAuthorWithFileAs(testEpubAuthor, "", "")
+	e.Pkg.AddContributorWithFileAs("Jay Rubin", PropertyRoleTranslator, "Rubin, Jay")
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+
+	for _, testElement := range []string{
+		`<dc:creator id="creator0" xml:lang="ja">夏目漱石</dc:creator>`,
+		`<meta refines="#creator0" property="file-as">Natsume, Sōseki</meta>`,
+		`<dc:creator id="creator1">` + testEpubAuthor + `</dc:creator>`,
+		`<meta refines="#contributor0" property="file-as">Rubin, Jay</meta>`,
+	} {
+		if !strings.Contains(string(pkgFileContent), testElement) {
+			t.Errorf(
+				"Package file doesn't contain the expected element\n"+
+					"Got: %s\n"+
+					"Expected: %s",
+				pkgFileContent,
+				testElement)
+		}
+	}
+	if strings.Contains(string(pkgFileContent), `refines="#creator1" property="file-as"`) {
+		t.Errorf("Package file shouldn't contain an empty sort name\nGot: %s", pkgFileContent)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestDisplaySeq(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	if err := e.Pkg.AddCreatorWithSeq("Second Author", PropertyRoleAuthor, 2); err != nil {
+		t.Errorf("Unexpected error adding creator: %s", err)
+	}
+	if err := e.Pkg.AddCreatorWithSeq("First Author", PropertyRoleAuthor, 1); err != nil {
+		t.Errorf("Unexpected error adding creator: %s", err)
+	}
+	if err := e.Pkg.AddContributorWithSeq("Artist", PropertyRoleArtist, 1); err != nil {
+		t.Errorf("Unexpected error adding contributor: %s", err)
+	}
+	for _, err := range []error{
+		e.Pkg.AddCreatorWithSeq("Invalid", PropertyRoleAuthor, 0),
+		e.Pkg.AddContributorWithSeq("Invalid", PropertyRoleArtist, -1),
+	} {
+		if _, ok := err.(*InvalidValueError); !ok {
+			t.Errorf("Expected error InvalidValueError not returned. Returned instead: %+v", err)
+		}
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+
+	for _, testElement := range []string{
+		`<dc:creator id="creator0">Second Author</dc:creator>`,
+		`<meta refines="#creator0" property="display-seq">2</meta>`,
+		`<dc:creator id="creator1">First Author</dc:creator>`,
+		`<meta refines="#creator1" property="display-seq">1</meta>`,
+		`<meta refines="#contributor0" property="display-seq">1</meta>`,
+	} {
+		if !strings.Contains(string(pkgFileContent), testElement) {
+			t.Errorf(
+				"Package file doesn't contain the expected element\n"+
+					"Got: %s\n"+
+					"Expected: %s",
+				pkgFileContent,
+				testElement)
+		}
+	}
+	if strings.Contains(string(pkgFileContent), "Invalid") {
+		t.Errorf("Package file shouldn't contain the invalid creators\nGot: %s", pkgFileContent)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestCreatorAndContributorIDs(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	// Like a creator of an opened EPUB which already has an ID
+	e.Pkg.xml.Metadata.Creator = []PkgCreator{{ID: "creator1", Data: "Opened Author"}}
+	e.Pkg.AddAuthor("First Author", "")
+	e.Pkg.AddContributor("Translator", PropertyRoleTranslator)
+	e.Pkg.AddAuthor("Second Author", "")
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+
+	for _, testElement := range []string{
+		`<dc:creator id="creator1">Opened Author</dc:creator>`,
+		`<dc:creator id="creator0">First Author</dc:creator>`,
+		`<dc:creator id="creator2">Second Author</dc:creator>`,
+		`<dc:contributor id="contributor0">Translator</dc:contributor>`,
+		`<meta refines="#contributor0" property="role" scheme="marc:relators" id="meta-contributor0">trl</meta>`,
+	} {
+		if !strings.Contains(string(pkgFileContent), testElement) {
+			t.Errorf(
+				"Package file doesn't contain the expected element\n"+
+					"Got: %s\n"+
+					"Expected: %s",
+				pkgFileContent,
+				testElement)
+		}
+	}
+	if strings.Contains(string(pkgFileContent), `<dc:creator id="contributor0">`) {
+		t.Errorf("Contributor shouldn't be a creator\nGot: %s", pkgFileContent)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestAddIdentifierIDs(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	e.Pkg.AddAuthor(testEpubAuthor, "")
+	e.Pkg.AddIdentifier("urn:isbn:9780000000002", SchemeONIXCodeList5, PropertyIdentifierTypeISBN13)
+	// Like an identifier of an opened EPUB which already has the next ID
+	e.Pkg.xml.Metadata.Identifier = append(e.Pkg.xml.Metadata.Identifier, PkgIdentifier{ID: "pub-id3", Data: "opened"})
+	e.Pkg.AddIdentifier("doi:10.1000/182", SchemeONIXCodeList5, PropertyIdentifierTypeDOI)
+
+	ids := map[string]bool{}
+	for _, identifier := range e.Pkg.Identifiers() {
+		if ids[identifier.ID] {
+			t.Errorf("Identifier ID %s is used more than once", identifier.ID)
+		}
+		ids[identifier.ID] = true
+	}
+	for _, testID := range []string{"pub-id", "pub-id1", "pub-id3", "pub-id4"} {
+		if !ids[testID] {
+			t.Errorf("Identifier ID %s not found in %v", testID, e.Pkg.Identifiers())
+		}
+	}
+	if ids[pkgCreatorID+"0"] {
+		t.Errorf("Identifier uses the ID of the creator")
+	}
+}
+
+func TestRemoveIdentifier(t *testing.T) {
+	testISBN := "urn:isbn:9780000000002"
+	e := NewEpub(testEpubTitle)
+	e.Pkg.AddIdentifier(testISBN, SchemeONIXCodeList5, PropertyIdentifierTypeISBN13)
+	if err := e.Pkg.SetUniqueIdentifier(pkgIdentifierID + "1"); err != nil {
+		t.Errorf("Unexpected error setting unique identifier: %s", err)
+	}
+	if err := e.Pkg.RemoveIdentifier(pkgIdentifierID); err != nil {
+		t.Errorf("Unexpected error removing identifier: %s", err)
+	}
+	for _, err := range []error{
+		e.Pkg.RemoveIdentifier("missing"),
+		e.Pkg.SetUniqueIdentifier("missing"),
+	} {
+		if _, ok := err.(*IdentifierNotFoundError); !ok {
+			t.Errorf("Expected error IdentifierNotFoundError not returned. Returned instead: %+v", err)
+		}
+	}
+	err := e.Pkg.RemoveIdentifier(pkgIdentifierID + "1")
+	if _, ok := err.(*InvalidValueError); !ok {
+		t.Errorf("Expected error InvalidValueError not returned. Returned instead: %+v", err)
+	}
+	if e.Pkg.uniqueIdentifier() != testISBN {
+		t.Errorf("Unique identifier doesn't match\nGot: %s\nExpected: %s", e.Pkg.uniqueIdentifier(), testISBN)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+
+	for _, testElement := range []string{
+		`unique-identifier="pub-id1"`,
+		`<dc:identifier id="pub-id1">` + testISBN + `</dc:identifier>`,
+	} {
+		if !strings.Contains(string(pkgFileContent), testElement) {
+			t.Errorf(
+				"Package file doesn't contain the expected element\n"+
+					"Got: %s\n"+
+					"Expected: %s",
+				pkgFileContent,
+				testElement)
+		}
+	}
+	for _, removed := range []string{`id="pub-id"`, `refines="#pub-id"`, urnUUIDPrefix} {
+		if strings.Contains(string(pkgFileContent), removed) {
+			t.Errorf("Package file shouldn't contain the removed identifier\nGot: %s", pkgFileContent)
+		}
+	}
+
+	cleanup(testEpubFilename, tempDir)
+
+	// Removing the unique identifier makes the next one unique
+	e = NewEpub(testEpubTitle)
+	e.Pkg.AddIdentifier(testISBN, SchemeONIXCodeList5, PropertyIdentifierTypeISBN13)
+	e.Pkg.RemoveIdentifier(pkgIdentifierID)
+	if e.Pkg.uniqueIdentifier() != testISBN {
+		t.Errorf("Unique identifier doesn't match\nGot: %s\nExpected: %s", e.Pkg.uniqueIdentifier(), testISBN)
+	}
+}
+
+func TestRightsCoverageRelation(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	e.Pkg.SetRights(testEpubRights)
+	e.Pkg.SetCoverage("Paris, 1890-1900")
+	e.Pkg.SetRelation("urn:isbn:9780000000002")
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+
+	for _, testElement := range []string{
+		`<dc:rights>` + testEpubRights + `</dc:rights>`,
+		`<dc:coverage>Paris, 1890-1900</dc:coverage>`,
+		`<dc:relation>urn:isbn:9780000000002</dc:relation>`,
+	} {
+		if !strings.Contains(string(pkgFileContent), testElement) {
+			t.Errorf(
+				"Package file doesn't contain the expected element\n"+
+					"Got: %s\n"+
+					"Expected: %s",
+				pkgFileContent,
+				testElement)
+		}
+	}
+
+	cleanup(testEpubFilename, tempDir)
+
+	// The elements are omitted unless they're set
+	e = NewEpub(testEpubTitle)
+	output, err := xml.Marshal(e.Pkg.xml)
+	if err != nil {
+		t.Fatalf("Unexpected error marshalling package file: %s", err)
+	}
+	for _, element := range []string{"dc:rights", "dc:coverage", "dc:relation"} {
+		if strings.Contains(string(output), element) {
+			t.Errorf("Package file shouldn't contain %s\nGot: %s", element, output)
+		}
+	}
+}
+
+func TestRemoveMeta(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	e.Pkg.AddCustomMeta("calibre:series", "Series")
+	e.Pkg.AddCustomMeta("calibre:series_index", "1")
+	e.Pkg.SetMediaActiveClass("active")
+	e.Pkg.SetRenditionFlow(RenditionFlowPaginated)
+
+	e.Pkg.RemoveCustomMeta("calibre:series")
+	e.Pkg.RemoveMeta(PropertyMediaActiveClass)
+	// Empty names and properties shouldn't match anything
+	e.Pkg.RemoveCustomMeta("")
+	e.Pkg.RemoveMeta("")
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	for _, removed := range []string{`name="calibre:series"`, PropertyMediaActiveClass} {
+		if strings.Contains(string(pkgFileContent), removed) {
+			t.Errorf("Package file shouldn't contain %s after it was removed\nGot: %s", removed, pkgFileContent)
+		}
+	}
+	for _, kept := range []string{`name="calibre:series_index"`, PropertyRenditionFlow, PropertyIdentifierType} {
+		if !strings.Contains(string(pkgFileContent), kept) {
+			t.Errorf("Package file should still contain %s\nGot: %s", kept, pkgFileContent)
+		}
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
@@ -0,0 +1,199 @@
+package epub
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Elements after which a line break is added when converting to text
+var previewBlockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true,
+	"dd": true, "div": true, "dl": true, "dt": true, "figcaption": true,
+	"figure": true, "footer": true, "h1": true, "h2": true, "h3": true,
+	"h4": true, "h5": true, "h6": true, "header": true, "hr": true, "li": true,
+	"ol": true, "p": true, "pre": true, "section": true, "table": true,
+	"tr": true, "ul": true,
+}
+
+// Text returns the text of the sections in reading order without any markup,
+// e.g. to review or diff the content of the EPUB without a reader. The title
+// of each section (if any) is used as its heading.
+//
+// An error is returned if the body of a section isn't valid XHTML.
+func (e *Epub) Text() (string, error) {
+	e.Lock()
+	defer e.Unlock()
+	return e.preview(false)
+}
+
+// Markdown is like Text, but approximates the structure of the sections using
+// Markdown, e.g. for headings, lists, and emphasis.
+func (e *Epub) Markdown() (string, error) {
+	e.Lock()
+	defer e.Unlock()
+	return e.preview(true)
+}
+
+func (e *Epub) preview(markdown bool) (string, error) {
+	sections := map[string]*xhtml{}
+	for _, section := range e.sections {
+		sections[section.filename] = section.xhtml
+	}
+
+	var b strings.Builder
+	for _, filename := range e.spine() {
+		x := sections[filename]
+		text, err := previewText(x.xml.Body.XML, markdown)
+		if err != nil {
+			return "", err
+		}
+		if x.Title() == "" && text == "" {
+			continue
+		}
+
+		if b.Len() > 0 {
+			b.WriteString("\n\n")
+		}
+		if x.Title() != "" {
+			if markdown {
+				b.WriteString("# ")
+			}
+			b.WriteString(x.Title())
+			b.WriteString("\n\n")
+		}
+		b.WriteString(text)
+	}
+
+	return b.String(), nil
+}
+
+// Convert the body of an XHTML document to text, or to Markdown if markdown is
+// true
+// Prefix of the names of the meta elements with the word count of a section,
+// see SetEmbedChapterStats
+const chapterWordCountMetaPrefix = "chapter-wordcount:"
+
+// Count the words of the body of a section, i.e. the whitespace separated
+// parts of its text
+func wordCount(body string) (int, error) {
+	text, err := previewText(body, false)
+	if err != nil {
+		return 0, err
+	}
+	return len(strings.Fields(text)), nil
+}
+
+func previewText(body string, markdown bool) (string, error) {
+	d := newHTMLDecoder("<body>" + body + "</body>")
+
+	var lines []string
+	var line strings.Builder
+	// Stack of the lists the current element is in; true for ordered lists
+	var lists []bool
+	var itemNumbers []int
+	pre := false
+
+	endLine := func() {
+		l := line.String()
+		if !pre {
+			l = strings.Join(strings.Fields(l), " ")
+		}
+		if l != "" {
+			lines = append(lines, l)
+		}
+		line.Reset()
+	}
+
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		switch t := t.(type) {
+		case xml.StartElement:
+			name := strings.ToLower(t.Name.Local)
+			if previewBlockElements[name] {
+				endLine()
+			}
+			switch name {
+			case "br":
+				endLine()
+			case "pre":
+				pre = true
+			case "ul", "ol":
+				lists = append(lists, name == "ol")
+				itemNumbers = append(itemNumbers, 0)
+			case "h1", "h2", "h3", "h4", "h5", "h6":
+				if markdown {
+					line.WriteString(strings.Repeat("#", int(name[1]-'0')+1) + " ")
+				}
+			case "li":
+				if markdown && len(lists) > 0 {
+					line.WriteString(strings.Repeat("  ", len(lists)-1))
+					if lists[len(lists)-1] {
+						itemNumbers[len(itemNumbers)-1]++
+						line.WriteString(strconv.Itoa(itemNumbers[len(itemNumbers)-1]) + ". ")
+					} else {
+						line.WriteString("- ")
+					}
+				}
+			case "em", "i":
+				if markdown {
+					line.WriteString("*")
+				}
+			case "strong", "b":
+				if markdown {
+					line.WriteString("**")
+				}
+			}
+		case xml.EndElement:
+			name := strings.ToLower(t.Name.Local)
+			switch name {
+			case "pre":
+				endLine()
+				pre = false
+			case "ul", "ol":
+				if len(lists) > 0 {
+					lists = lists[:len(lists)-1]
+					itemNumbers = itemNumbers[:len(itemNumbers)-1]
+				}
+			case "em", "i":
+				if markdown {
+					line.WriteString("*")
+				}
+			case "strong", "b":
+				if markdown {
+					line.WriteString("**")
+				}
+			}
+			if previewBlockElements[name] {
+				endLine()
+			}
+		case xml.CharData:
+			if pre {
+				for i, l := range strings.Split(string(t), "\n") {
+					if i > 0 {
+						endLine()
+					}
+					line.WriteString(l)
+				}
+			} else {
+				line.WriteString(string(t))
+			}
+		}
+	}
+	endLine()
+
+	separator := "\n"
+	if markdown {
+		// Markdown needs blank lines between paragraphs
+		separator = "\n\n"
+	}
+	return strings.Join(lines, separator), nil
+}
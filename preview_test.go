@@ -0,0 +1,117 @@
+package epub
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bmaupin/go-epub/internal/storage"
+)
+
+const testPreviewSectionBody = `<h2>Part &amp; <em>one</em></h2>
+<p>This is a
+  paragraph.<br />With a line break.</p>
+<ol>
+  <li>First</li>
+  <li><strong>Second</strong></li>
+</ol>`
+
+func TestText(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	e.AddSection(testPreviewSectionBody, testSectionTitle, "", "")
+	e.AddSection("<p>No title</p>", "", "", "")
+
+	text, err := e.Text()
+	if err != nil {
+		t.Errorf("Error getting text: %s", err)
+	}
+	testText := `Section 1
+
+Part & one
+This is a paragraph.
+With a line break.
+First
+Second
+
+No title`
+	if text != testText {
+		t.Errorf(
+			"Text doesn't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			text,
+			testText)
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	e.AddSection(testPreviewSectionBody, testSectionTitle, "", "")
+
+	markdown, err := e.Markdown()
+	if err != nil {
+		t.Errorf("Error getting Markdown: %s", err)
+	}
+	testMarkdown := `# Section 1
+
+### Part & *one*
+
+This is a paragraph.
+
+With a line break.
+
+1. First
+
+2. **Second**`
+	if markdown != testMarkdown {
+		t.Errorf(
+			"Markdown doesn't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			markdown,
+			testMarkdown)
+	}
+
+	e.AddSection("<p class=\"unterminated></p>", "", "", "")
+	if _, err := e.Markdown(); err == nil {
+		t.Errorf("Expected error for invalid XHTML")
+	}
+}
+
+func TestSetEmbedChapterStats(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	testImagePath, _ := e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+	e.SetCover(testImagePath, "")
+	e.AddSection("<h1>Chapter 1</h1><p>One two <em>three</em> four.</p>", "Chapter 1", "", "")
+	e.AddSection("<p>Five six</p>", "Chapter 2", "chapter2.xhtml", "")
+	e.SetEmbedChapterStats(true)
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	for _, testElement := range []string{
+		`<meta name="chapter-wordcount:section0001" content="6"></meta>`,
+		`<meta name="chapter-wordcount:chapter2" content="2"></meta>`,
+	} {
+		if !strings.Contains(string(pkgFileContent), testElement) {
+			t.Errorf(
+				"Package file doesn't contain the expected element\n"+
+					"Got: %s\n"+
+					"Expected: %s",
+				pkgFileContent,
+				testElement)
+		}
+	}
+	if strings.Contains(string(pkgFileContent), "chapter-wordcount:cover") {
+		t.Errorf("Package file shouldn't contain the word count of the cover\nGot: %s", pkgFileContent)
+	}
+	// The counts are only added to the written package file
+	if len(e.Pkg.xml.Metadata.Meta) != len(NewEpub(testEpubTitle).Pkg.xml.Metadata.Meta)+1 {
+		t.Errorf("Word counts shouldn't be kept in the package file, got %v", e.Pkg.xml.Metadata.Meta)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
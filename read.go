@@ -0,0 +1,688 @@
+package epub
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/vincent-petithory/dataurl"
+)
+
+// UnableToOpenEpubError is thrown by Open if the EPUB file can't be read or
+// isn't a valid EPUB file.
+type UnableToOpenEpubError struct {
+	Path string // The path that was given to Open
+	Err  error  // The underlying error that was thrown
+}
+
+func (e *UnableToOpenEpubError) Error() string {
+	return fmt.Sprintf("Error opening EPUB at %q: %+v", e.Path, e.Err)
+}
+
+const (
+	containerFilePath   = "META-INF/container.xml"
+	encryptionFilePath  = "META-INF/encryption.xml"
+	mediaTypePackage    = "application/oebps-package+xml"
+	navItemProperty     = "nav"
+	coverImageProperty  = "cover-image"
+	stylesheetLinkRel   = "stylesheet"
+	tocNavEpubTypeLocal = "type"
+)
+
+// The container file (META-INF/container.xml) of an opened EPUB, which points
+// to the package file
+type openContainer struct {
+	Rootfiles []struct {
+		FullPath  string `xml:"full-path,attr"`
+		MediaType string `xml:"media-type,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+// The encryption file (META-INF/encryption.xml) of an opened EPUB
+type openEncryption struct {
+	EncryptedData []struct {
+		EncryptionMethod struct {
+			Algorithm string `xml:"Algorithm,attr"`
+		} `xml:"EncryptionMethod"`
+		CipherReference struct {
+			URI string `xml:"URI,attr"`
+		} `xml:"CipherData>CipherReference"`
+	} `xml:"EncryptedData"`
+}
+
+// The package file of an opened EPUB. The elements in the Dublin Core namespace
+// need their namespace to be read, unlike when they're written
+type openPkgRoot struct {
+	UniqueIdentifier string          `xml:"unique-identifier,attr"`
+	Prefix           string          `xml:"prefix,attr"`
+	Metadata         openPkgMetadata `xml:"metadata"`
+	ManifestItems    []PkgItem       `xml:"manifest>item"`
+	Spine            PkgSpine        `xml:"spine"`
+}
+
+type openPkgMetadata struct {
+	Identifier  []PkgIdentifier  `xml:"http://purl.org/dc/elements/1.1/ identifier"`
+	Title       []openPkgElement `xml:"http://purl.org/dc/elements/1.1/ title"`
+	Language    []string         `xml:"http://purl.org/dc/elements/1.1/ language"`
+	Description string           `xml:"http://purl.org/dc/elements/1.1/ description"`
+	Publisher   string           `xml:"http://purl.org/dc/elements/1.1/ publisher"`
+	Source      string           `xml:"http://purl.org/dc/elements/1.1/ source"`
+	Rights      string           `xml:"http://purl.org/dc/elements/1.1/ rights"`
+	Coverage    string           `xml:"http://purl.org/dc/elements/1.1/ coverage"`
+	Relation    string           `xml:"http://purl.org/dc/elements/1.1/ relation"`
+	Date        string           `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Subject     []string         `xml:"http://purl.org/dc/elements/1.1/ subject"`
+	Creator     []openPkgElement `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Contributor []openPkgElement `xml:"http://purl.org/dc/elements/1.1/ contributor"`
+	Meta        []PkgMeta        `xml:"meta"`
+	Link        []PkgLink        `xml:"link"`
+}
+
+// A Dublin Core element which may have an ID and a language
+type openPkgElement struct {
+	ID   string `xml:"id,attr"`
+	Lang string `xml:"http://www.w3.org/XML/1998/namespace lang,attr"`
+	Data string `xml:",chardata"`
+}
+
+// A section of an opened EPUB
+type openXhtml struct {
+	Head struct {
+		Title   string       `xml:"title"`
+		Links   []xhtmlLink  `xml:"link"`
+		Scripts []openScript `xml:"script"`
+	} `xml:"head"`
+	Body struct {
+		XML string `xml:",innerxml"`
+	} `xml:"body"`
+}
+
+// A script in the head of a section of an opened EPUB, either linked or inline
+type openScript struct {
+	Src  string `xml:"src,attr"`
+	Type string `xml:"type,attr"`
+	Data string `xml:",chardata"`
+}
+
+// Get the markup of the script with the given source, which replaces the
+// original one
+func (s openScript) markup(src string) string {
+	markup := "<script"
+	if s.Type != "" {
+		markup += ` type="` + html.EscapeString(s.Type) + `"`
+	}
+	if src != "" {
+		markup += ` src="` + html.EscapeString(src) + `"`
+	}
+	var data strings.Builder
+	xml.EscapeText(&data, []byte(s.Data))
+	return markup + ">" + data.String() + "</script>"
+}
+
+// The EPUB v2 TOC file of an opened EPUB
+type openNcx struct {
+	NavMap []tocNcxNavPoint `xml:"navMap>navPoint"`
+}
+
+// Open reads an existing EPUB file so it can be inspected, e.g. with
+// Pkg.Metadata and Sections, or changed and written again with Write.
+//
+// The metadata of the package file is kept as is, except for the modification
+// date, which is set when the EPUB is written again. The sections are added in
+// the reading order with their titles (and nesting) from the table of contents,
+// along with the first stylesheet linked from each section and the scripts in
+// its head. All other files in the manifest are added as CSS files, fonts,
+// images, videos, audio files, scripts, or other media depending on their media
+// type, and the links to them in the sections are rewritten to their new paths. Links with a fragment (e.g.
+// section0001.xhtml#note1) are only kept working if the file wasn't renamed.
+// Fonts obfuscated with the IDPF font obfuscation algorithm are restored and
+// obfuscated again when the EPUB is written, like with AddObfuscatedFont.
+//
+// If the file can't be read or isn't a valid EPUB file, UnableToOpenEpubError
+// will be returned.
+func Open(epubPath string) (*Epub, error) {
+	r, err := zip.OpenReader(epubPath)
+	if err != nil {
+		return nil, &UnableToOpenEpubError{Path: epubPath, Err: err}
+	}
+	defer r.Close()
+
+	e, err := openEpub(&r.Reader)
+	if err != nil {
+		return nil, &UnableToOpenEpubError{Path: epubPath, Err: err}
+	}
+
+	return e, nil
+}
+
+// Sections returns the internal filenames (as returned by AddSection) of the
+// sections of the EPUB in the reading order, including the covers.
+func (e *Epub) Sections() []string {
+	e.Lock()
+	defer e.Unlock()
+	return e.spine()
+}
+
+func openEpub(z *zip.Reader) (*Epub, error) {
+	files := map[string]*zip.File{}
+	for _, f := range z.File {
+		files[f.Name] = f
+	}
+	readFile := func(name string) ([]byte, error) {
+		f, ok := files[name]
+		if !ok {
+			return nil, fmt.Errorf("file not found: %s", name)
+		}
+		r, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	}
+
+	// Find the package file
+	content, err := readFile(containerFilePath)
+	if err != nil {
+		return nil, err
+	}
+	container := &openContainer{}
+	if err := xml.Unmarshal(content, container); err != nil {
+		return nil, fmt.Errorf("unable to parse container file: %w", err)
+	}
+	pkgPath := ""
+	for _, rootfile := range container.Rootfiles {
+		if rootfile.MediaType == mediaTypePackage || pkgPath == "" {
+			pkgPath = rootfile.FullPath
+		}
+	}
+	if pkgPath == "" {
+		return nil, errors.New("no package file found in container file")
+	}
+
+	content, err = readFile(pkgPath)
+	if err != nil {
+		return nil, err
+	}
+	pkg := &openPkgRoot{}
+	if err := xml.Unmarshal(content, pkg); err != nil {
+		return nil, fmt.Errorf("unable to parse package file: %w", err)
+	}
+	pkgDir := path.Dir(pkgPath)
+
+	title := ""
+	if len(pkg.Metadata.Title) > 0 {
+		title = pkg.Metadata.Title[0].Data
+	}
+	e := NewEpub(title)
+	e.openMetadata(pkg)
+
+	// Full paths of the files in the EPUB, by manifest ID
+	itemPaths := map[string]string{}
+	// Whether the files are scripted, by their full path
+	scripted := map[string]bool{}
+	for _, item := range pkg.ManifestItems {
+		href, err := url.PathUnescape(item.Href)
+		if err != nil {
+			href = item.Href
+		}
+		itemPaths[item.ID] = path.Join(pkgDir, href)
+		scripted[itemPaths[item.ID]] = hasProperty(item.Properties, scriptedProperty)
+	}
+
+	// Full paths of the fonts obfuscated with the IDPF algorithm
+	obfuscated := map[string]bool{}
+	if _, ok := files[encryptionFilePath]; ok {
+		content, err := readFile(encryptionFilePath)
+		if err != nil {
+			return nil, err
+		}
+		encryption := &openEncryption{}
+		if err := xml.Unmarshal(content, encryption); err != nil {
+			return nil, fmt.Errorf("unable to parse encryption file: %w", err)
+		}
+		for _, data := range encryption.EncryptedData {
+			if data.EncryptionMethod.Algorithm != fontObfuscationAlgorithm {
+				continue
+			}
+			uri, err := url.PathUnescape(data.CipherReference.URI)
+			if err != nil {
+				uri = data.CipherReference.URI
+			}
+			obfuscated[path.Clean(uri)] = true
+		}
+	}
+
+	// New paths of the files, relative to the sections, by their full path in
+	// the opened EPUB
+	newPaths := map[string]string{}
+
+	// Add everything but the sections and TOC files
+	var navPath, ncxPath string
+	for _, item := range pkg.ManifestItems {
+		itemPath := itemPaths[item.ID]
+		switch {
+		case hasProperty(item.Properties, navItemProperty):
+			navPath = itemPath
+			continue
+		case item.MediaType == mediaTypeNcx:
+			ncxPath = itemPath
+			continue
+		case item.MediaType == mediaTypeXhtml || isRemoteResource(item.Href):
+			continue
+		}
+
+		content, err := readFile(itemPath)
+		if err != nil {
+			return nil, err
+		}
+		if obfuscated[itemPath] {
+			content = obfuscateFont(content, e.Pkg.uniqueIdentifier())
+		}
+		source := openDataURL(content, item.MediaType)
+		mediaFolderName, mediaFileFormat, mediaMap := e.openMediaMap(item.MediaType)
+		filename := openFilename(path.Base(itemPath), mediaFileFormat, mediaMap)
+		mediaMap[filename] = source
+		if obfuscated[itemPath] && mediaFolderName == FontFolderName {
+			e.obfuscatedFonts[filename] = true
+		}
+		e.recordMedia(mediaFolderName, filename)
+		newPaths[itemPath] = path.Join("..", mediaFolderName, filename)
+
+		if mediaFolderName == ImageFolderName && hasProperty(item.Properties, coverImageProperty) {
+			e.cover.imageFilename = filename
+			e.Pkg.SetCover(filename)
+		}
+	}
+
+	// Get the titles of the sections from the table of contents
+	var titles, parents map[string]string
+	if navPath != "" {
+		content, err := readFile(navPath)
+		if err != nil {
+			return nil, err
+		}
+		titles, parents, err = openNavTitles(content, path.Dir(navPath))
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse nav file: %w", err)
+		}
+	} else if ncxPath != "" {
+		content, err := readFile(ncxPath)
+		if err != nil {
+			return nil, err
+		}
+		titles, parents, err = openNcxTitles(content, path.Dir(ncxPath))
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse NCX file: %w", err)
+		}
+	}
+
+	// Add the sections in the reading order, followed by any sections which
+	// aren't in the spine
+	sectionPaths := []string{}
+	inSpine := map[string]bool{}
+	nonLinear := map[string]bool{}
+	for _, itemref := range pkg.Spine.Items {
+		if itemPath, ok := itemPaths[itemref.Idref]; ok && !inSpine[itemPath] {
+			sectionPaths = append(sectionPaths, itemPath)
+			inSpine[itemPath] = true
+			nonLinear[itemPath] = itemref.Linear == spineLinearNo
+		}
+	}
+	for _, item := range pkg.ManifestItems {
+		itemPath := itemPaths[item.ID]
+		if item.MediaType == mediaTypeXhtml && itemPath != navPath && !inSpine[itemPath] && !isRemoteResource(item.Href) {
+			sectionPaths = append(sectionPaths, itemPath)
+		}
+	}
+
+	sections := make([]*openXhtml, len(sectionPaths))
+	for i, sectionPath := range sectionPaths {
+		content, err := readFile(sectionPath)
+		if err != nil {
+			return nil, err
+		}
+		sections[i] = &openXhtml{}
+		if err := newHTMLDecoder(string(content)).Decode(sections[i]); err != nil {
+			return nil, fmt.Errorf("unable to parse section %s: %w", sectionPath, err)
+		}
+
+		sectionTitle := sections[i].Head.Title
+		if titles != nil {
+			sectionTitle = titles[sectionPath]
+		}
+		filename, err := e.addSection("", sectionTitle, path.Base(sectionPath), "")
+		if _, ok := err.(*FilenameAlreadyUsedError); ok {
+			filename, err = e.addSection("", sectionTitle, "", "")
+		}
+		if err != nil {
+			return nil, err
+		}
+		e.sections[len(e.sections)-1].nonLinear = nonLinear[sectionPath]
+		if scripted[sectionPath] {
+			e.sections[len(e.sections)-1].properties = scriptedProperty
+		}
+		newPaths[sectionPath] = filename
+	}
+
+	// Now that the new paths of all files are known, set the bodies of the
+	// sections with the links rewritten to the new paths
+	for i, sectionPath := range sectionPaths {
+		sectionDir := path.Dir(sectionPath)
+		mapping := map[string]string{}
+		for oldPath, newPath := range newPaths {
+			if relativePath, err := filepath.Rel(filepath.FromSlash(sectionDir), filepath.FromSlash(oldPath)); err == nil {
+				mapping[filepath.ToSlash(relativePath)] = newPath
+			}
+		}
+		body, err := rewriteResourceLinks(sections[i].Body.XML, mapping)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse section %s: %w", sectionPath, err)
+		}
+
+		section := &e.sections[i]
+		section.xhtml.setBody(strings.TrimSpace(body))
+		cssPaths := []string{}
+		for _, link := range sections[i].Head.Links {
+			if link.Rel != stylesheetLinkRel {
+				continue
+			}
+			if cssPath, ok := newPaths[openResolve(link.Href, sectionDir)]; ok {
+				cssPaths = append(cssPaths, cssPath)
+			}
+		}
+		section.xhtml.setCSS(cssPaths...)
+		// Scripts are kept since the section may be scripted
+		for _, script := range sections[i].Head.Scripts {
+			src := script.Src
+			if scriptPath, ok := newPaths[openResolve(src, sectionDir)]; ok {
+				src = scriptPath
+			}
+			section.xhtml.appendHead(script.markup(src))
+		}
+		if parent, ok := newPaths[parents[sectionPath]]; ok {
+			section.parent = parent
+		}
+	}
+
+	return e, nil
+}
+
+// Copy the metadata of an opened package file to the EPUB
+func (e *Epub) openMetadata(pkg *openPkgRoot) {
+	metadata := &e.Pkg.xml.Metadata
+	if len(pkg.Metadata.Identifier) > 0 {
+		metadata.Identifier = pkg.Metadata.Identifier
+		for i := range metadata.Identifier {
+			if metadata.Identifier[i].ID == "" {
+				metadata.Identifier[i].ID = fmt.Sprintf("%s%d", pkgIdentifierID, i)
+			}
+		}
+		if pkg.UniqueIdentifier != "" {
+			e.Pkg.xml.UniqueIdentifier = pkg.UniqueIdentifier
+		}
+		// Drop the generated identifier along with its metadata
+		metadata.Meta = nil
+	}
+	if len(pkg.Metadata.Title) > 0 {
+		metadata.TitleID = pkg.Metadata.Title[0].ID
+		metadata.TitleLang = pkg.Metadata.Title[0].Lang
+		metadata.Titles = nil
+		for _, title := range pkg.Metadata.Title[1:] {
+			metadata.Titles = append(metadata.Titles, PkgTitle{ID: title.ID, Lang: title.Lang, Data: title.Data})
+		}
+	}
+	if len(pkg.Metadata.Language) > 0 {
+		metadata.Language = pkg.Metadata.Language[0]
+	}
+	metadata.Description = pkg.Metadata.Description
+	metadata.Publisher = pkg.Metadata.Publisher
+	metadata.Source = pkg.Metadata.Source
+	metadata.Rights = pkg.Metadata.Rights
+	metadata.Coverage = pkg.Metadata.Coverage
+	metadata.Relation = pkg.Metadata.Relation
+	metadata.Date = pkg.Metadata.Date
+	metadata.Subject = pkg.Metadata.Subject
+	for i, creator := range pkg.Metadata.Creator {
+		if creator.ID == "" {
+			creator.ID = fmt.Sprintf("%s%d", pkgCreatorID, i)
+		}
+		metadata.Creator = append(metadata.Creator, PkgCreator{ID: creator.ID, Lang: creator.Lang, Data: creator.Data})
+	}
+	for i, contributor := range pkg.Metadata.Contributor {
+		if contributor.ID == "" {
+			contributor.ID = fmt.Sprintf("%s%d", pkgContributorID, i)
+		}
+		metadata.Contributor = append(metadata.Contributor, PkgContributor{ID: contributor.ID, Lang: contributor.Lang, Data: contributor.Data})
+	}
+	for _, meta := range pkg.Metadata.Meta {
+		// The modification date is set when the EPUB is written, and the EPUB 2
+		// cover meta element is set again if the cover image is found
+		if meta.Property == PropertyModified || meta.Name == "cover" {
+			continue
+		}
+		metadata.Meta = append(metadata.Meta, meta)
+	}
+	// Links to files in the EPUB would point to the wrong path
+	for _, link := range pkg.Metadata.Link {
+		if isRemoteResource(link.Href) {
+			metadata.Link = append(metadata.Link, link)
+		}
+	}
+
+	e.Pkg.xml.Prefix = pkg.Prefix
+	e.Pkg.xml.Spine.Ppd = pkg.Spine.Ppd
+	e.toc.setTitle(e.Pkg.title())
+}
+
+// Get the folder, filename format, and map of the EPUB to add a file of an
+// opened EPUB to, depending on its media type
+func (e *Epub) openMediaMap(mediaType string) (string, string, map[string]string) {
+	switch {
+	case mediaType == mediaTypeCSS:
+		return CSSFolderName, cssFileFormat, e.css
+	case strings.HasPrefix(mediaType, "font/") || legacyFontMediaTypes[mediaType] != "":
+		return FontFolderName, fontFileFormat, e.fonts
+	case strings.HasPrefix(mediaType, "image/"):
+		return ImageFolderName, imageFileFormat, e.images
+	case strings.HasPrefix(mediaType, "video/"):
+		return VideoFolderName, videoFileFormat, e.videos
+	case strings.HasPrefix(mediaType, "audio/"):
+		return AudioFolderName, audioFileFormat, e.audios
+	case javaScriptMediaTypes[mediaType]:
+		return ScriptFolderName, scriptFileFormat, e.scripts
+	default:
+		return MediaFolderName, mediaFileFormat, e.media
+	}
+}
+
+// Get a data URL with the content of a file of an opened EPUB. The data URL
+// package rejects some media types (e.g. font/ttf), so the content is stored as
+// binary data then; the media type is detected again when the EPUB is written.
+func openDataURL(content []byte, mediaType string) string {
+	if _, err := dataurl.DecodeString(dataurl.New(nil, mediaType).String()); err != nil {
+		mediaType = "application/octet-stream"
+	}
+	return dataurl.New(content, mediaType).String()
+}
+
+// Get a filename for a file of an opened EPUB, keeping its filename unless it's
+// already used
+func openFilename(filename string, mediaFileFormat string, mediaMap map[string]string) string {
+	if _, ok := mediaMap[filename]; !ok {
+		return filename
+	}
+	for i := len(mediaMap) + 1; ; i++ {
+		generated := fmt.Sprintf(mediaFileFormat, i, strings.ToLower(path.Ext(filename)))
+		if _, ok := mediaMap[generated]; !ok {
+			return generated
+		}
+	}
+}
+
+// Get the full path of the file a link in an opened EPUB points to, without
+// the fragment, or an empty string for remote links
+func openResolve(href string, dir string) string {
+	if isRemoteResource(href) {
+		return ""
+	}
+	if i := strings.Index(href, "#"); i != -1 {
+		href = href[:i]
+	}
+	if unescaped, err := url.PathUnescape(href); err == nil {
+		href = unescaped
+	}
+	if href == "" {
+		return ""
+	}
+	return path.Join(dir, href)
+}
+
+// Get the titles of the sections from the EPUB v3 TOC file (nav.xhtml) of an
+// opened EPUB, along with the section each section is nested under, by their
+// full paths
+func openNavTitles(content []byte, navDir string) (map[string]string, map[string]string, error) {
+	titles := map[string]string{}
+	parents := map[string]string{}
+
+	d := newHTMLDecoder(string(content))
+	inToc := false
+	// Targets of the links of the enclosing list items
+	targets := []string{}
+	var label *strings.Builder
+	target := ""
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch t := t.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "nav" {
+				for _, attr := range t.Attr {
+					if attr.Name.Local == tocNavEpubTypeLocal && attr.Value == tocNavEpubType {
+						inToc = true
+					}
+				}
+			}
+			if !inToc {
+				continue
+			}
+			switch t.Name.Local {
+			case "li":
+				targets = append(targets, "")
+			case "a":
+				target = ""
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "href" {
+						target = openResolve(attr.Value, navDir)
+					}
+				}
+				label = &strings.Builder{}
+			}
+		case xml.CharData:
+			if label != nil {
+				label.Write(t)
+			}
+		case xml.EndElement:
+			if !inToc {
+				continue
+			}
+			switch t.Name.Local {
+			case "nav":
+				inToc = false
+			case "a":
+				if _, ok := titles[target]; target != "" && !ok && label != nil {
+					titles[target] = strings.Join(strings.Fields(label.String()), " ")
+					for i := len(targets) - 2; i >= 0; i-- {
+						if targets[i] != "" {
+							parents[target] = targets[i]
+							break
+						}
+					}
+					if len(targets) > 0 {
+						targets[len(targets)-1] = target
+					}
+				}
+				label = nil
+			case "li":
+				if len(targets) > 0 {
+					targets = targets[:len(targets)-1]
+				}
+			}
+		}
+	}
+
+	return titles, parents, nil
+}
+
+// Get the titles of the sections from the EPUB v2 TOC file (toc.ncx) of an
+// opened EPUB, along with the section each section is nested under, by their
+// full paths
+func openNcxTitles(content []byte, ncxDir string) (map[string]string, map[string]string, error) {
+	ncx := &openNcx{}
+	if err := xml.Unmarshal(content, ncx); err != nil {
+		return nil, nil, err
+	}
+
+	titles := map[string]string{}
+	parents := map[string]string{}
+	var addNavPoints func(navPoints []tocNcxNavPoint, parent string)
+	addNavPoints = func(navPoints []tocNcxNavPoint, parent string) {
+		for _, navPoint := range navPoints {
+			target := openResolve(navPoint.Content.Src, ncxDir)
+			if _, ok := titles[target]; target != "" && !ok {
+				titles[target] = strings.TrimSpace(navPoint.Text)
+				if parent != "" {
+					parents[target] = parent
+				}
+			}
+			addNavPoints(navPoint.Children, target)
+		}
+	}
+	addNavPoints(ncx.NavMap, "")
+
+	return titles, parents, nil
+}
+
+// Check whether a space separated list of properties contains a property
+func hasProperty(properties string, property string) bool {
+	for _, p := range strings.Fields(properties) {
+		if p == property {
+			return true
+		}
+	}
+	return false
+}
+
+// Add a property to a space separated list of properties, unless it's already
+// there
+func addProperty(properties string, property string) string {
+	if hasProperty(properties, property) {
+		return properties
+	}
+	return strings.TrimSpace(properties + " " + property)
+}
+
+// Remove a property from a space separated list of properties
+func removeProperty(properties string, property string) string {
+	kept := []string{}
+	for _, p := range strings.Fields(properties) {
+		if p != property {
+			kept = append(kept, p)
+		}
+	}
+	return strings.Join(kept, " ")
+}
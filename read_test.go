@@ -0,0 +1,263 @@
+package epub
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bmaupin/go-epub/internal/storage"
+	"github.com/vincent-petithory/dataurl"
+)
+
+func TestOpen(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	e.Pkg.AddCreator(testEpubAuthor, PropertyRoleAuthor)
+	e.Pkg.SetLang(testEpubLang)
+	e.Pkg.SetDescription(testEpubDescription)
+	e.Pkg.SetRights(testEpubRights)
+	cssPath, err := e.AddCSS(testCoverCSSSource, "")
+	if err != nil {
+		t.Fatalf("Error adding CSS: %s", err)
+	}
+	imagePath, err := e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+	if err != nil {
+		t.Fatalf("Error adding image: %s", err)
+	}
+	_, err = e.AddSection(`<h1>Section 1</h1><img src="`+imagePath+`" alt="Gopher"/>`, "Section 1", "", cssPath)
+	if err != nil {
+		t.Fatalf("Error adding section: %s", err)
+	}
+	_, err = e.AddSubSection(testSectionFilename, "<h1>Section 1.1</h1>", "Section 1.1", "", "")
+	if err != nil {
+		t.Fatalf("Error adding subsection: %s", err)
+	}
+	_, err = e.AddSection("<h1>Section 2</h1>", "Section 2", "", "")
+	if err != nil {
+		t.Fatalf("Error adding section: %s", err)
+	}
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	cleanup("", tempDir)
+	defer os.Remove(testEpubFilename)
+
+	opened, err := Open(testEpubFilename)
+	if err != nil {
+		t.Fatalf("Error opening EPUB: %s", err)
+	}
+
+	metadata := opened.Pkg.Metadata()
+	if metadata.Title != testEpubTitle {
+		t.Errorf("Title doesn't match\nGot: %s\nExpected: %s", metadata.Title, testEpubTitle)
+	}
+	if metadata.Creator[0].Data != testEpubAuthor {
+		t.Errorf("Author doesn't match\nGot: %s\nExpected: %s", metadata.Creator[0].Data, testEpubAuthor)
+	}
+	if metadata.Language != testEpubLang {
+		t.Errorf("Language doesn't match\nGot: %s\nExpected: %s", metadata.Language, testEpubLang)
+	}
+	if metadata.Rights != testEpubRights {
+		t.Errorf("Rights don't match\nGot: %s\nExpected: %s", metadata.Rights, testEpubRights)
+	}
+	identifier := e.Pkg.Metadata().Identifier[0].Data
+	if metadata.Identifier[0].Data != identifier {
+		t.Errorf("Identifier doesn't match\nGot: %s\nExpected: %s", metadata.Identifier[0].Data, identifier)
+	}
+
+	expectedSections := []string{"section0001.xhtml", "section0002.xhtml", "section0003.xhtml"}
+	if sections := opened.Sections(); !reflect.DeepEqual(sections, expectedSections) {
+		t.Errorf("Sections don't match\nGot: %v\nExpected: %v", sections, expectedSections)
+	}
+	if opened.sections[1].parent != testSectionFilename {
+		t.Errorf("Parent of the subsection doesn't match\nGot: %s\nExpected: %s", opened.sections[1].parent, testSectionFilename)
+	}
+	if opened.sections[0].xhtml.Title() != "Section 1" {
+		t.Errorf("Section title doesn't match\nGot: %s\nExpected: %s", opened.sections[0].xhtml.Title(), "Section 1")
+	}
+
+	// Write the opened EPUB again to make sure the sections still link to
+	// the CSS and image
+	tempDir = writeAndExtractEpub(t, opened, testEpubFilename)
+	defer cleanup("", tempDir)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, testSectionFilename))
+	if err != nil {
+		t.Fatalf("Unexpected error reading section file: %s", err)
+	}
+	for _, expected := range []string{
+		`src="` + imagePath + `"`,
+		`href="` + cssPath + `"`,
+	} {
+		if !strings.Contains(string(contents), expected) {
+			t.Errorf("Section doesn't contain %s\nGot: %s", expected, contents)
+		}
+	}
+	_, err = storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, ImageFolderName, testImageFromFileFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading image file: %s", err)
+	}
+}
+
+func TestOpenFont(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	fontPath, err := e.AddFont(testFontFromFileSource, "")
+	if err != nil {
+		t.Fatalf("Error adding font: %s", err)
+	}
+	e.AddSection(testSectionBody, testSectionTitle, testSectionFilename, "")
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	cleanup("", tempDir)
+	defer os.Remove(testEpubFilename)
+
+	opened, err := Open(testEpubFilename)
+	if err != nil {
+		t.Fatalf("Error opening EPUB: %s", err)
+	}
+
+	// Write the opened EPUB again to make sure the font can still be read
+	tempDir = writeAndExtractEpub(t, opened, testEpubFilename)
+	defer cleanup("", tempDir)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, FontFolderName, filepath.Base(fontPath)))
+	if err != nil {
+		t.Fatalf("Unexpected error reading font file: %s", err)
+	}
+	expected, err := ioutil.ReadFile(testFontFromFileSource)
+	if err != nil {
+		t.Fatalf("Unexpected error reading font: %s", err)
+	}
+	if !bytes.Equal(contents, expected) {
+		t.Error("Font file doesn't match the added font")
+	}
+
+	contents, err = storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Fatalf("Unexpected error reading package file: %s", err)
+	}
+	testManifestItem := `media-type="` + mediaTypeTTF + `"`
+	if !strings.Contains(string(contents), testManifestItem) {
+		t.Errorf(
+			"Package file doesn't contain the font media type\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			contents,
+			testManifestItem)
+	}
+}
+
+func TestOpenScript(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	scriptPath, err := e.AddJavaScript(dataurl.EncodeBytes([]byte(`document.body.className = "js";`)), "main.js")
+	if err != nil {
+		t.Fatalf("Error adding script: %s", err)
+	}
+	s, err := e.AddSectionHandle(testSectionBody, testSectionTitle, testSectionFilename, "")
+	if err != nil {
+		t.Fatalf("Error adding section: %s", err)
+	}
+	s.AppendHead(`<script src="` + scriptPath + `"></script>`)
+	s.AppendHead(`<script>if (a &lt; b) { init(); }</script>`)
+	e.SetSectionScripted(testSectionFilename, true)
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	cleanup("", tempDir)
+	defer os.Remove(testEpubFilename)
+
+	opened, err := Open(testEpubFilename)
+	if err != nil {
+		t.Fatalf("Error opening EPUB: %s", err)
+	}
+
+	tempDir = writeAndExtractEpub(t, opened, testEpubFilename)
+	defer cleanup("", tempDir)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, testSectionFilename))
+	if err != nil {
+		t.Fatalf("Unexpected error reading section file: %s", err)
+	}
+	for _, testScript := range []string{
+		`<script src="../scripts/main.js"></script>`,
+		`<script>if (a &lt; b) { init(); }</script>`,
+	} {
+		if !strings.Contains(string(contents), testScript) {
+			t.Errorf(
+				"Section doesn't contain the script\n"+
+					"Got: %s\n"+
+					"Expected: %s",
+				contents,
+				testScript)
+		}
+	}
+
+	contents, err = storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Fatalf("Unexpected error reading package file: %s", err)
+	}
+	testManifestItem := `href="xhtml/` + testSectionFilename + `" media-type="application/xhtml+xml" properties="scripted"`
+	if !strings.Contains(string(contents), testManifestItem) {
+		t.Errorf(
+			"Package file doesn't declare the section as scripted\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			contents,
+			testManifestItem)
+	}
+}
+
+func TestOpenObfuscatedFont(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	_, err := e.AddObfuscatedFont(testFontFromFileSource, "font.ttf")
+	if err != nil {
+		t.Fatalf("Error adding font: %s", err)
+	}
+	e.AddSection(testSectionBody, testSectionTitle, testSectionFilename, "")
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	cleanup("", tempDir)
+	defer os.Remove(testEpubFilename)
+
+	opened, err := Open(testEpubFilename)
+	if err != nil {
+		t.Fatalf("Error opening EPUB: %s", err)
+	}
+	if !opened.obfuscatedFonts["font.ttf"] {
+		t.Error("Font of the opened EPUB should be obfuscated")
+	}
+
+	// Write the opened EPUB again to make sure the font is obfuscated once
+	tempDir = writeAndExtractEpub(t, opened, testEpubFilename)
+	defer cleanup("", tempDir)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, FontFolderName, "font.ttf"))
+	if err != nil {
+		t.Fatalf("Unexpected error reading font file: %s", err)
+	}
+	font, err := ioutil.ReadFile(testFontFromFileSource)
+	if err != nil {
+		t.Fatalf("Unexpected error reading font: %s", err)
+	}
+	if !bytes.Equal(obfuscateFont(contents, opened.Pkg.uniqueIdentifier()), font) {
+		t.Error("Font file isn't the obfuscated font")
+	}
+
+	encryptionFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, metaInfFolderName, encryptionFilename))
+	if err != nil {
+		t.Fatalf("Unexpected error reading encryption file: %s", err)
+	}
+	testCipherReference := `<enc:CipherReference URI="EPUB/fonts/font.ttf"></enc:CipherReference>`
+	if !strings.Contains(string(encryptionFileContent), testCipherReference) {
+		t.Errorf(
+			"Encryption file doesn't contain obfuscated font\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			encryptionFileContent,
+			testCipherReference)
+	}
+}
+
+func TestOpenInvalidEpub(t *testing.T) {
+	_, err := Open(testImageFromFileSource)
+	if _, ok := err.(*UnableToOpenEpubError); !ok {
+		t.Errorf("Expected error UnableToOpenEpubError, got: %v", err)
+	}
+}
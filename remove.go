@@ -0,0 +1,147 @@
+package epub
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ResourceInUseError is thrown by RemoveCSS, RemoveFont, RemoveImage, or
+// RemoveVideo if the file is still used by the EPUB, e.g. by the cover or a
+// section, so removing it would leave a broken reference.
+type ResourceInUseError struct {
+	Path   string   // The internal path of the file that couldn't be removed
+	UsedBy []string // What the file is used by, e.g. "cover" or a section filename
+}
+
+func (e *ResourceInUseError) Error() string {
+	return fmt.Sprintf("Resource %s is still used by %s", e.Path, strings.Join(e.UsedBy, ", "))
+}
+
+// RemoveCSS removes a CSS file added with AddCSS (or a similar method), given
+// its internal path as returned by AddCSS. If no such file exists,
+// FilenameNotFoundError will be returned. If the file is still used by the
+// cover or a section, or is a global stylesheet (see AddGlobalCSS),
+// ResourceInUseError will be returned and the file isn't removed.
+func (e *Epub) RemoveCSS(internalPath string) error {
+	e.Lock()
+	defer e.Unlock()
+	return e.removeMedia(internalPath, CSSFolderName, e.css)
+}
+
+// RemoveFont removes a font added with AddFont (or a similar method), given its
+// internal path as returned by AddFont. If no such font exists,
+// FilenameNotFoundError will be returned. If the font is still referenced by a
+// section, ResourceInUseError will be returned and the font isn't removed.
+// References from CSS files aren't checked.
+func (e *Epub) RemoveFont(internalPath string) error {
+	e.Lock()
+	defer e.Unlock()
+	return e.removeMedia(internalPath, FontFolderName, e.fonts)
+}
+
+// RemoveImage removes an image added with AddImage (or a similar method), given
+// its internal path as returned by AddImage. If no such image exists,
+// FilenameNotFoundError will be returned. If the image is still used by the
+// cover, the back cover, the cover thumbnail, the publisher logo, an entry of
+// the table of contents, or a section, ResourceInUseError will be returned and
+// the image isn't removed. References from CSS files aren't checked.
+func (e *Epub) RemoveImage(internalPath string) error {
+	e.Lock()
+	defer e.Unlock()
+	return e.removeMedia(internalPath, ImageFolderName, e.images)
+}
+
+// RemoveVideo removes a video added with AddVideo, given its internal path as
+// returned by AddVideo. If no such video exists, FilenameNotFoundError will be
+// returned. If the video is still used by the cover or a section,
+// ResourceInUseError will be returned and the video isn't removed.
+func (e *Epub) RemoveVideo(internalPath string) error {
+	e.Lock()
+	defer e.Unlock()
+	return e.removeMedia(internalPath, VideoFolderName, e.videos)
+}
+
+// Remove a media file unless it's still used
+func (e *Epub) removeMedia(internalPath string, mediaFolderName string, mediaMap map[string]string) error {
+	filename := path.Base(internalPath)
+	if _, ok := mediaMap[filename]; !ok || internalPath != path.Join("..", mediaFolderName, filename) {
+		return &FilenameNotFoundError{Filename: internalPath}
+	}
+	if usedBy := e.mediaUsers(internalPath, mediaFolderName, filename); len(usedBy) > 0 {
+		return &ResourceInUseError{Path: internalPath, UsedBy: usedBy}
+	}
+
+	delete(mediaMap, filename)
+	mediaPath := path.Join(mediaFolderName, filename)
+	delete(e.mediaSequence, mediaPath)
+	delete(e.manifestOrder, mediaPath)
+	if mediaFolderName == FontFolderName {
+		delete(e.obfuscatedFonts, filename)
+	}
+
+	return nil
+}
+
+// Get what uses the media file with the given internal path, e.g. "cover" or
+// the filenames of the sections referencing it
+func (e *Epub) mediaUsers(internalPath string, mediaFolderName string, filename string) []string {
+	usedBy := []string{}
+	for _, cover := range []struct {
+		name  string
+		cover *epubCover
+	}{
+		{"cover", e.cover},
+		{"back cover", e.backCover},
+	} {
+		if cover.cover.xhtmlFilename == "" {
+			continue
+		}
+		if (mediaFolderName == ImageFolderName && cover.cover.imageFilename == filename) ||
+			(mediaFolderName == VideoFolderName && cover.cover.videoFilename == filename) ||
+			(mediaFolderName == CSSFolderName && cover.cover.cssFilename == filename) {
+			usedBy = append(usedBy, cover.name)
+		}
+	}
+
+	switch mediaFolderName {
+	case ImageFolderName:
+		if e.coverThumbnail == filename {
+			usedBy = append(usedBy, "cover thumbnail")
+		}
+		if e.publisherLogo == filename {
+			usedBy = append(usedBy, "publisher logo")
+		}
+		for _, entry := range e.tocEntries {
+			if entry.iconPath == path.Join(ImageFolderName, filename) {
+				usedBy = append(usedBy, "table of contents")
+				break
+			}
+		}
+	case CSSFolderName:
+		for _, cssPath := range e.globalCSS {
+			if cssPath == internalPath {
+				usedBy = append(usedBy, "global CSS")
+				break
+			}
+		}
+	}
+
+	for _, section := range e.sections {
+		// The cover pages were checked above
+		if section.filename == e.cover.xhtmlFilename || section.filename == e.backCover.xhtmlFilename {
+			continue
+		}
+		used := strings.Contains(section.xhtml.xml.Body.XML, internalPath)
+		for _, link := range section.xhtml.xml.Head.Links {
+			if link.Href == internalPath {
+				used = true
+			}
+		}
+		if used {
+			usedBy = append(usedBy, section.filename)
+		}
+	}
+
+	return usedBy
+}
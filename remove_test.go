@@ -0,0 +1,79 @@
+package epub
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bmaupin/go-epub/internal/storage"
+)
+
+func TestRemoveMedia(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	testCoverPath, _ := e.AddImage(testImageFromFileSource, "cover.png")
+	testImagePath, _ := e.AddImage(testImageFromFileSource, "image.png")
+	testUnusedImagePath, _ := e.AddImage(testImageFromFileSource, "unused.png")
+	testCSSPath, _ := e.AddCSS(testCoverCSSSource, "section.css")
+	testFontPath, _ := e.AddFont(testFontFromFileSource, "")
+	testVideoPath, _ := e.AddVideo(testVideoFromFileSource, testVideoFromFileFilename)
+	e.SetCover(testCoverPath, "")
+	e.AddSection(`<img src="`+testImagePath+`" alt="Test"/>`, testSectionTitle, testSectionFilename, testCSSPath)
+
+	for _, test := range []struct {
+		remove       func(string) error
+		internalPath string
+		usedBy       []string
+	}{
+		{e.RemoveImage, testCoverPath, []string{"cover"}},
+		{e.RemoveImage, testImagePath, []string{testSectionFilename}},
+		{e.RemoveCSS, testCSSPath, []string{testSectionFilename}},
+	} {
+		err := test.remove(test.internalPath)
+		inUseErr, ok := err.(*ResourceInUseError)
+		if !ok {
+			t.Errorf("Expected error ResourceInUseError not returned. Returned instead: %+v", err)
+			continue
+		}
+		if strings.Join(inUseErr.UsedBy, ",") != strings.Join(test.usedBy, ",") {
+			t.Errorf("Users of %s don't match\nGot: %v\nExpected: %v", test.internalPath, inUseErr.UsedBy, test.usedBy)
+		}
+	}
+	for _, err := range []error{
+		e.RemoveImage("../images/doesnotexist.png"),
+		e.RemoveFont(testCSSPath),
+	} {
+		if _, ok := err.(*FilenameNotFoundError); !ok {
+			t.Errorf("Expected error FilenameNotFoundError not returned. Returned instead: %+v", err)
+		}
+	}
+
+	for _, err := range []error{
+		e.RemoveImage(testUnusedImagePath),
+		e.RemoveFont(testFontPath),
+		e.RemoveVideo(testVideoPath),
+	} {
+		if err != nil {
+			t.Errorf("Error removing media: %s", err)
+		}
+	}
+	if err := e.RemoveImage(testUnusedImagePath); err == nil {
+		t.Errorf("Removing an image twice should fail")
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	pkgFileContent, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	for _, removedPath := range []string{testUnusedImagePath, testFontPath, testVideoPath} {
+		if strings.Contains(string(pkgFileContent), strings.TrimPrefix(removedPath, "../")) {
+			t.Errorf("Removed file %s shouldn't be in the package file\nGot: %s", removedPath, pkgFileContent)
+		}
+	}
+	if !strings.Contains(string(pkgFileContent), strings.TrimPrefix(testImagePath, "../")) {
+		t.Errorf("Image in use should still be in the package file\nGot: %s", pkgFileContent)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
@@ -0,0 +1,238 @@
+package epub
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Matches the attributes of a tag which can refer to resources, e.g. src="a.png"
+var resourceAttributeRegexp = regexp.MustCompile(`(\s(?:src|href|poster|xlink:href|data)\s*=\s*)(?:"([^"]*)"|'([^']*)')`)
+
+// Matches the references of a CSS file to other files, e.g.
+// url("../fonts/font.ttf") or @import "other.css"
+var cssReferenceRegexp = regexp.MustCompile(`url\(\s*(?:"([^"]*)"|'([^']*)'|([^)\s]*))\s*\)|@import\s+(?:"([^"]*)"|'([^']*)')`)
+
+// UnusedResources returns the relative paths (in the same format as returned
+// by AddCSS, AddFont, AddImage, AddVideo, AddAudio, and AddJavaScript) of the
+// CSS files, fonts, images, videos, audio files, and scripts which aren't
+// referenced by any section.
+//
+// A resource is considered referenced if an attribute in the body of a section
+// or in the markup added to its head with Section.AppendHead (src, href, poster,
+// xlink:href, or data) points to it, if it's the stylesheet of a section, or if
+// a CSS file which is itself referenced points to it with url() or @import
+// (e.g. fonts used in a @font-face rule). The references are resolved relative
+// to the file they're in and may be URL-encoded. If a CSS file can't be
+// retrieved, every font and image is considered referenced since they can't be
+// checked.
+func (e *Epub) UnusedResources() []string {
+	e.Lock()
+	defer e.Unlock()
+	return e.unusedResources()
+}
+
+// PruneUnusedResources removes the resources returned by UnusedResources from
+// the EPUB so they won't be written to it.
+func (e *Epub) PruneUnusedResources() {
+	e.Lock()
+	defer e.Unlock()
+	for _, unused := range e.unusedResources() {
+		filename := path.Base(unused)
+		switch path.Base(path.Dir(unused)) {
+		case CSSFolderName:
+			delete(e.css, filename)
+		case FontFolderName:
+			delete(e.fonts, filename)
+		case ImageFolderName:
+			delete(e.images, filename)
+		case VideoFolderName:
+			delete(e.videos, filename)
+		case AudioFolderName:
+			delete(e.audios, filename)
+		case ScriptFolderName:
+			delete(e.scripts, filename)
+		}
+	}
+}
+
+func (e *Epub) unusedResources() []string {
+	// Paths of the referenced files relative to the content folder, e.g.
+	// images/image0001.png
+	referenced := map[string]bool{}
+	addReference := func(dir string, reference string) {
+		if referencePath, ok := resolveReference(dir, reference); ok {
+			referenced[referencePath] = true
+		}
+	}
+	for _, section := range e.sections {
+		for _, markup := range []string{section.xhtml.xml.Body.XML, section.xhtml.xml.Head.Markup} {
+			for _, m := range resourceAttributeRegexp.FindAllStringSubmatch(markup, -1) {
+				addReference(xhtmlFolderName, html.UnescapeString(m[2]+m[3]))
+			}
+		}
+		for _, link := range section.xhtml.xml.Head.Links {
+			addReference(xhtmlFolderName, link.Href)
+		}
+	}
+
+	isReferenced := func(mediaFolderName string, mediaFilename string) bool {
+		return referenced[path.Join(mediaFolderName, mediaFilename)]
+	}
+
+	// CSS files can be referenced from other (referenced) CSS files, so keep
+	// going until no more referenced CSS files are found
+	usedCSS := map[string]bool{}
+	allReferenced := false
+	for found := true; found; {
+		found = false
+		for cssFilename, cssSource := range e.css {
+			if usedCSS[cssFilename] || !isReferenced(CSSFolderName, cssFilename) {
+				continue
+			}
+			usedCSS[cssFilename] = true
+			found = true
+
+			content, err := e.newGrabber().readMedia(cssSource)
+			if err != nil {
+				allReferenced = true
+				continue
+			}
+			for _, m := range cssReferenceRegexp.FindAllStringSubmatch(string(content), -1) {
+				addReference(CSSFolderName, strings.Join(m[1:], ""))
+			}
+		}
+	}
+
+	unused := []string{}
+	for cssFilename := range e.css {
+		if !usedCSS[cssFilename] {
+			unused = append(unused, path.Join("..", CSSFolderName, cssFilename))
+		}
+	}
+	for mediaFolderName, mediaMap := range map[string]map[string]string{
+		FontFolderName:   e.fonts,
+		ImageFolderName:  e.images,
+		VideoFolderName:  e.videos,
+		AudioFolderName:  e.audios,
+		ScriptFolderName: e.scripts,
+	} {
+		for mediaFilename := range mediaMap {
+			if allReferenced && mediaFolderName != VideoFolderName && mediaFolderName != AudioFolderName && mediaFolderName != ScriptFolderName {
+				continue
+			}
+			if !isReferenced(mediaFolderName, mediaFilename) {
+				unused = append(unused, path.Join("..", mediaFolderName, mediaFilename))
+			}
+		}
+	}
+	sort.Strings(unused)
+
+	return unused
+}
+
+// Resolve a reference (e.g. the src of an image) of a file in the given folder
+// to the path of the referenced file relative to the content folder. The
+// returned bool is false for remote files and data URLs.
+func resolveReference(dir string, reference string) (string, bool) {
+	if i := strings.IndexAny(reference, "?#"); i != -1 {
+		reference = reference[:i]
+	}
+	reference = strings.TrimSpace(reference)
+	if reference == "" || isRemoteResource(reference) || isDataURL(reference) || path.IsAbs(reference) {
+		return "", false
+	}
+	if unescaped, err := url.PathUnescape(reference); err == nil {
+		reference = unescaped
+	}
+	return path.Join(dir, reference), true
+}
+
+// CheckRemoteResources sends a HEAD request to every remote URL the EPUB uses
+// and returns a FileRetrievalError for each one which can't be reached, so dead
+// links can be caught before the EPUB is published. An empty slice is returned
+// if every URL can be reached.
+//
+// This includes the URLs of media added from a remote source (e.g. with
+// AddImage), remote resources and links in the body of the sections (e.g. a
+// remote video cover), and remote entries of the table of contents. The
+// requests are sent with the HTTP client of the EPUB and can be cancelled with
+// the context.
+func (e *Epub) CheckRemoteResources(ctx context.Context) []error {
+	e.Lock()
+	defer e.Unlock()
+	errs := []error{}
+	for _, url := range e.remoteResources() {
+		if err := e.checkRemoteResource(ctx, url); err != nil {
+			errs = append(errs, &FileRetrievalError{Source: url, Err: err})
+		}
+	}
+	return errs
+}
+
+// Get the remote URLs used by the EPUB, sorted and without duplicates
+func (e *Epub) remoteResources() []string {
+	urls := map[string]bool{}
+	for _, mediaMap := range []map[string]string{
+		e.css,
+		e.fonts,
+		e.images,
+		e.videos,
+		e.audios,
+		e.scripts,
+		e.media,
+		e.lexicons,
+		e.overlays,
+		e.encrypted,
+	} {
+		for _, source := range mediaMap {
+			if isRemoteResource(source) {
+				urls[source] = true
+			}
+		}
+	}
+	for _, section := range e.sections {
+		for _, m := range resourceAttributeRegexp.FindAllStringSubmatch(section.xhtml.xml.Body.XML, -1) {
+			if value := html.UnescapeString(m[2] + m[3]); isRemoteResource(value) {
+				urls[value] = true
+			}
+		}
+	}
+	for _, entry := range e.tocEntries {
+		if isRemoteResource(entry.href) {
+			urls[entry.href] = true
+		}
+	}
+
+	sorted := make([]string, 0, len(urls))
+	for url := range urls {
+		sorted = append(sorted, url)
+	}
+	sort.Strings(sorted)
+
+	return sorted
+}
+
+// Send a HEAD request to a remote URL and return an error if it fails
+func (e *Epub) checkRemoteResource(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return err
+	}
+	addHeaders(req, e.fetchHeaders)
+	resp, err := e.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("bad status code: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package epub
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestUnusedResources(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	testFontCSSPath, _ := e.AddWebFont(testFontFromFileSource, "Redacted Script")
+	testUnusedCSSPath, _ := e.AddCSS(testCoverCSSSource, testCoverCSSFilename)
+	testImagePath, _ := e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+	testUnusedImagePath, _ := e.AddImage(testImageFromFileSource, testNumberFilenameStart)
+	testUnusedVideoPath, _ := e.AddVideo(testVideoFromFileSource, testVideoFromFileFilename)
+	e.AddSection(fmt.Sprintf(`<img src="%s" alt="" />`, testImagePath), testSectionTitle, "", testFontCSSPath)
+
+	testUnused := []string{testUnusedCSSPath, testUnusedImagePath, testUnusedVideoPath}
+	unused := e.UnusedResources()
+	if strings.Join(unused, ",") != strings.Join(testUnused, ",") {
+		t.Errorf(
+			"Unused resources don't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			unused,
+			testUnused)
+	}
+
+	e.PruneUnusedResources()
+	if len(e.css) != 1 || len(e.fonts) != 1 || len(e.images) != 1 || len(e.videos) != 0 {
+		t.Errorf("Unused resources weren't pruned: css=%v fonts=%v images=%v videos=%v", e.css, e.fonts, e.images, e.videos)
+	}
+	if unused := e.UnusedResources(); len(unused) != 0 {
+		t.Errorf("Expected no unused resources after pruning, got: %s", unused)
+	}
+}
+
+func TestUnusedResourcesEscaped(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	testImagePath, _ := e.AddImage(testImageFromFileSource, testSpaceInFilename)
+	testAmpersandPath, _ := e.AddImage(testImageFromFileSource, "a&b.png")
+	testPrefixPath, _ := e.AddImage(testImageFromFileSource, "a.png")
+	e.AddSection(`<img src="../images/filename%20with%20space.png" alt="" />
+<img src="../images/a&amp;b.png" alt="" />
+<a href="../images/a.png.bak">Not an image of the EPUB</a>`, testSectionTitle, "", "")
+
+	testUnused := []string{testPrefixPath}
+	if unused := e.UnusedResources(); strings.Join(unused, ",") != strings.Join(testUnused, ",") {
+		t.Errorf(
+			"Unused resources don't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			unused,
+			testUnused)
+	}
+
+	e.PruneUnusedResources()
+	for _, testPath := range []string{testImagePath, testAmpersandPath} {
+		if _, ok := e.images[path.Base(testPath)]; !ok {
+			t.Errorf("Referenced image %s shouldn't have been pruned", testPath)
+		}
+	}
+}
+
+func TestCheckRemoteResources(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/image.png", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, testImageFromFileSource)
+	}))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	e := NewEpub(testEpubTitle)
+	e.AddImage(ts.URL+"/image.png", "")
+	e.AddSection(fmt.Sprintf(`<p><a href="%s/missing.html">Link</a></p><video src="%s/image.png"></video>`, ts.URL, ts.URL), testSectionTitle, "", "")
+
+	errs := e.CheckRemoteResources(context.Background())
+	if len(errs) != 1 {
+		t.Fatalf("Expected 1 error, got %d: %v", len(errs), errs)
+	}
+	err, ok := errs[0].(*FileRetrievalError)
+	if !ok {
+		t.Fatalf("Expected error FileRetrievalError not returned. Returned instead: %+v", errs[0])
+	}
+	if err.Source != ts.URL+"/missing.html" {
+		t.Errorf("Unreachable URL doesn't match\nGot: %s\nExpected: %s", err.Source, ts.URL+"/missing.html")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if errs := e.CheckRemoteResources(ctx); len(errs) != 2 {
+		t.Errorf("Expected 2 errors with a cancelled context, got %d: %v", len(errs), errs)
+	}
+}
@@ -0,0 +1,107 @@
+package epub
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	footnoteIDFormat  = "footnote%d"
+	footnoteTemplate  = `<aside epub:type="footnote" id="%s">%s</aside>`
+	footnoteRefFormat = `<a epub:type="noteref" href="#%s" id="%s-ref">%d</a>`
+)
+
+// Section is a handle to a section of the EPUB, which is returned by
+// AddSectionHandle and can be used to edit the section after it has been
+// added without looking it up by its filename.
+type Section struct {
+	e        *Epub
+	filename string
+	xhtml    *xhtml
+	// Number of footnotes added to the section
+	footnoteCount int
+	// Footnotes at the end of the body of the section
+	footnotes string
+}
+
+// AddSectionHandle adds a new section to the EPUB like AddSection, but returns
+// a handle to the section instead of its filename.
+func (e *Epub) AddSectionHandle(body string, sectionTitle string, internalFilename string, internalCSSPath string) (*Section, error) {
+	e.Lock()
+	defer e.Unlock()
+	if err := e.checkManifestItems(1); err != nil {
+		return nil, err
+	}
+	filename, err := e.addSection(body, sectionTitle, internalFilename, internalCSSPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Section{
+		e:        e,
+		filename: filename,
+		xhtml:    e.sections[len(e.sections)-1].xhtml,
+	}, nil
+}
+
+// Filename returns the internal filename of the section, which is the relative
+// path returned by AddSection.
+func (s *Section) Filename() string {
+	return s.filename
+}
+
+// AppendHTML appends content to the body of the section, before any footnotes
+// added by AddFootnote. The content must be valid XHTML.
+func (s *Section) AppendHTML(html string) {
+	s.e.Lock()
+	defer s.e.Unlock()
+	body := s.body()
+	s.xhtml.xml.Body.XML = body + html + "\n" + s.footnotes
+}
+
+// AppendHead appends markup to the <head> element of the section, after the
+// title and the stylesheets, e.g. <meta name="viewport" content="..." />, an
+// inline <style> element or a <script> element. The markup must be valid XHTML.
+func (s *Section) AppendHead(markup string) {
+	s.e.Lock()
+	defer s.e.Unlock()
+	s.xhtml.appendHead(markup)
+}
+
+// SetTitle sets the title of the section, which is used for the table of
+// contents.
+func (s *Section) SetTitle(title string) {
+	s.e.Lock()
+	defer s.e.Unlock()
+	s.xhtml.setTitle(title)
+}
+
+// AddFootnote adds a footnote with the provided content to the end of the
+// section and returns a numbered reference to the footnote, which should be
+// added to the body of the section where the footnote applies, e.g. with
+// AppendHTML. Reading systems usually show the footnote in a pop-up when the
+// reference is selected.
+//
+// The content must be valid XHTML, e.g. <p>Footnote text.</p>
+func (s *Section) AddFootnote(footnote string) string {
+	s.e.Lock()
+	defer s.e.Unlock()
+	s.footnoteCount++
+	id := fmt.Sprintf(footnoteIDFormat, s.footnoteCount)
+
+	body := s.body()
+	s.footnotes += fmt.Sprintf(footnoteTemplate, id, footnote) + "\n"
+	s.xhtml.xml.Body.XML = body + s.footnotes
+	s.xhtml.setXmlnsEpub(xmlnsEpub)
+
+	return fmt.Sprintf(footnoteRefFormat, id, id, s.footnoteCount)
+}
+
+// Get the body of the section without the footnotes
+func (s *Section) body() string {
+	if !strings.HasSuffix(s.xhtml.xml.Body.XML, s.footnotes) {
+		// The body has been replaced, e.g. by ReplaceSection
+		s.footnotes = ""
+	}
+	return strings.TrimSuffix(s.xhtml.xml.Body.XML, s.footnotes)
+}
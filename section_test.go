@@ -0,0 +1,104 @@
+package epub
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bmaupin/go-epub/internal/storage"
+)
+
+func TestAddSectionHandle(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	e.AddSection(testSectionBody, testSectionTitle, testSectionFilename, "")
+	_, err := e.AddSectionHandle(testSectionBody, testSectionTitle, testSectionFilename, "")
+	if _, ok := err.(*FilenameAlreadyUsedError); !ok {
+		t.Errorf("Expected error FilenameAlreadyUsedError not returned. Returned instead: %+v", err)
+	}
+
+	s, err := e.AddSectionHandle(`<p>Text</p>`, "Old title", "", "")
+	if err != nil {
+		t.Fatalf("Error adding section: %s", err)
+	}
+	if s.Filename() != "section0002.xhtml" {
+		t.Errorf(
+			"Section filename doesn't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			s.Filename(),
+			"section0002.xhtml")
+	}
+
+	s.SetTitle("New title")
+	ref := s.AddFootnote(`<p>First note</p>`)
+	s.AppendHTML(`<p>More text` + ref + `</p>`)
+	s.AppendHTML(`<p>Even more text` + s.AddFootnote(`<p>Second note</p>`) + `</p>`)
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, s.Filename()))
+	if err != nil {
+		t.Errorf("Unexpected error reading section file: %s", err)
+	}
+
+	testSectionContents := `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+  <head>
+    <title>New title</title>
+  </head>
+  <body>
+<p>Text</p>
+<p>More text<a epub:type="noteref" href="#footnote1" id="footnote1-ref">1</a></p>
+<p>Even more text<a epub:type="noteref" href="#footnote2" id="footnote2-ref">2</a></p>
+<aside epub:type="footnote" id="footnote1"><p>First note</p></aside>
+<aside epub:type="footnote" id="footnote2"><p>Second note</p></aside>
+</body>
+</html>`
+	if !strings.Contains(string(contents), testSectionContents) {
+		t.Errorf(
+			"Section file contents don't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			contents,
+			testSectionContents)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestSectionAppendHead(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	testCSSPath, err := e.AddCSS(testCoverCSSSource, "")
+	if err != nil {
+		t.Fatalf("Error adding CSS: %s", err)
+	}
+	s, err := e.AddSectionHandle(testSectionBody, testSectionTitle, testSectionFilename, testCSSPath)
+	if err != nil {
+		t.Fatalf("Error adding section: %s", err)
+	}
+	s.AppendHead(`<meta name="viewport" content="width=device-width" />`)
+	s.AppendHead(`<style>p { margin: 0; }</style>`)
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, s.Filename()))
+	if err != nil {
+		t.Errorf("Unexpected error reading section file: %s", err)
+	}
+
+	testSectionHead := `  <head>
+    <title>` + testSectionTitle + `</title>
+    <link rel="stylesheet" type="text/css" href="` + testCSSPath + `"></link>
+    <meta name="viewport" content="width=device-width" />
+    <style>p { margin: 0; }</style>
+  </head>`
+	if !strings.Contains(string(contents), testSectionHead) {
+		t.Errorf(
+			"Section file head doesn't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			contents,
+			testSectionHead)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
@@ -0,0 +1,175 @@
+package epub
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/vincent-petithory/dataurl"
+)
+
+const (
+	responsiveImagesCSSFilename = "responsive-images.css"
+	responsiveImagesCSSContent  = `img {
+  height: auto;
+  max-width: 100%;
+}
+`
+	themeCSSFilename = "theme.css"
+	themeCSSTemplate = `body {
+  background-color: %s;
+  color: %s;
+  font-family: %s;
+}
+h1, h2, h3, h4, h5, h6 {
+  font-family: %s;
+}
+`
+	themeCoverCSSTemplate = `body {
+  background-color: %s;
+  margin-bottom: 0px;
+  margin-left: 0px;
+  margin-right: 0px;
+  margin-top: 0px;
+  text-align: center;
+}
+img, video {
+  max-height: 100%%;
+  max-width: 100%%;
+}
+`
+)
+
+// Theme bundles the basic styling of an EPUB so it can be given a consistent
+// look without writing any CSS. See ApplyTheme.
+type Theme struct {
+	// CSS font-family of the body text, e.g. `Georgia, serif`
+	FontFamily string
+	// CSS font-family of the headings; the body font is used if empty
+	HeadingFontFamily string
+	// CSS colors of the text and the page background, e.g. #000000
+	TextColor       string
+	BackgroundColor string
+	// CSS color of the background of the cover page; the page background color
+	// is used if empty
+	CoverBackgroundColor string
+}
+
+// Built-in themes which can be used with ApplyTheme
+var (
+	ThemeSerif = Theme{
+		FontFamily:      `Georgia, "Times New Roman", serif`,
+		TextColor:       "#000000",
+		BackgroundColor: "#FFFFFF",
+	}
+	ThemeSansSerif = Theme{
+		FontFamily:      `"Helvetica Neue", Helvetica, Arial, sans-serif`,
+		TextColor:       "#222222",
+		BackgroundColor: "#FFFFFF",
+	}
+)
+
+// ApplyTheme generates a CSS file from the theme and adds it as global CSS (see
+// AddGlobalCSS) so it is used by every section. The theme also sets the
+// background of the cover page if the default cover CSS is used (see
+// SetCover).
+//
+// Applying another theme replaces the previous one. Global CSS files added by
+// AddGlobalCSS and the CSS of each section can still be used to override the
+// theme.
+func (e *Epub) ApplyTheme(theme Theme) error {
+	e.Lock()
+	defer e.Unlock()
+
+	// Remove the previous theme
+	if e.themeCSSPath != "" {
+		e.removeGlobalCSS(e.themeCSSPath)
+		e.themeCSSPath = ""
+	}
+
+	headingFontFamily := theme.HeadingFontFamily
+	if headingFontFamily == "" {
+		headingFontFamily = theme.FontFamily
+	}
+	themeCSSContent := fmt.Sprintf(themeCSSTemplate, theme.BackgroundColor, theme.TextColor, theme.FontFamily, headingFontFamily)
+	themeCSSPath, err := e.addGlobalCSS(dataurl.EncodeBytes([]byte(themeCSSContent)), themeCSSFilename)
+	if err != nil {
+		return err
+	}
+	// The theme CSS should come first so any other global CSS can override it
+	e.moveGlobalCSSFirst()
+	e.themeCSSPath = themeCSSPath
+	e.theme = &theme
+
+	// Update the default cover CSS if it's being used
+	if e.cover.defaultCSS {
+		e.css[e.cover.cssFilename] = dataurl.EncodeBytes([]byte(e.defaultCoverCSSContent()))
+	}
+
+	return nil
+}
+
+// SetResponsiveImages sets whether a built-in stylesheet is added as global CSS
+// (see AddGlobalCSS) which keeps images from running off the page by limiting
+// their width to the width of the page. It is off by default.
+//
+// The stylesheet comes before any other global CSS, so the rule can be
+// overridden by other global CSS or the CSS of a section.
+func (e *Epub) SetResponsiveImages(responsive bool) {
+	e.Lock()
+	defer e.Unlock()
+	if e.responsiveImagesCSSPath != "" {
+		e.removeGlobalCSS(e.responsiveImagesCSSPath)
+		e.responsiveImagesCSSPath = ""
+	}
+	if !responsive {
+		return
+	}
+
+	source := dataurl.EncodeBytes([]byte(responsiveImagesCSSContent))
+	cssPath, err := e.addGlobalCSS(source, responsiveImagesCSSFilename)
+	// If that doesn't work, generate a filename
+	if _, ok := err.(*FilenameAlreadyUsedError); ok {
+		cssPath, err = e.addGlobalCSS(source, "")
+	}
+	if err != nil {
+		// This shouldn't cause an error since we're not specifying a filename
+		panic(fmt.Sprintf("Error adding responsive images CSS file: %s", err))
+	}
+	e.moveGlobalCSSFirst()
+	e.responsiveImagesCSSPath = cssPath
+}
+
+// Remove a global CSS file from the EPUB
+func (e *Epub) removeGlobalCSS(internalCSSPath string) {
+	for i, cssPath := range e.globalCSS {
+		if cssPath == internalCSSPath {
+			e.globalCSS = append(e.globalCSS[:i], e.globalCSS[i+1:]...)
+			break
+		}
+	}
+	delete(e.css, path.Base(internalCSSPath))
+}
+
+// Move the global CSS file added last to the front so any other global CSS can
+// override it
+func (e *Epub) moveGlobalCSSFirst() {
+	last := e.globalCSS[len(e.globalCSS)-1]
+	copy(e.globalCSS[1:], e.globalCSS[:len(e.globalCSS)-1])
+	e.globalCSS[0] = last
+}
+
+// Get the content of the default cover CSS, taking the theme into account
+func (e *Epub) defaultCoverCSSContent() string {
+	if e.theme == nil {
+		return defaultCoverCSSContent
+	}
+	backgroundColor := e.theme.CoverBackgroundColor
+	if backgroundColor == "" {
+		backgroundColor = e.theme.BackgroundColor
+	}
+	if backgroundColor == "" {
+		return defaultCoverCSSContent
+	}
+
+	return fmt.Sprintf(themeCoverCSSTemplate, backgroundColor)
+}
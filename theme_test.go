@@ -0,0 +1,131 @@
+package epub
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bmaupin/go-epub/internal/storage"
+)
+
+func TestApplyTheme(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	testImagePath, _ := e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+	e.SetCover(testImagePath, "")
+	testGlobalCSSPath, err := e.AddGlobalCSS(testFontCSSSource, testFontCSSFilename)
+	if err != nil {
+		t.Errorf("Error adding global CSS: %s", err)
+	}
+	testCSSPath, _ := e.AddCSS(testCoverCSSSource, "section.css")
+	testSectionPath, _ := e.AddSection(testSectionBody, testSectionTitle, "", testCSSPath)
+
+	theme := ThemeSerif
+	theme.CoverBackgroundColor = "#123456"
+	e.ApplyTheme(ThemeSansSerif)
+	err = e.ApplyTheme(theme)
+	if err != nil {
+		t.Errorf("Error applying theme: %s", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	// The theme should come first, then the global CSS, then the CSS of the section
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, testSectionPath))
+	if err != nil {
+		t.Errorf("Unexpected error reading section file: %s", err)
+	}
+	testCSSLinkElements := fmt.Sprintf(testCSSLinkTemplate, "../css/"+themeCSSFilename) + "\n" +
+		fmt.Sprintf(testCSSLinkTemplate, testGlobalCSSPath) + "\n" +
+		fmt.Sprintf(testCSSLinkTemplate, testCSSPath)
+	if !strings.Contains(trimAllSpace(string(contents)), testCSSLinkElements) {
+		t.Errorf(
+			"CSS links don't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			contents,
+			testCSSLinkElements)
+	}
+
+	contents, err = storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, CSSFolderName, themeCSSFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading theme CSS file: %s", err)
+	}
+	if !strings.Contains(string(contents), ThemeSerif.FontFamily) {
+		t.Errorf("Theme CSS doesn't contain the font family of the theme\nGot: %s", contents)
+	}
+
+	// The cover should only use the (themed) cover CSS
+	contents, err = storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, defaultCoverXhtmlFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading cover file: %s", err)
+	}
+	if strings.Count(string(contents), "<link") != 1 {
+		t.Errorf("Cover should only link the cover CSS\nGot: %s", contents)
+	}
+	contents, err = storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, CSSFolderName, defaultCoverCSSFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading cover CSS file: %s", err)
+	}
+	if !strings.Contains(string(contents), "#123456") {
+		t.Errorf("Cover CSS doesn't contain the cover background color of the theme\nGot: %s", contents)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestSetResponsiveImages(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	testGlobalCSSPath, err := e.AddGlobalCSS(testFontCSSSource, testFontCSSFilename)
+	if err != nil {
+		t.Errorf("Error adding global CSS: %s", err)
+	}
+	// Enabling more than once shouldn't add the CSS more than once
+	e.SetResponsiveImages(true)
+	e.SetResponsiveImages(true)
+	testSectionPath, _ := e.AddSection(testSectionBody, testSectionTitle, "", "")
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	// The responsive images CSS should come before the other global CSS
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, testSectionPath))
+	if err != nil {
+		t.Errorf("Unexpected error reading section file: %s", err)
+	}
+	testCSSLinkElements := fmt.Sprintf(testCSSLinkTemplate, "../css/"+responsiveImagesCSSFilename) + "\n" +
+		fmt.Sprintf(testCSSLinkTemplate, testGlobalCSSPath)
+	if !strings.Contains(trimAllSpace(string(contents)), testCSSLinkElements) {
+		t.Errorf(
+			"CSS links don't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			contents,
+			testCSSLinkElements)
+	}
+
+	contents, err = storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, CSSFolderName, responsiveImagesCSSFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading responsive images CSS file: %s", err)
+	}
+	if string(contents) != responsiveImagesCSSContent {
+		t.Errorf(
+			"Responsive images CSS doesn't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			contents,
+			responsiveImagesCSSContent)
+	}
+	cleanup(testEpubFilename, tempDir)
+
+	// Disabling should remove the CSS
+	e.SetResponsiveImages(false)
+	tempDir = writeAndExtractEpub(t, e, testEpubFilename)
+	contents, err = storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, testSectionPath))
+	if err != nil {
+		t.Errorf("Unexpected error reading section file: %s", err)
+	}
+	if strings.Contains(string(contents), responsiveImagesCSSFilename) {
+		t.Errorf("Section still links the responsive images CSS\nGot: %s", contents)
+	}
+	cleanup(testEpubFilename, tempDir)
+}
@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"path/filepath"
 	"strconv"
+	"strings"
+
+	"github.com/bmaupin/go-epub/internal/storage"
 )
 
 const (
@@ -15,7 +18,10 @@ const (
       </ol>
     </nav>
 `
+	tocLandmarksEpubType = "landmarks"
+	tocLandmarksTitle    = "Landmarks"
 	tocNavFilename       = "nav.xhtml"
+	tocNavTitle          = "Table of Contents"
 	tocNavItemID         = "nav"
 	tocNavItemProperties = "nav"
 	tocNavEpubType       = "toc"
@@ -37,6 +43,17 @@ const (
 	xmlnsEpub = "http://www.idpf.org/2007/ops"
 )
 
+// Types of landmarks commonly used with AddLandmark, see
+// https://www.w3.org/TR/epub-ssv-11/
+const (
+	LandmarkBackMatter  = "backmatter"
+	LandmarkBodyMatter  = "bodymatter"
+	LandmarkCover       = "cover"
+	LandmarkFrontMatter = "frontmatter"
+	LandmarkIndex       = "index"
+	LandmarkTOC         = "toc"
+)
+
 // toc implements the EPUB table of contents
 type toc struct {
 	// This holds the body XML for the EPUB v3 TOC file (nav.xhtml). Since this is
@@ -53,7 +70,20 @@ type toc struct {
 	// Spec: http://www.idpf.org/epub/20/spec/OPF_2.0.1_draft.htm#Section2.4.1
 	ncxXML *tocNcxRoot
 
-	title string // EPUB title
+	// This holds the landmarks of the EPUB v3 TOC file (nav.xhtml), e.g. where the
+	// body of the EPUB starts. It is only written if any landmarks were added
+	//
+	// Spec: http://www.idpf.org/epub/301/spec/epub-contentdocs.html#sec-xhtml-nav-def-types-landmarks
+	landmarksXML *tocLandmarksBody
+
+	title     string // EPUB title
+	navTitle  string // Title of the EPUB v3 TOC file, if different from the EPUB title
+	docAuthor string // Author in the EPUB v2 TOC file, if any
+	// Whether to prefix the labels of the entries with their number
+	numbering bool
+	// Position of each entry by its path, as the indexes of the entry and its
+	// ancestors, so entries can be nested under it
+	positions map[string][]int
 }
 
 type tocNavBody struct {
@@ -64,13 +94,48 @@ type tocNavBody struct {
 }
 
 type tocNavItem struct {
-	A tocNavLink `xml:"a"`
+	A        tocNavLink  `xml:"a"`
+	Children *tocNavList `xml:"ol,omitempty"`
+}
+
+// The entries nested under a TOC entry, which is only written if there are any
+// since an empty list isn't valid
+type tocNavList struct {
+	Items []tocNavItem `xml:"li"`
+}
+
+// An icon shown before the link of a TOC entry
+type tocNavImg struct {
+	Src string `xml:"src,attr"`
+	Alt string `xml:"alt,attr"`
 }
 
 type tocNavLink struct {
 	XMLName xml.Name `xml:"a"`
 	Href    string   `xml:"href,attr"`
-	Data    string   `xml:",chardata"`
+	// The icon is part of the link so the entry keeps the structure required
+	// for the EPUB v3 TOC (a link followed by an optional list)
+	Img  *tocNavImg `xml:"img,omitempty"`
+	Data string     `xml:",chardata"`
+}
+
+type tocLandmarksBody struct {
+	XMLName  xml.Name          `xml:"nav"`
+	EpubType string            `xml:"epub:type,attr"`
+	Hidden   string            `xml:"hidden,attr"`
+	H1       string            `xml:"h1"`
+	Links    []tocLandmarkItem `xml:"ol>li"`
+}
+
+type tocLandmarkItem struct {
+	A tocLandmarkLink `xml:"a"`
+}
+
+type tocLandmarkLink struct {
+	XMLName  xml.Name `xml:"a"`
+	EpubType string   `xml:"epub:type,attr"`
+	Href     string   `xml:"href,attr"`
+	Data     string   `xml:",chardata"`
 }
 
 type tocNcxRoot struct {
@@ -78,9 +143,15 @@ type tocNcxRoot struct {
 	Version string           `xml:"version,attr"`
 	Meta    tocNcxMeta       `xml:"head>meta"`
 	Title   string           `xml:"docTitle>text"`
+	Author  *tocNcxText      `xml:"docAuthor,omitempty"`
 	NavMap  []tocNcxNavPoint `xml:"navMap>navPoint"`
 }
 
+// The text of an element which is only written if it's set
+type tocNcxText struct {
+	Text string `xml:"text"`
+}
+
 type tocNcxContent struct {
 	Src string `xml:"src,attr"`
 }
@@ -91,20 +162,28 @@ type tocNcxMeta struct {
 }
 
 type tocNcxNavPoint struct {
-	XMLName xml.Name      `xml:"navPoint"`
-	ID      string        `xml:"id,attr"`
-	Text    string        `xml:"navLabel>text"`
-	Content tocNcxContent `xml:"content"`
+	XMLName  xml.Name         `xml:"navPoint"`
+	ID       string           `xml:"id,attr"`
+	Text     string           `xml:"navLabel>text"`
+	Content  tocNcxContent    `xml:"content"`
+	Children []tocNcxNavPoint `xml:"navPoint"`
 }
 
 // Constructor for toc
 func newToc() *toc {
-	t := &toc{}
+	t := &toc{
+		positions: map[string][]int{},
+	}
 
 	t.navXML = newTocNavXML()
 
 	t.ncxXML = newTocNcxXML()
 
+	t.landmarksXML = &tocLandmarksBody{
+		EpubType: tocLandmarksEpubType,
+		H1:       tocLandmarksTitle,
+	}
+
 	return t
 }
 
@@ -145,16 +224,63 @@ func newTocNcxXML() *tocNcxRoot {
 	return n
 }
 
+// Get a new TOC with the same settings as this one but without any entries
+func (t *toc) withoutEntries() *toc {
+	n := newToc()
+	n.title = t.title
+	n.navTitle = t.navTitle
+	n.docAuthor = t.docAuthor
+	n.numbering = t.numbering
+	return n
+}
+
 // Add a section to the TOC (navXML as well as ncxXML)
 func (t *toc) addSection(index int, title string, relativePath string) {
+	t.addEntry(index, title, relativePath, nil)
+}
+
+// Add a section to the TOC nested under the entry with the given path, or at
+// the top level if there is no such entry
+func (t *toc) addSubSection(parentPath string, index int, title string, relativePath string) {
+	t.addNestedEntry(t.positions[filepath.ToSlash(parentPath)], index, title, relativePath, nil)
+}
+
+// Add an entry to the TOC (navXML as well as ncxXML) with an optional icon,
+// which is only shown in navXML since the EPUB v2 TOC doesn't support images
+func (t *toc) addEntry(index int, title string, relativePath string, icon *tocNavImg) {
+	t.addNestedEntry(nil, index, title, relativePath, icon)
+}
+
+// Add an entry to the TOC nested under the entry at the given position
+func (t *toc) addNestedEntry(parent []int, index int, title string, relativePath string, icon *tocNavImg) {
 	relativePath = filepath.ToSlash(relativePath)
+	navItems := &t.navXML.Links
+	ncxNavPoints := &t.ncxXML.NavMap
+	for _, i := range parent {
+		if (*navItems)[i].Children == nil {
+			(*navItems)[i].Children = &tocNavList{}
+		}
+		navItems = &(*navItems)[i].Children.Items
+		ncxNavPoints = &(*ncxNavPoints)[i].Children
+	}
+	position := append(append([]int{}, parent...), len(*navItems))
+	t.positions[relativePath] = position
+
+	if t.numbering {
+		numbers := make([]int, len(position))
+		for i, p := range position {
+			numbers[i] = p + 1
+		}
+		title = tocNumberLabel(numbers, title)
+	}
 	l := &tocNavItem{
 		A: tocNavLink{
 			Href: relativePath,
+			Img:  icon,
 			Data: title,
 		},
 	}
-	t.navXML.Links = append(t.navXML.Links, *l)
+	*navItems = append(*navItems, *l)
 
 	np := &tocNcxNavPoint{
 		ID:   "navPoint-" + strconv.Itoa(index),
@@ -163,21 +289,69 @@ func (t *toc) addSection(index int, title string, relativePath string) {
 			Src: relativePath,
 		},
 	}
-	t.ncxXML.NavMap = append(t.ncxXML.NavMap, *np)
+	*ncxNavPoints = append(*ncxNavPoints, *np)
+}
+
+// Add a landmark to the EPUB v3 TOC file (navXML)
+func (t *toc) addLandmark(epubType string, title string, relativePath string) {
+	l := &tocLandmarkItem{
+		A: tocLandmarkLink{
+			EpubType: epubType,
+			Href:     filepath.ToSlash(relativePath),
+			Data:     title,
+		},
+	}
+	t.landmarksXML.Links = append(t.landmarksXML.Links, *l)
+}
+
+// Prefix the label of a TOC entry with its hierarchical number, given by the
+// position of the entry and its ancestors, e.g. "1. Introduction" for the first
+// entry or "1.1 Background" for the first entry nested in it
+func tocNumberLabel(numbers []int, title string) string {
+	parts := make([]string, len(numbers))
+	for i, n := range numbers {
+		parts[i] = strconv.Itoa(n)
+	}
+	number := strings.Join(parts, ".")
+	if len(numbers) == 1 {
+		number += "."
+	}
+	return number + " " + title
 }
 
 func (t *toc) setTitle(title string) {
 	t.title = title
 }
 
+func (t *toc) setNavTitle(title string) {
+	t.navTitle = title
+}
+
+func (t *toc) setDocAuthor(author string) {
+	t.docAuthor = author
+}
+
+func (t *toc) setNumbering(numbering bool) {
+	t.numbering = numbering
+}
+
 // Write the TOC files
-func (t *toc) write(tempDir string) {
-	t.writeNavDoc(tempDir)
-	t.writeNcxDoc(tempDir)
+func (t *toc) write(fsys storage.Storage, tempDir string) {
+	t.writeNavDoc(fsys, tempDir)
+	t.writeNcxDoc(fsys, tempDir)
 }
 
 // Write the the EPUB v3 TOC file (nav.xhtml) to the temporary directory
-func (t *toc) writeNavDoc(tempDir string) {
+func (t *toc) writeNavDoc(fsys storage.Storage, tempDir string) {
+	// The TOC must have a heading to be valid, so fall back to a generic one if
+	// the EPUB has no title
+	t.navXML.H1 = t.navTitle
+	if t.navXML.H1 == "" {
+		t.navXML.H1 = t.title
+	}
+	if t.navXML.H1 == "" {
+		t.navXML.H1 = tocNavTitle
+	}
 	navBodyContent, err := xml.MarshalIndent(t.navXML, "    ", "  ")
 	if err != nil {
 		panic(fmt.Sprintf(
@@ -187,17 +361,37 @@ func (t *toc) writeNavDoc(tempDir string) {
 			t.navXML))
 	}
 
+	if len(t.landmarksXML.Links) > 0 {
+		landmarksContent, err := xml.MarshalIndent(t.landmarksXML, "    ", "  ")
+		if err != nil {
+			panic(fmt.Sprintf(
+				"Error marshalling XML for EPUB v3 TOC landmarks: %s\n"+
+					"\tXML=%#v",
+				err,
+				t.landmarksXML))
+		}
+		navBodyContent = append(navBodyContent, "\n"...)
+		navBodyContent = append(navBodyContent, landmarksContent...)
+	}
+
 	n := newXhtml(string(navBodyContent))
 	n.setXmlnsEpub(xmlnsEpub)
 	n.setTitle(t.title)
+	if t.navTitle != "" {
+		n.setTitle(t.navTitle)
+	}
 
 	navFilePath := filepath.Join(tempDir, contentFolderName, tocNavFilename)
-	n.write(navFilePath)
+	n.write(fsys, navFilePath)
 }
 
 // Write the EPUB v2 TOC file (toc.ncx) to the temporary directory
-func (t *toc) writeNcxDoc(tempDir string) {
+func (t *toc) writeNcxDoc(fsys storage.Storage, tempDir string) {
 	t.ncxXML.Title = t.title
+	t.ncxXML.Author = nil
+	if t.docAuthor != "" {
+		t.ncxXML.Author = &tocNcxText{Text: t.docAuthor}
+	}
 
 	ncxFileContent, err := xml.MarshalIndent(t.ncxXML, "", "  ")
 	if err != nil {
@@ -214,7 +408,7 @@ func (t *toc) writeNcxDoc(tempDir string) {
 	ncxFileContent = append(ncxFileContent, "\n"...)
 
 	ncxFilePath := filepath.Join(tempDir, contentFolderName, tocNcxFilename)
-	if err := filesystem.WriteFile(ncxFilePath, []byte(ncxFileContent), filePermissions); err != nil {
+	if err := fsys.WriteFile(ncxFilePath, []byte(ncxFileContent), filePermissions); err != nil {
 		panic(fmt.Sprintf("Error writing EPUB v2 TOC file: %s", err))
 	}
 }
@@ -0,0 +1,580 @@
+package epub
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bmaupin/go-epub/internal/storage"
+)
+
+func TestSetTOCTitle(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	e.AddSection(testSectionBody, testSectionTitle, "", "")
+	e.SetTOCTitle("Contents")
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, tocNavFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading nav file: %s", err)
+	}
+	for _, testElement := range []string{"<title>Contents</title>", "<h1>Contents</h1>"} {
+		if !strings.Contains(string(contents), testElement) {
+			t.Errorf(
+				"Nav file doesn't contain expected element\n"+
+					"Got: %s\n"+
+					"Expected: %s",
+				contents,
+				testElement)
+		}
+	}
+
+	// The EPUB v2 TOC should still use the title of the EPUB
+	contents, err = storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, tocNcxFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading NCX file: %s", err)
+	}
+	testDocTitle := "<text>" + testEpubTitle + "</text>"
+	if !strings.Contains(string(contents), testDocTitle) {
+		t.Errorf(
+			"NCX file doc title doesn't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			contents,
+			testDocTitle)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestTOCDefaultHeading(t *testing.T) {
+	e := NewEpub("")
+	e.AddSection(testSectionBody, testSectionTitle, "", "")
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, tocNavFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading nav file: %s", err)
+	}
+	testHeading := "<h1>" + tocNavTitle + "</h1>"
+	if !strings.Contains(string(contents), testHeading) {
+		t.Errorf(
+			"Nav file heading doesn't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			contents,
+			testHeading)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestNCXDocAuthor(t *testing.T) {
+	for _, override := range []string{"", "Translated by Somebody"} {
+		e := NewEpub(testEpubTitle)
+		e.Pkg.AddCreator("Illustrator", PropertyRoleArtist)
+		e.Pkg.AddCreator(testEpubAuthor, PropertyRoleAuthor)
+		e.Pkg.SetTitle("Another Title")
+		e.SetNCXDocAuthor(override)
+
+		tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+		contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, tocNcxFilename))
+		if err != nil {
+			t.Errorf("Unexpected error reading NCX file: %s", err)
+		}
+		expectedAuthor := testEpubAuthor
+		if override != "" {
+			expectedAuthor = override
+		}
+		for _, testElement := range []string{
+			"<docTitle>\n    <text>Another Title</text>\n  </docTitle>",
+			"<docAuthor>\n    <text>" + expectedAuthor + "</text>\n  </docAuthor>",
+		} {
+			if !strings.Contains(string(contents), testElement) {
+				t.Errorf(
+					"NCX file doesn't contain expected element\n"+
+						"Got: %s\n"+
+						"Expected: %s",
+					contents,
+					testElement)
+			}
+		}
+
+		cleanup(testEpubFilename, tempDir)
+	}
+
+	// The doc author is optional
+	e := NewEpub(testEpubTitle)
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, tocNcxFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading NCX file: %s", err)
+	}
+	if strings.Contains(string(contents), "docAuthor") {
+		t.Errorf("NCX file shouldn't contain a doc author if there is no author\nGot: %s", contents)
+	}
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestSetBodyStart(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	e.AddSection(testSectionBody, "Foreword", "", "")
+	testSectionPath, _ := e.AddSection(testSectionBody, testSectionTitle, "", "")
+
+	err := e.SetBodyStart("doesnotexist.xhtml")
+	if _, ok := err.(*FilenameNotFoundError); !ok {
+		t.Errorf("Expected error FilenameNotFoundError not returned. Returned instead: %+v", err)
+	}
+	err = e.SetBodyStart(testSectionPath)
+	if err != nil {
+		t.Errorf("Error setting body start: %s", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, tocNavFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading nav file: %s", err)
+	}
+	testLandmark := `<a epub:type="bodymatter" href="xhtml/section0002.xhtml">Start of Content</a>`
+	if !strings.Contains(string(contents), testLandmark) {
+		t.Errorf(
+			"Nav file doesn't contain landmark\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			contents,
+			testLandmark)
+	}
+
+	contents, err = storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	testReference := `<reference type="text" title="Start of Content" href="xhtml/section0002.xhtml"></reference>`
+	if !strings.Contains(string(contents), testReference) {
+		t.Errorf(
+			"Package file doesn't contain guide reference\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			contents,
+			testReference)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestNoEmptyGuide(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	e.AddSection(testSectionBody, testSectionTitle, testSectionFilename, "")
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	// The OPF schema requires at least one reference in the guide
+	if strings.Contains(string(contents), "<guide") {
+		t.Errorf("Package file shouldn't contain a guide\nGot: %s", contents)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestAddLandmark(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	testImagePath, _ := e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+	e.SetCover(testImagePath, "")
+	testSectionPath, _ := e.AddSection(testSectionBody, testSectionTitle, "", "")
+	removedSectionPath, _ := e.AddSection(testSectionBody, "Removed", "", "")
+
+	err := e.AddLandmark("doesnotexist.xhtml", LandmarkBodyMatter, "Start")
+	if _, ok := err.(*FilenameNotFoundError); !ok {
+		t.Errorf("Expected error FilenameNotFoundError not returned. Returned instead: %+v", err)
+	}
+	err = e.AddLandmark(testSectionPath, "", "Start")
+	if _, ok := err.(*InvalidValueError); !ok {
+		t.Errorf("Expected error InvalidValueError not returned. Returned instead: %+v", err)
+	}
+	for _, landmark := range []struct {
+		sectionPath string
+		epubType    string
+		title       string
+	}{
+		{defaultCoverXhtmlFilename, LandmarkCover, "Cover"},
+		{"", LandmarkTOC, "Table of Contents"},
+		{testSectionPath + "#start", LandmarkBodyMatter, "Start"},
+		{removedSectionPath, LandmarkBackMatter, "Removed"},
+	} {
+		if err := e.AddLandmark(landmark.sectionPath, landmark.epubType, landmark.title); err != nil {
+			t.Errorf("Error adding landmark: %s", err)
+		}
+	}
+	e.RemoveSection(removedSectionPath)
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, tocNavFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading nav file: %s", err)
+	}
+	for _, testLandmark := range []string{
+		`<a epub:type="cover" href="xhtml/cover.xhtml">Cover</a>`,
+		`<a epub:type="toc" href="nav.xhtml">Table of Contents</a>`,
+		`<a epub:type="bodymatter" href="xhtml/section0001.xhtml#start">Start</a>`,
+	} {
+		if !strings.Contains(string(contents), testLandmark) {
+			t.Errorf(
+				"Nav file doesn't contain landmark\n"+
+					"Got: %s\n"+
+					"Expected: %s",
+				contents,
+				testLandmark)
+		}
+	}
+	if strings.Contains(string(contents), LandmarkBackMatter) {
+		t.Errorf("Nav file shouldn't contain the landmark of the removed section\nGot: %s", contents)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestTOCEntries(t *testing.T) {
+	t.Run("Empty", func(t *testing.T) {
+		e := NewEpub(testEpubTitle)
+		e.AddSection(testSectionBody, "", "", "")
+		testTOCEntries(t, e, `<a href="xhtml/section0001.xhtml">`+testEpubTitle+`</a>`)
+	})
+	t.Run("NoSections", func(t *testing.T) {
+		e := NewEpub(testEpubTitle)
+		testTOCEntries(t, e, "")
+	})
+	t.Run("SingleEntry", func(t *testing.T) {
+		e := NewEpub(testEpubTitle)
+		e.AddSection(testSectionBody, "", "", "")
+		e.AddSection(testSectionBody, testSectionTitle, "", "")
+		testTOCEntries(t, e, `<a href="xhtml/section0002.xhtml">`+testSectionTitle+`</a>`)
+	})
+}
+
+// Check that the TOC contains only an entry with the given link, or no entry at
+// all if the link is empty
+func testTOCEntries(t *testing.T, e *Epub, testLink string) {
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	testCount := 1
+	if testLink == "" {
+		testCount = 0
+	}
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, tocNavFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading nav file: %s", err)
+	}
+	if strings.Count(string(contents), "<li>") != testCount || !strings.Contains(string(contents), testLink) {
+		t.Errorf(
+			"Nav file doesn't contain the expected entries\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			contents,
+			testLink)
+	}
+	if !strings.Contains(string(contents), "<h1>"+testEpubTitle+"</h1>") {
+		t.Errorf("Nav file doesn't contain the title of the EPUB as its heading\nGot: %s", contents)
+	}
+
+	contents, err = storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, tocNcxFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading NCX file: %s", err)
+	}
+	if strings.Count(string(contents), "<navPoint") != testCount {
+		t.Errorf("NCX file should contain %d nav points\nGot: %s", testCount, contents)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestAddTocEntryWithIcon(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	testSectionPath, _ := e.AddSection(testSectionBody, testSectionTitle, "", "")
+	testIconPath, _ := e.AddImage(testImageFromFileSource, "icon.png")
+
+	err := e.AddTocEntryWithIcon("Map", testSectionPath+"#map", "../images/doesnotexist.png", "")
+	if _, ok := err.(*FilenameNotFoundError); !ok {
+		t.Errorf("Expected error FilenameNotFoundError not returned. Returned instead: %+v", err)
+	}
+	err = e.AddTocEntryWithIcon("Map", testSectionPath+"#map", testIconPath, "Map icon")
+	if err != nil {
+		t.Errorf("Error adding TOC entry: %s", err)
+	}
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, tocNavFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading nav file: %s", err)
+	}
+	testEntries := `<li>
+          <a href="xhtml/section0001.xhtml">Section 1</a>
+        </li>
+        <li>
+          <a href="xhtml/section0001.xhtml#map">
+            <img src="images/icon.png" alt="Map icon"></img>Map
+          </a>
+        </li>`
+	if !strings.Contains(string(contents), testEntries) {
+		t.Errorf(
+			"Nav file entries don't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			contents,
+			testEntries)
+	}
+	if err := checkNavItems(contents); err != nil {
+		t.Errorf("Nav file entries aren't valid: %s\nGot: %s", err, contents)
+	}
+
+	contents, err = storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, tocNcxFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading NCX file: %s", err)
+	}
+	if strings.Count(string(contents), "<navPoint") != 2 {
+		t.Errorf("NCX file should contain a nav point for each entry\nGot: %s", contents)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestSetTOCNumbering(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	e.AddSection(testSectionBody, "Introduction", "", "")
+	e.AddSection(testSectionBody, "", "", "")
+	e.AddSection(testSectionBody, "Background", "", "")
+	e.SetTOCNumbering(true)
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	// Sections without a title aren't in the TOC, so they aren't numbered
+	for _, test := range []struct {
+		filename string
+		labels   []string
+	}{
+		{tocNavFilename, []string{">1. Introduction</a>", ">2. Background</a>"}},
+		{tocNcxFilename, []string{"<text>1. Introduction</text>", "<text>2. Background</text>"}},
+	} {
+		contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, test.filename))
+		if err != nil {
+			t.Errorf("Unexpected error reading %s: %s", test.filename, err)
+		}
+		for _, testLabel := range test.labels {
+			if !strings.Contains(string(contents), testLabel) {
+				t.Errorf(
+					"TOC labels don't match\n"+
+						"Got: %s\n"+
+						"Expected: %s",
+					contents,
+					testLabel)
+			}
+		}
+	}
+
+	// The titles of the sections shouldn't be numbered
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, "section0001.xhtml"))
+	if err != nil {
+		t.Errorf("Unexpected error reading section file: %s", err)
+	}
+	if !strings.Contains(string(contents), "<title>Introduction</title>") {
+		t.Errorf("Section title shouldn't be numbered\nGot: %s", contents)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestTocNumberLabel(t *testing.T) {
+	for _, test := range []struct {
+		numbers  []int
+		expected string
+	}{
+		{[]int{1}, "1. Title"},
+		{[]int{1, 1}, "1.1 Title"},
+		{[]int{2, 3, 4}, "2.3.4 Title"},
+	} {
+		if got := tocNumberLabel(test.numbers, "Title"); got != test.expected {
+			t.Errorf("TOC number label doesn't match\nGot: %s\nExpected: %s", got, test.expected)
+		}
+	}
+}
+
+func TestAddSubSection(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	part1, _ := e.AddSection(testSectionBody, "Part I", "part1.xhtml", "")
+	part2, _ := e.AddSection(testSectionBody, "Part II", "part2.xhtml", "")
+	chapter1, err := e.AddSubSection(part1, testSectionBody, "Chapter 1", "chapter1.xhtml", "")
+	if err != nil {
+		t.Errorf("Error adding sub section: %s", err)
+	}
+	e.AddSubSection(part1, testSectionBody, "Chapter 2", "chapter2.xhtml", "")
+	e.AddSubSection(chapter1, testSectionBody, "Section 1.1", "section11.xhtml", "")
+	e.AddSubSection(part2, testSectionBody, "Chapter 3", "chapter3.xhtml", "")
+
+	_, err = e.AddSubSection("doesnotexist.xhtml", testSectionBody, testSectionTitle, "", "")
+	if _, ok := err.(*FilenameNotFoundError); !ok {
+		t.Errorf("Expected error FilenameNotFoundError not returned. Returned instead: %+v", err)
+	}
+	_, err = e.AddSubSection(part1, testSectionBody, testSectionTitle, chapter1, "")
+	if _, ok := err.(*FilenameAlreadyUsedError); !ok {
+		t.Errorf("Expected error FilenameAlreadyUsedError not returned. Returned instead: %+v", err)
+	}
+
+	// Sub sections come after their parent and its other sub sections in the
+	// reading order
+	testSpine := []string{"part1.xhtml", "chapter1.xhtml", "section11.xhtml", "chapter2.xhtml", "part2.xhtml", "chapter3.xhtml"}
+	if spine := e.spine(); strings.Join(spine, " ") != strings.Join(testSpine, " ") {
+		t.Errorf(
+			"Spine doesn't match\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			spine,
+			testSpine)
+	}
+
+	e.SetTOCNumbering(true)
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, tocNavFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading nav file: %s", err)
+	}
+	testNavList := `<ol><li><a href="xhtml/part1.xhtml">1. Part I</a>` +
+		`<ol><li><a href="xhtml/chapter1.xhtml">1.1 Chapter 1</a>` +
+		`<ol><li><a href="xhtml/section11.xhtml">1.1.1 Section 1.1</a></li></ol></li>` +
+		`<li><a href="xhtml/chapter2.xhtml">1.2 Chapter 2</a></li></ol></li>` +
+		`<li><a href="xhtml/part2.xhtml">2. Part II</a>` +
+		`<ol><li><a href="xhtml/chapter3.xhtml">2.1 Chapter 3</a></li></ol></li></ol>`
+	if !strings.Contains(strings.ReplaceAll(trimAllSpace(string(contents)), "\n", ""), testNavList) {
+		t.Errorf(
+			"Nav file doesn't contain the nested TOC\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			contents,
+			testNavList)
+	}
+
+	contents, err = storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, tocNcxFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading NCX file: %s", err)
+	}
+	testNavPoint := `<navPoint id="navPoint-1"><navLabel><text>1.1 Chapter 1</text></navLabel><content src="xhtml/chapter1.xhtml"></content>` +
+		`<navPoint id="navPoint-2"><navLabel><text>1.1.1 Section 1.1</text></navLabel><content src="xhtml/section11.xhtml"></content></navPoint></navPoint>`
+	if !strings.Contains(strings.ReplaceAll(trimAllSpace(string(contents)), "\n", ""), testNavPoint) {
+		t.Errorf(
+			"NCX file doesn't contain the nested nav points\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			contents,
+			testNavPoint)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+func TestSetMaxNestingDepth(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	parent, _ := e.AddSection(testSectionBody, "Level 1", "", "")
+	for depth := 2; depth <= defaultMaxNestingDepth; depth++ {
+		var err error
+		parent, err = e.AddSubSection(parent, testSectionBody, fmt.Sprintf("Level %d", depth), "", "")
+		if err != nil {
+			t.Fatalf("Error adding subsection at depth %d: %s", depth, err)
+		}
+	}
+	_, err := e.AddSubSection(parent, testSectionBody, "Too deep", "", "")
+	if _, ok := err.(*NestingTooDeepError); !ok {
+		t.Errorf("Expected error NestingTooDeepError not returned. Returned instead: %+v", err)
+	}
+	if len(e.sections) != defaultMaxNestingDepth {
+		t.Errorf("Section nested too deep shouldn't be added, got %d sections", len(e.sections))
+	}
+
+	e.SetMaxNestingDepth(0)
+	if _, err := e.AddSubSection(parent, testSectionBody, "Deep", "", ""); err != nil {
+		t.Errorf("Error adding subsection without a limit: %s", err)
+	}
+	e.SetMaxNestingDepth(1)
+	top, _ := e.AddSection(testSectionBody, "Top", "", "")
+	_, err = e.AddSubSection(top, testSectionBody, "Nested", "", "")
+	if _, ok := err.(*NestingTooDeepError); !ok {
+		t.Errorf("Expected error NestingTooDeepError not returned. Returned instead: %+v", err)
+	}
+}
+
+func TestSetAutoTOCLabels(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	testImagePath, _ := e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+	e.SetCover(testImagePath, "")
+	e.AddSection(testSectionBody, "Introduction", "", "")
+	testSectionPath, _ := e.AddSection(testSectionBody, "", "", "")
+	e.SetAutoTOCLabels(true)
+
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, tocNavFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading nav file: %s", err)
+	}
+	// The cover isn't counted
+	testLink := `<a href="xhtml/` + testSectionPath + `">Section 2</a>`
+	if !strings.Contains(string(contents), testLink) {
+		t.Errorf(
+			"Nav file doesn't contain the generated TOC entry\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			contents,
+			testLink)
+	}
+	if strings.Contains(string(contents), defaultCoverXhtmlFilename) {
+		t.Errorf("Nav file shouldn't contain the cover\nGot: %s", contents)
+	}
+
+	cleanup(testEpubFilename, tempDir)
+}
+
+// Check that each entry of the TOC only contains a link or span followed by an
+// optional list, as required by the EPUB v3 spec
+func checkNavItems(contents []byte) error {
+	d := xml.NewDecoder(bytes.NewReader(contents))
+	// The child elements of each open element, and whether it's a list entry
+	var children [][]string
+	var isItem []bool
+	for {
+		token, err := d.Token()
+		if err != nil {
+			break
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			if len(children) > 0 {
+				children[len(children)-1] = append(children[len(children)-1], token.Name.Local)
+			}
+			children = append(children, nil)
+			isItem = append(isItem, token.Name.Local == "li")
+		case xml.EndElement:
+			last := len(children) - 1
+			if isItem[last] {
+				names := strings.Join(children[last], " ")
+				if names != "a" && names != "span" && names != "a ol" && names != "span ol" {
+					return fmt.Errorf("entry contains %q", names)
+				}
+			}
+			children = children[:last]
+			isItem = isItem[:last]
+		}
+	}
+	return nil
+}
@@ -2,17 +2,42 @@ package epub
 
 import (
 	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"context"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 	"unicode"
 	"unicode/utf8"
 
+	"github.com/bmaupin/go-epub/internal/storage"
+	"github.com/bmaupin/go-epub/internal/storage/memory"
 	"github.com/gofrs/uuid"
 )
 
+// InconsistentEpubError is thrown by Write if the EPUB refers to files which
+// don't exist (e.g. a cover page which has been removed) or the package file
+// would be broken, instead of producing an invalid EPUB.
+type InconsistentEpubError struct {
+	Problems []string // Description of each problem that was found
+}
+
+func (e *InconsistentEpubError) Error() string {
+	return fmt.Sprintf("Inconsistent EPUB: %s", strings.Join(e.Problems, "; "))
+}
+
 // UnableToCreateEpubError is thrown by Write if it cannot create the destination EPUB file
 type UnableToCreateEpubError struct {
 	Path string // The path that was given to Write to create the EPUB
@@ -24,6 +49,7 @@ func (e *UnableToCreateEpubError) Error() string {
 }
 
 const (
+	autoTOCLabelFormat    = "Section %d"
 	containerFilename     = "container.xml"
 	containerFileTemplate = `<?xml version="1.0" encoding="UTF-8"?>
 <container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
@@ -34,72 +60,244 @@ const (
 `
 	// This seems to be the standard based on the latest EPUB spec:
 	// http://www.idpf.org/epub/31/spec/epub-ocf.html
-	contentFolderName    = "EPUB"
-	coverImageProperties = "cover-image"
+	contentFolderName       = "EPUB"
+	coverImageProperties    = "cover-image"
+	remoteResourcesProperty = "remote-resources"
+	scriptedProperty        = "scripted"
+	svgProperty             = "svg"
 	// Permissions for any new directories we create
 	dirPermissions = 0755
 	// Permissions for any new files we create
 	filePermissions   = 0644
 	mediaTypeCSS      = "text/css"
 	mediaTypeEpub     = "application/epub+zip"
+	mediaTypeJS       = "application/javascript"
 	mediaTypeJpeg     = "image/jpeg"
+	mediaTypeMP3      = "audio/mpeg"
+	mediaTypeMP4Audio = "audio/mp4"
 	mediaTypeNcx      = "application/x-dtbncx+xml"
+	mediaTypeOgg      = "audio/ogg"
+	mediaTypeOTF      = "font/otf"
+	mediaTypePNG      = "image/png"
+	mediaTypeSVG      = "image/svg+xml"
+	mediaTypeTTF      = "font/ttf"
+	mediaTypeWAV      = "audio/wav"
+	mediaTypeWOFF     = "font/woff"
+	mediaTypeWOFF2    = "font/woff2"
 	mediaTypeXhtml    = "application/xhtml+xml"
 	metaInfFolderName = "META-INF"
 	mimetypeFilename  = "mimetype"
+	opdsThumbnailRel  = "opds:image/thumbnail"
 	pkgFilename       = "package.opf"
+	ppdRTL            = "rtl"
+	prefixFOAF        = "foaf"
+	prefixFOAFURI     = "http://xmlns.com/foaf/spec/"
+	prefixOPDS        = "opds"
+	prefixOPDSURI     = "http://opds-spec.org/"
+	publisherLogoRel  = "foaf:logo"
 	tempDirPrefix     = "go-epub"
 	xhtmlFolderName   = "xhtml"
 )
 
+// Epub can be streamed to any writer, e.g. an HTTP response, with WriteTo
+var _ io.WriterTo = (*Epub)(nil)
+
 // WriteTo the dest io.Writer. The return value is the number of bytes written. Any error encountered during the write is also returned.
 func (e *Epub) WriteTo(dst io.Writer) (int64, error) {
+	return e.writeTo(context.Background(), filesystem, dst)
+}
+
+// Bytes returns the EPUB file as it would be written by Write. The EPUB is
+// assembled entirely in memory, regardless of the underlying storage, so no
+// temporary files are created. This is convenient to serve small EPUBs, but
+// note that all of their content is kept in memory twice while building them.
+func (e *Epub) Bytes() ([]byte, error) {
+	var b bytes.Buffer
+	_, err := e.writeTo(context.Background(), memory.NewMemory(), &b)
+	if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// Write the EPUB to dst, assembling its files in fsys first
+func (e *Epub) writeTo(ctx context.Context, fsys storage.Storage, dst io.Writer) (int64, error) {
 	e.Lock()
 	defer e.Unlock()
+	// Media is retrieved with the context of the write
+	e.ctx = ctx
+	e.stagingFS = fsys
+	defer func() {
+		e.ctx = nil
+		e.stagingFS = nil
+	}()
+	err := e.checkReferences()
+	if err != nil {
+		return 0, err
+	}
+
+	// The references to generated pages such as the copyright page are added
+	// to the guide while writing, so restore it afterwards to keep them from
+	// being added again the next time the EPUB is written
+	guide := e.Pkg.xml.Guide
+	if guide != nil {
+		e.Pkg.xml.Guide = &PkgGuide{References: append([]PkgReference(nil), guide.References...)}
+	}
+	defer func() {
+		e.Pkg.xml.Guide = guide
+	}()
+
 	tempDir := uuid.Must(uuid.NewV4()).String()
 
-	err := filesystem.Mkdir(tempDir, dirPermissions)
+	err = fsys.Mkdir(tempDir, dirPermissions)
 	if err != nil {
 		panic(fmt.Sprintf("Error creating temp directory: %s", err))
 	}
 	defer func() {
-		if err := filesystem.RemoveAll(tempDir); err != nil {
+		if err := fsys.RemoveAll(tempDir); err != nil {
 			panic(fmt.Sprintf("Error removing temp directory: %s", err))
 		}
 	}()
-	writeMimetype(tempDir)
-	createEpubFolders(tempDir)
+	err = e.writeContents(tempDir)
+	if err != nil {
+		return 0, err
+	}
+
+	// Must be called after:
+	// writeContents()
+	e.writePackageFile(tempDir)
+	if e.integrityManifest {
+		// Must be called after:
+		// writePackageFile()
+		err = e.writeIntegrityManifest(tempDir)
+		if err != nil {
+			return 0, err
+		}
+	}
+	// Must be called last
+	return e.writeEpub(tempDir, dst)
+}
+
+// OPF returns the package file (package.opf) of the EPUB exactly as Write
+// would write it, e.g. to inspect or lint it without writing the EPUB. Since
+// the package file declares the media type of every resource, all media is
+// retrieved as when writing the EPUB, and the same errors are returned. The
+// EPUB itself isn't changed.
+func (e *Epub) OPF() (string, error) {
+	e.Lock()
+	defer e.Unlock()
+	err := e.checkReferences()
+	if err != nil {
+		return "", err
+	}
+
+	// Writing the contents adds them to the package file and the TOC, so use
+	// copies of them which are discarded afterwards
+	pkgXML, t := e.Pkg.xml, e.toc
+	e.Pkg.xml, e.toc = pkgXML.clone(), t.withoutEntries()
+	defer func() {
+		e.Pkg.xml, e.toc = pkgXML, t
+	}()
+
+	tempDir := uuid.Must(uuid.NewV4()).String()
+
+	fsys := e.stagingStorage()
+	err = fsys.Mkdir(tempDir, dirPermissions)
+	if err != nil {
+		panic(fmt.Sprintf("Error creating temp directory: %s", err))
+	}
+	defer func() {
+		if err := fsys.RemoveAll(tempDir); err != nil {
+			panic(fmt.Sprintf("Error removing temp directory: %s", err))
+		}
+	}()
+	err = e.writeContents(tempDir)
+	if err != nil {
+		return "", err
+	}
+
+	return string(e.packageFile()), nil
+}
+
+// Write everything but the package file to the temporary directory and add it
+// to the package file
+func (e *Epub) writeContents(tempDir string) error {
+	fsys := e.stagingStorage()
+	writeMimetype(fsys, tempDir)
+	createEpubFolders(fsys, tempDir)
 
 	// Must be called after:
 	// createEpubFolders()
-	writeContainerFile(tempDir)
+	writeContainerFile(fsys, tempDir)
 
 	// Must be called after:
 	// createEpubFolders()
-	err = e.writeCSSFiles(tempDir)
+	err := e.writeCSSFiles(tempDir)
 	if err != nil {
-		return 0, err
+		return err
 	}
 
 	// Must be called after:
 	// createEpubFolders()
 	err = e.writeFonts(tempDir)
 	if err != nil {
-		return 0, err
+		return err
 	}
 
 	// Must be called after:
 	// createEpubFolders()
 	err = e.writeImages(tempDir)
 	if err != nil {
-		return 0, err
+		return err
 	}
 
 	// Must be called after:
 	// createEpubFolders()
 	err = e.writeVideos(tempDir)
 	if err != nil {
-		return 0, err
+		return err
+	}
+
+	// Must be called after:
+	// createEpubFolders()
+	err = e.writeAudios(tempDir)
+	if err != nil {
+		return err
+	}
+
+	// Must be called after:
+	// createEpubFolders()
+	err = e.writeScripts(tempDir)
+	if err != nil {
+		return err
+	}
+
+	// Must be called after:
+	// createEpubFolders()
+	err = e.writeLexicons(tempDir)
+	if err != nil {
+		return err
+	}
+
+	// Must be called after:
+	// createEpubFolders()
+	err = e.writeMediaOverlays(tempDir)
+	if err != nil {
+		return err
+	}
+
+	// Must be called after:
+	// createEpubFolders()
+	err = e.writeMediaWithFallbacks(tempDir)
+	if err != nil {
+		return err
+	}
+
+	// Must be called after:
+	// createEpubFolders()
+	err = e.writeEncryptedResources(tempDir)
+	if err != nil {
+		return err
 	}
 
 	// Must be called after:
@@ -116,18 +314,34 @@ func (e *Epub) WriteTo(dst io.Writer) (int64, error) {
 	// writeCSSFiles()
 	// writeImages()
 	// writeVideos()
+	// writeAudios()
+	// writeScripts()
+	// writeLexicons()
+	// writeMediaOverlays()
+	// writeMediaWithFallbacks()
+	// writeEncryptedResources()
 	// writeSections()
 	// writeToc()
-	e.writePackageFile(tempDir)
-	// Must be called last
-	return e.writeEpub(tempDir, dst)
+	err = e.checkPackage()
+	if err != nil {
+		return err
+	}
+
+	return nil
 }
 
 // Write writes the EPUB file. The destination path must be the full path to
 // the resulting file, including filename and extension.
 // The result is always writen to the local filesystem even if the underlying storage is in memory.
 func (e *Epub) Write(destFilePath string) error {
+	return e.WriteContext(context.Background(), destFilePath)
+}
 
+// WriteContext writes the EPUB file like Write, but remote media is retrieved
+// with the provided context. If the context is cancelled or times out, e.g.
+// because the client of the HTTP handler building the EPUB disconnected,
+// writing the EPUB is aborted and the error of the context is returned.
+func (e *Epub) WriteContext(ctx context.Context, destFilePath string) error {
 	f, err := os.Create(destFilePath)
 	if err != nil {
 		return &UnableToCreateEpubError{
@@ -136,13 +350,13 @@ func (e *Epub) Write(destFilePath string) error {
 		}
 	}
 	defer f.Close()
-	_, err = e.WriteTo(f)
+	_, err = e.writeTo(ctx, filesystem, f)
 	return err
 }
 
 // Create the EPUB folder structure in a temp directory
-func createEpubFolders(rootEpubDir string) {
-	if err := filesystem.Mkdir(
+func createEpubFolders(fsys storage.Storage, rootEpubDir string) {
+	if err := fsys.Mkdir(
 		filepath.Join(
 			rootEpubDir,
 			contentFolderName,
@@ -152,7 +366,7 @@ func createEpubFolders(rootEpubDir string) {
 		panic(fmt.Sprintf("Error creating EPUB subdirectory: %s", err))
 	}
 
-	if err := filesystem.Mkdir(
+	if err := fsys.Mkdir(
 		filepath.Join(
 			rootEpubDir,
 			contentFolderName,
@@ -162,7 +376,7 @@ func createEpubFolders(rootEpubDir string) {
 		panic(fmt.Sprintf("Error creating xhtml subdirectory: %s", err))
 	}
 
-	if err := filesystem.Mkdir(
+	if err := fsys.Mkdir(
 		filepath.Join(
 			rootEpubDir,
 			metaInfFolderName,
@@ -177,9 +391,9 @@ func createEpubFolders(rootEpubDir string) {
 //
 // Sample: https://github.com/bmaupin/epub-samples/blob/master/minimal-v3plus2/META-INF/container.xml
 // Spec: http://www.idpf.org/epub/301/spec/epub-ocf.html#sec-container-metainf-container.xml
-func writeContainerFile(rootEpubDir string) {
+func writeContainerFile(fsys storage.Storage, rootEpubDir string) {
 	containerFilePath := filepath.Join(rootEpubDir, metaInfFolderName, containerFilename)
-	if err := filesystem.WriteFile(
+	if err := fsys.WriteFile(
 		containerFilePath,
 		[]byte(
 			fmt.Sprintf(
@@ -202,15 +416,60 @@ func (e *Epub) writeCSSFiles(rootEpubDir string) error {
 		return err
 	}
 
-	// Clean up the cover temp file if one was created
-	os.Remove(e.cover.cssTempFile)
+	if e.stripCSSSourceMaps {
+		for cssFilename := range e.css {
+			cssFilePath := filepath.Join(rootEpubDir, contentFolderName, CSSFolderName, cssFilename)
+			if err := stripCSSSourceMap(e.stagingStorage(), cssFilePath); err != nil {
+				return err
+			}
+		}
+	}
+
+	if e.normalizeCSS {
+		for cssFilename := range e.css {
+			cssFilePath := filepath.Join(rootEpubDir, contentFolderName, CSSFolderName, cssFilename)
+			if err := normalizeCSSFile(e.stagingStorage(), cssFilePath); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Matches the start tag of an inline SVG element, with or without a namespace
+// prefix, e.g. <svg xmlns="http://www.w3.org/2000/svg"> or <svg:svg>
+var svgElementRegexp = regexp.MustCompile(`<(?:[A-Za-z_][\w.-]*:)?svg[\s/>]`)
+
+// Matches source map references of CSS files, e.g. /*# sourceMappingURL=epub.css.map */
+var cssSourceMapRegexp = regexp.MustCompile(`/\*[#@]\s*sourceMappingURL=[^*]*\*/[ \t]*\n?`)
 
+// Remove the source map reference from a CSS file, since the source map isn't
+// part of the EPUB
+func stripCSSSourceMap(fsys storage.Storage, cssFilePath string) error {
+	content, err := storage.ReadFile(fsys, cssFilePath)
+	if err != nil {
+		return fmt.Errorf("unable to read CSS file: %w", err)
+	}
+	if !cssSourceMapRegexp.Match(content) {
+		return nil
+	}
+	content = cssSourceMapRegexp.ReplaceAll(content, nil)
+	if err := fsys.WriteFile(cssFilePath, content, filePermissions); err != nil {
+		return fmt.Errorf("unable to write CSS file: %w", err)
+	}
 	return nil
 }
 
+// Number of bytes written between two calls of the progress handler
+const progressInterval = 64 * 1024
+
 // writeCounter counts the number of bytes written to it.
 type writeCounter struct {
 	Total int64 // Total # of bytes written
+
+	handler  func(Progress) // Called at every progressInterval, if set
+	reported int64          // Total at the last call of the handler
 }
 
 // Write implements the io.Writer interface.
@@ -218,24 +477,49 @@ type writeCounter struct {
 func (wc *writeCounter) Write(p []byte) (int, error) {
 	n := len(p)
 	wc.Total += int64(n)
+	if wc.handler != nil && wc.Total-wc.reported >= progressInterval {
+		wc.reported = wc.Total
+		wc.handler(Progress{BytesWritten: wc.Total})
+	}
 	return n, nil
 }
 
 // Write the EPUB file itself by zipping up everything from a temp directory
 // The return value is the number of bytes written. Any error encountered during the write is also returned.
 func (e *Epub) writeEpub(rootEpubDir string, dst io.Writer) (int64, error) {
-	counter := &writeCounter{}
+	counter := &writeCounter{handler: e.progressHandler}
 	teeWriter := io.MultiWriter(counter, dst)
 
 	z := zip.NewWriter(teeWriter)
+	// The files are stored uncompressed with NoCompression, otherwise they're
+	// compressed with the configured level
+	method := zip.Deflate
+	switch e.compressionLevel {
+	case flate.NoCompression:
+		method = zip.Store
+	case flate.DefaultCompression:
+	default:
+		level := e.compressionLevel
+		z.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(w, level)
+		})
+	}
 
 	skipMimetypeFile := false
 
+	var sourceTimes map[string]time.Time
+	if e.preserveSourceTimes {
+		sourceTimes = e.sourceTimes()
+	}
+
 	// addFileToZip adds the file present at path to the zip archive. The path is relative to the rootEpubDir
 	addFileToZip := func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
+		if err := e.context().Err(); err != nil {
+			return err
+		}
 
 		// Get the path of the file relative to the folder we're zipping
 		relativePath, err := filepath.Rel(rootEpubDir, path)
@@ -260,19 +544,38 @@ func (e *Epub) writeEpub(rootEpubDir string, dst io.Writer) (int64, error) {
 			if skipMimetypeFile == true {
 				return nil
 			}
-			// The mimetype file must be uncompressed according to the EPUB spec
+			// The mimetype file must be uncompressed according to the EPUB spec.
+			// Some strict readers also reject it with a data descriptor or extra
+			// field, so it's written raw with the size and checksum in the header.
+			var content []byte
+			content, err = storage.ReadFile(e.stagingStorage(), path)
+			if err != nil {
+				return fmt.Errorf("error reading mimetype file: %w", err)
+			}
+			w, err = z.CreateRaw(&zip.FileHeader{
+				Name:               relativePath,
+				Method:             zip.Store,
+				CRC32:              crc32.ChecksumIEEE(content),
+				CompressedSize64:   uint64(len(content)),
+				UncompressedSize64: uint64(len(content)),
+			})
+		} else if modTime, ok := sourceTimes[relativePath]; ok {
 			w, err = z.CreateHeader(&zip.FileHeader{
-				Name:   relativePath,
-				Method: zip.Store,
+				Name:     relativePath,
+				Method:   method,
+				Modified: modTime,
 			})
 		} else {
-			w, err = z.Create(relativePath)
+			w, err = z.CreateHeader(&zip.FileHeader{
+				Name:   relativePath,
+				Method: method,
+			})
 		}
 		if err != nil {
 			return fmt.Errorf("error creating zip writer: %w", err)
 		}
 
-		r, err := filesystem.Open(path)
+		r, err := e.stagingStorage().Open(path)
 		if err != nil {
 			return fmt.Errorf("error opening file %v being added to EPUB: %w", path, err)
 		}
@@ -291,7 +594,7 @@ func (e *Epub) writeEpub(rootEpubDir string, dst io.Writer) (int64, error) {
 
 	// Add the mimetype file first
 	mimetypeFilePath := filepath.Join(rootEpubDir, mimetypeFilename)
-	mimetypeInfo, err := fs.Stat(filesystem, mimetypeFilePath)
+	mimetypeInfo, err := fs.Stat(e.stagingStorage(), mimetypeFilePath)
 	if err != nil {
 		if err := z.Close(); err != nil {
 			panic(err)
@@ -308,7 +611,7 @@ func (e *Epub) writeEpub(rootEpubDir string, dst io.Writer) (int64, error) {
 
 	skipMimetypeFile = true
 
-	err = fs.WalkDir(filesystem, rootEpubDir, addFileToZip)
+	err = fs.WalkDir(e.stagingStorage(), rootEpubDir, addFileToZip)
 	if err != nil {
 		if err := z.Close(); err != nil {
 			panic(err)
@@ -317,9 +620,47 @@ func (e *Epub) writeEpub(rootEpubDir string, dst io.Writer) (int64, error) {
 	}
 
 	err = z.Close()
+	if err == nil && e.progressHandler != nil {
+		e.progressHandler(Progress{BytesWritten: counter.Total, Done: true})
+	}
 	return counter.Total, err
 }
 
+// Get the modification times of the media added from local files, keyed by
+// their path in the EPUB file
+func (e *Epub) sourceTimes() map[string]time.Time {
+	sourceTimes := map[string]time.Time{}
+	for _, media := range []struct {
+		folderName string
+		sources    map[string]string
+	}{
+		{CSSFolderName, e.css},
+		{FontFolderName, e.fonts},
+		{ImageFolderName, e.images},
+		{VideoFolderName, e.videos},
+		{AudioFolderName, e.audios},
+		{ScriptFolderName, e.scripts},
+		{MediaFolderName, e.media},
+		{LexiconFolderName, e.lexicons},
+		{OverlayFolderName, e.overlays},
+		{EncryptedFolderName, e.encrypted},
+	} {
+		for filename, source := range media.sources {
+			// Data URLs decoded to a temporary file don't have a meaningful time
+			if e.stagingDir != "" && filepath.Dir(source) == e.stagingDir {
+				continue
+			}
+			info, err := os.Stat(source)
+			if err != nil || !info.Mode().IsRegular() {
+				// Not a local file, e.g. a URL or a data URL
+				continue
+			}
+			sourceTimes[path.Join(contentFolderName, media.folderName, filename)] = info.ModTime()
+		}
+	}
+	return sourceTimes
+}
+
 // Get fonts from their source and save them in the temporary directory
 func (e *Epub) writeFonts(rootEpubDir string) error {
 	return e.writeMedia(rootEpubDir, e.fonts, FontFolderName)
@@ -327,7 +668,40 @@ func (e *Epub) writeFonts(rootEpubDir string) error {
 
 // Get images from their source and save them in the temporary directory
 func (e *Epub) writeImages(rootEpubDir string) error {
-	return e.writeMedia(rootEpubDir, e.images, ImageFolderName)
+	err := e.writeMedia(rootEpubDir, e.images, ImageFolderName)
+	if err != nil {
+		return err
+	}
+
+	if e.stripColorProfiles {
+		for imageFilename := range e.images {
+			imageFilePath := filepath.Join(rootEpubDir, contentFolderName, ImageFolderName, imageFilename)
+			if err := stripImageMetadataFile(e.stagingStorage(), imageFilePath); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Get media with fallbacks from their source, save them in the temporary
+// directory, and declare the fallbacks in the package file
+func (e *Epub) writeMediaWithFallbacks(rootEpubDir string) error {
+	err := e.writeMedia(rootEpubDir, e.media, MediaFolderName)
+	if err != nil {
+		return err
+	}
+
+	for i, item := range e.Pkg.xml.ManifestItems {
+		if path.Dir(item.Href) != MediaFolderName {
+			continue
+		}
+		if fallbackFilename, ok := e.mediaFallbacks[path.Base(item.Href)]; ok {
+			e.Pkg.xml.ManifestItems[i].Fallback = fixXMLId(fallbackFilename)
+		}
+	}
+	return nil
 }
 
 // Get videos from their source and save them in the temporary directory
@@ -335,19 +709,65 @@ func (e *Epub) writeVideos(rootEpubDir string) error {
 	return e.writeMedia(rootEpubDir, e.videos, VideoFolderName)
 }
 
+// Get audio files from their source and save them in the temporary directory
+func (e *Epub) writeAudios(rootEpubDir string) error {
+	err := e.writeMedia(rootEpubDir, e.audios, AudioFolderName)
+	if err != nil {
+		return err
+	}
+
+	// The detected media types aren't always the ones EPUB reading systems
+	// expect, e.g. for .m4a files
+	for i, item := range e.Pkg.xml.ManifestItems {
+		if path.Dir(item.Href) == AudioFolderName {
+			e.Pkg.xml.ManifestItems[i].MediaType = audioMediaType(item.MediaType, item.Href)
+		}
+	}
+	return nil
+}
+
+// Get scripts from their source and save them in the temporary directory
+func (e *Epub) writeScripts(rootEpubDir string) error {
+	err := e.writeMedia(rootEpubDir, e.scripts, ScriptFolderName)
+	if err != nil {
+		return err
+	}
+
+	// Scripts are usually detected as plain text
+	for i, item := range e.Pkg.xml.ManifestItems {
+		if path.Dir(item.Href) == ScriptFolderName {
+			e.Pkg.xml.ManifestItems[i].MediaType = mediaTypeJS
+		}
+	}
+	return nil
+}
+
 // Get media from their source and save them in the temporary directory
 func (e *Epub) writeMedia(rootEpubDir string, mediaMap map[string]string, mediaFolderName string) error {
 	if len(mediaMap) > 0 {
 		mediaFolderPath := filepath.Join(rootEpubDir, contentFolderName, mediaFolderName)
-		if err := filesystem.Mkdir(mediaFolderPath, dirPermissions); err != nil {
+		if err := e.stagingStorage().Mkdir(mediaFolderPath, dirPermissions); err != nil {
 			return fmt.Errorf("unable to create directory: %s", err)
 		}
 
-		for mediaFilename, mediaSource := range mediaMap {
-			mediaType, err := grabber{(e.Client)}.fetchMedia(mediaSource, mediaFolderPath, mediaFilename)
-			if err != nil {
-				return err
+		mediaFilenames := e.orderedMedia(mediaMap, mediaFolderName)
+		mediaTypes := make([]string, len(mediaFilenames))
+		err := e.fetchAll(len(mediaFilenames), func(i int) error {
+			var err error
+			mediaTypes[i], err = e.newGrabber().fetchMedia(mediaMap[mediaFilenames[i]], mediaFolderPath, mediaFilenames[i])
+			return err
+		})
+		if err != nil {
+			// Report the cancellation rather than the failed retrieval
+			if ctxErr := e.context().Err(); ctxErr != nil {
+				return ctxErr
 			}
+			return err
+		}
+
+		// Add the media in order regardless of when they were retrieved
+		for i, mediaFilename := range mediaFilenames {
+			mediaType := mediaTypes[i]
 			// The cover image has a special value for the properties attribute
 			mediaProperties := ""
 			if mediaFilename == e.cover.imageFilename {
@@ -356,11 +776,93 @@ func (e *Epub) writeMedia(rootEpubDir string, mediaMap map[string]string, mediaF
 
 			// Add the file to the OPF manifest
 			e.Pkg.AddToManifest(fixXMLId(mediaFilename), filepath.Join(mediaFolderName, mediaFilename), mediaType, mediaProperties)
+
+			if mediaFolderName == ImageFolderName && mediaFilename == e.publisherLogo {
+				e.Pkg.AddPrefix(prefixFOAF, prefixFOAFURI)
+				e.Pkg.AddLink(filepath.Join(mediaFolderName, mediaFilename), publisherLogoRel, mediaType)
+			}
+			if mediaFolderName == ImageFolderName && mediaFilename == e.coverThumbnail {
+				e.Pkg.AddPrefix(prefixOPDS, prefixOPDSURI)
+				e.Pkg.AddLink(filepath.Join(mediaFolderName, mediaFilename), opdsThumbnailRel, mediaType)
+			}
+		}
+	}
+	return nil
+}
+
+// Call fetch for the indexes from 0 to n-1, running up to fetchConcurrency of
+// them at the same time. No more calls are started once one of them has failed
+// or the write has been canceled. The error of the call with the lowest index
+// which failed is returned, so the error doesn't depend on the order in which
+// the calls complete.
+func (e *Epub) fetchAll(n int, fetch func(i int) error) error {
+	concurrency := e.fetchConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	errs := make([]error, n)
+	var failed int32
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, concurrency)
+	for i := 0; i < n; i++ {
+		semaphore <- struct{}{}
+		if atomic.LoadInt32(&failed) != 0 {
+			break
+		}
+		if err := e.context().Err(); err != nil {
+			errs[i] = err
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			if errs[i] = fetch(i); errs[i] != nil {
+				atomic.StoreInt32(&failed, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+// Get the filenames of the media in the order they're added to the manifest:
+// first the media with an ordering hint sorted by the hint, then in the order
+// they were added
+func (e *Epub) orderedMedia(mediaMap map[string]string, mediaFolderName string) []string {
+	filenames := make([]string, 0, len(mediaMap))
+	for mediaFilename := range mediaMap {
+		filenames = append(filenames, mediaFilename)
+	}
+	sort.Slice(filenames, func(i, j int) bool {
+		pathI, pathJ := path.Join(mediaFolderName, filenames[i]), path.Join(mediaFolderName, filenames[j])
+		orderI, hintedI := e.manifestOrder[pathI]
+		orderJ, hintedJ := e.manifestOrder[pathJ]
+		if hintedI != hintedJ {
+			return hintedI
+		}
+		if orderI != orderJ {
+			return orderI < orderJ
+		}
+		sequenceI, addedI := e.mediaSequence[pathI]
+		sequenceJ, addedJ := e.mediaSequence[pathJ]
+		if addedI != addedJ {
+			return addedI
+		}
+		if sequenceI != sequenceJ {
+			return sequenceI < sequenceJ
+		}
+		return filenames[i] < filenames[j]
+	})
+	return filenames
+}
+
 // fixXMLId takes a string and returns an XML id compatible string.
 // https://www.w3.org/TR/REC-xml-names/#NT-NCName
 // This means it must not contain a colon (:) or whitespace and it must not
@@ -392,56 +894,265 @@ func fixXMLId(id string) string {
 //
 // Sample: https://github.com/bmaupin/epub-samples/blob/master/minimal-v3plus2/mimetype
 // Spec: http://www.idpf.org/epub/301/spec/epub-ocf.html#sec-zip-container-mime
-func writeMimetype(rootEpubDir string) {
+func writeMimetype(fsys storage.Storage, rootEpubDir string) {
 	mimetypeFilePath := filepath.Join(rootEpubDir, mimetypeFilename)
 
-	if err := filesystem.WriteFile(mimetypeFilePath, []byte(mediaTypeEpub), filePermissions); err != nil {
+	if err := fsys.WriteFile(mimetypeFilePath, []byte(mediaTypeEpub), filePermissions); err != nil {
 		panic(fmt.Sprintf("Error writing mimetype file: %s", err))
 	}
 }
 
 func (e *Epub) writePackageFile(rootEpubDir string) {
-	e.Pkg.write(rootEpubDir)
+	pkgFilePath := filepath.Join(rootEpubDir, contentFolderName, pkgFilename)
+
+	if err := e.stagingStorage().WriteFile(pkgFilePath, e.packageFile(), filePermissions); err != nil {
+		panic(fmt.Sprintf("Error writing package file: %s", err))
+	}
+}
+
+// Get the content of the package file
+func (e *Epub) packageFile() []byte {
+	// The inferred direction isn't kept so it follows later changes of the
+	// language
+	if e.autoPageProgression && e.Pkg.xml.Spine.Ppd == "" && isRTLLang(e.Pkg.xml.Metadata.Language) {
+		e.Pkg.xml.Spine.Ppd = ppdRTL
+		defer func() {
+			e.Pkg.xml.Spine.Ppd = ""
+		}()
+	}
+	// The statistics are computed on every write so they follow later changes
+	// of the sections
+	if e.embedChapterStats {
+		meta := e.Pkg.xml.Metadata.Meta
+		e.Pkg.xml.Metadata.Meta = append(append([]PkgMeta(nil), meta...), e.chapterStatsMeta()...)
+		defer func() {
+			e.Pkg.xml.Metadata.Meta = meta
+		}()
+	}
+	return e.Pkg.marshal()
+}
+
+// Get a <meta> element with the word count of each section in reading order,
+// except the covers and sections whose body isn't valid XHTML
+func (e *Epub) chapterStatsMeta() []PkgMeta {
+	bodies := map[string]string{}
+	for _, section := range e.sections {
+		bodies[section.filename] = section.xhtml.xml.Body.XML
+	}
+
+	meta := []PkgMeta{}
+	for _, filename := range e.spine() {
+		if filename == e.cover.xhtmlFilename || filename == e.backCover.xhtmlFilename {
+			continue
+		}
+		count, err := wordCount(bodies[filename])
+		if err != nil {
+			continue
+		}
+		meta = append(meta, PkgMeta{
+			Name:    chapterWordCountMetaPrefix + strings.TrimSuffix(filename, path.Ext(filename)),
+			Content: strconv.Itoa(count),
+		})
+	}
+	return meta
+}
+
+// Primary language subtags of the languages which are written right to left
+var rtlLangs = map[string]bool{
+	"ar":  true, // Arabic
+	"arc": true, // Aramaic
+	"ckb": true, // Central Kurdish
+	"dv":  true, // Divehi
+	"fa":  true, // Persian
+	"he":  true, // Hebrew
+	"iw":  true, // Hebrew (deprecated tag)
+	"ji":  true, // Yiddish (deprecated tag)
+	"ks":  true, // Kashmiri
+	"ps":  true, // Pashto
+	"sd":  true, // Sindhi
+	"syr": true, // Syriac
+	"ug":  true, // Uyghur
+	"ur":  true, // Urdu
+	"yi":  true, // Yiddish
+}
+
+// Script subtags of the scripts which are written right to left
+var rtlScripts = map[string]bool{
+	"adlm": true, // Adlam
+	"arab": true, // Arabic
+	"hebr": true, // Hebrew
+	"nkoo": true, // N'Ko
+	"rohg": true, // Hanifi Rohingya
+	"syrc": true, // Syriac
+	"thaa": true, // Thaana
+}
+
+// Check whether a language tag (e.g. "ar" or "az-Arab") is written right to
+// left, using its script subtag if it has one
+func isRTLLang(lang string) bool {
+	subtags := strings.Split(strings.ToLower(lang), "-")
+	for _, subtag := range subtags[1:] {
+		if len(subtag) == 4 {
+			return rtlScripts[subtag]
+		}
+	}
+	return rtlLangs[subtags[0]]
 }
 
 // Write the section files to the temporary directory and add the sections to
 // the TOC and package files
 func (e *Epub) writeSections(rootEpubDir string) {
 	if len(e.sections) > 0 {
-		// If a cover was set, add it to the package spine first so it shows up
-		// first in the reading order
-		if e.cover.xhtmlFilename != "" {
-			e.Pkg.AddToSpine(e.cover.xhtmlFilename)
+		lexiconLinks := e.lexiconLinks()
+		var chapterLinks map[string]string
+		if e.autoChapterLinks {
+			chapterLinks = e.chapterLinks()
 		}
-
+		// Number of the section in the reading order, not counting the covers
+		sectionNumber := 0
 		for i, section := range e.sections {
-			// Set the title of the cover page XHTML to the title of the EPUB
-			if section.filename == e.cover.xhtmlFilename {
-				section.xhtml.setTitle(e.Pkg.xml.Metadata.Title)
+			// Set the title of the cover pages XHTML to the title of the EPUB
+			if section.filename == e.cover.xhtmlFilename || section.filename == e.backCover.xhtmlFilename {
+				section.xhtml.setTitle(e.Pkg.title())
+			} else {
+				section.xhtml.setGlobalCSS(e.globalCSS)
 			}
+			isCopyrightPage := section.filename == e.copyrightPage
+			if isCopyrightPage {
+				section.xhtml.setTitle(copyrightPageTitle)
+			}
+			section.xhtml.setLexicons(lexiconLinks)
+			section.xhtml.setViewport(e.viewport)
 
 			sectionFilePath := filepath.Join(rootEpubDir, contentFolderName, xhtmlFolderName, section.filename)
-			section.xhtml.write(sectionFilePath)
+			if links, ok := chapterLinks[section.filename]; ok {
+				// The links are only added to the written file, since the
+				// reading order may still change
+				body := section.xhtml.xml.Body.XML
+				section.xhtml.xml.Body.XML = body + links + "\n"
+				section.xhtml.write(e.stagingStorage(), sectionFilePath)
+				section.xhtml.xml.Body.XML = body
+			} else {
+				section.xhtml.write(e.stagingStorage(), sectionFilePath)
+			}
 
 			relativePath := filepath.Join(xhtmlFolderName, section.filename)
-			// Don't add pages without titles or the cover to the TOC
-			if section.xhtml.Title() != "" && section.filename != e.cover.xhtmlFilename {
-				e.toc.addSection(i, section.xhtml.Title(), relativePath)
+			isCover := section.filename == e.cover.xhtmlFilename || section.filename == e.backCover.xhtmlFilename
+			tocTitle := section.xhtml.Title()
+			if !isCover && !isCopyrightPage {
+				sectionNumber++
+				if tocTitle == "" && e.autoTOCLabels {
+					tocTitle = fmt.Sprintf(autoTOCLabelFormat, sectionNumber)
+				}
 			}
-			// The cover page should have already been added to the spine first
-			if section.filename != e.cover.xhtmlFilename {
-				e.Pkg.AddToSpine(section.filename)
+			// Don't add pages without titles, the covers, or the copyright page
+			// unless requested to the TOC
+			if tocTitle != "" && !isCover && (!isCopyrightPage || e.copyrightPageInTOC) {
+				if section.parent != "" {
+					e.toc.addSubSection(filepath.Join(xhtmlFolderName, section.parent), i, tocTitle, relativePath)
+				} else {
+					e.toc.addSection(i, tocTitle, relativePath)
+				}
+			}
+			properties := section.properties
+			if svgElementRegexp.MatchString(section.xhtml.xml.Body.XML) {
+				properties = addProperty(properties, svgProperty)
+			}
+			e.Pkg.AddToManifest(section.filename, relativePath, mediaTypeXhtml, properties)
+			if overlayFilename, ok := e.sectionOverlays[section.filename]; ok {
+				e.Pkg.xml.ManifestItems[len(e.Pkg.xml.ManifestItems)-1].MediaOverlay = fixXMLId(overlayFilename)
+			}
+
+			if section.filename == e.backCover.xhtmlFilename {
+				e.toc.addLandmark(backCoverLandmarkEpubType, backCoverTitle, relativePath)
 			}
-			e.Pkg.AddToManifest(section.filename, relativePath, mediaTypeXhtml, "")
+			if isCopyrightPage {
+				e.toc.addLandmark(copyrightPageEpubType, copyrightPageTitle, relativePath)
+				e.Pkg.AddToGuide(copyrightPageEpubType, copyrightPageTitle, relativePath)
+			}
+			if section.filename == e.bodyStart {
+				e.toc.addLandmark(bodyStartEpubType, bodyStartTitle, relativePath)
+				e.Pkg.AddToGuide(bodyStartGuideType, bodyStartTitle, relativePath)
+			}
+		}
+
+		sections := map[string]epubSection{}
+		for _, section := range e.sections {
+			sections[section.filename] = section
+		}
+		for _, filename := range e.spine() {
+			section := sections[filename]
+			e.Pkg.AddToSpine(filename)
+			itemref := &e.Pkg.xml.Spine.Items[len(e.Pkg.xml.Spine.Items)-1]
+			itemref.Properties = strings.TrimSpace(section.spineProperties + " " + section.pageSpread)
+			if section.nonLinear {
+				itemref.Linear = spineLinearNo
+			}
+		}
+	}
+}
+
+// Get the filenames of the sections in reading order
+func (e *Epub) spine() []string {
+	// The reading order, not including the covers
+	spine := []string{}
+	for _, section := range e.sections {
+		// The cover pages are added to the spine separately
+		if section.filename != e.cover.xhtmlFilename && section.filename != e.backCover.xhtmlFilename {
+			spine = append(spine, section.filename)
+		}
+	}
+
+	// If a cover was set, insert it into the spine at the requested position
+	// (first by default) so it shows up there in the reading order
+	if e.cover.xhtmlFilename != "" {
+		index := e.cover.spineIndex
+		if index > len(spine) {
+			index = len(spine)
 		}
+		spine = append(spine[:index], append([]string{e.cover.xhtmlFilename}, spine[index:]...)...)
 	}
+
+	// The back cover is always last
+	if e.backCover.xhtmlFilename != "" {
+		spine = append(spine, e.backCover.xhtmlFilename)
+	}
+
+	return spine
 }
 
 // Write the TOC file to the temporary directory and add the TOC entries to the
 // package file
 func (e *Epub) writeToc(rootEpubDir string) {
+	for i, entry := range e.tocEntries {
+		var icon *tocNavImg
+		if entry.iconPath != "" {
+			icon = &tocNavImg{Src: entry.iconPath, Alt: entry.iconAlt}
+		}
+		e.toc.addEntry(len(e.sections)+i, entry.title, entry.href, icon)
+	}
+
+	// The TOC must have at least one entry to be valid, so if no section has a
+	// title, link to the beginning of the EPUB instead. An EPUB without any
+	// content has nothing to link to, and the TOC can't link to itself.
+	if spine := e.spine(); len(e.toc.navXML.Links) == 0 && len(spine) > 0 {
+		e.toc.addSection(0, e.Pkg.title(), filepath.Join(xhtmlFolderName, spine[0]))
+	}
+
+	for _, landmark := range e.landmarks {
+		e.toc.addLandmark(landmark.epubType, landmark.title, landmark.href)
+	}
+
+	// The title and author may have been set on the package file directly
+	e.toc.setTitle(e.Pkg.title())
+	docAuthor := e.ncxDocAuthor
+	if docAuthor == "" {
+		docAuthor = e.Pkg.author()
+	}
+	e.toc.setDocAuthor(docAuthor)
+
 	e.Pkg.AddToManifest(tocNavItemID, tocNavFilename, mediaTypeXhtml, tocNavItemProperties)
 	e.Pkg.AddToManifest(tocNcxItemID, tocNcxFilename, mediaTypeNcx, "")
 
-	e.toc.write(rootEpubDir)
+	e.toc.write(e.stagingStorage(), rootEpubDir)
 }
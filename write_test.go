@@ -1,12 +1,26 @@
 package epub
 
 import (
+	"archive/zip"
 	"bytes"
+	"compress/flate"
+	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/bmaupin/go-epub/internal/storage"
+	"github.com/bmaupin/go-epub/internal/storage/osfs"
+	"github.com/vincent-petithory/dataurl"
 )
 
 func TestEpubWriteTo(t *testing.T) {
@@ -21,6 +35,117 @@ func TestEpubWriteTo(t *testing.T) {
 	}
 }
 
+func TestMimetypeEntry(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	var b bytes.Buffer
+	_, err := e.WriteTo(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Readers detect EPUB files by the mimetype right after the first local file
+	// header, which is 30 bytes plus the filename
+	testMagic := "mimetype" + testMimetypeContents
+	if magic := string(b.Bytes()[30 : 30+len(testMagic)]); magic != testMagic {
+		t.Errorf(
+			"EPUB file doesn't start with the mimetype\n"+
+				"Got: %q\n"+
+				"Expected: %q",
+			magic,
+			testMagic)
+	}
+
+	r, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := r.File[0]
+	if f.Name != mimetypeFilename {
+		t.Errorf("First zip entry should be %s, got %s", mimetypeFilename, f.Name)
+	}
+	if f.Method != zip.Store {
+		t.Errorf("Mimetype entry should be stored, got method %d", f.Method)
+	}
+	if f.Flags&0x8 != 0 {
+		t.Error("Mimetype entry shouldn't have a data descriptor")
+	}
+	if len(f.Extra) != 0 {
+		t.Errorf("Mimetype entry shouldn't have an extra field, got %v", f.Extra)
+	}
+	for _, f := range r.File[1:] {
+		if f.Name == mimetypeFilename {
+			t.Error("Mimetype entry should only be written once")
+		}
+	}
+}
+
+func TestProgressHandler(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	// Random content doesn't compress, so the EPUB is bigger than the interval
+	testVideoContent := make([]byte, 3*progressInterval)
+	rand.New(rand.NewSource(1)).Read(testVideoContent)
+	e.AddVideo(dataurl.EncodeBytes(testVideoContent), "video.mp4")
+
+	progress := []Progress{}
+	e.SetProgressHandler(func(p Progress) {
+		progress = append(progress, p)
+	})
+
+	var b bytes.Buffer
+	n, err := e.WriteTo(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(progress) < 4 {
+		t.Fatalf("Expected at least 4 progress reports, got %v", progress)
+	}
+	for i, p := range progress[:len(progress)-1] {
+		if p.Done || p.BytesWritten < int64(i+1)*progressInterval {
+			t.Errorf("Unexpected progress report %d: %+v", i, p)
+		}
+	}
+	if last := progress[len(progress)-1]; !last.Done || last.BytesWritten != n {
+		t.Errorf(
+			"Last progress report doesn't match\n"+
+				"Got: %+v\n"+
+				"Expected: %+v",
+			last,
+			Progress{BytesWritten: n, Done: true})
+	}
+}
+
+func TestProgressHandlerLocked(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	sectionsRead := make(chan struct{})
+	e.SetProgressHandler(func(p Progress) {
+		if !p.Done {
+			return
+		}
+		// Calling e.ContentManifest here would deadlock, but it can be called
+		// from another goroutine, where it waits until the EPUB has been written
+		go func() {
+			e.ContentManifest()
+			close(sectionsRead)
+		}()
+		select {
+		case <-sectionsRead:
+			t.Error("The EPUB should be locked while the progress handler is called")
+		case <-time.After(10 * time.Millisecond):
+		}
+	})
+
+	var b bytes.Buffer
+	if _, err := e.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-sectionsRead:
+	case <-time.After(time.Second):
+		t.Error("The EPUB should be unlocked after it has been written")
+	}
+}
+
 func TestWriteToErrors(t *testing.T) {
 	t.Run("CSS", func(t *testing.T) {
 		e := NewEpub(testEpubTitle)
@@ -67,3 +192,320 @@ func testWriteToErrors(t *testing.T, e *Epub, adder func(string, string) (string
 		t.Fatal("Expected error")
 	}
 }
+
+func TestStripCSSSourceMaps(t *testing.T) {
+	testCSSContent := "body{color:red}\n/*# sourceMappingURL=epub.css.map */\n"
+	for _, strip := range []bool{true, false} {
+		e := NewEpub(testEpubTitle)
+		e.SetStripCSSSourceMaps(strip)
+		testCSSPath, _ := e.AddCSS(dataurl.EncodeBytes([]byte(testCSSContent)), "epub.css")
+
+		tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+
+		contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, xhtmlFolderName, testCSSPath))
+		if err != nil {
+			t.Errorf("Unexpected error reading CSS file: %s", err)
+		}
+		if strings.Contains(string(contents), "sourceMappingURL") == strip {
+			t.Errorf("Unexpected CSS file contents when stripping is %v\nGot: %s", strip, contents)
+		}
+		if !strings.Contains(string(contents), "body{color:red}") {
+			t.Errorf("CSS rules shouldn't be removed\nGot: %s", contents)
+		}
+
+		cleanup(testEpubFilename, tempDir)
+	}
+}
+
+func TestPreserveSourceTimes(t *testing.T) {
+	data, err := ioutil.ReadFile(testImageFromFileSource)
+	if err != nil {
+		t.Fatal("cannot open testdata")
+	}
+	testImageSource := filepath.Join(t.TempDir(), testImageFromFileFilename)
+	if err := ioutil.WriteFile(testImageSource, data, filePermissions); err != nil {
+		t.Fatal(err)
+	}
+	testModTime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	if err := os.Chtimes(testImageSource, testModTime, testModTime); err != nil {
+		t.Fatal(err)
+	}
+
+	e := NewEpub(testEpubTitle)
+	e.SetPreserveSourceTimes(true)
+	testImagePath, _ := e.AddImage(testImageSource, "")
+	e.AddSection(testSectionBody, testSectionTitle, "", "")
+
+	var b bytes.Buffer
+	_, err = e.WriteTo(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testImageEntry := contentFolderName + "/" + ImageFolderName + "/" + filepath.Base(testImagePath)
+	for _, f := range r.File {
+		if f.Name == testImageEntry {
+			if !f.Modified.Equal(testModTime) {
+				t.Errorf(
+					"Modification time of the image doesn't match\n"+
+						"Got: %s\n"+
+						"Expected: %s",
+					f.Modified,
+					testModTime)
+			}
+		} else if f.Modified.Equal(testModTime) {
+			t.Errorf("Generated file %s shouldn't have the modification time of the image", f.Name)
+		}
+	}
+}
+
+func TestSetAutoPageProgression(t *testing.T) {
+	tests := []struct {
+		lang     string
+		ppd      string
+		auto     bool
+		expected string
+	}{
+		{"ar", "", true, ppdRTL},
+		{"he-IL", "", true, ppdRTL},
+		{"az-Arab", "", true, ppdRTL},
+		{"ku-Latn", "", true, ""},
+		{"en", "", true, ""},
+		{"ar", "ltr", true, "ltr"},
+		{"ar", "", false, ""},
+	}
+	for _, test := range tests {
+		e := NewEpub(testEpubTitle)
+		e.Pkg.SetLang(test.lang)
+		e.Pkg.SetPpd(test.ppd)
+		e.SetAutoPageProgression(test.auto)
+
+		tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+		contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+		if err != nil {
+			t.Errorf("Unexpected error reading package file: %s", err)
+		}
+		cleanup(testEpubFilename, tempDir)
+
+		testPpd := fmt.Sprintf(testPpdTemplate, test.expected)
+		if test.expected == "" {
+			if strings.Contains(string(contents), "page-progression-direction") {
+				t.Errorf("Package file for %s shouldn't have a page progression direction\nGot: %s", test.lang, contents)
+			}
+		} else if !strings.Contains(string(contents), testPpd) {
+			t.Errorf("Package file for %s doesn't contain %s\nGot: %s", test.lang, testPpd, contents)
+		}
+		if e.Pkg.xml.Spine.Ppd != test.ppd {
+			t.Errorf("Page progression direction shouldn't be kept after writing\nGot: %s\nExpected: %s", e.Pkg.xml.Spine.Ppd, test.ppd)
+		}
+	}
+}
+
+func TestOPF(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	testCSSPath, _ := e.AddCSS(testCoverCSSSource, testCoverCSSFilename)
+	e.AddSection(testSectionBody, testSectionTitle, testSectionFilename, testCSSPath)
+
+	opf, err := e.OPF()
+	if err != nil {
+		t.Fatalf("Unexpected error getting OPF: %s", err)
+	}
+	for _, expected := range []string{
+		`<item id="cover.css" href="css/cover.css" media-type="text/css"></item>`,
+		`<item id="section0001.xhtml" href="xhtml/section0001.xhtml" media-type="application/xhtml+xml"></item>`,
+		`<itemref idref="section0001.xhtml"></itemref>`,
+		`<meta property="dcterms:modified">`,
+	} {
+		if !strings.Contains(opf, expected) {
+			t.Errorf("OPF doesn't contain %s\nGot: %s", expected, opf)
+		}
+	}
+	if len(e.Pkg.xml.ManifestItems) != 0 || len(e.toc.navXML.Links) != 0 {
+		t.Errorf("OPF shouldn't change the EPUB, got manifest items %v", e.Pkg.xml.ManifestItems)
+	}
+
+	// The package file written to the EPUB is the same, apart from the
+	// modification date
+	tempDir := writeAndExtractEpub(t, e, testEpubFilename)
+	defer cleanup(testEpubFilename, tempDir)
+	contents, err := storage.ReadFile(filesystem, filepath.Join(tempDir, contentFolderName, pkgFilename))
+	if err != nil {
+		t.Errorf("Unexpected error reading package file: %s", err)
+	}
+	modifiedRegexp := regexp.MustCompile(`<meta property="dcterms:modified">[^<]*</meta>`)
+	if modifiedRegexp.ReplaceAllString(string(contents), "") != modifiedRegexp.ReplaceAllString(opf, "") {
+		t.Errorf(
+			"OPF doesn't match the package file\n"+
+				"Got: %s\n"+
+				"Expected: %s",
+			opf,
+			contents)
+	}
+}
+
+func TestWriteContext(t *testing.T) {
+	// The server answers the check when the image is added, but never sends the
+	// image itself
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			return
+		}
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	e := NewEpub(testEpubTitle)
+	_, err := e.AddImage(server.URL+"/image.png", "")
+	if err != nil {
+		t.Fatalf("Error adding image: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = e.WriteContext(ctx, testEpubFilename)
+	defer os.Remove(testEpubFilename)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected error context.DeadlineExceeded not returned. Returned instead: %+v", err)
+	}
+}
+
+func TestBytes(t *testing.T) {
+	newTestEpub := func() *Epub {
+		e := NewEpub(testEpubTitle)
+		testCSSPath, _ := e.AddCSS(testCoverCSSSource, testCoverCSSFilename)
+		e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+		e.AddSection(testSectionBody, testSectionTitle, testSectionFilename, testCSSPath)
+		return e
+	}
+
+	got, err := newTestEpub().Bytes()
+	if err != nil {
+		t.Fatalf("Unexpected error getting EPUB bytes: %s", err)
+	}
+	var expected bytes.Buffer
+	_, err = newTestEpub().WriteTo(&expected)
+	if err != nil {
+		t.Fatalf("Unexpected error writing EPUB: %s", err)
+	}
+
+	// The EPUBs only differ by their identifier and modification date, so
+	// compare the files of both
+	zipContents := func(b []byte) map[string]string {
+		r, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+		if err != nil {
+			t.Fatalf("Unexpected error reading EPUB: %s", err)
+		}
+		contents := map[string]string{}
+		for _, f := range r.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatalf("Unexpected error opening %s: %s", f.Name, err)
+			}
+			content, err := ioutil.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatalf("Unexpected error reading %s: %s", f.Name, err)
+			}
+			contents[f.Name] = string(content)
+		}
+		return contents
+	}
+	gotContents, expectedContents := zipContents(got), zipContents(expected.Bytes())
+	if len(gotContents) != len(expectedContents) {
+		t.Errorf("Number of files doesn't match\nGot: %d\nExpected: %d", len(gotContents), len(expectedContents))
+	}
+	for _, name := range []string{
+		mimetypeFilename,
+		filepath.Join(contentFolderName, xhtmlFolderName, testSectionFilename),
+		filepath.Join(contentFolderName, CSSFolderName, testCoverCSSFilename),
+		filepath.Join(contentFolderName, ImageFolderName, testImageFromFileFilename),
+		filepath.Join(contentFolderName, tocNcxFilename),
+	} {
+		if gotContents[name] == "" || gotContents[name] != expectedContents[name] {
+			t.Errorf("%s doesn't match\nGot: %s\nExpected: %s", name, gotContents[name], expectedContents[name])
+		}
+	}
+}
+
+func TestBytesWithoutDisk(t *testing.T) {
+	defaultFilesystem := filesystem
+	defer func() {
+		filesystem = defaultFilesystem
+	}()
+
+	// Nothing should be written to the default storage
+	tempDir := t.TempDir()
+	filesystem = osfs.NewOSFS(tempDir)
+	e := NewEpub(testEpubTitle)
+	e.AddImage(testImageFromFileSource, testImageFromFileFilename)
+	e.AddSection(testSectionBody, testSectionTitle, testSectionFilename, "")
+	if _, err := e.Bytes(); err != nil {
+		t.Fatalf("Unexpected error getting EPUB bytes: %s", err)
+	}
+	entries, err := ioutil.ReadDir(tempDir)
+	if err != nil {
+		t.Fatalf("Unexpected error reading temp dir: %s", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Bytes shouldn't write to the default storage, got %d files", len(entries))
+	}
+
+	Use(MemoryFS)
+	if _, err := e.Bytes(); err != nil {
+		t.Errorf("Unexpected error getting EPUB bytes with the memory storage: %s", err)
+	}
+}
+
+func TestSetCompressionLevel(t *testing.T) {
+	e := NewEpub(testEpubTitle)
+	err := e.SetCompressionLevel(12)
+	if _, ok := err.(*InvalidValueError); !ok {
+		t.Errorf("Expected error InvalidValueError not returned. Returned instead: %+v", err)
+	}
+
+	for _, tt := range []struct {
+		level  int
+		method uint16
+	}{
+		{flate.NoCompression, zip.Store},
+		{flate.BestCompression, zip.Deflate},
+		{flate.DefaultCompression, zip.Deflate},
+	} {
+		e := NewEpub(testEpubTitle)
+		e.AddSection(testSectionBody, testSectionTitle, testSectionFilename, "")
+		if err := e.SetCompressionLevel(tt.level); err != nil {
+			t.Errorf("Error setting compression level: %s", err)
+		}
+		var b bytes.Buffer
+		if _, err := e.WriteTo(&b); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range r.File {
+			// The mimetype file is always stored uncompressed
+			expectedMethod := tt.method
+			if f.Name == mimetypeFilename {
+				expectedMethod = zip.Store
+			}
+			if f.Method != expectedMethod {
+				t.Errorf("Compression method of %s doesn't match with level %d\nGot: %d\nExpected: %d", f.Name, tt.level, f.Method, expectedMethod)
+			}
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatalf("Unexpected error opening %s: %s", f.Name, err)
+			}
+			if _, err := io.Copy(ioutil.Discard, rc); err != nil {
+				t.Errorf("Unexpected error reading %s: %s", f.Name, err)
+			}
+			rc.Close()
+		}
+	}
+}
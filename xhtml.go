@@ -3,13 +3,16 @@ package epub
 import (
 	"encoding/xml"
 	"fmt"
+
+	"github.com/bmaupin/go-epub/internal/storage"
 )
 
 const (
 	xhtmlDoctype = `<!DOCTYPE html>
 `
-	xhtmlLinkRel  = "stylesheet"
-	xhtmlTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+	xhtmlLinkRel      = "stylesheet"
+	xhtmlViewportName = "viewport"
+	xhtmlTemplate     = `<?xml version="1.0" encoding="UTF-8"?>
 <!DOCTYPE html>
 <html xmlns="http://www.w3.org/1999/xhtml">
   <head>
@@ -35,23 +38,43 @@ type xhtmlRoot struct {
 
 type xhtmlHead struct {
 	Title string `xml:"title"`
-	Link  *xhtmlLink
+	// Size of the page in fixed-layout EPUBs
+	Viewport *xhtmlMeta
+	// Stylesheets shared by all sections, which come first so the stylesheet
+	// of the section can override them
+	GlobalLinks []xhtmlLink
+	// Stylesheets of the section, in the order they were given
+	Links []xhtmlLink
+	// Pronunciation lexicons for text-to-speech
+	LexiconLinks []xhtmlLink
+	// Markup added to the head by the user, e.g. <meta> or <style> elements
+	Markup string `xml:",innerxml"`
 }
 
-// The <link> element, used to link to stylesheets
+// The <link> element, used to link to stylesheets and pronunciation lexicons
 // Ex: <link rel="stylesheet" type="text/css" href="../css/epub.css" />
 type xhtmlLink struct {
-	XMLName xml.Name `xml:"link,omitempty"`
-	Rel     string   `xml:"rel,attr,omitempty"`
-	Type    string   `xml:"type,attr,omitempty"`
-	Href    string   `xml:"href,attr,omitempty"`
+	XMLName  xml.Name `xml:"link,omitempty"`
+	Rel      string   `xml:"rel,attr,omitempty"`
+	Type     string   `xml:"type,attr,omitempty"`
+	Href     string   `xml:"href,attr,omitempty"`
+	Hreflang string   `xml:"hreflang,attr,omitempty"`
+}
+
+// The <meta> element, used to set the viewport of fixed-layout documents
+// Ex: <meta name="viewport" content="width=1200, height=1600" />
+type xhtmlMeta struct {
+	XMLName xml.Name `xml:"meta"`
+	Name    string   `xml:"name,attr"`
+	Content string   `xml:"content,attr"`
 }
 
 // This holds the content of the XHTML document between the <body> tags. It is
 // implemented as a string because we don't know what it will contain and we
 // leave it up to the user of the package to validate the content
 type xhtmlInnerxml struct {
-	XML string `xml:",innerxml"`
+	EpubType string `xml:"epub:type,attr,omitempty"`
+	XML      string `xml:",innerxml"`
 }
 
 // Constructor for xhtml
@@ -77,6 +100,8 @@ func newXhtmlRoot() *xhtmlRoot {
 			*r,
 			xhtmlTemplate))
 	}
+	// Unmarshalling puts the whole content of the template's head in Markup
+	r.Head.Markup = ""
 
 	return r
 }
@@ -85,18 +110,68 @@ func (x *xhtml) setBody(body string) {
 	x.xml.Body.XML = "\n" + body + "\n"
 }
 
-func (x *xhtml) setCSS(path string) {
-	x.xml.Head.Link = &xhtmlLink{
-		Rel:  xhtmlLinkRel,
-		Type: mediaTypeCSS,
-		Href: path,
+// Set the epub:type attribute of the <body> element. This also declares the epub
+// namespace, which the attribute requires.
+func (x *xhtml) setBodyEpubType(epubType string) {
+	x.setXmlnsEpub(xmlnsEpub)
+	x.xml.Body.EpubType = epubType
+}
+
+// Set the stylesheets of the document, replacing any set before. Empty paths
+// are ignored, so an empty path removes the stylesheets.
+func (x *xhtml) setCSS(paths ...string) {
+	x.xml.Head.Links = nil
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		x.xml.Head.Links = append(x.xml.Head.Links, xhtmlLink{
+			Rel:  xhtmlLinkRel,
+			Type: mediaTypeCSS,
+			Href: path,
+		})
+	}
+}
+
+// Set the stylesheets shared with other documents, replacing any set before
+func (x *xhtml) setGlobalCSS(paths []string) {
+	x.xml.Head.GlobalLinks = nil
+	for _, path := range paths {
+		x.xml.Head.GlobalLinks = append(x.xml.Head.GlobalLinks, xhtmlLink{
+			Rel:  xhtmlLinkRel,
+			Type: mediaTypeCSS,
+			Href: path,
+		})
 	}
 }
 
+// Set the pronunciation lexicons of the document, replacing any set before
+func (x *xhtml) setLexicons(links []xhtmlLink) {
+	x.xml.Head.LexiconLinks = links
+}
+
+// Append markup to the end of the head of the document, after the title and
+// the stylesheets
+func (x *xhtml) appendHead(markup string) {
+	x.xml.Head.Markup += "\n    " + markup
+}
+
 func (x *xhtml) setTitle(title string) {
 	x.xml.Head.Title = title
 }
 
+// Set the viewport of the document. An empty viewport removes it.
+func (x *xhtml) setViewport(viewport string) {
+	if viewport == "" {
+		x.xml.Head.Viewport = nil
+		return
+	}
+	x.xml.Head.Viewport = &xhtmlMeta{
+		Name:    xhtmlViewportName,
+		Content: viewport,
+	}
+}
+
 func (x *xhtml) setXmlnsEpub(xmlns string) {
 	x.xml.XmlnsEpub = xmlns
 }
@@ -105,8 +180,8 @@ func (x *xhtml) Title() string {
 	return x.xml.Head.Title
 }
 
-// Write the XHTML file to the specified path
-func (x *xhtml) write(xhtmlFilePath string) {
+// Write the XHTML file to the specified path of fsys
+func (x *xhtml) write(fsys storage.Storage, xhtmlFilePath string) {
 	xhtmlFileContent, err := xml.MarshalIndent(x.xml, "", "  ")
 	if err != nil {
 		panic(fmt.Sprintf(
@@ -123,7 +198,7 @@ func (x *xhtml) write(xhtmlFilePath string) {
 	// It's generally nice to have files end with a newline
 	xhtmlFileContent = append(xhtmlFileContent, "\n"...)
 
-	if err := filesystem.WriteFile(xhtmlFilePath, []byte(xhtmlFileContent), filePermissions); err != nil {
+	if err := fsys.WriteFile(xhtmlFilePath, []byte(xhtmlFileContent), filePermissions); err != nil {
 		panic(fmt.Sprintf("Error writing XHTML file: %s", err))
 	}
 }